@@ -0,0 +1,95 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/convert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+const convertCommand = "convert"
+
+// runConvert reads the AthenzDomain and Service manifests from the files given as arguments, or from stdin if there
+// are none or the file is "-", and prints the generated Istio RBAC resources as YAML on stdout. The warnings are
+// printed on stderr. The exit code is returned.
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet(convertCommand, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] [file ...]\n\n", os.Args[0], convertCommand)
+		fmt.Fprintln(flags.Output(), "Converts AthenzDomain and Service manifests into Istio RBAC resources without cluster access.")
+		flags.PrintDefaults()
+	}
+	rbacProvider := flags.String("rbac-provider", string(rbac.ProviderV2), "istio rbac resources generated, use 'v1' for service roles and service role bindings, "+
+		"'v2' for authorization policies of the authz enabled services or 'both'")
+	enableOriginJwtSubject := flags.Bool("enable-origin-jwt-subject", true, "enable adding origin jwt subject to service role binding")
+	apSelectorLabels := flags.String("ap-selector-labels", "", "comma separated label keys of the service selectors used as the workload selectors of the authorization policies, "+
+		"all the selector labels are used by default and the "+common.SelectorLabelsAnnotation+" service annotation takes precedence")
+	conversion := addConversionFlags(flags, svcMatchModeFlag, queryStringModeFlag, trustMaxDepthFlag)
+	logLevel := flags.String("log-level", "error", "logging level, the logs are printed on stderr")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	log.InitLogger("", *logLevel)
+	log.SetOutput(os.Stderr)
+	if err := conversion.parse(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %s\n", err)
+		return 2
+	}
+
+	providerMode, err := rbac.ParseProviderMode(*rbacProvider)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing rbac-provider: %s\n", err)
+		return 2
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var in convert.Input
+	for _, file := range files {
+		if err := decodeFile(file, &in); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", file, err)
+			return 1
+		}
+	}
+
+	result := convert.Convert(in, convert.Options{
+		Provider:               providerMode,
+		EnableOriginJwtSubject: *enableOriginJwtSubject,
+		SelectorLabels:         common.ParseSelectorLabels(*apSelectorLabels),
+		MaxTrustDepth:          conversion.maxTrustDepth,
+		Conversion:             conversion.options,
+	})
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if err := convert.Encode(os.Stdout, result.Configs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the istio rbac resources: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// decodeFile decodes the manifests of the file, or of stdin for "-"
+func decodeFile(file string, in *convert.Input) error {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	return convert.Decode(r, in)
+}
@@ -0,0 +1,133 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/convert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/explain"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+const explainCommand = "explain"
+
+// runExplain reads the AthenzDomain and Service manifests like the convert subcommand and prints the explanation of
+// the request given with the flags on stdout. The exit code is 0 if the request is allowed, 1 if it is denied and 2
+// on errors.
+func runExplain(args []string) int {
+	flags := flag.NewFlagSet(explainCommand, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] [file ...]\n\n", os.Args[0], explainCommand)
+		fmt.Fprintln(flags.Output(), "Explains whether the authorization policies generated from the AthenzDomain manifests allow a request.")
+		flags.PrintDefaults()
+	}
+	principal := flags.String("principal", "", "athenz name of the user or the service sending the request, e.g. user.name or my.domain.service")
+	namespace := flags.String("namespace", "", "namespace of the service receiving the request")
+	service := flags.String("service", "", "name of the service receiving the request, the svc label of its manifest is used if given")
+	method := flags.String("method", "GET", "http method of the request")
+	path := flags.String("path", "/", "http path of the request")
+	sourceIP := flags.String("source-ip", "", "(optional) source ip address of the request matched with the source.ip conditions of the roles")
+	destinationPort := flags.String("destination-port", "", "(optional) destination port of the request matched with the destination.port conditions of the roles")
+	var headers, claims stringListFlag
+	flags.Var(&headers, "header", "(optional) name=value header of the request matched with the request.headers conditions of the roles, can be repeated")
+	flags.Var(&claims, "claim", "(optional) name=value jwt claim of the request matched with the request.auth.claims conditions of the roles, can be repeated")
+	output := flags.String("output", "yaml", "output format, yaml or json")
+	conversion := addConversionFlags(flags, svcMatchModeFlag, queryStringModeFlag, trustMaxDepthFlag)
+	logLevel := flags.String("log-level", "error", "logging level, the logs are printed on stderr")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *principal == "" || *namespace == "" || *service == "" {
+		fmt.Fprintln(os.Stderr, "The principal, namespace and service flags are required")
+		return 2
+	}
+
+	log.InitLogger("", *logLevel)
+	log.SetOutput(os.Stderr)
+	if err := conversion.parse(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %s\n", err)
+		return 2
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var in convert.Input
+	for _, file := range files {
+		if err := decodeFile(file, &in); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", file, err)
+			return 2
+		}
+	}
+
+	req := explain.Request{
+		Principal:       *principal,
+		Namespace:       *namespace,
+		Service:         *service,
+		Method:          *method,
+		Path:            *path,
+		SourceIP:        *sourceIP,
+		DestinationPort: *destinationPort,
+	}
+	var err error
+	if req.Headers, err = explain.ParseKeyValues(headers); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing header: %s\n", err)
+		return 2
+	}
+	if req.Claims, err = explain.ParseKeyValues(claims); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing claim: %s\n", err)
+		return 2
+	}
+	for _, svc := range in.Services {
+		if svc.Namespace == req.Namespace && svc.Name == req.Service {
+			req.SvcLabel = svc.Labels["svc"]
+		}
+	}
+	athenzModel, err := convert.NewModel(in, athenz.ResolveDomain(req.Namespace), conversion.maxTrustDepth)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error explaining the request: %s\n", err)
+		return 2
+	}
+	e := explain.Explain(athenzModel, req, conversion.options)
+
+	var out []byte
+	switch *output {
+	case "json":
+		out, err = json.MarshalIndent(e, "", "  ")
+		out = append(out, '\n')
+	case "yaml":
+		out, err = yaml.Marshal(e)
+	default:
+		err = fmt.Errorf("output format %s is not one of yaml or json", *output)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the explanation: %s\n", err)
+		return 2
+	}
+	os.Stdout.Write(out)
+	if !e.Allowed() {
+		return 1
+	}
+	return 0
+}
+
+// stringListFlag is a flag which can be repeated, e.g. --header x-env=prod --header x-team=core
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
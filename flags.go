@@ -0,0 +1,80 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+)
+
+// Names of the flags of the conversion of the athenz domains shared by the controller and the subcommands
+const (
+	svcMatchModeFlag    = "svc-match-mode"
+	queryStringModeFlag = "query-string-mode"
+	trustMaxDepthFlag   = "trust-max-depth"
+)
+
+// conversionFlags holds the values of the conversion flags registered on a flag set, the flags which are not
+// registered are nil
+type conversionFlags struct {
+	svcMatchMode    *string
+	queryStringMode *string
+	trustMaxDepth   *int
+	// options and maxTrustDepth are parsed from the flags, they hold the defaults of the flags which are not registered
+	options       common.ConversionOptions
+	maxTrustDepth int
+}
+
+// addConversionFlags registers the conversion flags with the given names on the flag set
+func addConversionFlags(flags *flag.FlagSet, names ...string) *conversionFlags {
+	f := &conversionFlags{
+		options:       common.DefaultConversionOptions(),
+		maxTrustDepth: athenz.DefaultMaxTrustDepth,
+	}
+	for _, name := range names {
+		switch name {
+		case svcMatchModeFlag:
+			f.svcMatchMode = flags.String(svcMatchModeFlag, string(common.SvcMatchGlob), "matching of the svc of the athenz assertion resources with the svc label of the services, "+
+				"'glob' for athenz globs matching the whole label, 'legacy-regex' for unanchored regular expressions")
+		case queryStringModeFlag:
+			f.queryStringMode = flags.String(queryStringModeFlag, string(common.QueryStringHeader), "conversion of the query string of the athenz assertion resource paths in authorization policies, "+
+				"'header' for a condition on the :path header, 'drop' for matching any query string, 'deny' for skipping the ALLOW assertions until reviewed")
+		case trustMaxDepthFlag:
+			f.trustMaxDepth = flags.Int(trustMaxDepthFlag, athenz.DefaultMaxTrustDepth, "maximum number of trust domains followed when resolving the members of a delegated athenz role")
+		default:
+			panic("unknown conversion flag " + name)
+		}
+	}
+	return f
+}
+
+// parse parses the values of the flags into the conversion options, the error is prefixed with the name of the
+// invalid flag
+func (f *conversionFlags) parse() error {
+	if f.trustMaxDepth != nil {
+		// the delegated roles are never resolved without following at least one trust domain
+		if *f.trustMaxDepth < 1 {
+			return fmt.Errorf("%s: max trust depth %d is less than 1", trustMaxDepthFlag, *f.trustMaxDepth)
+		}
+		f.maxTrustDepth = *f.trustMaxDepth
+	}
+	if f.svcMatchMode != nil {
+		svcMatchMode, err := common.ParseSvcMatchMode(*f.svcMatchMode)
+		if err != nil {
+			return fmt.Errorf("%s: %s", svcMatchModeFlag, err)
+		}
+		f.options.SvcMatchMode = svcMatchMode
+	}
+	if f.queryStringMode != nil {
+		queryStringMode, err := common.ParseQueryStringMode(*f.queryStringMode)
+		if err != nil {
+			return fmt.Errorf("%s: %s", queryStringModeFlag, err)
+		}
+		f.options.QueryStringMode = queryStringMode
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/convert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/lint"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+const lintCommand = "lint"
+
+// runLint reads the AthenzDomain manifests like the convert subcommand and prints the lint report of each domain on
+// stdout. The exit code is 1 if a finding has the fail-on severity or a higher one, and 2 on errors.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet(lintCommand, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] [file ...]\n\n", os.Args[0], lintCommand)
+		fmt.Fprintln(flags.Output(), "Reports the assertions of the AthenzDomain manifests which cannot be translated into istio rbac resources.")
+		flags.PrintDefaults()
+	}
+	output := flags.String("output", "text", "output format, text or json")
+	failOn := flags.String("fail-on", lint.SeverityError, "severity of the findings failing the command, 'error', 'warning' or 'none'")
+	rbacProvider := flags.String("rbac-provider", string(rbac.ProviderV2), "istio rbac resources whose conversion rules are checked, use 'v1' for service roles and service role bindings, "+
+		"'v2' for authorization policies or 'both'")
+	conversion := addConversionFlags(flags, svcMatchModeFlag, queryStringModeFlag)
+	logLevel := flags.String("log-level", "error", "logging level, the logs are printed on stderr")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Output format %s is not one of text or json\n", *output)
+		return 2
+	}
+	if *failOn != lint.SeverityError && *failOn != lint.SeverityWarning && *failOn != "none" {
+		fmt.Fprintf(os.Stderr, "Fail-on severity %s is not one of error, warning or none\n", *failOn)
+		return 2
+	}
+
+	log.InitLogger("", *logLevel)
+	log.SetOutput(os.Stderr)
+
+	if err := conversion.parse(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %s\n", err)
+		return 2
+	}
+
+	providerMode, err := rbac.ParseProviderMode(*rbacProvider)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing rbac-provider: %s\n", err)
+		return 2
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var in convert.Input
+	for _, file := range files {
+		if err := decodeFile(file, &in); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", file, err)
+			return 2
+		}
+	}
+
+	reports := make([]lint.Report, 0, len(in.AthenzDomains))
+	errors, warnings := 0, 0
+	for _, athenzDomain := range in.AthenzDomains {
+		report := lint.Lint(athenzDomain.Spec.Domain, providerMode, conversion.options)
+		reports = append(reports, report)
+		errors += report.Count(lint.SeverityError)
+		warnings += report.Count(lint.SeverityWarning)
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(reports)
+	} else {
+		for _, report := range reports {
+			if err = report.WriteText(os.Stdout); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the lint report: %s\n", err)
+		return 2
+	}
+
+	switch *failOn {
+	case lint.SeverityError:
+		if errors > 0 {
+			return 1
+		}
+	case lint.SeverityWarning:
+		if errors+warnings > 0 {
+			return 1
+		}
+	}
+	return 0
+}
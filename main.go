@@ -5,27 +5,53 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/controller"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/explain"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/health"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/guard"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/syncstatus"
 	adClientset "github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned"
 	versionedclient "istio.io/client-go/pkg/clientset/versioned"
 	crdController "istio.io/istio/pilot/pkg/config/kube/crd/controller"
 	"istio.io/istio/pkg/config/schema/collection"
 	"istio.io/istio/pkg/config/schema/collections"
 	"istio.io/pkg/ledger"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 )
 
+const podNamespaceEnv = "POD_NAMESPACE"
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == convertCommand {
+		os.Exit(runConvert(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == explainCommand {
+		os.Exit(runExplain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == lintCommand {
+		os.Exit(runLint(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == svcMatchReportCommand {
+		os.Exit(runSvcMatchReport(os.Args[2:]))
+	}
+
 	dnsSuffix := flag.String("dns-suffix", "svc.cluster.local", "dns suffix used for service role target services")
 	kubeconfig := flag.String("kubeconfig", "", "(optional) absolute path to the kubeconfig file")
 	adResyncIntervalRaw := flag.String("ad-resync-interval", "1h", "athenz domain resync interval")
@@ -34,12 +60,113 @@ func main() {
 	enableOriginJwtSubject := flag.Bool("enable-origin-jwt-subject", true, "enable adding origin jwt subject to service role binding")
 	logFile := flag.String("log-file", "/var/log/k8s-athenz-istio-auth/k8s-athenz-istio-auth.log", "log file location")
 	logLevel := flag.String("log-level", "info", "logging level")
-	enableAuthzPolicyController := flag.Bool("enable-ap-controller", true, "enable authzpolicy controller to create authzpolicy dry run resource")
+	enableAuthzPolicyController := flag.Bool("enable-ap-controller", true, "enable authzpolicy controller to create authzpolicy dry run resource, only used with the v2 rbac provider")
 	authzPolicyEnabledList := flag.String("ap-enabled-list", "", "List of namespace/service that enabled authz policy, "+
 		"use format 'example-ns1/example-service1' to enable a single service, use format 'example-ns2/*' to enable all services in a namespace, and use '*' to enable all services in the cluster' ")
+	rbacProvider := flag.String("rbac-provider", "", "(optional) istio rbac resources managed by the controllers, use 'v1' for service roles, service role bindings and cluster rbac config, "+
+		"'v2' for authorization policies only, and 'both' for both of them, defaults to the providers of the istio security apis served by the cluster")
+	enableOnboardingController := flag.Bool("enable-onboarding-controller", true, "enable onboarding controller to manage the cluster rbac config, only used with the v1 rbac provider")
+	domainMappingConfigMap := flag.String("domain-mapping-configmap", "", "(optional) config map in the <namespace>/<name> format with a namespace to athenz domain mapping table, "+
+		"the "+athenz.DomainAnnotation+" namespace annotation takes precedence over the table and the dot / dash naming convention is used as a fallback, "+
+		"a domain annotated on or mapped to several namespaces is not mapped to any of them")
+	guardDomainMaxRemovals := flag.Int("guard-domain-max-removals", 0, "(optional) maximum number of deleted resources and removed rules of an athenz domain applied in one sync, 0 disables the limit")
+	guardDomainMaxRemovalPercent := flag.Int("guard-domain-max-removal-percent", 0, "(optional) maximum percentage of deleted resources and removed rules of an athenz domain applied in one sync, 0 disables the limit")
+	guardClusterMaxRemovals := flag.Int("guard-cluster-max-removals", 0, "(optional) maximum number of deleted resources and removed rules across all athenz domains applied within the guard-cluster-window, 0 disables the limit")
+	guardClusterMaxRemovalPercent := flag.Int("guard-cluster-max-removal-percent", 0, "(optional) maximum percentage of deleted resources and removed rules across all athenz domains applied within the guard-cluster-window, 0 disables the limit")
+	guardClusterWindowRaw := flag.String("guard-cluster-window", "10m", "window of the cluster-wide removal limits")
+	guardApprovalConfigMap := flag.String("guard-approval-configmap", "", "(optional) config map in the <namespace>/<name> format approving the held destructive changes, "+
+		"the keys are athenz domains and the values comma separated fingerprints, required to approve the changes of the domains read from the athenz-domain-dir")
+	onDomainDelete := flag.String("on-domain-delete", string(rbac.DomainDeleteRetain), "policy applied to the istio rbac resources of a deleted athenz domain, use 'retain' to leave them in place, "+
+		"'delete' to delete them, and 'deny-all' to deny all requests to the services of the domain, the domains deleted while the controller was not running "+
+		"are found from the "+common.SourceDomainAnnotation+" annotation of the owned resources on start and on every resync")
+	enableLeaderElection := flag.Bool("enable-leader-election", false, "enable lease based leader election, only the leader replica runs the controller workers")
+	leaderElectionNamespace := flag.String("leader-election-namespace", "", "(optional) namespace of the leader election lease, defaults to the "+podNamespaceEnv+" environment variable")
+	leaderElectionName := flag.String("leader-election-name", "k8s-athenz-istio-auth", "name of the leader election lease")
+	leaderElectionLeaseDurationRaw := flag.String("leader-election-lease-duration", "15s", "duration the standby replicas wait before taking over the lease of the leader")
+	leaderElectionRenewDeadlineRaw := flag.String("leader-election-renew-deadline", "10s", "duration the leader retries renewing the lease before giving up the leadership")
+	leaderElectionRetryPeriodRaw := flag.String("leader-election-retry-period", "2s", "interval between the attempts to acquire or renew the lease")
+	shutdownTimeoutRaw := flag.String("shutdown-timeout", "20s", "duration the controller workers are given on shutdown to finish their in-flight keys and drain the processor queue, the queues are shut down at once when the leadership is lost")
+	metricsBindAddress := flag.String("metrics-bind-address", ":8080", "address of the /metrics prometheus endpoint, an empty address disables the endpoint")
+	healthProbeBindAddress := flag.String("health-probe-bind-address", ":8081", "address of the "+health.ReadinessPath+" and "+health.LivenessPath+" probes, an empty address disables the probes")
+	healthStallThresholdRaw := flag.String("health-stall-threshold", "10m", "duration a worker may go without progress while its queue is not empty before the liveness probe fails")
+	debugBindAddress := flag.String("debug-bind-address", "", "(optional) address of the "+explain.Path+" debug endpoint, an empty address disables the endpoint")
+	enableSyncStatus := flag.Bool("enable-sync-status", false, "enable writing the outcome of the athenz domain and service syncs to AthenzIstioSyncStatus resources in the domain namespaces")
+	athenzDomainDir := flag.String("athenz-domain-dir", "", "(optional) directory of signed athenz domain json files in the zms SignedDomain format watched instead of the AthenzDomain custom resources")
+	apSelectorLabels := flag.String("ap-selector-labels", "", "(optional) comma separated label keys of the service selectors used as the workload selectors of the authorization policies, "+
+		"all the selector labels are used by default and the "+common.SelectorLabelsAnnotation+" service annotation takes precedence")
+	adoptUnlabeledResources := flag.Bool("adopt-unlabeled-resources", true, "adopt the service roles, service role bindings and authorization policies without the "+common.ManagedByLabel+" label "+
+		"which match the generated spec, so that the resources generated by a version without the label are updated and deleted, disable once they are labeled")
+	conversion := addConversionFlags(flag.CommandLine, svcMatchModeFlag, queryStringModeFlag, trustMaxDepthFlag)
 	flag.Parse()
 	log.InitLogger(*logFile, *logLevel)
 
+	explicitFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+	if err := conversion.parse(); err != nil {
+		log.Panicf("Error parsing %s", err.Error())
+	}
+
+	// If kubeconfig arg is not passed-in, try user $HOME config only if it exists
+	if *kubeconfig == "" {
+		home := filepath.Join(homedir.HomeDir(), ".kube", "config")
+		if _, err := os.Stat(home); err == nil {
+			*kubeconfig = home
+		}
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		log.Panicf("Error creating kubernetes in cluster config: %s", err.Error())
+	}
+
+	k8sClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Panicf("Error creating k8s client: %s", err.Error())
+	}
+
+	// Only the providers and controllers of the istio security apis served by the
+	// cluster are enabled, the informers of missing apis would never sync
+	capabilities, err := rbac.DetectCapabilities(k8sClient.Discovery())
+	if err != nil {
+		log.Panicf("Error detecting istio security apis: %s", err.Error())
+	}
+	log.Infof("Detected istio security apis: %s", capabilities)
+
+	var requestedProviderMode rbac.ProviderMode
+	if *rbacProvider != "" {
+		requestedProviderMode, err = rbac.ParseProviderMode(*rbacProvider)
+		if err != nil {
+			log.Panicf("Error parsing rbac-provider: %s", err.Error())
+		}
+	}
+	rbacProviderMode, err := capabilities.ResolveProviderMode(requestedProviderMode)
+	if err != nil {
+		log.Panicf("Error resolving rbac-provider: %s", err.Error())
+	}
+	if rbacProviderMode.V2Enabled() && !*enableAuthzPolicyController {
+		if requestedProviderMode != "" || !rbacProviderMode.V1Enabled() {
+			log.Panicf("The authzpolicy controller must be enabled for rbac provider %s", rbacProviderMode)
+		}
+		rbacProviderMode = rbac.ProviderV1
+	}
+	// The authorization policies are not managed by the v1 rbac provider, the default enabled authzpolicy
+	// controller is disabled rather than creating them next to the service roles
+	if !rbacProviderMode.V2Enabled() && *enableAuthzPolicyController {
+		if explicitFlags["enable-ap-controller"] {
+			log.Panicf("The authzpolicy controller cannot be enabled with rbac provider %s", rbacProviderMode)
+		}
+		log.Infof("Authorization policies are not managed by rbac provider %s, disabling the authzpolicy controller", rbacProviderMode)
+		*enableAuthzPolicyController = false
+	}
+	if *enableAuthzPolicyController && !capabilities.SecurityV1Beta1 {
+		log.Warningln("Authorization policies are not served, disabling the authzpolicy controller")
+		*enableAuthzPolicyController = false
+	}
+	log.Infof("Enabled controllers: rbac provider: %s, authzpolicy controller: %t, onboarding controller: %t",
+		rbacProviderMode, *enableAuthzPolicyController, rbacProviderMode.V1Enabled() && *enableOnboardingController)
+
 	// When enableAuthzPolicyController is set to true create a dry run folder which
 	// would contain the Authorization Policy resource for all the namespaces/services which
 	// are not passed as a parameter in --ap-enabled-list
@@ -60,14 +187,14 @@ func main() {
 		}
 	}
 
-	configDescriptor := collection.SchemasFor(collections.IstioRbacV1Alpha1Serviceroles, collections.IstioRbacV1Alpha1Clusterrbacconfigs, collections.IstioRbacV1Alpha1Servicerolebindings, collections.IstioSecurityV1Beta1Authorizationpolicies)
-	// If kubeconfig arg is not passed-in, try user $HOME config only if it exists
-	if *kubeconfig == "" {
-		home := filepath.Join(homedir.HomeDir(), ".kube", "config")
-		if _, err := os.Stat(home); err == nil {
-			*kubeconfig = home
-		}
+	var schemas []collection.Schema
+	if rbacProviderMode.V1Enabled() {
+		schemas = append(schemas, collections.IstioRbacV1Alpha1Serviceroles, collections.IstioRbacV1Alpha1Clusterrbacconfigs, collections.IstioRbacV1Alpha1Servicerolebindings)
+	}
+	if capabilities.SecurityV1Beta1 {
+		schemas = append(schemas, collections.IstioSecurityV1Beta1Authorizationpolicies)
 	}
+	configDescriptor := collection.SchemasFor(schemas...)
 
 	// Ledger for tracking config distribution, specify how long it can retain its previous state
 	configLedger := ledger.Make(time.Hour)
@@ -76,16 +203,6 @@ func main() {
 		log.Panicf("Error creating istio crd client: %s", err.Error())
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		log.Panicf("Error creating kubernetes in cluster config: %s", err.Error())
-	}
-
-	k8sClient, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Panicf("Error creating k8s client: %s", err.Error())
-	}
-
 	adClient, err := adClientset.NewForConfig(config)
 	if err != nil {
 		log.Panicf("Error creating athenz domain client: %s", err.Error())
@@ -108,6 +225,60 @@ func main() {
 		log.Panicf("Error parsing ap-resync-interval duration: %s", err.Error())
 	}
 
+	shutdownTimeout, err := time.ParseDuration(*shutdownTimeoutRaw)
+	if err != nil {
+		log.Panicf("Error parsing shutdown-timeout duration: %s", err.Error())
+	}
+
+	healthStallThreshold, err := time.ParseDuration(*healthStallThresholdRaw)
+	if err != nil {
+		log.Panicf("Error parsing health-stall-threshold duration: %s", err.Error())
+	}
+
+	var leaderElectionConfig controller.LeaderElectionConfig
+	if *enableLeaderElection {
+		leaderElectionConfig, err = newLeaderElectionConfig(*leaderElectionNamespace, *leaderElectionName, *leaderElectionLeaseDurationRaw, *leaderElectionRenewDeadlineRaw, *leaderElectionRetryPeriodRaw)
+		if err != nil {
+			log.Panicf("Error creating leader election config: %s", err.Error())
+		}
+	}
+
+	domainDeletePolicy, err := rbac.ParseDomainDeletePolicy(*onDomainDelete)
+	if err != nil {
+		log.Panicf("Error parsing on-domain-delete: %s", err.Error())
+	}
+
+	guardClusterWindow, err := time.ParseDuration(*guardClusterWindowRaw)
+	if err != nil {
+		log.Panicf("Error parsing guard-cluster-window duration: %s", err.Error())
+	}
+
+	var guardApprovals *guard.ConfigMapApprovals
+	if *guardApprovalConfigMap != "" {
+		guardApprovals, err = guard.NewConfigMapApprovals(k8sClient, *guardApprovalConfigMap)
+		if err != nil {
+			log.Panicf("Error creating guard approvals: %s", err.Error())
+		}
+	}
+
+	// Destructive change lists exceeding the thresholds are held until approved
+	// with the athenz domain annotation or the approval config map
+	guardConfig := guard.Config{
+		Domain: guard.Thresholds{
+			MaxRemovals:       *guardDomainMaxRemovals,
+			MaxRemovalPercent: *guardDomainMaxRemovalPercent,
+		},
+		Cluster: guard.Thresholds{
+			MaxRemovals:       *guardClusterMaxRemovals,
+			MaxRemovalPercent: *guardClusterMaxRemovalPercent,
+		},
+		Window:    guardClusterWindow,
+		Approvals: guardApprovals,
+	}
+	if *athenzDomainDir != "" && guardApprovals == nil && (*guardDomainMaxRemovals > 0 || *guardDomainMaxRemovalPercent > 0) {
+		log.Warningln("The athenz domains read from a directory cannot be annotated, the changes held by the domain thresholds are only approved with the guard-approval-configmap")
+	}
+
 	// When enableAuthzPolicyController is set to true determine which services,
 	// namespaces or cluster to create Authorization Policies for
 	var componentsEnabledAuthzPolicy *common.ComponentEnabled
@@ -118,10 +289,60 @@ func main() {
 		}
 	}
 
-	c := controller.NewController(*dnsSuffix, istioClient, k8sClient, adClient, istioClientSet, adResyncInterval, crcResyncInterval, apResyncInterval, *enableOriginJwtSubject, *enableAuthzPolicyController, componentsEnabledAuthzPolicy)
+	var statusWriter *syncstatus.Writer
+	if *enableSyncStatus {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			log.Panicf("Error creating dynamic client: %s", err.Error())
+		}
+		statusWriter = syncstatus.NewWriter(dynamicClient)
+	}
+
+	c := controller.NewController(*dnsSuffix, istioClient, k8sClient, adClient, istioClientSet, adResyncInterval, crcResyncInterval, apResyncInterval, *enableOriginJwtSubject, *enableAuthzPolicyController, componentsEnabledAuthzPolicy,
+		rbacProviderMode, *enableOnboardingController, guardConfig, domainDeletePolicy, shutdownTimeout, statusWriter, *athenzDomainDir, common.ParseSelectorLabels(*apSelectorLabels), conversion.maxTrustDepth, conversion.options, *adoptUnlabeledResources)
+
+	if *metricsBindAddress != "" {
+		go serveMetrics(*metricsBindAddress)
+	}
+	if *healthProbeBindAddress != "" {
+		go serveHealthProbes(*healthProbeBindAddress, c, healthStallThreshold)
+	}
+	if *debugBindAddress != "" {
+		go serveDebug(*debugBindAddress, c)
+	}
 
 	stopCh := make(chan struct{})
-	go c.Run(stopCh)
+	namespaceMapper, err := athenz.NewKubeNamespaceMapper(k8sClient, *domainMappingConfigMap, c.EventRecorder())
+	if err != nil {
+		log.Panicf("Error creating namespace mapper: %s", err.Error())
+	}
+	namespaceMapper.AddDomainHandler(c.ProcessDomainMappingEvent)
+	namespaceMapper.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, namespaceMapper.HasSynced) {
+		log.Panicln("Failed to sync namespace mapper cache")
+	}
+	athenz.SetNamespaceMapper(namespaceMapper)
+
+	if guardApprovals != nil {
+		guardApprovals.AddDomainHandler(c.ProcessDomainMappingEvent)
+		guardApprovals.Run(stopCh)
+		if !cache.WaitForCacheSync(stopCh, guardApprovals.HasSynced) {
+			log.Panicln("Failed to sync guard approvals cache")
+		}
+	}
+
+	leaderElectionCh := make(chan error, 1)
+	runDone := make(chan struct{})
+	if *enableLeaderElection {
+		go func() {
+			leaderElectionCh <- c.RunWithLeaderElection(stopCh, k8sClient, leaderElectionConfig)
+		}()
+	} else {
+		go func() {
+			c.Run(stopCh)
+			close(runDone)
+		}()
+	}
 
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
@@ -130,10 +351,83 @@ func main() {
 		case <-signalCh:
 			log.Infoln("Shutdown signal received, stopping controllers...")
 			close(stopCh)
-			// sleep to allow go routines to successfully exit
-			time.Sleep(time.Second)
+			// wait for the workers to drain the queues and the lease to be released
+			if *enableLeaderElection {
+				<-leaderElectionCh
+			} else {
+				<-runDone
+			}
 			log.Infoln("Shutting down...")
 			os.Exit(0)
+		case err := <-leaderElectionCh:
+			// the work queues are shut down once the leadership is lost, the
+			// replica is restarted to stand by again
+			log.Panicf("Leader election stopped: %s", err.Error())
 		}
 	}
 }
+
+// serveMetrics serves the prometheus metrics of the controllers on the /metrics endpoint
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Infof("Serving metrics on %s/metrics", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Errorf("Error serving metrics: %s", err.Error())
+	}
+}
+
+// serveHealthProbes serves the readiness and liveness probes of the controller
+func serveHealthProbes(address string, c *controller.Controller, stallThreshold time.Duration) {
+	handler := health.NewHandler(c.CheckReadiness, func() error {
+		return c.CheckLiveness(stallThreshold)
+	})
+	log.Infof("Serving health probes on %s", address)
+	if err := http.ListenAndServe(address, handler); err != nil {
+		log.Errorf("Error serving health probes: %s", err.Error())
+	}
+}
+
+// serveDebug serves the explain debug endpoint of the controller
+func serveDebug(address string, c *controller.Controller) {
+	mux := http.NewServeMux()
+	mux.Handle(explain.Path, explain.NewHandler(c.Explain))
+	log.Infof("Serving debug endpoints on %s%s", address, explain.Path)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Errorf("Error serving debug endpoints: %s", err.Error())
+	}
+}
+
+// newLeaderElectionConfig returns the leader election config from the command line arguments, the hostname is used
+// as the identity of the replica
+func newLeaderElectionConfig(namespace, name, leaseDurationRaw, renewDeadlineRaw, retryPeriodRaw string) (controller.LeaderElectionConfig, error) {
+	config := controller.LeaderElectionConfig{
+		Namespace: namespace,
+		Name:      name,
+	}
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv(podNamespaceEnv)
+	}
+	if config.Namespace == "" {
+		return config, fmt.Errorf("leader-election-namespace or the %s environment variable must be set", podNamespaceEnv)
+	}
+
+	var err error
+	config.Identity, err = os.Hostname()
+	if err != nil {
+		return config, fmt.Errorf("error getting hostname: %s", err.Error())
+	}
+	config.LeaseDuration, err = time.ParseDuration(leaseDurationRaw)
+	if err != nil {
+		return config, fmt.Errorf("error parsing leader-election-lease-duration: %s", err.Error())
+	}
+	config.RenewDeadline, err = time.ParseDuration(renewDeadlineRaw)
+	if err != nil {
+		return config, fmt.Errorf("error parsing leader-election-renew-deadline: %s", err.Error())
+	}
+	config.RetryPeriod, err = time.ParseDuration(retryPeriodRaw)
+	if err != nil {
+		return config, fmt.Errorf("error parsing leader-election-retry-period: %s", err.Error())
+	}
+	return config, nil
+}
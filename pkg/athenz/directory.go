@@ -0,0 +1,267 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DirectoryListWatch lists and watches the Athenz domains of a directory of signed domain JSON files in the ZMS
+// SignedDomain format, so that an informer can be fed without the AthenzDomain custom resources of the syncer. The
+// domains are named after the Athenz domain name and their resource version is the hash of the file content. Hidden
+// files and files without the .json extension are ignored, and the last valid content of a file is kept if it cannot
+// be parsed, e.g. while it is being written.
+type DirectoryListWatch struct {
+	dir     string
+	mu      sync.Mutex
+	domains map[string]*v1.AthenzDomain
+}
+
+// NewDirectoryListWatch returns a DirectoryListWatch of the signed domain files in the directory
+func NewDirectoryListWatch(dir string) *DirectoryListWatch {
+	return &DirectoryListWatch{
+		dir:     dir,
+		domains: make(map[string]*v1.AthenzDomain),
+	}
+}
+
+// NewDirectoryInformer returns an Athenz domain informer fed with the signed domain files in the directory
+func NewDirectoryInformer(dir string) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(NewDirectoryListWatch(dir), &v1.AthenzDomain{}, 0, cache.Indexers{})
+}
+
+// List reads the signed domain files of the directory and returns them as an AthenzDomainList
+func (d *DirectoryListWatch) List(_ metav1.ListOptions) (runtime.Object, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	domains, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+	d.domains = domains
+
+	list := &v1.AthenzDomainList{}
+	for _, file := range sortedKeys(domains) {
+		list.Items = append(list.Items, *domains[file].DeepCopy())
+	}
+	return list, nil
+}
+
+// Watch watches the directory and sends the changes of the Athenz domains since the last list or event. The watch
+// ends on a watcher error, so that the informer starts a new one.
+func (d *DirectoryListWatch) Watch(_ metav1.ListOptions) (watch.Interface, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating directory watcher: %s", err.Error())
+	}
+	if err := watcher.Add(d.dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("error watching directory %s: %s", d.dir, err.Error())
+	}
+
+	w := &directoryWatch{
+		result: make(chan watch.Event),
+		stopCh: make(chan struct{}),
+	}
+	go d.run(watcher, w)
+	return w, nil
+}
+
+// run sends the changes of the directory to the watch until it is stopped. The directory is read again on each
+// file event since a single change may be reported as several events, e.g. the symlink swap of a mounted config map.
+func (d *DirectoryListWatch) run(watcher *fsnotify.Watcher, w *directoryWatch) {
+	defer close(w.result)
+	defer watcher.Close()
+
+	// changes made between the list and the start of the watcher are sent first
+	if !w.send(d.changes()) {
+		return
+	}
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			log.Debugf("Athenz domain directory event: %s", event.String())
+			if !w.send(d.changes()) {
+				return
+			}
+		case err, ok := <-watcher.Errors:
+			if ok {
+				log.Errorf("Error watching athenz domain directory %s: %s", d.dir, err.Error())
+			}
+			return
+		}
+	}
+}
+
+// changes reads the directory and returns the watch events of the Athenz domains added, modified or deleted since
+// the last read
+func (d *DirectoryListWatch) changes() []watch.Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	domains, err := d.read()
+	if err != nil {
+		log.Errorf("Error reading athenz domain directory: %s", err.Error())
+		return nil
+	}
+
+	previous := byDomainName(d.domains)
+	current := byDomainName(domains)
+	d.domains = domains
+
+	var events []watch.Event
+	for _, name := range sortedKeys(current) {
+		athenzDomain := current[name]
+		old, exists := previous[name]
+		switch {
+		case !exists:
+			events = append(events, watch.Event{Type: watch.Added, Object: athenzDomain.DeepCopy()})
+		case old.ResourceVersion != athenzDomain.ResourceVersion:
+			events = append(events, watch.Event{Type: watch.Modified, Object: athenzDomain.DeepCopy()})
+		}
+	}
+	for _, name := range sortedKeys(previous) {
+		if _, exists := current[name]; !exists {
+			events = append(events, watch.Event{Type: watch.Deleted, Object: previous[name].DeepCopy()})
+		}
+	}
+	return events
+}
+
+// read returns the Athenz domains of the signed domain files of the directory by file name. The previously read
+// domain is kept for the files which cannot be read or parsed, and the files of a domain already defined by an
+// alphabetically prior file are skipped.
+func (d *DirectoryListWatch) read() (map[string]*v1.AthenzDomain, error) {
+	files, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string]*v1.AthenzDomain)
+	names := make(map[string]string)
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		athenzDomain, err := readSignedDomainFile(filepath.Join(d.dir, file.Name()))
+		if err != nil {
+			previous, exists := d.domains[file.Name()]
+			if !exists {
+				log.Errorf("Error reading athenz domain file %s, skipping: %s", file.Name(), err.Error())
+				continue
+			}
+			log.Errorf("Error reading athenz domain file %s, keeping its previous content: %s", file.Name(), err.Error())
+			athenzDomain = previous
+		}
+
+		if other, exists := names[athenzDomain.Name]; exists {
+			log.Errorf("Athenz domain %s of file %s is already defined in file %s, skipping", athenzDomain.Name, file.Name(), other)
+			continue
+		}
+		names[athenzDomain.Name] = file.Name()
+		domains[file.Name()] = athenzDomain
+	}
+	return domains, nil
+}
+
+// readSignedDomainFile returns the Athenz domain of a signed domain file
+func readSignedDomainFile(path string) (*v1.AthenzDomain, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var signedDomain zms.SignedDomain
+	if err := json.Unmarshal(data, &signedDomain); err != nil {
+		return nil, err
+	}
+	if signedDomain.Domain == nil || signedDomain.Domain.Name == "" {
+		return nil, fmt.Errorf("signed domain does not have a domain name")
+	}
+
+	hash := fnv.New64a()
+	hash.Write(data)
+	return &v1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            string(signedDomain.Domain.Name),
+			ResourceVersion: fmt.Sprintf("%x", hash.Sum64()),
+		},
+		Spec: v1.AthenzDomainSpec{
+			SignedDomain: signedDomain,
+		},
+	}, nil
+}
+
+// byDomainName returns the Athenz domains by domain name
+func byDomainName(domains map[string]*v1.AthenzDomain) map[string]*v1.AthenzDomain {
+	named := make(map[string]*v1.AthenzDomain, len(domains))
+	for _, athenzDomain := range domains {
+		named[athenzDomain.Name] = athenzDomain
+	}
+	return named
+}
+
+// sortedKeys returns the keys of the Athenz domain map in alphabetical order
+func sortedKeys(domains map[string]*v1.AthenzDomain) []string {
+	keys := make([]string, 0, len(domains))
+	for key := range domains {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// directoryWatch is the watch.Interface of a DirectoryListWatch
+type directoryWatch struct {
+	result   chan watch.Event
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// Stop stops the watch, the result channel is closed once the watcher goroutine has exited
+func (w *directoryWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+}
+
+// ResultChan returns the channel of the Athenz domain events
+func (w *directoryWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// send sends the events to the result channel, it returns false if the watch was stopped
+func (w *directoryWatch) send(events []watch.Event) bool {
+	for _, event := range events {
+		select {
+		case w.result <- event:
+		case <-w.stopCh:
+			return false
+		}
+	}
+	return true
+}
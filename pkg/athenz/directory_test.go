@@ -0,0 +1,145 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// writeSignedDomainFile writes a signed domain file with a role of the given name
+func writeSignedDomainFile(t *testing.T, dir, file, domain, role string) {
+	data := fmt.Sprintf(`{
+  "domain": {
+    "name": "%s",
+    "modified": "2021-01-01T00:00:00.000Z",
+    "roles": [{"name": "%s:role.%s", "roleMembers": [{"memberName": "user.name"}]}],
+    "policies": {"contents": {"domain": "%s", "policies": []}, "keyId": "0", "signature": "signature"}
+  },
+  "keyId": "0",
+  "signature": "signature"
+}`, domain, domain, role, domain)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0644))
+}
+
+func newTestDirectory(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "athenz-domains")
+	assert.Nil(t, err)
+	return dir
+}
+
+func TestDirectoryListWatchList(t *testing.T) {
+	dir := newTestDirectory(t)
+	defer os.RemoveAll(dir)
+
+	writeSignedDomainFile(t, dir, "a.json", "test.namespace", "reader")
+	writeSignedDomainFile(t, dir, "b.json", "test.namespace", "writer")
+	writeSignedDomainFile(t, dir, "c.json", "other.namespace", "reader")
+	writeSignedDomainFile(t, dir, ".hidden.json", "hidden.namespace", "reader")
+	writeSignedDomainFile(t, dir, "d.yaml", "yaml.namespace", "reader")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "e.json"), []byte("{"), 0644))
+
+	d := NewDirectoryListWatch(dir)
+	obj, err := d.List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	list, ok := obj.(*v1.AthenzDomainList)
+	assert.True(t, ok, "list should be an athenz domain list")
+
+	var names []string
+	for _, athenzDomain := range list.Items {
+		names = append(names, athenzDomain.Name)
+		assert.NotEmpty(t, athenzDomain.ResourceVersion, "resource version should be set")
+	}
+	assert.Equal(t, []string{"test.namespace", "other.namespace"}, names, "only the valid json files should be listed, the first file of a domain wins")
+	assert.Equal(t, "test.namespace:role.reader", string(list.Items[0].Spec.Domain.Roles[0].Name))
+
+	// the last valid content is kept while a file cannot be parsed
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("{"), 0644))
+	obj, err = d.List(metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, obj.(*v1.AthenzDomainList).Items, 2)
+	assert.Equal(t, list.Items[0].ResourceVersion, obj.(*v1.AthenzDomainList).Items[0].ResourceVersion)
+
+	_, err = NewDirectoryListWatch(filepath.Join(dir, "missing")).List(metav1.ListOptions{})
+	assert.NotNil(t, err, "listing a missing directory should fail")
+}
+
+func TestDirectoryListWatchWatch(t *testing.T) {
+	dir := newTestDirectory(t)
+	defer os.RemoveAll(dir)
+
+	writeSignedDomainFile(t, dir, "a.json", "test.namespace", "reader")
+	d := NewDirectoryListWatch(dir)
+	_, err := d.List(metav1.ListOptions{})
+	assert.Nil(t, err)
+
+	// the domain added between the list and the watch is sent first
+	writeSignedDomainFile(t, dir, "b.json", "other.namespace", "reader")
+	w, err := d.Watch(metav1.ListOptions{})
+	assert.Nil(t, err)
+	defer w.Stop()
+
+	next := func() watch.Event {
+		select {
+		case event := <-w.ResultChan():
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a watch event")
+			return watch.Event{}
+		}
+	}
+	eventOf := func(event watch.Event) (watch.EventType, string) {
+		return event.Type, event.Object.(*v1.AthenzDomain).Name
+	}
+
+	eventType, name := eventOf(next())
+	assert.Equal(t, watch.Added, eventType)
+	assert.Equal(t, "other.namespace", name)
+
+	writeSignedDomainFile(t, dir, "a.json", "test.namespace", "writer")
+	eventType, name = eventOf(next())
+	assert.Equal(t, watch.Modified, eventType)
+	assert.Equal(t, "test.namespace", name)
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, "b.json")))
+	eventType, name = eventOf(next())
+	assert.Equal(t, watch.Deleted, eventType)
+	assert.Equal(t, "other.namespace", name)
+
+	w.Stop()
+	for range w.ResultChan() {
+	}
+}
+
+func TestNewDirectoryInformer(t *testing.T) {
+	dir := newTestDirectory(t)
+	defer os.RemoveAll(dir)
+
+	writeSignedDomainFile(t, dir, "a.json", "test.namespace", "reader")
+	informer := NewDirectoryInformer(dir)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+	assert.True(t, cache.WaitForCacheSync(stopCh, informer.HasSynced))
+
+	_, exists, err := informer.GetIndexer().GetByKey("test.namespace")
+	assert.Nil(t, err)
+	assert.True(t, exists, "athenz domain should be in the informer cache")
+
+	writeSignedDomainFile(t, dir, "b.json", "other.namespace", "reader")
+	assert.Eventually(t, func() bool {
+		_, exists, _ := informer.GetIndexer().GetByKey("other.namespace")
+		return exists
+	}, 5*time.Second, 10*time.Millisecond, "added athenz domain should be in the informer cache")
+}
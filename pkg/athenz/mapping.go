@@ -0,0 +1,382 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// DomainAnnotation is the namespace annotation used to map a namespace to an Athenz domain
+	DomainAnnotation = "athenz.io/domain"
+	domainIndex      = "domain"
+	// MappingConflictEventReason is the reason of the events recorded on the namespaces or the mapping table
+	// ConfigMap when several namespaces are mapped to the same Athenz domain
+	MappingConflictEventReason = "NamespaceMappingConflict"
+)
+
+// NamespaceMapper maps Athenz domains to Kubernetes namespaces and back
+type NamespaceMapper interface {
+	// DomainToNamespace returns the namespace governed by the Athenz domain
+	DomainToNamespace(domain string) string
+	// NamespaceToDomain returns the Athenz domain governing the namespace
+	NamespaceToDomain(namespace string) string
+}
+
+// conventionMapper maps Athenz domains to namespaces using the dot / dash naming convention
+type conventionMapper struct{}
+
+func (conventionMapper) DomainToNamespace(domain string) string {
+	return DomainToNamespace(domain)
+}
+
+func (conventionMapper) NamespaceToDomain(namespace string) string {
+	return NamespaceToDomain(namespace)
+}
+
+var (
+	mapperLock sync.RWMutex
+	mapper     NamespaceMapper = conventionMapper{}
+)
+
+// SetNamespaceMapper sets the mapper used to resolve the namespace of an Athenz domain and the Athenz domain of a
+// namespace, the dot / dash naming convention is used if it is not set
+func SetNamespaceMapper(m NamespaceMapper) {
+	mapperLock.Lock()
+	defer mapperLock.Unlock()
+	mapper = m
+}
+
+// ResolveNamespace returns the namespace governed by the Athenz domain using the configured namespace mapper
+func ResolveNamespace(domain string) string {
+	mapperLock.RLock()
+	defer mapperLock.RUnlock()
+	return mapper.DomainToNamespace(domain)
+}
+
+// ResolveDomain returns the Athenz domain governing the namespace using the configured namespace mapper
+func ResolveDomain(namespace string) string {
+	mapperLock.RLock()
+	defer mapperLock.RUnlock()
+	return mapper.NamespaceToDomain(namespace)
+}
+
+// KubeNamespaceMapper resolves the Athenz domain of a namespace in the following order:
+// 1. DomainAnnotation on the Namespace object
+// 2. Mapping table in a ConfigMap, the data keys are namespaces and the values are Athenz domains
+// 3. Dot / dash naming convention
+// An Athenz domain annotated on several namespaces, or mapped to several namespaces in the mapping table, is not
+// mapped to any namespace until the conflict is resolved.
+type KubeNamespaceMapper struct {
+	namespaceIndexInformer cache.SharedIndexInformer
+	configMapIndexInformer cache.SharedIndexInformer
+	configMapKey           string
+	recorder               record.EventRecorder
+	// conflicts holds the conflicting namespaces of each domain which were last reported
+	conflictsLock sync.Mutex
+	conflicts     map[string]string
+}
+
+// NewKubeNamespaceMapper returns a KubeNamespaceMapper watching the namespaces in the cluster and the mapping table
+// ConfigMap in the <namespace>/<name> format, the mapping table is not used if configMapKey is empty. The mapping
+// conflicts are recorded as events with the recorder if it is not nil.
+func NewKubeNamespaceMapper(k8sClient kubernetes.Interface, configMapKey string, recorder record.EventRecorder) (*KubeNamespaceMapper, error) {
+	namespaceListWatch := cache.NewListWatchFromClient(k8sClient.CoreV1().RESTClient(), "namespaces", corev1.NamespaceAll, fields.Everything())
+	namespaceIndexInformer := cache.NewSharedIndexInformer(namespaceListWatch, &corev1.Namespace{}, 0, cache.Indexers{
+		domainIndex: namespaceDomainIndexFunc,
+	})
+
+	m := &KubeNamespaceMapper{
+		namespaceIndexInformer: namespaceIndexInformer,
+		recorder:               recorder,
+		conflicts:              make(map[string]string),
+	}
+
+	if configMapKey != "" {
+		namespace, name, err := cache.SplitMetaNamespaceKey(configMapKey)
+		if err != nil || namespace == "" || name == "" {
+			return nil, fmt.Errorf("config map %s is not in the <namespace>/<name> format", configMapKey)
+		}
+		configMapListWatch := cache.NewListWatchFromClient(k8sClient.CoreV1().RESTClient(), "configmaps", namespace, fields.OneTermEqualSelector("metadata.name", name))
+		m.configMapIndexInformer = cache.NewSharedIndexInformer(configMapListWatch, &corev1.ConfigMap{}, 0, nil)
+		m.configMapKey = configMapKey
+	}
+
+	return m, nil
+}
+
+// namespaceDomainIndexFunc indexes the namespaces by the value of the DomainAnnotation
+func namespaceDomainIndexFunc(obj interface{}) ([]string, error) {
+	namespace, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return nil, fmt.Errorf("namespace cast failed")
+	}
+	if domain, exists := namespace.Annotations[DomainAnnotation]; exists && domain != "" {
+		return []string{domain}, nil
+	}
+	return nil, nil
+}
+
+// Run starts the namespace and config map informers
+func (m *KubeNamespaceMapper) Run(stopCh <-chan struct{}) {
+	go m.namespaceIndexInformer.Run(stopCh)
+	if m.configMapIndexInformer != nil {
+		go m.configMapIndexInformer.Run(stopCh)
+	}
+}
+
+// HasSynced returns true once the namespace and config map informers have synced
+func (m *KubeNamespaceMapper) HasSynced() bool {
+	if m.configMapIndexInformer != nil && !m.configMapIndexInformer.HasSynced() {
+		return false
+	}
+	return m.namespaceIndexInformer.HasSynced()
+}
+
+// getMappingTable returns the namespace to Athenz domain mapping table from the config map
+func (m *KubeNamespaceMapper) getMappingTable() map[string]string {
+	configMap := m.getConfigMap()
+	if configMap == nil {
+		return nil
+	}
+	return configMap.Data
+}
+
+// getConfigMap returns the mapping table config map, nil if it is not used or does not exist
+func (m *KubeNamespaceMapper) getConfigMap() *corev1.ConfigMap {
+	if m.configMapIndexInformer == nil {
+		return nil
+	}
+	obj, exists, err := m.configMapIndexInformer.GetIndexer().GetByKey(m.configMapKey)
+	if err != nil || !exists {
+		return nil
+	}
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		log.Errorln("Could not cast to config map object, skipping namespace mapping table")
+		return nil
+	}
+	return configMap
+}
+
+// DomainToNamespace returns the namespace annotated with the Athenz domain, the namespace mapped to the Athenz domain
+// in the mapping table, or the namespace by convention. An empty namespace is returned if the domain is annotated on
+// multiple namespaces or mapped to multiple namespaces in the mapping table, or if the namespace by convention is
+// mapped to another domain.
+func (m *KubeNamespaceMapper) DomainToNamespace(domain string) string {
+	objs, err := m.namespaceIndexInformer.GetIndexer().ByIndex(domainIndex, domain)
+	if err != nil {
+		log.Errorf("Error looking up the namespace annotated with domain %s: %s", domain, err.Error())
+	}
+	namespaces := make([]string, 0, len(objs))
+	conflicting := make([]runtime.Object, 0, len(objs))
+	for _, obj := range objs {
+		if namespace, ok := obj.(*corev1.Namespace); ok {
+			namespaces = append(namespaces, namespace.Name)
+			conflicting = append(conflicting, namespace)
+		}
+	}
+	if len(namespaces) > 0 {
+		return m.resolveConflict(domain, "annotated on", namespaces, conflicting)
+	}
+
+	for namespace, mappedDomain := range m.getMappingTable() {
+		if strings.TrimSpace(mappedDomain) == domain {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	if len(namespaces) > 0 {
+		var configMap []runtime.Object
+		if obj := m.getConfigMap(); obj != nil {
+			configMap = append(configMap, obj)
+		}
+		return m.resolveConflict(domain, "mapped in table "+m.configMapKey+" to", namespaces, configMap)
+	}
+	m.forgetConflict(domain)
+
+	namespace := DomainToNamespace(domain)
+	governing := m.mappedDomain(namespace)
+	if governing == "" {
+		governing = NamespaceToDomain(namespace)
+	}
+	if governing != domain {
+		log.Debugf("Domain %s is not mapped to namespace %s governed by domain %s", domain, namespace, governing)
+		return ""
+	}
+	return namespace
+}
+
+// resolveConflict returns the only namespace the domain is mapped to. If the domain is mapped to several namespaces,
+// the conflict is logged and recorded as an event on the objects mapping the namespaces once per set of namespaces,
+// and an empty namespace is returned rather than an arbitrary one.
+func (m *KubeNamespaceMapper) resolveConflict(domain string, source string, namespaces []string, objects []runtime.Object) string {
+	if len(namespaces) == 1 {
+		m.forgetConflict(domain)
+		return namespaces[0]
+	}
+
+	sort.Strings(namespaces)
+	conflict := strings.Join(namespaces, ", ")
+	m.conflictsLock.Lock()
+	reported := m.conflicts[domain] == conflict
+	m.conflicts[domain] = conflict
+	m.conflictsLock.Unlock()
+	if reported {
+		return ""
+	}
+
+	message := fmt.Sprintf("Athenz domain %s is %s several namespaces: %s, the domain is not mapped to any namespace until the conflict is resolved", domain, source, conflict)
+	log.Errorln(message)
+	if m.recorder != nil {
+		for _, obj := range objects {
+			m.recorder.Event(obj, corev1.EventTypeWarning, MappingConflictEventReason, message)
+		}
+	}
+	return ""
+}
+
+// forgetConflict forgets the last reported conflict of the domain, so that a new conflict is reported again
+func (m *KubeNamespaceMapper) forgetConflict(domain string) {
+	m.conflictsLock.Lock()
+	defer m.conflictsLock.Unlock()
+	delete(m.conflicts, domain)
+}
+
+// NamespaceToDomain returns the Athenz domain from the namespace annotation, the mapping table, or by convention. An
+// empty domain is returned if the domain by convention is mapped to another namespace.
+func (m *KubeNamespaceMapper) NamespaceToDomain(namespace string) string {
+	if domain := m.mappedDomain(namespace); domain != "" {
+		return domain
+	}
+
+	domain := NamespaceToDomain(namespace)
+	if mapped := m.DomainToNamespace(domain); mapped != namespace {
+		log.Debugf("Namespace %s is not governed by domain %s mapped to namespace %s", namespace, domain, mapped)
+		return ""
+	}
+	return domain
+}
+
+// mappedDomain returns the Athenz domain from the namespace annotation or the mapping table, an empty domain is
+// returned if the namespace is neither annotated nor in the mapping table
+func (m *KubeNamespaceMapper) mappedDomain(namespace string) string {
+	obj, exists, err := m.namespaceIndexInformer.GetIndexer().GetByKey(namespace)
+	if err != nil {
+		log.Errorf("Error looking up namespace %s: %s", namespace, err.Error())
+	}
+	if exists {
+		if ns, ok := obj.(*corev1.Namespace); ok {
+			if domain, exists := ns.Annotations[DomainAnnotation]; exists && domain != "" {
+				return domain
+			}
+		}
+	}
+
+	if domain, exists := m.getMappingTable()[namespace]; exists && strings.TrimSpace(domain) != "" {
+		return strings.TrimSpace(domain)
+	}
+	return ""
+}
+
+// AddDomainHandler registers a handler called with the Athenz domains whose namespace may have changed, on a change
+// of the DomainAnnotation of a namespace or of the mapping table. The handler is called with the domains mapped to
+// the namespace before and after the change and with the domain of the namespace by convention.
+func (m *KubeNamespaceMapper) AddDomainHandler(handler func(domain string)) {
+	m.namespaceIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			m.processNamespaceEvent(nil, obj, handler)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			m.processNamespaceEvent(oldObj, newObj, handler)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			m.processNamespaceEvent(obj, nil, handler)
+		},
+	})
+	if m.configMapIndexInformer == nil {
+		return
+	}
+	m.configMapIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			m.processConfigMapEvent(nil, obj, handler)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			m.processConfigMapEvent(oldObj, newObj, handler)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			m.processConfigMapEvent(obj, nil, handler)
+		},
+	})
+}
+
+// processNamespaceEvent calls the handler with the affected domains if the DomainAnnotation of the namespace changed
+func (m *KubeNamespaceMapper) processNamespaceEvent(oldObj, newObj interface{}, handler func(domain string)) {
+	var name, oldDomain, newDomain string
+	if namespace, ok := oldObj.(*corev1.Namespace); ok {
+		name, oldDomain = namespace.Name, namespace.Annotations[DomainAnnotation]
+	}
+	if namespace, ok := newObj.(*corev1.Namespace); ok {
+		name, newDomain = namespace.Name, namespace.Annotations[DomainAnnotation]
+	}
+	if oldDomain == newDomain {
+		return
+	}
+	m.notifyDomainHandler(name, handler, oldDomain, newDomain, strings.TrimSpace(m.getMappingTable()[name]))
+}
+
+// processConfigMapEvent calls the handler with the affected domains of every namespace changed in the mapping table
+func (m *KubeNamespaceMapper) processConfigMapEvent(oldObj, newObj interface{}, handler func(domain string)) {
+	var oldTable, newTable map[string]string
+	if configMap, ok := oldObj.(*corev1.ConfigMap); ok {
+		oldTable = configMap.Data
+	}
+	if configMap, ok := newObj.(*corev1.ConfigMap); ok {
+		newTable = configMap.Data
+	}
+	namespaces := make(map[string]bool, len(oldTable)+len(newTable))
+	for namespace := range oldTable {
+		namespaces[namespace] = true
+	}
+	for namespace := range newTable {
+		namespaces[namespace] = true
+	}
+	for namespace := range namespaces {
+		oldDomain, newDomain := strings.TrimSpace(oldTable[namespace]), strings.TrimSpace(newTable[namespace])
+		if oldDomain == newDomain {
+			continue
+		}
+		m.notifyDomainHandler(namespace, handler, oldDomain, newDomain)
+	}
+}
+
+// notifyDomainHandler calls the handler once with each of the non empty domains and the domain of the namespace by
+// convention, the domains no longer mapped to the namespace need to clean up its resources
+func (m *KubeNamespaceMapper) notifyDomainHandler(namespace string, handler func(domain string), domains ...string) {
+	notified := make(map[string]bool, len(domains)+1)
+	for _, domain := range append(domains, NamespaceToDomain(namespace)) {
+		if domain == "" || notified[domain] {
+			continue
+		}
+		notified[domain] = true
+		log.Infof("Namespace mapping of namespace %s changed, notifying domain %s", namespace, domain)
+		handler(domain)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newFakeNamespace(name, domain string) *corev1.Namespace {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	if domain != "" {
+		namespace.Annotations = map[string]string{DomainAnnotation: domain}
+	}
+	return namespace
+}
+
+func TestNewKubeNamespaceMapper(t *testing.T) {
+	cases := []struct {
+		test         string
+		configMapKey string
+		expectedErr  error
+	}{
+		{
+			test:         "without mapping table",
+			configMapKey: "",
+		},
+		{
+			test:         "with mapping table",
+			configMapKey: "kube-system/athenz-domain-mapping",
+		},
+		{
+			test:         "invalid mapping table key",
+			configMapKey: "athenz-domain-mapping",
+			expectedErr:  fmt.Errorf("config map athenz-domain-mapping is not in the <namespace>/<name> format"),
+		},
+	}
+
+	for _, c := range cases {
+		_, err := NewKubeNamespaceMapper(fake.NewSimpleClientset(), c.configMapKey, nil)
+		assert.Equal(t, c.expectedErr, err, c.test)
+	}
+}
+
+func TestKubeNamespaceMapper(t *testing.T) {
+	m, err := NewKubeNamespaceMapper(fake.NewSimpleClientset(), "kube-system/athenz-domain-mapping", nil)
+	assert.Nil(t, err, "error should be nil")
+
+	namespaces := []*corev1.Namespace{
+		newFakeNamespace("annotated", "annotated.domain"),
+		newFakeNamespace("annotated-and-mapped", "precedence.domain"),
+		newFakeNamespace("mapped", ""),
+		newFakeNamespace("convention-domain", ""),
+		newFakeNamespace("annotated-domain", ""),
+		newFakeNamespace("mapped-domain", ""),
+		newFakeNamespace("foo-bar", "remapped.domain"),
+	}
+	for _, namespace := range namespaces {
+		assert.Nil(t, m.namespaceIndexInformer.GetIndexer().Add(namespace), "error should be nil")
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "athenz-domain-mapping",
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{
+			"mapped":               "mapped.domain",
+			"annotated-and-mapped": "other.domain",
+			"unknown":              " table.domain ",
+			"baz-qux":              "remapped.domain",
+		},
+	}
+	assert.Nil(t, m.configMapIndexInformer.GetIndexer().Add(configMap), "error should be nil")
+
+	namespaceToDomain := []struct {
+		namespace string
+		expected  string
+	}{
+		{namespace: "annotated", expected: "annotated.domain"},
+		{namespace: "annotated-and-mapped", expected: "precedence.domain"},
+		{namespace: "mapped", expected: "mapped.domain"},
+		{namespace: "unknown", expected: "table.domain"},
+		{namespace: "convention-domain", expected: "convention.domain"},
+		{namespace: "annotated-domain", expected: ""},
+		{namespace: "mapped-domain", expected: ""},
+		{namespace: "foo-bar", expected: "remapped.domain"},
+		{namespace: "baz-qux", expected: "remapped.domain"},
+	}
+	for _, c := range namespaceToDomain {
+		assert.Equal(t, c.expected, m.NamespaceToDomain(c.namespace), "domain should be equal for namespace "+c.namespace)
+	}
+
+	domainToNamespace := []struct {
+		domain   string
+		expected string
+	}{
+		{domain: "annotated.domain", expected: "annotated"},
+		{domain: "precedence.domain", expected: "annotated-and-mapped"},
+		{domain: "mapped.domain", expected: "mapped"},
+		{domain: "table.domain", expected: "unknown"},
+		{domain: "convention.domain", expected: "convention-domain"},
+		{domain: "foo.bar", expected: ""},
+		{domain: "baz.qux", expected: ""},
+	}
+	for _, c := range domainToNamespace {
+		assert.Equal(t, c.expected, m.DomainToNamespace(c.domain), "namespace should be equal for domain "+c.domain)
+	}
+}
+
+func TestKubeNamespaceMapperConflicts(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	m, err := NewKubeNamespaceMapper(fake.NewSimpleClientset(), "kube-system/athenz-domain-mapping", recorder)
+	assert.Nil(t, err, "error should be nil")
+
+	for _, namespace := range []*corev1.Namespace{newFakeNamespace("first", "annotated.domain"), newFakeNamespace("second", "annotated.domain")} {
+		assert.Nil(t, m.namespaceIndexInformer.GetIndexer().Add(namespace), "error should be nil")
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "athenz-domain-mapping",
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{
+			"mapped":       "mapped.domain",
+			"also-mapped":  "mapped.domain",
+			"mapped-other": "other.domain",
+		},
+	}
+	assert.Nil(t, m.configMapIndexInformer.GetIndexer().Add(configMap), "error should be nil")
+
+	assert.Equal(t, "", m.DomainToNamespace("annotated.domain"), "domain annotated on several namespaces should not be mapped")
+	assert.Equal(t, "", m.DomainToNamespace("annotated.domain"), "domain annotated on several namespaces should not be mapped")
+	assert.Equal(t, "", m.DomainToNamespace("mapped.domain"), "domain mapped to several namespaces should not be mapped")
+	assert.Equal(t, "mapped-other", m.DomainToNamespace("other.domain"), "domain mapped to one namespace should be mapped")
+	assert.Len(t, recorder.Events, 3, "conflict should be recorded once on each annotated namespace and on the config map")
+	expected := "Warning NamespaceMappingConflict Athenz domain annotated.domain is annotated on several namespaces: first, second, " +
+		"the domain is not mapped to any namespace until the conflict is resolved"
+	assert.Equal(t, expected, <-recorder.Events, "event should be equal")
+	<-recorder.Events
+	expected = "Warning NamespaceMappingConflict Athenz domain mapped.domain is mapped in table kube-system/athenz-domain-mapping to several namespaces: " +
+		"also-mapped, mapped, the domain is not mapped to any namespace until the conflict is resolved"
+	assert.Equal(t, expected, <-recorder.Events, "event should be equal")
+
+	assert.Nil(t, m.namespaceIndexInformer.GetIndexer().Delete(newFakeNamespace("second", "annotated.domain")), "error should be nil")
+	assert.Equal(t, "first", m.DomainToNamespace("annotated.domain"), "domain should be mapped once the conflict is resolved")
+	assert.Nil(t, m.namespaceIndexInformer.GetIndexer().Add(newFakeNamespace("third", "annotated.domain")), "error should be nil")
+	assert.Equal(t, "", m.DomainToNamespace("annotated.domain"), "new conflict should not be mapped")
+	assert.Len(t, recorder.Events, 2, "new conflict should be recorded again")
+}
+
+func TestKubeNamespaceMapperDomainHandler(t *testing.T) {
+	m, err := NewKubeNamespaceMapper(fake.NewSimpleClientset(), "kube-system/athenz-domain-mapping", nil)
+	assert.Nil(t, err, "error should be nil")
+
+	var domains []string
+	handler := func(domain string) {
+		domains = append(domains, domain)
+	}
+
+	m.processNamespaceEvent(nil, newFakeNamespace("home", "home.domain"), handler)
+	assert.Equal(t, []string{"home.domain", "home"}, domains, "annotated and convention domains should be notified on add")
+
+	domains = nil
+	m.processNamespaceEvent(newFakeNamespace("home", "home.domain"), newFakeNamespace("home", "home.domain"), handler)
+	assert.Nil(t, domains, "no domain should be notified without an annotation change")
+
+	domains = nil
+	m.processNamespaceEvent(newFakeNamespace("foo-bar", "home.domain"), newFakeNamespace("foo-bar", "other.domain"), handler)
+	assert.Equal(t, []string{"home.domain", "other.domain", "foo.bar"}, domains, "old, new and convention domains should be notified on update")
+
+	domains = nil
+	m.processNamespaceEvent(newFakeNamespace("home", "home.domain"), nil, handler)
+	assert.Equal(t, []string{"home.domain", "home"}, domains, "annotated and convention domains should be notified on delete")
+
+	newConfigMap := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "athenz-domain-mapping",
+				Namespace: "kube-system",
+			},
+			Data: data,
+		}
+	}
+
+	domains = nil
+	m.processConfigMapEvent(newConfigMap(map[string]string{"kept": "kept.domain", "moved": "old.domain"}),
+		newConfigMap(map[string]string{"kept": "kept.domain", "moved": " new.domain "}), handler)
+	assert.Equal(t, []string{"old.domain", "new.domain", "moved"}, domains, "old, new and convention domains of the changed namespace should be notified")
+
+	domains = nil
+	m.processConfigMapEvent(newConfigMap(map[string]string{"removed": "removed.domain"}), nil, handler)
+	assert.Equal(t, []string{"removed.domain", "removed"}, domains, "domains of the removed table should be notified")
+}
+
+func TestSetNamespaceMapper(t *testing.T) {
+	assert.Equal(t, "home-domain", ResolveNamespace("home.domain"), "convention should be used by default")
+	assert.Equal(t, "home.domain", ResolveDomain("home-domain"), "convention should be used by default")
+
+	m, err := NewKubeNamespaceMapper(fake.NewSimpleClientset(), "", nil)
+	assert.Nil(t, err, "error should be nil")
+	assert.Nil(t, m.namespaceIndexInformer.GetIndexer().Add(newFakeNamespace("home", "home.domain")), "error should be nil")
+
+	SetNamespaceMapper(m)
+	defer SetNamespaceMapper(conventionMapper{})
+	assert.Equal(t, "home", ResolveNamespace("home.domain"), "namespace should be resolved from the annotation")
+	assert.Equal(t, "home.domain", ResolveDomain("home"), "domain should be resolved from the annotation")
+}
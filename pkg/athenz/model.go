@@ -4,16 +4,20 @@
 package athenz
 
 import (
-	"fmt"
-	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/ardielle/ardielle-go/rdl"
 	"github.com/yahoo/athenz/clients/go/zms"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
-	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
+// memberExpiryDelay is added to the member expiration to make sure the member has expired at sync time
+const memberExpiryDelay = time.Second
+
 var roleReplacer = strings.NewReplacer("*", ".*", "?", ".", "^", "\\^", "$", "\\$", ".", "\\.", "|", "\\|", "[", "\\[", "+", "\\+", "\\", "\\\\", "(", "\\(", ")", "\\)", "{", "\\{")
 
 // Athenz data structures the way we would want
@@ -29,14 +33,37 @@ type RoleMembers map[zms.ResourceName][]*zms.RoleMember
 // map of Group:GroupMembers for an Athenz domain
 type GroupMembers map[zms.MemberName][]*zms.GroupMember
 
+// map of Role:TrustDomains visited while resolving the members of the delegated roles in an Athenz domain
+type RoleTrustDomains map[zms.ResourceName][]zms.DomainName
+
+// ConditionTagPrefix is the prefix of the role tags holding the conditions of the requests matching the assertions
+// of the role, e.g. the istio.when.source.ip tag holds the source ips allowed for the role
+const ConditionTagPrefix = "istio.when."
+
+// tag key suffixes of the conditions on a named attribute, the name is surrounded by brackets in the condition key,
+// e.g. request.headers[x-env] for the istio.when.request.headers.x-env tag
+var namedConditionKeys = []string{"request.headers", "request.auth.claims"}
+
+// Condition is a constraint on the requests matching the assertions of a role, the key is an authorization policy
+// condition key such as source.ip or request.headers[x-env] and the request matches if one of the values matches
+type Condition struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+// map of Role:Conditions for an Athenz domain
+type RoleConditions map[zms.ResourceName][]Condition
+
 // RBAC object to hold the policies for an Athenz domain
 type Model struct {
-	Name         zms.DomainName `json:"name"`
-	Namespace    string         `json:"namespace"`
-	Roles        Roles          `json:"roles,omitempty"`
-	Rules        RoleAssertions `json:"rules,omitempty"`
-	Members      RoleMembers    `json:"members,omitempty"`
-	GroupMembers GroupMembers   `json:"groups,omitempty"`
+	Name         zms.DomainName   `json:"name"`
+	Namespace    string           `json:"namespace"`
+	Roles        Roles            `json:"roles,omitempty"`
+	Rules        RoleAssertions   `json:"rules,omitempty"`
+	Members      RoleMembers      `json:"members,omitempty"`
+	GroupMembers GroupMembers     `json:"groups,omitempty"`
+	TrustDomains RoleTrustDomains `json:"trustDomains,omitempty"`
+	Conditions   RoleConditions   `json:"conditions,omitempty"`
 }
 
 // getRolesForDomain returns the role names list in the same order as defined on the Athenz domain
@@ -87,30 +114,88 @@ func getRulesForDomain(domain *zms.DomainData) RoleAssertions {
 	return rules
 }
 
-// getMembersForRole returns the members for each role in an Athenz domain
-func getMembersForRole(domain *zms.DomainData, crCache *cache.SharedIndexInformer) RoleMembers {
+// getConditionsForRole returns the conditions of the roles with condition tags in an Athenz domain
+func getConditionsForRole(domain *zms.DomainData) RoleConditions {
+	roleConditions := make(RoleConditions)
+
+	if domain == nil || domain.Roles == nil {
+		return roleConditions
+	}
+
+	for _, role := range domain.Roles {
+		if conditions := GetRoleConditions(role); len(conditions) > 0 {
+			roleConditions[zms.ResourceName(role.Name)] = conditions
+		}
+	}
+
+	return roleConditions
+}
+
+// GetRoleConditions returns the conditions of the condition tags of a role sorted by key, the key of the condition is
+// the tag key without the ConditionTagPrefix, with the name of the named attributes surrounded by brackets
+func GetRoleConditions(role *zms.Role) []Condition {
+	if role == nil {
+		return nil
+	}
+
+	var conditions []Condition
+	for tagKey, tagValues := range role.Tags {
+		if !strings.HasPrefix(string(tagKey), ConditionTagPrefix) {
+			continue
+		}
+		condition := Condition{Key: strings.TrimPrefix(string(tagKey), ConditionTagPrefix)}
+		for _, prefix := range namedConditionKeys {
+			if strings.HasPrefix(condition.Key, prefix+".") {
+				condition.Key = prefix + "[" + strings.TrimPrefix(condition.Key, prefix+".") + "]"
+				break
+			}
+		}
+		if tagValues != nil {
+			for _, value := range tagValues.List {
+				condition.Values = append(condition.Values, string(value))
+			}
+		}
+		conditions = append(conditions, condition)
+	}
+	sort.Slice(conditions, func(i, j int) bool {
+		return conditions[i].Key < conditions[j].Key
+	})
+
+	return conditions
+}
+
+// getMembersForRole returns the members for each role in an Athenz domain, the members of delegated roles are
+// resolved by following at most maxTrustDepth trust domains and the visited trust domains are returned for each
+// delegated role
+func getMembersForRole(domain *zms.DomainData, crCache *cache.SharedIndexInformer, maxTrustDepth int) (RoleMembers, RoleTrustDomains) {
 	roleMembers := make(RoleMembers)
+	trustDomains := make(RoleTrustDomains)
 
 	if domain == nil || domain.Roles == nil {
-		return roleMembers
+		return roleMembers, trustDomains
 	}
 
+	resolver := NewTrustResolver(crCache, maxTrustDepth)
 	roles := domain.Roles
 	for _, role := range roles {
+		roleName := zms.ResourceName(role.Name)
+		members := role.RoleMembers
 		// add role members of trust domain
 		if role.Trust != "" {
-			var err error
-			role.RoleMembers, err = processTrustDomain(crCache, role.Trust, string(role.Name))
+			result, err := resolver.Resolve(domain.Name, role)
+			if len(result.VisitedDomains) > 0 {
+				trustDomains[roleName] = result.VisitedDomains
+			}
 			if err != nil {
 				log.Printf("Error occurred when processing trust domain. Error: %v", err)
 				continue
 			}
+			members = result.Members
 		}
-		roleName := zms.ResourceName(role.Name)
-		roleMembers[roleName] = role.RoleMembers
+		roleMembers[roleName] = members
 	}
 
-	return roleMembers
+	return roleMembers, trustDomains
 }
 
 // getMembersForGroup returns the members for each group in an Athenz domain
@@ -132,73 +217,64 @@ func getMembersForGroup(domain *zms.DomainData) GroupMembers {
 	return groupMembers
 }
 
-// ConvertAthenzPoliciesIntoRbacModel transforms the given Athenz Domain structure into role-centric policies and members
-func ConvertAthenzPoliciesIntoRbacModel(domain *zms.DomainData, crCache *cache.SharedIndexInformer) Model {
+// ConvertAthenzPoliciesIntoRbacModel transforms the given Athenz Domain structure into role-centric policies and members,
+// the members of the delegated roles are resolved by following at most maxTrustDepth trust domains
+func ConvertAthenzPoliciesIntoRbacModel(domain *zms.DomainData, crCache *cache.SharedIndexInformer, maxTrustDepth int) Model {
 	var domainName zms.DomainName
 	if domain != nil {
 		domainName = domain.Name
 	}
+	members, trustDomains := getMembersForRole(domain, crCache, maxTrustDepth)
 	return Model{
 		Name:         domainName,
-		Namespace:    DomainToNamespace(string(domainName)),
+		Namespace:    ResolveNamespace(string(domainName)),
 		Roles:        getRolesForDomain(domain),
 		Rules:        getRulesForDomain(domain),
-		Members:      getMembersForRole(domain, crCache),
+		Members:      members,
 		GroupMembers: getMembersForGroup(domain),
+		TrustDomains: trustDomains,
+		Conditions:   getConditionsForRole(domain),
 	}
 }
 
-// processTrustDomain -  in delegated domain check assume_role action in policy that contains current role as a resource, return role's member list
-func processTrustDomain(informer *cache.SharedIndexInformer, trust zms.DomainName, roleName string) ([]*zms.RoleMember, error) {
-	var res []*zms.RoleMember
-	// handle case which crIndexInformer is not initialized at the beginning, return directly.
-	if (*informer) == nil {
-		return res, nil
-	}
-	trustDomain := string(trust)
-	crContent, exists, _ := (*informer).GetStore().GetByKey(trustDomain)
-	if !exists {
-		return res, fmt.Errorf("Error when finding trustDomain %s for this role name %s in the cache: Domain cr is not found in the cache store", trustDomain, roleName)
-	}
-	// cast it to AthenzDomain object
-	obj, ok := crContent.(*v1.AthenzDomain)
-	if !ok {
-		return res, fmt.Errorf("Error occurred when casting trust domain interface to athen domain object")
+// GetNextMemberExpiry returns the earliest role or group member expiration in the model which is after the given time,
+// nil is returned if none of the members expire after the given time
+func GetNextMemberExpiry(m Model, now time.Time) *time.Time {
+	var next *time.Time
+	update := func(expiration *rdl.Timestamp) {
+		if expiration == nil || !expiration.After(now) {
+			return
+		}
+		if next == nil || expiration.Before(*next) {
+			t := expiration.Time
+			next = &t
+		}
 	}
 
-	for _, policy := range obj.Spec.SignedDomain.Domain.Policies.Contents.Policies {
-		if policy == nil || len(policy.Assertions) == 0 {
-			log.Println("policy in Contents.Policies is nil")
-			continue
+	for _, members := range m.Members {
+		for _, member := range members {
+			if member != nil {
+				update(member.Expiration)
+			}
 		}
-		for _, assertion := range policy.Assertions {
-			// check if policy contains action "assume_role", and resource matches with delegated role name
-			if assertion.Action == "assume_role" {
-				// form correct role name
-				matched, err := regexp.MatchString("^"+roleReplacer.Replace(assertion.Resource)+"$", roleName)
-				if err != nil {
-					log.Println("string matching failed with err: ", err)
-					continue
-				}
-				if matched {
-					delegatedRole := assertion.Role
-					// check if above policy's corresponding role is delegated role or not
-					for _, role := range obj.Spec.SignedDomain.Domain.Roles {
-						if string(role.Name) == delegatedRole {
-							if role.Trust != "" {
-								// return empty array since athenz zms library does not recursively check delegated domain
-								// it only checks one level above. Refer to: https://github.com/yahoo/athenz/blob/master/servers/zms/src/main/java/com/yahoo/athenz/zms/DBService.java#L1972
-								return res, nil
-							}
-							for _, member := range role.RoleMembers {
-								res = append(res, member)
-							}
-							return res, nil
-						}
-					}
-				}
+	}
+	for _, members := range m.GroupMembers {
+		for _, member := range members {
+			if member != nil {
+				update(member.Expiration)
 			}
 		}
 	}
-	return res, nil
+	return next
+}
+
+// ScheduleMemberExpiry adds the key back to the queue at the next role or group member expiration of the model, so
+// that the access of expired members is revoked on schedule instead of at the next resync
+func ScheduleMemberExpiry(queue workqueue.DelayingInterface, key string, m Model) {
+	next := GetNextMemberExpiry(m, time.Now())
+	if next == nil {
+		return
+	}
+	log.Infof("Scheduling sync for key: %s at the next member expiration: %s", key, next.Format(time.RFC3339))
+	queue.AddAfter(key, time.Until(*next)+memberExpiryDelay)
 }
@@ -6,17 +6,24 @@ package athenz
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ardielle/ardielle-go/rdl"
 	"github.com/stretchr/testify/assert"
 	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
 	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
 	"github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned/fake"
 	athenzInformer "github.com/yahoo/k8s-athenz-syncer/pkg/client/informers/externalversions/athenz/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
+func init() {
+	log.InitLogger("", "debug")
+}
+
 const (
 	trustDomainName = "test.trust.domain"
 	trustusername   = "trustuser.name"
@@ -494,7 +501,7 @@ func TestGetMembersForRole(t *testing.T) {
 	crIndexInformer := athenzInformer.NewAthenzDomainInformer(athenzclientset, 0, cache.Indexers{})
 
 	for _, c := range cases {
-		if got := getMembersForRole(c.domain, &crIndexInformer); !reflect.DeepEqual(got, c.expected) {
+		if got, _ := getMembersForRole(c.domain, &crIndexInformer, DefaultMaxTrustDepth); !reflect.DeepEqual(got, c.expected) {
 			assert.Equal(t, c.expected, got, c.test)
 		}
 	}
@@ -517,6 +524,8 @@ func TestConvertAthenzPoliciesIntoRbacModel(t *testing.T) {
 			domain: nil,
 			expected: Model{
 				Members:      RoleMembers{},
+				TrustDomains: RoleTrustDomains{},
+				Conditions:   RoleConditions{},
 				Rules:        RoleAssertions{},
 				Roles:        Roles{},
 				GroupMembers: GroupMembers{},
@@ -728,6 +737,8 @@ func TestConvertAthenzPoliciesIntoRbacModel(t *testing.T) {
 					},
 				},
 				GroupMembers: GroupMembers{},
+				TrustDomains: RoleTrustDomains{},
+				Conditions:   RoleConditions{},
 			},
 		},
 		{
@@ -820,6 +831,10 @@ func TestConvertAthenzPoliciesIntoRbacModel(t *testing.T) {
 					},
 				},
 				GroupMembers: GroupMembers{},
+				TrustDomains: RoleTrustDomains{
+					zms.ResourceName("home.domain:role.delegated"): []zms.DomainName{trustDomainName},
+				},
+				Conditions: RoleConditions{},
 			},
 		},
 
@@ -1090,6 +1105,8 @@ func TestConvertAthenzPoliciesIntoRbacModel(t *testing.T) {
 						},
 					},
 				},
+				TrustDomains: RoleTrustDomains{},
+				Conditions:   RoleConditions{},
 			},
 		},
 	}
@@ -1099,12 +1116,77 @@ func TestConvertAthenzPoliciesIntoRbacModel(t *testing.T) {
 	crIndexInformer.GetStore().Add(ad1.DeepCopy())
 
 	for _, c := range cases {
-		if got := ConvertAthenzPoliciesIntoRbacModel(c.domain, &crIndexInformer); !reflect.DeepEqual(got, c.expected) {
+		if got := ConvertAthenzPoliciesIntoRbacModel(c.domain, &crIndexInformer, DefaultMaxTrustDepth); !reflect.DeepEqual(got, c.expected) {
 			assert.Equal(t, c.expected, got, c.test)
 		}
 	}
 }
 
+func TestGetRoleConditions(t *testing.T) {
+	cases := []struct {
+		test     string
+		role     *zms.Role
+		expected []Condition
+	}{
+		{
+			test: "nil role",
+		},
+		{
+			test: "role without condition tags",
+			role: &zms.Role{
+				Name: "athenz.domain:role.reader",
+				Tags: map[zms.CompoundName]*zms.StringList{
+					"owner": {List: []zms.CompoundName{"team"}},
+				},
+			},
+		},
+		{
+			test: "role with condition tags",
+			role: &zms.Role{
+				Name: "athenz.domain:role.reader",
+				Tags: map[zms.CompoundName]*zms.StringList{
+					"owner":                              {List: []zms.CompoundName{"team"}},
+					"istio.when.source.ip":               {List: []zms.CompoundName{"10.0.0.1", "10.0.0.2"}},
+					"istio.when.request.headers.x-env":   {List: []zms.CompoundName{"prod"}},
+					"istio.when.request.auth.claims.iss": {List: []zms.CompoundName{"athenz"}},
+					"istio.when.destination.port":        {List: []zms.CompoundName{"8080"}},
+					"istio.when.request.headers":         nil,
+					"istio.when.connection.sni":          {List: []zms.CompoundName{"api"}},
+				},
+			},
+			expected: []Condition{
+				{Key: "connection.sni", Values: []string{"api"}},
+				{Key: "destination.port", Values: []string{"8080"}},
+				{Key: "request.auth.claims[iss]", Values: []string{"athenz"}},
+				{Key: "request.headers"},
+				{Key: "request.headers[x-env]", Values: []string{"prod"}},
+				{Key: "source.ip", Values: []string{"10.0.0.1", "10.0.0.2"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, GetRoleConditions(c.role), c.test)
+	}
+
+	domain := &zms.DomainData{
+		Name: "athenz.domain",
+		Roles: []*zms.Role{
+			{Name: "athenz.domain:role.reader"},
+			{
+				Name: "athenz.domain:role.writer",
+				Tags: map[zms.CompoundName]*zms.StringList{
+					"istio.when.source.ip": {List: []zms.CompoundName{"10.0.0.1"}},
+				},
+			},
+		},
+	}
+	expected := RoleConditions{
+		"athenz.domain:role.writer": {{Key: "source.ip", Values: []string{"10.0.0.1"}}},
+	}
+	assert.Equal(t, expected, getConditionsForRole(domain), "only the roles with conditions should be in the model")
+}
+
 func getFakeTrustAthenzDomain() *v1.AthenzDomain {
 	spec := v1.AthenzDomainSpec{
 		SignedDomain: getFakeTrustDomain(),
@@ -1169,3 +1251,91 @@ func getFakeTrustDomain() zms.SignedDomain {
 		Signature: "signature",
 	}
 }
+
+func TestGetNextMemberExpiry(t *testing.T) {
+	now := time.Now()
+	expired := rdl.Timestamp{Time: now.Add(-time.Hour)}
+	soon := rdl.Timestamp{Time: now.Add(time.Minute)}
+	later := rdl.Timestamp{Time: now.Add(time.Hour)}
+
+	cases := []struct {
+		test     string
+		model    Model
+		expected *time.Time
+	}{
+		{
+			test:     "empty model",
+			model:    Model{},
+			expected: nil,
+		},
+		{
+			test: "members without expiration or already expired",
+			model: Model{
+				Members: RoleMembers{
+					"athenz.domain:role.reader": []*zms.RoleMember{
+						{MemberName: "user.foo"},
+						{MemberName: "user.bar", Expiration: &expired},
+					},
+				},
+			},
+			expected: nil,
+		},
+		{
+			test: "earliest role member expiration",
+			model: Model{
+				Members: RoleMembers{
+					"athenz.domain:role.reader": []*zms.RoleMember{
+						{MemberName: "user.foo", Expiration: &later},
+						{MemberName: "user.bar", Expiration: &soon},
+					},
+				},
+			},
+			expected: &soon.Time,
+		},
+		{
+			test: "earliest group member expiration",
+			model: Model{
+				Members: RoleMembers{
+					"athenz.domain:role.reader": []*zms.RoleMember{
+						{MemberName: "user.foo", Expiration: &later},
+					},
+				},
+				GroupMembers: GroupMembers{
+					"athenz.domain:group.readers": []*zms.GroupMember{
+						{MemberName: "user.bar", Expiration: &soon},
+					},
+				},
+			},
+			expected: &soon.Time,
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, GetNextMemberExpiry(c.model, now), c.test)
+	}
+}
+
+func TestScheduleMemberExpiry(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	ScheduleMemberExpiry(queue, "test.namespace", Model{})
+	assert.Equal(t, 0, queue.Len(), "queue length should be 0 for a model without expiring members")
+
+	expiration := rdl.Timestamp{Time: time.Now().Add(time.Millisecond * 500)}
+	ScheduleMemberExpiry(queue, "test.namespace", Model{
+		Members: RoleMembers{
+			"test.namespace:role.reader": []*zms.RoleMember{
+				{
+					MemberName: "user.name",
+					Expiration: &expiration,
+				},
+			},
+		},
+	})
+	assert.Equal(t, 0, queue.Len(), "queue length should be 0 before the member expiration")
+	time.Sleep(time.Second * 2)
+	assert.Equal(t, 1, queue.Len(), "queue length should be 1 after the member expiration")
+	item, shutdown := queue.Get()
+	assert.False(t, shutdown, "shutdown should be false")
+	assert.Equal(t, "test.namespace", item, "key should be equal")
+}
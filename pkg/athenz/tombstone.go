@@ -0,0 +1,80 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Tombstones holds the deleted Athenz domains, so that the resources generated for a domain can be cleaned up after
+// it is gone from the informer cache. Only the object meta and the domain name of a deleted domain are kept, the
+// domain resolves to an empty model without any roles or policies.
+type Tombstones struct {
+	mu      sync.RWMutex
+	domains map[string]*v1.AthenzDomain
+}
+
+// NewTombstones returns an empty Tombstones
+func NewTombstones() *Tombstones {
+	return &Tombstones{
+		domains: make(map[string]*v1.AthenzDomain),
+	}
+}
+
+// Add records the deleted Athenz domain from the informer delete event, the object is either the Athenz domain or
+// the cache.DeletedFinalStateUnknown tombstone of it
+func (t *Tombstones) Add(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	athenzDomain, ok := obj.(*v1.AthenzDomain)
+	if !ok {
+		log.Errorf("Error recording deleted athenz domain, unexpected object type %T", obj)
+		return
+	}
+
+	deleted := &v1.AthenzDomain{
+		TypeMeta:   athenzDomain.TypeMeta,
+		ObjectMeta: *athenzDomain.ObjectMeta.DeepCopy(),
+	}
+	deleted.Spec.SignedDomain.Domain = &zms.DomainData{Name: zms.DomainName(athenzDomain.Name)}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.domains[athenzDomain.Name] = deleted
+}
+
+// Get returns the deleted Athenz domain with the given name
+func (t *Tombstones) Get(domain string) (*v1.AthenzDomain, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	athenzDomain, exists := t.domains[domain]
+	return athenzDomain, exists
+}
+
+// List returns the sorted names of the deleted Athenz domains, they are re-synced until their tombstones are purged
+func (t *Tombstones) List() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	domains := make([]string, 0, len(t.domains))
+	for domain := range t.domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// Delete removes the Athenz domain with the given name, it is called once the domain exists again or once the
+// domain delete policy is applied
+func (t *Tombstones) Delete(domain string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.domains, domain)
+}
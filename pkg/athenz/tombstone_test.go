@@ -0,0 +1,55 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/athenz/clients/go/zms"
+	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestTombstones(t *testing.T) {
+	tombstones := NewTombstones()
+	_, exists := tombstones.Get("home.domain")
+	assert.False(t, exists, "empty tombstones should not return a domain")
+
+	expected := &v1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "home.domain",
+			Annotations: map[string]string{"key": "value"},
+		},
+	}
+	expected.Spec.SignedDomain.Domain = &zms.DomainData{Name: "home.domain"}
+
+	// only the object meta and the domain name are kept
+	tombstones.Add(newFakeDelegatingAthenzDomain("other.domain", "", "user.name"))
+	tombstones.Add(cache.DeletedFinalStateUnknown{
+		Key: "home.domain",
+		Obj: &v1.AthenzDomain{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "home.domain",
+				Annotations: map[string]string{"key": "value"},
+			},
+			Spec: newFakeDelegatingAthenzDomain("home.domain", "", "user.name").Spec,
+		},
+	})
+
+	actual, exists := tombstones.Get("home.domain")
+	assert.True(t, exists, "deleted domain should exist")
+	assert.Equal(t, expected, actual, "deleted domain should be equal")
+	actual, exists = tombstones.Get("other.domain")
+	assert.True(t, exists, "deleted domain should exist")
+	assert.Equal(t, zms.DomainName("other.domain"), actual.Spec.SignedDomain.Domain.Name, "deleted domain name should be equal")
+
+	assert.Equal(t, []string{"home.domain", "other.domain"}, tombstones.List(), "deleted domains should be equal")
+
+	tombstones.Delete("home.domain")
+	_, exists = tombstones.Get("home.domain")
+	assert.False(t, exists, "re-created domain should not exist")
+	assert.Equal(t, []string{"other.domain"}, tombstones.List(), "deleted domains should be equal")
+}
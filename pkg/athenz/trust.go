@@ -0,0 +1,221 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultMaxTrustDepth is the default maximum number of trust domains followed when resolving the
+	// members of a delegated role
+	DefaultMaxTrustDepth = 3
+	assumeRoleAction     = "assume_role"
+)
+
+// TrustResult holds the resolved members of a delegated role and the trust domains visited while resolving them
+type TrustResult struct {
+	Members        []*zms.RoleMember
+	VisitedDomains []zms.DomainName
+}
+
+// TrustResolver resolves the members of delegated roles by following the trust chain through the Athenz Domain cache.
+// e.g. home.domain:role.reader (trust: a.domain) -> a.domain:role.reader (trust: b.domain) -> b.domain:role.reader
+type TrustResolver struct {
+	informer *cache.SharedIndexInformer
+	maxDepth int
+}
+
+// NewTrustResolver returns a TrustResolver which follows at most maxDepth trust domains
+func NewTrustResolver(informer *cache.SharedIndexInformer, maxDepth int) *TrustResolver {
+	return &TrustResolver{
+		informer: informer,
+		maxDepth: maxDepth,
+	}
+}
+
+// Resolve returns the members of the delegated role defined in the given domain. The visited trust domains are
+// returned even if the resolution fails, so that callers can track the domains the role depends on.
+func (r *TrustResolver) Resolve(domainName zms.DomainName, role *zms.Role) (*TrustResult, error) {
+	result := &TrustResult{}
+	if role == nil || role.Trust == "" {
+		return result, nil
+	}
+	// handle case which crIndexInformer is not initialized at the beginning, return directly.
+	if r.informer == nil || (*r.informer) == nil {
+		return result, nil
+	}
+
+	chain := []zms.DomainName{domainName}
+	trust := role.Trust
+	roleName := string(role.Name)
+	for {
+		for _, visited := range chain {
+			if visited == trust {
+				return result, fmt.Errorf("trust cycle detected for role %s: %s -> %s", role.Name, formatTrustChain(chain), trust)
+			}
+		}
+		if len(chain) > r.maxDepth {
+			return result, fmt.Errorf("max trust depth %d exceeded for role %s: %s -> %s", r.maxDepth, role.Name, formatTrustChain(chain), trust)
+		}
+		chain = append(chain, trust)
+		result.VisitedDomains = append(result.VisitedDomains, trust)
+
+		delegatedRole, err := r.getDelegatedRole(trust, roleName)
+		if err != nil {
+			return result, err
+		}
+		if delegatedRole == nil {
+			return result, nil
+		}
+		if delegatedRole.Trust == "" {
+			result.Members = append(result.Members, delegatedRole.RoleMembers...)
+			return result, nil
+		}
+
+		// the delegated role is itself a trust role, continue with the next domain in the chain
+		trust = delegatedRole.Trust
+		roleName = string(delegatedRole.Name)
+	}
+}
+
+// getDelegatedRole checks the assume_role assertions in the trust domain for the one with the role name as a resource
+// and returns the role the assertion belongs to, nil is returned if no assertion matches
+func (r *TrustResolver) getDelegatedRole(trust zms.DomainName, roleName string) (*zms.Role, error) {
+	trustDomain := string(trust)
+	crContent, exists, _ := (*r.informer).GetStore().GetByKey(trustDomain)
+	if !exists {
+		return nil, fmt.Errorf("Error when finding trustDomain %s for this role name %s in the cache: Domain cr is not found in the cache store", trustDomain, roleName)
+	}
+	// cast it to AthenzDomain object
+	obj, ok := crContent.(*v1.AthenzDomain)
+	if !ok {
+		return nil, fmt.Errorf("Error occurred when casting trust domain interface to athen domain object")
+	}
+
+	domain := obj.Spec.SignedDomain.Domain
+	if domain == nil || domain.Policies == nil || domain.Policies.Contents == nil {
+		return nil, nil
+	}
+
+	for _, policy := range domain.Policies.Contents.Policies {
+		if policy == nil || len(policy.Assertions) == 0 {
+			log.Println("policy in Contents.Policies is nil")
+			continue
+		}
+		for _, assertion := range policy.Assertions {
+			// check if policy contains action "assume_role", and resource matches with delegated role name
+			if assertion.Action != assumeRoleAction {
+				continue
+			}
+			// form correct role name
+			matched, err := MatchGlob(assertion.Resource, roleName)
+			if err != nil {
+				log.Println("string matching failed with err: ", err)
+				continue
+			}
+			if !matched {
+				continue
+			}
+			// check if above policy's corresponding role is delegated role or not
+			for _, role := range domain.Roles {
+				if string(role.Name) == assertion.Role {
+					return role, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// formatTrustChain returns the trust chain in the format a.domain -> b.domain
+func formatTrustChain(chain []zms.DomainName) string {
+	out := ""
+	for i, domain := range chain {
+		if i > 0 {
+			out += " -> "
+		}
+		out += string(domain)
+	}
+	return out
+}
+
+// TrustIndex is a reverse index from trust domains to the Athenz domains which delegate roles to them, it is used to
+// re-sync the delegating domains when a trust domain changes
+type TrustIndex struct {
+	mu sync.RWMutex
+	// delegating maps a trust domain to the set of delegating domains
+	delegating map[string]map[string]bool
+	// trusted maps a delegating domain to the trust domains it depends on
+	trusted map[string][]string
+}
+
+// NewTrustIndex returns an empty TrustIndex
+func NewTrustIndex() *TrustIndex {
+	return &TrustIndex{
+		delegating: make(map[string]map[string]bool),
+		trusted:    make(map[string][]string),
+	}
+}
+
+// Update replaces the trust domains the given Athenz domain depends on with the trust domains visited while
+// resolving its delegated roles
+func (t *TrustIndex) Update(domain string, trustDomains RoleTrustDomains) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deleteLocked(domain)
+	seen := make(map[string]bool)
+	for _, visited := range trustDomains {
+		for _, trustDomain := range visited {
+			trust := string(trustDomain)
+			if trust == domain || seen[trust] {
+				continue
+			}
+			seen[trust] = true
+			if _, exists := t.delegating[trust]; !exists {
+				t.delegating[trust] = make(map[string]bool)
+			}
+			t.delegating[trust][domain] = true
+			t.trusted[domain] = append(t.trusted[domain], trust)
+		}
+	}
+}
+
+// Delete removes the trust domains the given Athenz domain depends on from the index
+func (t *TrustIndex) Delete(domain string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deleteLocked(domain)
+}
+
+func (t *TrustIndex) deleteLocked(domain string) {
+	for _, trust := range t.trusted[domain] {
+		delete(t.delegating[trust], domain)
+		if len(t.delegating[trust]) == 0 {
+			delete(t.delegating, trust)
+		}
+	}
+	delete(t.trusted, domain)
+}
+
+// GetDelegatingDomains returns the sorted list of Athenz domains which delegate roles to the given trust domain
+func (t *TrustIndex) GetDelegatingDomains(trustDomain string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	domains := make([]string, 0, len(t.delegating[trustDomain]))
+	for domain := range t.delegating[trustDomain] {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
@@ -0,0 +1,183 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package athenz
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/athenz/clients/go/zms"
+	v1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	"github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned/fake"
+	athenzInformer "github.com/yahoo/k8s-athenz-syncer/pkg/client/informers/externalversions/athenz/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newFakeDelegatingAthenzDomain returns an Athenz domain with a role.delegate role which can be assumed by any
+// role.delegated role, the role.delegate role is either trusted to the given domain or has the given members
+func newFakeDelegatingAthenzDomain(name string, trust zms.DomainName, members ...zms.MemberName) *v1.AthenzDomain {
+	allow := zms.ALLOW
+	role := &zms.Role{
+		Name:  zms.ResourceName(name + ":role.delegate"),
+		Trust: trust,
+	}
+	for _, member := range members {
+		role.RoleMembers = append(role.RoleMembers, &zms.RoleMember{MemberName: member})
+	}
+
+	return &v1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.AthenzDomainSpec{
+			SignedDomain: zms.SignedDomain{
+				Domain: &zms.DomainData{
+					Name: zms.DomainName(name),
+					Policies: &zms.SignedPolicies{
+						Contents: &zms.DomainPolicies{
+							Domain: zms.DomainName(name),
+							Policies: []*zms.Policy{
+								{
+									Assertions: []*zms.Assertion{
+										{
+											Role:     name + ":role.delegate",
+											Resource: "*:role.delegate*",
+											Action:   "assume_role",
+											Effect:   &allow,
+										},
+									},
+									Name: zms.ResourceName(name + ":policy.delegate"),
+								},
+							},
+						},
+					},
+					Roles: []*zms.Role{role},
+				},
+			},
+		},
+	}
+}
+
+func TestTrustResolverResolve(t *testing.T) {
+	delegatedRole := &zms.Role{
+		Name:  "home.domain:role.delegated",
+		Trust: "a.domain",
+	}
+
+	cases := []struct {
+		test            string
+		domains         []*v1.AthenzDomain
+		maxDepth        int
+		role            *zms.Role
+		expectedMembers []*zms.RoleMember
+		expectedVisited []zms.DomainName
+		expectedErr     error
+	}{
+		{
+			test:            "role without trust",
+			maxDepth:        DefaultMaxTrustDepth,
+			role:            &zms.Role{Name: "home.domain:role.reader"},
+			expectedMembers: nil,
+			expectedVisited: nil,
+		},
+		{
+			test: "single level trust",
+			domains: []*v1.AthenzDomain{
+				newFakeDelegatingAthenzDomain("a.domain", "", "user.a"),
+			},
+			maxDepth:        DefaultMaxTrustDepth,
+			role:            delegatedRole,
+			expectedMembers: []*zms.RoleMember{{MemberName: "user.a"}},
+			expectedVisited: []zms.DomainName{"a.domain"},
+		},
+		{
+			test: "multi level trust",
+			domains: []*v1.AthenzDomain{
+				newFakeDelegatingAthenzDomain("a.domain", "b.domain"),
+				newFakeDelegatingAthenzDomain("b.domain", "", "user.b", "user.c"),
+			},
+			maxDepth:        DefaultMaxTrustDepth,
+			role:            delegatedRole,
+			expectedMembers: []*zms.RoleMember{{MemberName: "user.b"}, {MemberName: "user.c"}},
+			expectedVisited: []zms.DomainName{"a.domain", "b.domain"},
+		},
+		{
+			test: "multi level trust exceeding max depth",
+			domains: []*v1.AthenzDomain{
+				newFakeDelegatingAthenzDomain("a.domain", "b.domain"),
+				newFakeDelegatingAthenzDomain("b.domain", "", "user.b"),
+			},
+			maxDepth:        1,
+			role:            delegatedRole,
+			expectedMembers: nil,
+			expectedVisited: []zms.DomainName{"a.domain"},
+			expectedErr:     fmt.Errorf("max trust depth 1 exceeded for role home.domain:role.delegated: home.domain -> a.domain -> b.domain"),
+		},
+		{
+			test: "trust cycle",
+			domains: []*v1.AthenzDomain{
+				newFakeDelegatingAthenzDomain("a.domain", "b.domain"),
+				newFakeDelegatingAthenzDomain("b.domain", "a.domain"),
+			},
+			maxDepth:        DefaultMaxTrustDepth,
+			role:            delegatedRole,
+			expectedMembers: nil,
+			expectedVisited: []zms.DomainName{"a.domain", "b.domain"},
+			expectedErr:     fmt.Errorf("trust cycle detected for role home.domain:role.delegated: home.domain -> a.domain -> b.domain -> a.domain"),
+		},
+		{
+			test: "missing trust domain",
+			domains: []*v1.AthenzDomain{
+				newFakeDelegatingAthenzDomain("a.domain", "b.domain"),
+			},
+			maxDepth:        DefaultMaxTrustDepth,
+			role:            delegatedRole,
+			expectedMembers: nil,
+			expectedVisited: []zms.DomainName{"a.domain", "b.domain"},
+			expectedErr:     fmt.Errorf("Error when finding trustDomain b.domain for this role name a.domain:role.delegate in the cache: Domain cr is not found in the cache store"),
+		},
+	}
+
+	for _, c := range cases {
+		athenzclientset := fake.NewSimpleClientset()
+		crIndexInformer := athenzInformer.NewAthenzDomainInformer(athenzclientset, 0, cache.Indexers{})
+		for _, domain := range c.domains {
+			crIndexInformer.GetStore().Add(domain.DeepCopy())
+		}
+
+		result, err := NewTrustResolver(&crIndexInformer, c.maxDepth).Resolve("home.domain", c.role)
+		assert.Equal(t, c.expectedErr, err, c.test)
+		assert.Equal(t, c.expectedMembers, result.Members, c.test)
+		assert.Equal(t, c.expectedVisited, result.VisitedDomains, c.test)
+	}
+}
+
+func TestTrustIndex(t *testing.T) {
+	index := NewTrustIndex()
+	assert.Equal(t, []string{}, index.GetDelegatingDomains("a.domain"), "empty index should return no delegating domains")
+
+	index.Update("home.domain", RoleTrustDomains{
+		"home.domain:role.reader": []zms.DomainName{"a.domain", "b.domain"},
+		"home.domain:role.writer": []zms.DomainName{"a.domain"},
+	})
+	index.Update("other.domain", RoleTrustDomains{
+		"other.domain:role.reader": []zms.DomainName{"a.domain"},
+	})
+	assert.Equal(t, []string{"home.domain", "other.domain"}, index.GetDelegatingDomains("a.domain"), "delegating domains should be equal")
+	assert.Equal(t, []string{"home.domain"}, index.GetDelegatingDomains("b.domain"), "delegating domains should be equal")
+
+	// the trust domains of a domain are replaced on update
+	index.Update("home.domain", RoleTrustDomains{
+		"home.domain:role.reader": []zms.DomainName{"c.domain"},
+	})
+	assert.Equal(t, []string{"other.domain"}, index.GetDelegatingDomains("a.domain"), "delegating domains should be equal")
+	assert.Equal(t, []string{}, index.GetDelegatingDomains("b.domain"), "delegating domains should be equal")
+	assert.Equal(t, []string{"home.domain"}, index.GetDelegatingDomains("c.domain"), "delegating domains should be equal")
+
+	index.Delete("home.domain")
+	assert.Equal(t, []string{}, index.GetDelegatingDomains("c.domain"), "delegating domains should be equal")
+	assert.Equal(t, []string{"other.domain"}, index.GetDelegatingDomains("a.domain"), "delegating domains should be equal")
+}
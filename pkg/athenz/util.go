@@ -3,7 +3,10 @@
 // github.com/yahoo/k8s-athenz-istio-auth for terms.
 package athenz
 
-import "strings"
+import (
+	"regexp"
+	"strings"
+)
 
 // DomainToNamespace will convert an athenz domain to a kubernetes namespace. Dots are converted to dashes
 // and dashes are converted to double dashes.
@@ -20,3 +23,10 @@ func NamespaceToDomain(ns string) (domain string) {
 	dotted := strings.Replace(ns, "-", ".", -1)
 	return strings.Replace(dotted, "..", "-", -1)
 }
+
+// MatchGlob reports whether the whole string matches the Athenz glob pattern, in which * matches any sequence of
+// characters and ? matches a single character. The other characters of the pattern are matched literally.
+// ex: api* matches api-admin but not legacy-api
+func MatchGlob(pattern string, s string) (bool, error) {
+	return regexp.MatchString("^"+roleReplacer.Replace(pattern)+"$", s)
+}
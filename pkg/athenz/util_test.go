@@ -15,3 +15,28 @@ func TestDomainNamespaceMap(t *testing.T) {
 	assert.Equal(t, "foo.bar.baz", NamespaceToDomain("foo-bar-baz"))
 	assert.Equal(t, "foo.bar-baz", NamespaceToDomain("foo-bar--baz"))
 }
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{pattern: "api", s: "api", expected: true},
+		{pattern: "api", s: "api-admin", expected: false},
+		{pattern: "api", s: "legacy-api", expected: false},
+		{pattern: "api*", s: "api-admin", expected: true},
+		{pattern: "*api", s: "legacy-api", expected: true},
+		{pattern: "api?", s: "api2", expected: true},
+		{pattern: "api?", s: "api", expected: false},
+		{pattern: "api.v1", s: "api-v1", expected: false},
+		{pattern: "api(v1)|web", s: "api(v1)|web", expected: true},
+		{pattern: "*", s: "", expected: true},
+	}
+
+	for _, c := range cases {
+		matched, err := MatchGlob(c.pattern, c.s)
+		assert.Nil(t, err, "pattern %s should be valid", c.pattern)
+		assert.Equal(t, c.expected, matched, "%s should match %s: %t", c.pattern, c.s, c.expected)
+	}
+}
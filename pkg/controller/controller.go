@@ -6,10 +6,14 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	"istio.io/client-go/pkg/clientset/versioned"
+	"istio.io/istio/pkg/config/schema/collection"
 	"istio.io/istio/pkg/config/schema/collections"
 
 	crd "istio.io/istio/pilot/pkg/config/kube/crd/controller"
@@ -17,26 +21,41 @@ import (
 	"istio.io/istio/pilot/pkg/serviceregistry/kube/controller"
 	v1 "k8s.io/api/core/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
 	m "github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/health"
 	authzpolicy "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/authorizationpolicy"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/guard"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/onboarding"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
 	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/lint"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/syncstatus"
 	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
 	adClientset "github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned"
+	adScheme "github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned/scheme"
 	adInformer "github.com/yahoo/k8s-athenz-syncer/pkg/client/informers/externalversions/athenz/v1"
 )
 
-const queueNumRetries = 3
+const (
+	queueNumRetries = 3
+	// controllerName identifies the domain controller in the blast radius guard, the queue and the metrics
+	controllerName       = "domain"
+	eventSourceComponent = "k8s-athenz-istio-auth"
+)
 
 type Controller struct {
 	configStoreCache            model.ConfigStoreCache
@@ -45,10 +64,26 @@ type Controller struct {
 	serviceIndexInformer        cache.SharedIndexInformer
 	adIndexInformer             cache.SharedIndexInformer
 	rbacProvider                rbac.Provider
+	rbacProviderMode            rbac.ProviderMode
 	apController                *authzpolicy.Controller
 	queue                       workqueue.RateLimitingInterface
 	adResyncInterval            time.Duration
 	enableAuthzPolicyController bool
+	trustIndex                  *athenz.TrustIndex
+	guard                       *guard.Guard
+	domainDeletePolicy          rbac.DomainDeletePolicy
+	tombstones                  *athenz.Tombstones
+	shutdownTimeout             time.Duration
+	informers                   sync.WaitGroup
+	worker                      *common.Worker
+	cachesSynced                health.Flag
+	runsWorkers                 health.Flag
+	recorder                    record.EventRecorder
+	statusWriter                *syncstatus.Writer
+	statuses                    *syncstatus.Cache
+	maxTrustDepth               int
+	conversionOptions           common.ConversionOptions
+	adoptUnlabeled              bool
 }
 
 // getCallbackHandler returns a error handler func that re-adds the athenz domain back to queue
@@ -72,6 +107,8 @@ func (c *Controller) getCallbackHandler(key string) common.OnCompleteFunc {
 		}
 		if c.queue.NumRequeues(key) >= queueNumRetries {
 			log.Errorf("Max number of retries reached for %s.", key)
+			metrics.QueueDropsTotal.WithLabelValues(controllerName).Inc()
+			common.NewEventReporter(c.recorder, common.DomainReference(key)).RetriesExhausted("sync", key, err)
 			return nil
 		}
 		if item != nil {
@@ -87,46 +124,163 @@ func (c *Controller) getCallbackHandler(key string) common.OnCompleteFunc {
 // 2. Convert to Athenz Model to group domain members and policies by role
 // 3. Convert Athenz Model to Service Role and Service Role Binding objects
 // 4. Create / Update / Delete Service Role and Service Role Binding objects
-func (c *Controller) sync(key string) error {
+// A deleted Athenz Domain is synced from its tombstone according to the domain delete policy, the tombstone
+// is purged once the resources are up-to-date
+// The outcome of the sync is written to the sync status once the Athenz Domain is found, the sync status is deleted
+// once the Athenz Domain is deleted
+func (c *Controller) sync(key string) (err error) {
+	// a domain whose namespace by convention is governed by another domain has no namespace, listing the
+	// resources of the empty namespace would list the resources of every namespace
+	namespace := athenz.ResolveNamespace(key)
+	if namespace == "" {
+		log.Infof("Athenz domain %s is not mapped to any namespace, skipping", key)
+		c.queue.Forget(key)
+		return nil
+	}
+
+	status := syncstatus.SyncStatus{
+		Controller: controllerName,
+		Domain:     key,
+		Mode:       syncstatus.ModeEnforced,
+	}
+	purged := false
+	defer func() {
+		switch {
+		case purged:
+			c.statusWriter.Delete(namespace, syncstatus.Name(controllerName, ""))
+			c.statuses.Delete(namespace, syncstatus.Name(controllerName, ""))
+			metrics.SkippedAssertions.DeleteLabelValues(controllerName, key)
+			metrics.LastSuccessfulSync.DeleteLabelValues(controllerName, key)
+			lint.Forget(key)
+		case status.ObservedResourceVersion != "":
+			c.statusWriter.Write(namespace, syncstatus.Name(controllerName, ""), status, err)
+			metrics.SkippedAssertions.WithLabelValues(controllerName, key).Set(float64(len(status.SkippedAssertions)))
+		}
+	}()
+
 	athenzDomainRaw, exists, err := c.adIndexInformer.GetIndexer().GetByKey(key)
 	if err != nil {
 		return err
 	}
 
-	if !exists {
-		// TODO, add the non existing athenz domain to the istio custom resource
-		// processing controller to delete them
-		return fmt.Errorf("athenz domain %s does not exist in cache", key)
+	deleted := false
+	if exists {
+		c.tombstones.Delete(key)
+	} else {
+		c.trustIndex.Delete(key)
+		tombstone, tombstoned := c.tombstones.Get(key)
+		if !tombstoned {
+			purged = true
+			// the domains deleted under the retain policy are not tombstoned, their resources are left in place
+			if c.domainDeletePolicy == rbac.DomainDeleteRetain {
+				log.Infof("Athenz domain %s is deleted, retaining its service roles and bindings", key)
+				c.queue.Forget(key)
+				return nil
+			}
+			return fmt.Errorf("athenz domain %s does not exist in cache", key)
+		}
+		log.Infof("Athenz domain %s is deleted, applying the %s domain delete policy", key, c.domainDeletePolicy)
+		athenzDomainRaw, deleted = tombstone, true
 	}
 
 	athenzDomain, ok := athenzDomainRaw.(*adv1.AthenzDomain)
 	if !ok {
 		return errors.New("athenz domain cast failed")
 	}
+	status.ObservedResourceVersion = athenzDomain.ResourceVersion
 
 	signedDomain := athenzDomain.Spec.SignedDomain
-	domainRBAC := m.ConvertAthenzPoliciesIntoRbacModel(signedDomain.Domain, &c.adIndexInformer)
-	desiredCRs := c.rbacProvider.ConvertAthenzModelIntoIstioRbac(domainRBAC, "", "", "")
-	currentCRs := c.rbacProvider.GetCurrentIstioRbac(domainRBAC, c.configStoreCache, "")
+	domainRBAC := m.ConvertAthenzPoliciesIntoRbacModel(signedDomain.Domain, &c.adIndexInformer, c.maxTrustDepth)
+	c.trustIndex.Update(key, domainRBAC.TrustDomains)
+	athenz.ScheduleMemberExpiry(c.queue, key, domainRBAC)
+	lint.Record(lint.Lint(signedDomain.Domain, c.rbacProviderMode, c.conversionOptions))
+	// the tombstone resolves to an empty model, the deny-all policy deletes the service role
+	// bindings as well since the onboarded services are denied without any of them
+	var desiredCRs []model.Config
+	if !deleted || c.domainDeletePolicy != rbac.DomainDeleteDelete {
+		reporter := common.NewEventReporter(c.recorder, common.DomainReference(key))
+		desiredCRs = c.rbacProvider.ConvertAthenzModelIntoIstioRbac(domainRBAC, "", "", nil, reporter)
+		status.SkippedAssertions, status.SkippedMembers = reporter.Skipped()
+		// the skipped assertions are reported once, not on every sync until they are fixed
+		reporter.RecordAssertions(c.statuses.Swap(namespace, syncstatus.Name(controllerName, ""), status))
+	}
+	status.Policies = syncstatus.NewPolicies(desiredCRs)
+	// only the resources owned by the controller are updated or deleted, the desired resources are not applied over
+	// the ones which are not owned
+	scope := common.AdoptionScope{AdoptUnlabeled: c.adoptUnlabeled, Model: domainRBAC}
+	reporter := common.NewEventReporter(c.recorder, common.DomainReference(key))
+	currentCRs, desiredCRs := common.FilterOwnedConfigs(c.rbacProvider.GetCurrentIstioRbac(domainRBAC, c.configStoreCache, ""), desiredCRs, scope, reporter)
 	cbHandler := c.getCallbackHandler(key)
 
 	changeList := common.ComputeChangeList(currentCRs, desiredCRs, cbHandler, nil)
+	// the changes of a deleted athenz domain are not held, they could never be approved on a domain which
+	// no longer exists
+	held := false
+	if !deleted {
+		computed := len(changeList)
+		changeList, held = c.guard.Filter(controllerName, c.queue, key, athenzDomain, currentCRs, changeList, true)
+		status.HeldChanges = computed - len(changeList)
+	}
 
 	// If change list is empty, nothing to do
-	if len(changeList) == 0 {
+	// the resources are not up-to-date while destructive changes are held
+	if len(changeList) == 0 && !held {
 		log.Infof("Everything is up-to-date for key: %s", key)
+		if deleted {
+			log.Infof("Domain delete policy %s is applied for athenz domain %s, purging its tombstone", c.domainDeletePolicy, key)
+			c.tombstones.Delete(key)
+			purged = true
+		}
 		c.queue.Forget(key)
+		metrics.LastSuccessfulSync.WithLabelValues(controllerName, key).SetToCurrentTime()
 		return nil
 	}
 
+	// the sync is not successful while destructive changes are held
+	if !held {
+		recordSyncOnApply(key, changeList)
+	}
 	for _, item := range changeList {
 		log.Infof("Adding resource action to processor queue: %s on %s for key: %s", item.Operation, item.Resource.Key(), key)
 		c.processor.ProcessConfigChange(item)
 	}
+	if held {
+		log.Warningf("Destructive changes are held for key: %s", key)
+	}
 
 	return nil
 }
 
+// recordSyncOnApply sets the last successful sync of the athenz domain once the processor has applied all the
+// changes of the change list, the failed changes are applied by a later sync of the domain
+func recordSyncOnApply(key string, changeList []*common.Item) {
+	remaining := int32(len(changeList))
+	for _, item := range changeList {
+		cbHandler := item.CallbackHandler
+		item.CallbackHandler = func(err error, item *common.Item) error {
+			if err == nil && atomic.AddInt32(&remaining, -1) == 0 {
+				metrics.LastSuccessfulSync.WithLabelValues(controllerName, key).SetToCurrentTime()
+			}
+			return cbHandler(err, item)
+		}
+	}
+}
+
+// newEventRecorder returns a recorder for the events on the athenz domains and the kubernetes objects
+func newEventRecorder(k8sClient kubernetes.Interface) record.EventRecorder {
+	eventScheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(eventScheme); err != nil {
+		log.Panicf("Error adding the kubernetes types to the event scheme: %s", err.Error())
+	}
+	if err := adScheme.AddToScheme(eventScheme); err != nil {
+		log.Panicf("Error adding the athenz domain types to the event scheme: %s", err.Error())
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(eventScheme, v1.EventSource{Component: eventSourceComponent})
+}
+
 // NewController is responsible for creating the main controller object and
 // initializing all of its dependencies:
 // 1. Rate limiting queue
@@ -135,24 +289,38 @@ func (c *Controller) sync(key string) error {
 // 3. Onboarding controller responsible for creating / updating / deleting the
 //    cluster rbac config object based on a service label
 // 4. Service shared index informer
-// 5. Athenz Domain shared index informer
+// 5. Athenz Domain shared index informer, fed with the signed domain files of
+//    athenzDomainDir instead of the AthenzDomain custom resources if it is set
 // 6. Authorization Policy controller responsible for creating / updating / deleting
 //    the authorization policy object based on service annotation and athenz domain spec
+// The service role and service role bindings are only managed by the domain controller
+// if the v1 rbac provider is enabled, the onboarding controller is only created if it
+// is enabled along with the v1 rbac provider.
 func NewController(dnsSuffix string, istioClient *crd.Client, k8sClient kubernetes.Interface, adClient adClientset.Interface,
-	istioClientSet versioned.Interface, adResyncInterval, crcResyncInterval, apResyncInterval time.Duration, enableOriginJwtSubject bool, enableAuthzPolicyController bool, componentsEnabledAuthzPolicy *common.ComponentEnabled) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	istioClientSet versioned.Interface, adResyncInterval, crcResyncInterval, apResyncInterval time.Duration, enableOriginJwtSubject bool, enableAuthzPolicyController bool, componentsEnabledAuthzPolicy *common.ComponentEnabled,
+	rbacProviderMode rbac.ProviderMode, enableOnboardingController bool, guardConfig guard.Config, domainDeletePolicy rbac.DomainDeletePolicy,
+	shutdownTimeout time.Duration, statusWriter *syncstatus.Writer, athenzDomainDir string, selectorLabels []string, maxTrustDepth int, conversionOptions common.ConversionOptions, adoptUnlabeled bool) *Controller {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName)
 	configStoreCache := crd.NewController(istioClient, controller.Options{})
+	recorder := newEventRecorder(k8sClient)
+	blastRadiusGuard := guard.NewGuard(guardConfig, recorder)
 
 	serviceListWatch := cache.NewListWatchFromClient(k8sClient.CoreV1().RESTClient(), "services", v1.NamespaceAll, fields.Everything())
 	serviceIndexInformer := cache.NewSharedIndexInformer(serviceListWatch, &v1.Service{}, 0, nil)
-	processor := processor.NewController(configStoreCache)
-	crcController := onboarding.NewController(configStoreCache, dnsSuffix, serviceIndexInformer, crcResyncInterval, processor)
-	adIndexInformer := adInformer.NewAthenzDomainInformer(adClient, 0, cache.Indexers{})
+	processor := processor.NewController(configStoreCache, recorder)
+	var adIndexInformer cache.SharedIndexInformer
+	if athenzDomainDir != "" {
+		log.Infof("Reading athenz domains from directory %s", athenzDomainDir)
+		adIndexInformer = athenz.NewDirectoryInformer(athenzDomainDir)
+	} else {
+		adIndexInformer = adInformer.NewAthenzDomainInformer(adClient, 0, cache.Indexers{})
+	}
 
 	// If enableAuthzPolicyController is enabled start the authzpolicy controller
 	var apController *authzpolicy.Controller
 	if enableAuthzPolicyController {
-		apController = authzpolicy.NewController(configStoreCache, serviceIndexInformer, adIndexInformer, istioClientSet, apResyncInterval, enableOriginJwtSubject, componentsEnabledAuthzPolicy)
+		// the domains are linted once, by the domain controller if it manages the v1 resources
+		apController = authzpolicy.NewController(configStoreCache, serviceIndexInformer, adIndexInformer, istioClientSet, apResyncInterval, enableOriginJwtSubject, componentsEnabledAuthzPolicy, blastRadiusGuard, domainDeletePolicy, recorder, statusWriter, selectorLabels, maxTrustDepth, conversionOptions, adoptUnlabeled, !rbacProviderMode.V1Enabled())
 		configStoreCache.RegisterEventHandler(collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind(), apController.EventHandler)
 	}
 
@@ -160,28 +328,57 @@ func NewController(dnsSuffix string, istioClient *crd.Client, k8sClient kubernet
 		serviceIndexInformer:        serviceIndexInformer,
 		adIndexInformer:             adIndexInformer,
 		configStoreCache:            configStoreCache,
-		crcController:               crcController,
 		processor:                   processor,
 		apController:                apController,
-		rbacProvider:                rbacv1.NewProvider(enableOriginJwtSubject),
 		queue:                       queue,
+		worker:                      common.NewWorker(queue, nil),
 		adResyncInterval:            adResyncInterval,
 		enableAuthzPolicyController: enableAuthzPolicyController,
+		trustIndex:                  athenz.NewTrustIndex(),
+		guard:                       blastRadiusGuard,
+		domainDeletePolicy:          domainDeletePolicy,
+		tombstones:                  athenz.NewTombstones(),
+		shutdownTimeout:             shutdownTimeout,
+		recorder:                    recorder,
+		statusWriter:                statusWriter,
+		statuses:                    syncstatus.NewCache(),
+		maxTrustDepth:               maxTrustDepth,
+		conversionOptions:           conversionOptions,
+		adoptUnlabeled:              adoptUnlabeled,
 	}
 
+	// With the v2 rbac provider only, the authorization policies are owned by the
+	// authzpolicy controller and the domain controller does not manage any resource
+	if !rbacProviderMode.V1Enabled() {
+		log.Infof("Service role, service role binding and cluster rbac config management is disabled for rbac provider %s", rbacProviderMode)
+		return c
+	}
+
+	c.rbacProvider = rbacv1.NewProvider(enableOriginJwtSubject)
+	c.rbacProviderMode = rbacProviderMode
 	configStoreCache.RegisterEventHandler(collections.IstioRbacV1Alpha1Serviceroles.Resource().GroupVersionKind(), c.processConfigEvent)
 	configStoreCache.RegisterEventHandler(collections.IstioRbacV1Alpha1Servicerolebindings.Resource().GroupVersionKind(), c.processConfigEvent)
-	configStoreCache.RegisterEventHandler(collections.IstioRbacV1Alpha1Clusterrbacconfigs.Resource().GroupVersionKind(), crcController.EventHandler)
+
+	if enableOnboardingController {
+		c.crcController = onboarding.NewController(configStoreCache, dnsSuffix, serviceIndexInformer, crcResyncInterval, processor)
+		configStoreCache.RegisterEventHandler(collections.IstioRbacV1Alpha1Clusterrbacconfigs.Resource().GroupVersionKind(), c.crcController.EventHandler)
+	}
 
 	adIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			c.processEvent(cache.MetaNamespaceKeyFunc, obj)
+			c.processDelegatingDomains(cache.MetaNamespaceKeyFunc, obj)
 		},
 		UpdateFunc: func(_, obj interface{}) {
 			c.processEvent(cache.MetaNamespaceKeyFunc, obj)
+			c.processDelegatingDomains(cache.MetaNamespaceKeyFunc, obj)
 		},
 		DeleteFunc: func(obj interface{}) {
+			if domainDeletePolicy != rbac.DomainDeleteRetain {
+				c.tombstones.Add(obj)
+			}
 			c.processEvent(cache.DeletionHandlingMetaNamespaceKeyFunc, obj)
+			c.processDelegatingDomains(cache.DeletionHandlingMetaNamespaceKeyFunc, obj)
 		},
 	})
 
@@ -199,77 +396,310 @@ func (c *Controller) processEvent(fn cache.KeyFunc, obj interface{}) {
 	log.Errorf("Error calling key func: %s", err.Error())
 }
 
-// processConfigEvent is responsible for adding the key of the item to the queue
+// processDelegatingDomains is responsible for adding the keys of the athenz
+// domains which delegate roles to the changed athenz domain to the queue, so
+// that the members of their trust roles are re-synced
+func (c *Controller) processDelegatingDomains(fn cache.KeyFunc, obj interface{}) {
+	key, err := fn(obj)
+	if err != nil {
+		log.Errorf("Error calling key func: %s", err.Error())
+		return
+	}
+	for _, domain := range c.trustIndex.GetDelegatingDomains(key) {
+		log.Infof("Adding delegating athenz domain %s to the queue for trust domain: %s", domain, key)
+		c.queue.Add(domain)
+	}
+}
+
+// processConfigEvent is responsible for adding the key of the item to the queue, the configs of a namespace which
+// is not governed by any athenz domain are skipped
 func (c *Controller) processConfigEvent(_ model.Config, config model.Config, e model.Event) {
-	domain := athenz.NamespaceToDomain(config.Namespace)
+	domain := athenz.ResolveDomain(config.Namespace)
+	if domain == "" {
+		return
+	}
+	c.queue.Add(domain)
+}
+
+// EventRecorder returns the recorder of the events of the controllers
+func (c *Controller) EventRecorder() record.EventRecorder {
+	return c.recorder
+}
+
+// ProcessDomainMappingEvent adds the athenz domain whose namespace mapping or guard approvals changed to the queues of
+// the domain and authzpolicy controllers, the domains which do not exist in the cache are skipped
+func (c *Controller) ProcessDomainMappingEvent(domain string) {
+	if c.enableAuthzPolicyController {
+		c.apController.ProcessDomainMappingEvent(domain)
+	}
+	if c.rbacProvider == nil {
+		return
+	}
+	if _, exists, _ := c.adIndexInformer.GetIndexer().GetByKey(domain); !exists {
+		if _, tombstoned := c.tombstones.Get(domain); !tombstoned {
+			return
+		}
+	}
 	c.queue.Add(domain)
 }
 
-// Run starts the main controller loop running sync at every poll interval. It
-// also starts the following controller dependencies:
+// RunInformers starts the following controller dependencies and waits for their
+// caches to sync, it is called on the standby replicas as well so that the caches
+// are warm when the leadership is acquired:
 // 1. Service informer
 // 2. Istio custom resource informer
 // 3. Athenz Domain informer
-func (c *Controller) Run(stopCh <-chan struct{}) {
-	go c.serviceIndexInformer.Run(stopCh)
-	go c.configStoreCache.Run(stopCh)
-	go c.adIndexInformer.Run(stopCh)
+func (c *Controller) RunInformers(stopCh <-chan struct{}) {
+	for _, run := range []func(<-chan struct{}){c.serviceIndexInformer.Run, c.configStoreCache.Run, c.adIndexInformer.Run} {
+		c.informers.Add(1)
+		go func(run func(<-chan struct{})) {
+			defer c.informers.Done()
+			run(stopCh)
+		}(run)
+	}
 
 	if !cache.WaitForCacheSync(stopCh, c.configStoreCache.HasSynced, c.serviceIndexInformer.HasSynced, c.adIndexInformer.HasSynced) {
 		log.Panicln("Timed out waiting for namespace cache to sync.")
 	}
+	c.cachesSynced.Set(true)
+}
+
+// RunWorkers starts the processor, the onboarding and authzpolicy controllers and
+// the main controller loop running sync at every poll interval. It blocks until
+// the stop channel is closed and the workers are shut down within the shutdown
+// timeout:
+// 1. The controller workers finish their in-flight key and stop taking new keys
+// 2. The processor stops accepting new items and drains its queue
+// 3. The keys and items left in the queues are reported as unprocessed
+// Once the shutdown timeout expires, the queues are shut down, the number of entries
+// left in them and the entries in flight are reported and it returns without waiting
+// for the workers which are still busy.
+func (c *Controller) RunWorkers(stopCh <-chan struct{}) {
+	c.runWorkers(stopCh, nil)
+}
+
+// runWorkers runs the workers like RunWorkers, they are stopped at once once the lost
+// channel is closed: another replica may be writing as the leader already, so the
+// queues are shut down without waiting for the workers or draining the processor
+func (c *Controller) runWorkers(stopCh <-chan struct{}, lostCh <-chan struct{}) {
+	c.runsWorkers.Set(true)
+	workersStopCh := make(chan struct{})
+	go func() {
+		defer close(workersStopCh)
+		select {
+		case <-stopCh:
+		case <-lostCh:
+		}
+	}()
+
+	var workers sync.WaitGroup
+	run := func(runFn func(<-chan struct{})) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			runFn(workersStopCh)
+		}()
+	}
 
+	// the processor is stopped separately, once the controller workers which add
+	// items to its queue have stopped
+	processorStopCh := make(chan struct{})
+	processorDone := make(chan struct{})
+	go func() {
+		defer close(processorDone)
+		c.processor.Run(processorStopCh)
+	}()
+
+	// the sync statuses are written in the background, the pending writes are dropped on shutdown
+	if c.statusWriter != nil {
+		run(c.statusWriter.Run)
+	}
 	// crc controller must wait for service informer to sync before starting
-	go c.processor.Run(stopCh)
-	go c.crcController.Run(stopCh)
+	if c.crcController != nil {
+		run(c.crcController.Run)
+	}
 	if c.enableAuthzPolicyController {
-		go c.apController.Run(stopCh)
+		run(c.apController.Run)
+	}
+	// the domain controller has nothing to sync without the v1 rbac provider
+	if c.rbacProvider != nil {
+		run(c.runDomainController)
+	}
+
+	select {
+	case <-stopCh:
+	case <-lostCh:
+		log.Warningln("Leadership lost, shutting down the queues without waiting for the controller workers")
+		c.shutDownQueues()
+		close(processorStopCh)
+		c.reportPending()
+		return
+	}
+	log.Infof("Stopping controller workers, shutdown timeout: %s", c.shutdownTimeout)
+	expired := make(chan struct{})
+	deadline := time.AfterFunc(c.shutdownTimeout, func() {
+		close(expired)
+	})
+	defer deadline.Stop()
+
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-expired:
+		// the workers stuck on their in-flight keys are abandoned, so that the shutdown timeout is kept
+		log.Warningln("Timed out waiting for the controller workers to finish their in-flight keys")
+		c.shutDownQueues()
+		close(processorStopCh)
+		c.reportPending()
+		return
 	}
-	go c.resync(stopCh)
 
-	defer c.queue.ShutDown()
-	wait.Until(c.runWorker, 0, stopCh)
+	c.processor.ShutDown()
+	select {
+	case <-processorDone:
+	case <-expired:
+		log.Warningln("Timed out draining the processor queue")
+		c.shutDownQueues()
+		close(processorStopCh)
+		c.reportPending()
+		return
+	}
+	close(processorStopCh)
+
+	c.reportUnprocessed()
+	log.Infoln("Controller workers stopped")
 }
 
-// runWorker calls processNextItem to process events of the work queue
-func (c *Controller) runWorker() {
-	for c.processNextItem() {
+// shutDownQueues shuts down the queues of the controllers, so that the abandoned workers
+// do not take new keys and items off the queues
+func (c *Controller) shutDownQueues() {
+	c.processor.ShutDown()
+	if c.crcController != nil {
+		c.crcController.ShutDown()
 	}
+	if c.enableAuthzPolicyController {
+		c.apController.ShutDown()
+	}
+	c.queue.ShutDown()
 }
 
-// processNextItem takes an item off the queue and calls the controllers sync
-// function, handles the logic of requeuing in case any errors occur
-func (c *Controller) processNextItem() bool {
-	keyRaw, quit := c.queue.Get()
-	if quit {
-		return false
+// reportPending logs the number of keys and items left in the queues of the controllers
+// and the ones in flight. The queues are not drained, since the abandoned workers may
+// still be running.
+func (c *Controller) reportPending() {
+	type pending struct {
+		queued   int
+		inFlight []string
+	}
+	queues := map[string]pending{}
+	queued, inFlight := c.processor.Pending()
+	queues["processor"] = pending{queued, inFlight}
+	if c.crcController != nil {
+		queued, inFlight := c.crcController.Pending()
+		queues["onboarding"] = pending{queued, inFlight}
+	}
+	if c.enableAuthzPolicyController {
+		queued, inFlight := c.apController.Pending()
+		queues["authzpolicy"] = pending{queued, inFlight}
+	}
+	if c.rbacProvider != nil {
+		queued, inFlight := c.worker.Pending()
+		queues["domain"] = pending{queued, inFlight}
+	}
+
+	for _, name := range []string{"domain", "authzpolicy", "onboarding", "processor"} {
+		p := queues[name]
+		if p.queued == 0 && len(p.inFlight) == 0 {
+			continue
+		}
+		log.Warningf("Shutdown left %d queued entries and %d in-flight entries in the %s queue, in flight: %s", p.queued, len(p.inFlight), name, strings.Join(p.inFlight, ", "))
+	}
+}
+
+// reportUnprocessed logs the keys and items left in the queues of the controllers, it
+// must only be called once the workers have returned
+func (c *Controller) reportUnprocessed() {
+	unprocessed := map[string][]string{
+		"processor": c.processor.Unprocessed(),
+	}
+	if c.crcController != nil {
+		unprocessed["onboarding"] = c.crcController.Unprocessed()
+	}
+	if c.enableAuthzPolicyController {
+		unprocessed["authzpolicy"] = c.apController.Unprocessed()
+	}
+	if c.rbacProvider != nil {
+		unprocessed["domain"] = c.worker.Unprocessed()
 	}
 
-	defer c.queue.Done(keyRaw)
+	total := 0
+	for _, name := range []string{"domain", "authzpolicy", "onboarding", "processor"} {
+		if len(unprocessed[name]) == 0 {
+			continue
+		}
+		total += len(unprocessed[name])
+		log.Warningf("Shutdown left %d unprocessed entries in the %s queue: %s", len(unprocessed[name]), name, strings.Join(unprocessed[name], ", "))
+	}
+	if total == 0 {
+		log.Infoln("All of the queues are drained")
+	}
+}
+
+// runDomainController runs the athenz domain resync and the worker of the domain controller
+func (c *Controller) runDomainController(stopCh <-chan struct{}) {
+	go c.resync(stopCh)
+
+	c.worker.Run(stopCh, c.processItem)
+}
+
+// Run starts the informers and the workers of the controller, it is used when
+// leader election is disabled. It blocks until the stop channel is closed and
+// all of the informers and workers have returned.
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	// the replica runs the workers from the start, it is not ready until they are started
+	c.runsWorkers.Set(true)
+	c.RunInformers(stopCh)
+	c.RunWorkers(stopCh)
+	c.informers.Wait()
+}
+
+// processItem calls the controllers sync function for a key taken off the queue,
+// handles the logic of requeuing in case any errors occur
+func (c *Controller) processItem(keyRaw interface{}) {
 	key, ok := keyRaw.(string)
 	if !ok {
 		log.Errorf("String cast failed for key %v", key)
 		c.queue.Forget(keyRaw)
-		return true
+		return
 	}
 
 	log.Infof("Processing key: %s", key)
+	start := time.Now()
 	err := c.sync(key)
+	metrics.ObserveSync(controllerName, start)
 	if err != nil {
 		log.Errorf("Error syncing athenz state for key %s: %s", keyRaw, err)
 		if c.queue.NumRequeues(keyRaw) < queueNumRetries {
 			log.Infof("Retrying key %s due to sync error", keyRaw)
 			c.queue.AddRateLimited(keyRaw)
-			return true
+			return
 		}
+		metrics.QueueDropsTotal.WithLabelValues(controllerName).Inc()
+		common.NewEventReporter(c.recorder, common.DomainReference(key)).RetriesExhausted("sync", key, err)
 	}
-
-	return true
 }
 
 // resync will run as a periodic resync at a given interval, it will take all
 // the current athenz domains in the cache and put them onto the queue
 func (c *Controller) resync(stopCh <-chan struct{}) {
+	for _, domain := range c.tombstoneOrphanedDomains() {
+		c.queue.Add(domain)
+	}
+
 	t := time.NewTicker(c.adResyncInterval)
 	defer t.Stop()
 	for {
@@ -280,9 +710,45 @@ func (c *Controller) resync(stopCh <-chan struct{}) {
 			for _, adRaw := range adListRaw {
 				c.processEvent(cache.MetaNamespaceKeyFunc, adRaw)
 			}
+			c.tombstoneOrphanedDomains()
+			// the deleted athenz domains are re-synced until their delete policy is applied
+			for _, domain := range c.tombstones.List() {
+				c.queue.Add(domain)
+			}
 		case <-stopCh:
 			log.Infoln("Stopping athenz domain resync...")
 			return
 		}
 	}
 }
+
+// tombstoneOrphanedDomains records the tombstones of the athenz domains which own service roles or service role
+// bindings but do not exist anymore, and returns them. The tombstones are only kept in memory, the domains deleted
+// while no replica was running, or before the leader changed, are derived from the source domain annotation of the
+// owned resources so that the domain delete policy is still applied to them.
+func (c *Controller) tombstoneOrphanedDomains() []string {
+	if c.domainDeletePolicy == rbac.DomainDeleteRetain {
+		return nil
+	}
+	var configs []model.Config
+	for _, schema := range []collection.Schema{collections.IstioRbacV1Alpha1Serviceroles, collections.IstioRbacV1Alpha1Servicerolebindings} {
+		list, err := c.configStoreCache.List(schema.Resource().GroupVersionKind(), v1.NamespaceAll)
+		if err != nil {
+			log.Errorf("Error listing the %s resources for the deleted athenz domains: %s", schema.Resource().Kind(), err.Error())
+			return nil
+		}
+		configs = append(configs, list...)
+	}
+	orphaned := common.OrphanedDomains(configs, func(domain string) bool {
+		if _, exists, _ := c.adIndexInformer.GetIndexer().GetByKey(domain); exists {
+			return true
+		}
+		_, tombstoned := c.tombstones.Get(domain)
+		return tombstoned
+	})
+	for _, domain := range orphaned {
+		log.Infof("Athenz domain %s of the owned service roles and bindings does not exist, applying the %s domain delete policy", domain, c.domainDeletePolicy)
+		c.tombstones.Add(&adv1.AthenzDomain{ObjectMeta: metav1.ObjectMeta{Name: domain}})
+	}
+	return orphaned
+}
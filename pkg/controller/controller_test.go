@@ -1,16 +1,27 @@
 package controller
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
 	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config/schema/collection"
+	"istio.io/istio/pkg/config/schema/collections"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
 	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
 	"github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned/fake"
 	adInformer "github.com/yahoo/k8s-athenz-syncer/pkg/client/informers/externalversions/athenz/v1"
@@ -64,25 +75,124 @@ func TestProcessConfigEvent(t *testing.T) {
 	assert.Equal(t, "test.namespace", item, "key should be equal")
 }
 
+func TestProcessDelegatingDomains(t *testing.T) {
+	c := &Controller{
+		queue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		trustIndex: athenz.NewTrustIndex(),
+	}
+	c.trustIndex.Update("home.domain", athenz.RoleTrustDomains{
+		"home.domain:role.delegated": []zms.DomainName{"test-namespace/test.namespace"},
+	})
+
+	c.processDelegatingDomains(cache.MetaNamespaceKeyFunc, ad.DeepCopy())
+
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1")
+	item, shutdown := c.queue.Get()
+	assert.False(t, shutdown, "shutdown should be false")
+	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0")
+	assert.Equal(t, "home.domain", item, "key should be equal")
+}
+
 func TestResync(t *testing.T) {
 	fakeClientset := fake.NewSimpleClientset()
 	adIndexInformer := adInformer.NewAthenzDomainInformer(fakeClientset, 0, cache.Indexers{})
 	adIndexInformer.GetStore().Add(ad.DeepCopy())
 
+	configStore := memory.Make(collection.SchemasFor(collections.IstioRbacV1Alpha1Serviceroles, collections.IstioRbacV1Alpha1Servicerolebindings))
+	// the service role of a domain deleted before the controller started is left with its source domain
+	orphanedSR := common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "orphaned-domain", "reader", &v1alpha1.ServiceRole{
+		Rules: []*v1alpha1.AccessRule{{Services: []string{common.WildCardAll}, Methods: []string{"GET"}}},
+	})
+	common.SetManagedMetadata(&orphanedSR, "orphaned.domain", "orphaned.domain:role.reader")
+	_, err := configStore.Create(orphanedSR)
+	assert.Nil(t, err, "configstore create resource should not return error")
+
 	c := &Controller{
-		queue:            workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		adIndexInformer:  adIndexInformer,
-		adResyncInterval: time.Second * 1,
+		queue:              workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		adIndexInformer:    adIndexInformer,
+		configStoreCache:   memory.NewController(configStore),
+		adResyncInterval:   time.Second * 1,
+		tombstones:         athenz.NewTombstones(),
+		domainDeletePolicy: rbac.DomainDeleteDelete,
 	}
+	// the deleted domain is re-synced until its tombstone is purged
+	c.tombstones.Add(&adv1.AthenzDomain{ObjectMeta: v1.ObjectMeta{Name: "deleted.domain"}})
 
 	stopCh := make(chan struct{})
 	go c.resync(stopCh)
 	time.Sleep(time.Second * 2)
 	close(stopCh)
 
-	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1")
+	assert.Equal(t, 3, c.queue.Len(), "queue length should be 3")
 	item, shutdown := c.queue.Get()
 	assert.False(t, shutdown, "shutdown should be false")
-	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0")
+	assert.Equal(t, "orphaned.domain", item, "orphaned domain should be synced at start")
+	_, tombstoned := c.tombstones.Get("orphaned.domain")
+	assert.True(t, tombstoned, "tombstone should be derived from the owned service role")
+	item, shutdown = c.queue.Get()
+	assert.False(t, shutdown, "shutdown should be false")
 	assert.Equal(t, "test-namespace/test.namespace", item, "key should be equal")
+	item, shutdown = c.queue.Get()
+	assert.False(t, shutdown, "shutdown should be false")
+	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0")
+	assert.Equal(t, "deleted.domain", item, "key should be equal")
+}
+
+func TestProcessDomainMappingEvent(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset()
+	adIndexInformer := adInformer.NewAthenzDomainInformer(fakeClientset, 0, cache.Indexers{})
+	adIndexInformer.GetStore().Add(&adv1.AthenzDomain{ObjectMeta: v1.ObjectMeta{Name: "existing.domain"}})
+
+	c := &Controller{
+		queue:           workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		adIndexInformer: adIndexInformer,
+		tombstones:      athenz.NewTombstones(),
+		rbacProvider:    rbacv1.NewProvider(false),
+	}
+	c.tombstones.Add(&adv1.AthenzDomain{ObjectMeta: v1.ObjectMeta{Name: "deleted.domain"}})
+
+	c.ProcessDomainMappingEvent("existing.domain")
+	c.ProcessDomainMappingEvent("deleted.domain")
+	c.ProcessDomainMappingEvent("unknown.domain")
+	assert.Equal(t, 2, c.queue.Len(), "queue length should be 2")
+	item, _ := c.queue.Get()
+	assert.Equal(t, "existing.domain", item, "key should be equal")
+	item, _ = c.queue.Get()
+	assert.Equal(t, "deleted.domain", item, "key should be equal")
+}
+
+func TestSyncDeletedDomainWithoutTombstone(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset()
+	adIndexInformer := adInformer.NewAthenzDomainInformer(fakeClientset, 0, cache.Indexers{})
+
+	c := &Controller{
+		queue:              workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		adIndexInformer:    adIndexInformer,
+		trustIndex:         athenz.NewTrustIndex(),
+		tombstones:         athenz.NewTombstones(),
+		domainDeletePolicy: rbac.DomainDeleteRetain,
+	}
+	// the domains deleted under the retain policy are expected to have no tombstone
+	assert.Nil(t, c.sync("deleted.domain"), "sync of a retained deleted domain should not return an error")
+
+	c.domainDeletePolicy = rbac.DomainDeleteDelete
+	assert.EqualError(t, c.sync("deleted.domain"), "athenz domain deleted.domain does not exist in cache", "sync of an unknown domain should return an error")
+}
+
+func TestRecordSyncOnApply(t *testing.T) {
+	cbHandler := func(err error, item *common.Item) error {
+		return err
+	}
+	changeList := []*common.Item{
+		{Operation: model.EventAdd, CallbackHandler: cbHandler},
+		{Operation: model.EventDelete, CallbackHandler: cbHandler},
+	}
+	recordSyncOnApply("applied.domain", changeList)
+
+	assert.Nil(t, changeList[0].CallbackHandler(nil, changeList[0]), "callback handler should return the error of the wrapped handler")
+	assert.False(t, metrics.LastSuccessfulSync.DeleteLabelValues(controllerName, "applied.domain"), "sync should not be recorded until all the changes are applied")
+	assert.NotNil(t, changeList[1].CallbackHandler(errors.New("conflict"), changeList[1]), "callback handler should return the error of the wrapped handler")
+	assert.False(t, metrics.LastSuccessfulSync.DeleteLabelValues(controllerName, "applied.domain"), "sync should not be recorded when a change fails")
+	assert.Nil(t, changeList[1].CallbackHandler(nil, changeList[1]), "callback handler should return the error of the wrapped handler")
+	assert.True(t, metrics.LastSuccessfulSync.DeleteLabelValues(controllerName, "applied.domain"), "sync should be recorded once all the changes are applied")
 }
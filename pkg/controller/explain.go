@@ -0,0 +1,42 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/explain"
+	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Explain evaluates the request against the athenz domain of the namespace in the cache. The svc label of the
+// service is matched with the athenz assertions if the service is found in the cache.
+func (c *Controller) Explain(req explain.Request) (*explain.Explanation, error) {
+	domainName := athenz.ResolveDomain(req.Namespace)
+	athenzDomainRaw, exists, err := c.adIndexInformer.GetIndexer().GetByKey(domainName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("athenz domain %s does not exist in cache", domainName)
+	}
+	athenzDomain, ok := athenzDomainRaw.(*adv1.AthenzDomain)
+	if !ok {
+		return nil, errors.New("athenz domain cast failed")
+	}
+
+	serviceRaw, exists, err := c.serviceIndexInformer.GetIndexer().GetByKey(req.Namespace + "/" + req.Service)
+	if err != nil {
+		return nil, err
+	}
+	if service, ok := serviceRaw.(*v1.Service); exists && ok {
+		req.SvcLabel = service.Labels["svc"]
+	}
+
+	athenzModel := athenz.ConvertAthenzPoliciesIntoRbacModel(athenzDomain.Spec.SignedDomain.Domain, &c.adIndexInformer, c.maxTrustDepth)
+	return explain.Explain(athenzModel, req, c.conversionOptions), nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CheckReadiness returns an error until the caches are synced and, on the replica
+// running the workers, the stale authorization policies are cleaned up. The standby
+// replicas of the leader election are ready once their caches are synced.
+func (c *Controller) CheckReadiness() error {
+	if !c.cachesSynced.IsSet() {
+		return errors.New("caches are not synced")
+	}
+	if c.runsWorkers.IsSet() && c.enableAuthzPolicyController && !c.apController.CleanedUp() {
+		return errors.New("stale authorization policies are not cleaned up")
+	}
+	return nil
+}
+
+// CheckLiveness returns an error if a worker has not made progress within the
+// threshold while its queue is not empty
+func (c *Controller) CheckLiveness(threshold time.Duration) error {
+	var stalled []string
+	if c.rbacProvider != nil && c.worker.Stalled(threshold) {
+		stalled = append(stalled, controllerName)
+	}
+	if c.enableAuthzPolicyController && c.apController.Stalled(threshold) {
+		stalled = append(stalled, "authzpolicy")
+	}
+	if c.crcController != nil && c.crcController.Stalled(threshold) {
+		stalled = append(stalled, "onboarding")
+	}
+	if c.processor.Stalled(threshold) {
+		stalled = append(stalled, "processor")
+	}
+
+	if len(stalled) > 0 {
+		return fmt.Errorf("%s workers have not made progress within %s", strings.Join(stalled, ", "), threshold)
+	}
+	return nil
+}
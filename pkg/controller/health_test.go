@@ -0,0 +1,46 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	c := newFakeRunController()
+	assert.NotNil(t, c.CheckReadiness(), "controller should not be ready before the caches are synced")
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c.RunInformers(stopCh)
+	assert.Nil(t, c.CheckReadiness(), "controller should be ready once the caches are synced")
+}
+
+func TestCheckLiveness(t *testing.T) {
+	c := newFakeRunController()
+	c.rbacProvider = rbacv1.NewProvider(false)
+	c.queue.Add("test.domain")
+	assert.Nil(t, c.CheckLiveness(time.Nanosecond), "worker which has not started should pass the liveness check")
+
+	// the worker blocks on the first key while the second one is left in the queue
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	release := make(chan struct{})
+	defer close(release)
+	taken := make(chan struct{})
+	go c.worker.Run(stopCh, func(interface{}) {
+		close(taken)
+		<-release
+	})
+	<-taken
+	c.queue.Add("other.domain")
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, c.CheckLiveness(time.Minute), "worker with progress within the threshold should pass the liveness check")
+	assert.Equal(t, "domain workers have not made progress within 1ns", c.CheckLiveness(time.Nanosecond).Error(),
+		"worker without progress within the threshold should fail the liveness check")
+}
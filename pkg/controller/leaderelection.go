@@ -0,0 +1,118 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionConfig holds the lease and the timings used to elect the replica running the controller workers
+type LeaderElectionConfig struct {
+	// Namespace and Name of the lease
+	Namespace string
+	Name      string
+	// Identity of the replica, it must be unique across the replicas
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// RunWithLeaderElection starts the informers on every replica so that the caches are warm on standby, and runs the
+// workers while the replica holds the lease. On stop the workers are stopped before the lease is released. An error
+// is returned if the leadership is lost, the work queues are shut down at that point so the replica must be restarted
+// to stand by again.
+func (c *Controller) RunWithLeaderElection(stopCh <-chan struct{}, k8sClient kubernetes.Interface, config LeaderElectionConfig) error {
+	c.RunInformers(stopCh)
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: config.Namespace,
+			Name:      config.Name,
+		},
+		Client: k8sClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.Identity,
+		},
+	}
+
+	var mu sync.Mutex
+	leading := false
+	workersDone := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				mu.Lock()
+				leading = true
+				mu.Unlock()
+				defer close(workersDone)
+
+				log.Infof("Acquired the leadership of lease %s/%s as %s, starting controller workers", config.Namespace, config.Name, config.Identity)
+				// the workers are drained within the shutdown timeout on stop, but not once the
+				// leadership is lost since the new leader may already be writing
+				c.runWorkers(stopCh, leaderCtx.Done())
+			},
+			OnStoppedLeading: func() {
+				log.Infof("Stopped leading lease %s/%s as %s", config.Namespace, config.Name, config.Identity)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != config.Identity {
+					log.Infof("Lease %s/%s is held by %s, standing by", config.Namespace, config.Name, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the workers are stopped before the election is cancelled, so that the lease is
+	// only released once none of the workers is writing anymore
+	isLeading := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return leading
+	}
+	go func() {
+		select {
+		case <-stopCh:
+			if isLeading() {
+				<-workersDone
+			}
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	elector.Run(ctx)
+	if isLeading() {
+		<-workersDone
+	}
+
+	select {
+	case <-stopCh:
+		c.informers.Wait()
+		return nil
+	default:
+		return errors.New("leadership lost")
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/istio/pilot/pkg/config/memory"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config/schema/collection"
+	"istio.io/istio/pkg/config/schema/collections"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	fcache "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeConfigStoreCache returns from Run once the stop channel is closed. The memory
+// config store is kept running, its monitor blocks on stop until it receives one
+// more event and panics on writes made after stop, while the processor queue drains.
+type fakeConfigStoreCache struct {
+	model.ConfigStoreCache
+}
+
+func (c *fakeConfigStoreCache) Run(stopCh <-chan struct{}) {
+	go c.ConfigStoreCache.Run(make(chan struct{}))
+	<-stopCh
+}
+
+func newFakeRunController() *Controller {
+	configStore := memory.Make(collection.SchemasFor(collections.IstioRbacV1Alpha1Serviceroles))
+	configStoreCache := &fakeConfigStoreCache{memory.NewController(configStore)}
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	return &Controller{
+		configStoreCache:     configStoreCache,
+		processor:            processor.NewController(configStoreCache, nil),
+		serviceIndexInformer: cache.NewSharedIndexInformer(fcache.NewFakeControllerSource(), &corev1.Service{}, 0, nil),
+		adIndexInformer:      cache.NewSharedIndexInformer(fcache.NewFakeControllerSource(), &adv1.AthenzDomain{}, 0, nil),
+		queue:                queue,
+		worker:               common.NewWorker(queue, nil),
+		shutdownTimeout:      5 * time.Second,
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	c := newFakeRunController()
+	stopCh := make(chan struct{})
+	c.RunInformers(stopCh)
+
+	done := make(chan struct{})
+	go func() {
+		c.RunWorkers(stopCh)
+		close(done)
+	}()
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers should stop once the stop channel is closed")
+	}
+}
+
+func newServiceRoleSpec() *v1alpha1.ServiceRole {
+	return &v1alpha1.ServiceRole{
+		Rules: []*v1alpha1.AccessRule{
+			{
+				Services: []string{common.WildCardAll},
+				Methods:  []string{"GET"},
+			},
+		},
+	}
+}
+
+func TestRunWorkersDrainsProcessor(t *testing.T) {
+	c := newFakeRunController()
+	stopCh := make(chan struct{})
+	c.RunInformers(stopCh)
+
+	done := make(chan struct{})
+	go func() {
+		c.RunWorkers(stopCh)
+		close(done)
+	}()
+
+	for _, name := range []string{"reader", "writer", "admin"} {
+		c.processor.ProcessConfigChange(&common.Item{
+			Operation: model.EventAdd,
+			Resource:  common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "test-namespace", name, newServiceRoleSpec()),
+		})
+	}
+	close(stopCh)
+	<-done
+
+	configs, err := c.configStoreCache.List(collections.IstioRbacV1Alpha1Serviceroles.Resource().GroupVersionKind(), "test-namespace")
+	assert.Nil(t, err, "list should not return an error")
+	assert.Equal(t, 3, len(configs), "processor queue should be drained on shutdown")
+
+	// the queue does not accept new items once it is drained
+	c.processor.ProcessConfigChange(&common.Item{
+		Operation: model.EventAdd,
+		Resource:  common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "test-namespace", "late", newServiceRoleSpec()),
+	})
+	assert.Nil(t, c.processor.Unprocessed(), "processor should not accept items after shutdown")
+}
+
+func TestRunWorkersShutdownTimeout(t *testing.T) {
+	c := newFakeRunController()
+	c.shutdownTimeout = 500 * time.Millisecond
+	stopCh := make(chan struct{})
+	c.RunInformers(stopCh)
+
+	done := make(chan struct{})
+	go func() {
+		c.RunWorkers(stopCh)
+		close(done)
+	}()
+
+	// the callback handler of the first item blocks the processor worker past the shutdown timeout
+	release := make(chan struct{})
+	defer close(release)
+	blocked := make(chan struct{})
+	c.processor.ProcessConfigChange(&common.Item{
+		Operation: model.EventAdd,
+		Resource:  common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "test-namespace", "blocking", newServiceRoleSpec()),
+		CallbackHandler: func(err error, item *common.Item) error {
+			close(blocked)
+			<-release
+			return nil
+		},
+	})
+	c.processor.ProcessConfigChange(&common.Item{
+		Operation: model.EventAdd,
+		Resource:  common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "test-namespace", "pending", newServiceRoleSpec()),
+	})
+	<-blocked
+	close(stopCh)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers should return once the shutdown timeout expires")
+	}
+	// the queue is not drained while the abandoned worker is still running
+	queued, inFlight := c.processor.Pending()
+	assert.Equal(t, 1, queued, "pending item should be left in the queue")
+	assert.Equal(t, 1, len(inFlight), "blocking item should be in flight")
+	assert.Contains(t, inFlight[0], "blocking", "blocking item should be in flight")
+}
+
+func TestRunWorkersLeadershipLost(t *testing.T) {
+	c := newFakeRunController()
+	c.shutdownTimeout = time.Minute
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c.RunInformers(stopCh)
+
+	lostCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.runWorkers(stopCh, lostCh)
+		close(done)
+	}()
+
+	// the callback handler of the first item blocks the processor worker, the pending item must not be written
+	// once the leadership is lost
+	release := make(chan struct{})
+	defer close(release)
+	blocked := make(chan struct{})
+	c.processor.ProcessConfigChange(&common.Item{
+		Operation: model.EventAdd,
+		Resource:  common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "test-namespace", "blocking", newServiceRoleSpec()),
+		CallbackHandler: func(err error, item *common.Item) error {
+			close(blocked)
+			<-release
+			return nil
+		},
+	})
+	c.processor.ProcessConfigChange(&common.Item{
+		Operation: model.EventAdd,
+		Resource:  common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "test-namespace", "pending", newServiceRoleSpec()),
+	})
+	<-blocked
+	close(lostCh)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers should return at once when the leadership is lost")
+	}
+	queued, _ := c.processor.Pending()
+	assert.Equal(t, 1, queued, "pending item should not be drained when the leadership is lost")
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	c := newFakeRunController()
+	k8sClient := k8sfake.NewSimpleClientset()
+	config := LeaderElectionConfig{
+		Namespace:     "test-namespace",
+		Name:          "test-lease",
+		Identity:      "replica-1",
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+
+	stopCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.RunWithLeaderElection(stopCh, k8sClient, config)
+	}()
+
+	holder := func() string {
+		lease, err := k8sClient.CoordinationV1().Leases(config.Namespace).Get(config.Name, v1.GetOptions{})
+		if err != nil || lease.Spec.HolderIdentity == nil {
+			return ""
+		}
+		return *lease.Spec.HolderIdentity
+	}
+	assert.Eventually(t, func() bool {
+		return holder() == config.Identity
+	}, 5*time.Second, 100*time.Millisecond, "replica should acquire the lease")
+
+	close(stopCh)
+	select {
+	case err := <-errCh:
+		assert.Nil(t, err, "leader election should not return an error on stop")
+	case <-time.After(5 * time.Second):
+		t.Fatal("leader election should return once the stop channel is closed")
+	}
+	assert.Equal(t, "", holder(), "lease should be released on stop")
+}
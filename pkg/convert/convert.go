@@ -0,0 +1,216 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	rbacv1 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v1"
+	rbacv2 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v2"
+	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config/schema/collections"
+	corev1 "k8s.io/api/core/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	authzEnabled           = "true"
+	authzEnabledAnnotation = "authz.istio.io/enabled"
+
+	athenzDomainKind = "AthenzDomain"
+	serviceKind      = "Service"
+	listKind         = "List"
+)
+
+// Options of the conversion
+type Options struct {
+	// Provider selects the v1 ServiceRole and ServiceRoleBinding resources, the v2 AuthorizationPolicy resources or both
+	Provider               rbac.ProviderMode
+	EnableOriginJwtSubject bool
+	// SelectorLabels are the label keys of the service selectors used as the workload selectors of the
+	// AuthorizationPolicy resources, all the selector labels are used if it is empty
+	SelectorLabels []string
+	// MaxTrustDepth is the maximum number of trust domains followed when resolving the members of a delegated role
+	MaxTrustDepth int
+	// Conversion selects how the assertions are matched with the Services and converted into AuthorizationPolicy
+	// resources
+	Conversion common.ConversionOptions
+}
+
+// Input holds the Athenz Domains and the Services read from the manifests
+type Input struct {
+	AthenzDomains []*adv1.AthenzDomain
+	Services      []*corev1.Service
+}
+
+// Result holds the generated Istio RBAC resources and the warnings of the Athenz data which could not be converted
+type Result struct {
+	Configs  []model.Config
+	Warnings []string
+}
+
+// Decode reads the AthenzDomain and Service manifests, as YAML or JSON documents or lists, and appends them to
+// the input. The manifests of other kinds are ignored.
+func Decode(r io.Reader, in *Input) error {
+	decoder := k8syaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var obj map[string]interface{}
+		err := decoder.Decode(&obj)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding manifest: %s", err)
+		}
+		if obj == nil {
+			continue
+		}
+		if err := in.add(obj); err != nil {
+			return err
+		}
+	}
+}
+
+// add appends the object to the input according to its kind
+func (in *Input) add(obj map[string]interface{}) error {
+	kind, _ := obj["kind"].(string)
+	switch kind {
+	case listKind:
+		items, _ := obj["items"].([]interface{})
+		for _, item := range items {
+			itemObj, ok := item.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("list item is not an object: %v", item)
+			}
+			if err := in.add(itemObj); err != nil {
+				return err
+			}
+		}
+	case athenzDomainKind:
+		athenzDomain := &adv1.AthenzDomain{}
+		if err := fromObject(obj, athenzDomain); err != nil {
+			return fmt.Errorf("error decoding athenz domain: %s", err)
+		}
+		in.AthenzDomains = append(in.AthenzDomains, athenzDomain)
+	case serviceKind:
+		service := &corev1.Service{}
+		if err := fromObject(obj, service); err != nil {
+			return fmt.Errorf("error decoding service: %s", err)
+		}
+		in.Services = append(in.Services, service)
+	}
+	return nil
+}
+
+// fromObject converts the decoded object into the typed object through json, the athenz types rely on their json
+// unmarshalers
+func fromObject(obj map[string]interface{}, out interface{}) error {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// Convert generates the Istio RBAC resources of the Athenz Domains. The v1 resources are generated for every Athenz
+// Domain and the v2 resources for every authz enabled Service in the namespace of an Athenz Domain. The delegated
+// roles are resolved against the Athenz Domains of the input.
+func Convert(in Input, opts Options) Result {
+	informer := in.newInformer()
+	var result Result
+	for _, athenzDomain := range in.AthenzDomains {
+		domainName := athenzDomain.Name
+		domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(athenzDomain.Spec.Domain, &informer, opts.MaxTrustDepth)
+
+		if opts.Provider.V1Enabled() {
+			reporter := common.NewEventReporter(nil)
+			configs := rbacv1.NewProvider(opts.EnableOriginJwtSubject).ConvertAthenzModelIntoIstioRbac(domainRBAC, "", "", nil, reporter)
+			result.Configs = append(result.Configs, configs...)
+			result.addWarnings("domain "+domainName, reporter)
+		}
+
+		if opts.Provider.V2Enabled() {
+			provider := rbacv2.NewProvider(nil, opts.EnableOriginJwtSubject, opts.Conversion)
+			for _, service := range in.Services {
+				if service.Namespace != domainRBAC.Namespace || service.Annotations[authzEnabledAnnotation] != authzEnabled {
+					continue
+				}
+				selector, err := common.WorkloadSelector(service, opts.SelectorLabels)
+				if err != nil {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("service %s/%s: skipped service: %s", service.Namespace, service.Name, err))
+					continue
+				}
+				reporter := common.NewEventReporter(nil)
+				configs := provider.ConvertAthenzModelIntoIstioRbac(domainRBAC, service.Name, service.Labels["svc"], selector, reporter)
+				result.Configs = append(result.Configs, configs...)
+				result.addWarnings("service "+service.Namespace+"/"+service.Name, reporter)
+			}
+		}
+	}
+	return result
+}
+
+// NewModel returns the athenz model of the Athenz Domain of the input with the given name, the delegated roles are
+// resolved against the Athenz Domains of the input by following at most maxTrustDepth trust domains
+func NewModel(in Input, domainName string, maxTrustDepth int) (athenz.Model, error) {
+	for _, athenzDomain := range in.AthenzDomains {
+		if athenzDomain.Name == domainName {
+			informer := in.newInformer()
+			return athenz.ConvertAthenzPoliciesIntoRbacModel(athenzDomain.Spec.Domain, &informer, maxTrustDepth), nil
+		}
+	}
+	return athenz.Model{}, fmt.Errorf("athenz domain %s is not found in the input", domainName)
+}
+
+// newInformer returns an informer holding the Athenz Domains of the input, it is never run and only its store is
+// used to look up the trust domains
+func (in Input) newInformer() cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &adv1.AthenzDomain{}, 0, cache.Indexers{})
+	for _, athenzDomain := range in.AthenzDomains {
+		informer.GetStore().Add(athenzDomain)
+	}
+	return informer
+}
+
+// addWarnings appends the assertions and members skipped by the reporter to the warnings
+func (r *Result) addWarnings(source string, reporter *common.EventReporter) {
+	skippedAssertions, skippedMembers := reporter.Skipped()
+	for _, a := range skippedAssertions {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%s: skipped assertion %s %s on %s: %s", source, a.Role, a.Action, a.Resource, a.Message))
+	}
+	for _, m := range skippedMembers {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%s: skipped member %s of %s: %s", source, m.Member, m.Role, m.Reason))
+	}
+}
+
+// Encode writes the Istio RBAC resources as a stream of YAML documents
+func Encode(w io.Writer, configs []model.Config) error {
+	for _, config := range configs {
+		schema, exists := collections.All.FindByGroupVersionKind(config.GroupVersionKind())
+		if !exists {
+			return fmt.Errorf("unknown schema for %s", config.Key())
+		}
+		obj, err := crd.ConvertConfig(schema, config)
+		if err != nil {
+			return fmt.Errorf("error converting %s: %s", config.Key(), err)
+		}
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("error marshaling %s: %s", config.Key(), err)
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
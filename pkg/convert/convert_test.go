@@ -0,0 +1,208 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package convert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"istio.io/api/security/v1beta1"
+	"istio.io/istio/pilot/pkg/config/kube/crd"
+	"istio.io/istio/pilot/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+const athenzDomainManifest = `
+apiVersion: athenz.io/v1
+kind: AthenzDomain
+metadata:
+  name: test.namespace
+spec:
+  keyId: "0"
+  signature: signature
+  domain:
+    name: test.namespace
+    modified: "2021-01-01T00:00:00.000Z"
+    roles:
+    - name: test.namespace:role.reader
+      roleMembers:
+      - memberName: user.name
+    policies:
+      keyId: "0"
+      signature: signature
+      contents:
+        domain: test.namespace
+        policies:
+        - name: test.namespace:policy.reader
+          assertions:
+          - role: test.namespace:role.reader
+            resource: test.namespace:svc.productpage
+            action: get
+            effect: ALLOW
+          - role: test.namespace:role.reader
+            resource: test.namespace:svc.productpage
+            action: launch
+            effect: ALLOW
+`
+
+const serviceManifests = `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: productpage
+    namespace: test-namespace
+    annotations:
+      authz.istio.io/enabled: "true"
+    labels:
+      app: productpage
+      svc: productpage
+  spec:
+    selector:
+      app: productpage
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: details
+    namespace: test-namespace
+    labels:
+      app: details
+      svc: details
+  spec:
+    selector:
+      app: details
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: external
+    namespace: test-namespace
+    annotations:
+      authz.istio.io/enabled: "true"
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: productpage
+  namespace: test-namespace
+`
+
+func decodeTestInput(t *testing.T) Input {
+	var in Input
+	assert.Nil(t, Decode(strings.NewReader(athenzDomainManifest), &in), "decode should not return an error")
+	assert.Nil(t, Decode(strings.NewReader(serviceManifests), &in), "decode should not return an error")
+	return in
+}
+
+func TestDecode(t *testing.T) {
+	in := decodeTestInput(t)
+	assert.Equal(t, 1, len(in.AthenzDomains), "athenz domain should be decoded")
+	assert.Equal(t, "test.namespace", in.AthenzDomains[0].Name, "athenz domain name should be equal")
+	assert.Equal(t, 2, len(in.AthenzDomains[0].Spec.Domain.Policies.Contents.Policies[0].Assertions), "athenz domain assertions should be decoded")
+	assert.Equal(t, 3, len(in.Services), "services of the list should be decoded and other kinds ignored")
+
+	err := Decode(strings.NewReader("kind: AthenzDomain\nspec:\n  domain: invalid\n"), &Input{})
+	assert.NotNil(t, err, "decode should return an error for an invalid athenz domain")
+}
+
+func TestConvert(t *testing.T) {
+	in := decodeTestInput(t)
+
+	cases := []struct {
+		test             string
+		provider         rbac.ProviderMode
+		expectedNames    []string
+		expectedWarnings int
+	}{
+		{
+			test:             "v1 provider",
+			provider:         rbac.ProviderV1,
+			expectedNames:    []string{"ServiceRole/reader", "ServiceRoleBinding/reader"},
+			expectedWarnings: 1,
+		},
+		{
+			test:             "v2 provider generates the authz enabled services with a selector only",
+			provider:         rbac.ProviderV2,
+			expectedNames:    []string{"AuthorizationPolicy/productpage"},
+			expectedWarnings: 2,
+		},
+		{
+			test:             "both providers",
+			provider:         rbac.ProviderBoth,
+			expectedNames:    []string{"ServiceRole/reader", "ServiceRoleBinding/reader", "AuthorizationPolicy/productpage"},
+			expectedWarnings: 3,
+		},
+	}
+
+	for _, c := range cases {
+		result := Convert(in, Options{Provider: c.provider, EnableOriginJwtSubject: true, MaxTrustDepth: athenz.DefaultMaxTrustDepth, Conversion: common.DefaultConversionOptions()})
+		var actualNames []string
+		for _, config := range result.Configs {
+			actualNames = append(actualNames, config.Type+"/"+config.Name)
+		}
+		assert.Equal(t, c.expectedNames, actualNames, c.test)
+		assert.Equal(t, c.expectedWarnings, len(result.Warnings), c.test)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	result := Convert(decodeTestInput(t), Options{Provider: rbac.ProviderBoth, EnableOriginJwtSubject: true, MaxTrustDepth: athenz.DefaultMaxTrustDepth, Conversion: common.DefaultConversionOptions()})
+	var out bytes.Buffer
+	assert.Nil(t, Encode(&out, result.Configs), "encode should not return an error")
+
+	configs, _, err := crd.ParseInputs(out.String())
+	assert.Nil(t, err, "encoded resources should be parsed")
+	assert.Equal(t, len(result.Configs), len(configs), "every resource should be encoded")
+	for i := range configs {
+		assert.Equal(t, result.Configs[i].Key(), configs[i].Key(), "encoded resource key should be equal")
+	}
+	assert.Equal(t, 1, len(authzPolicyRules(configs)), "encoded authorization policy should keep its rule")
+}
+
+func authzPolicyRules(configs []model.Config) []*v1beta1.Rule {
+	var rules []*v1beta1.Rule
+	for _, config := range configs {
+		if spec, ok := config.Spec.(*v1beta1.AuthorizationPolicy); ok {
+			rules = append(rules, spec.Rules...)
+		}
+	}
+	return rules
+}
+
+func TestSvcMatchChanges(t *testing.T) {
+	in := decodeTestInput(t)
+	assert.Empty(t, SvcMatchChanges(in, athenz.DefaultMaxTrustDepth), "exact svc should match with both modes")
+
+	in.Services = append(in.Services, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "productpage-admin",
+			Namespace:   "test-namespace",
+			Annotations: map[string]string{authzEnabledAnnotation: authzEnabled},
+			Labels:      map[string]string{"svc": "productpage-admin"},
+		},
+	})
+	assert.Equal(t, []SvcMatchChange{
+		{
+			Namespace: "test-namespace",
+			Service:   "productpage-admin",
+			SvcLabel:  "productpage-admin",
+			Role:      "test.namespace:role.reader",
+			Resource:  "test.namespace:svc.productpage",
+			Action:    "get",
+			Mode:      common.SvcMatchLegacyRegex,
+		},
+	}, SvcMatchChanges(in, athenz.DefaultMaxTrustDepth), "unsupported action should not be reported")
+}
@@ -0,0 +1,84 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package convert
+
+import (
+	"sort"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+)
+
+// SvcMatchChange is an assertion which matches an authz enabled Service with only one of the glob and the legacy
+// regex svc match modes, the AuthorizationPolicy of the Service changes when switching between the modes
+type SvcMatchChange struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	SvcLabel  string `json:"svcLabel"`
+	Role      string `json:"role"`
+	Resource  string `json:"resource"`
+	Action    string `json:"action"`
+	// Mode is the svc match mode matching the assertion with the Service
+	Mode common.SvcMatchMode `json:"mode"`
+}
+
+// SvcMatchChanges returns the assertions of the Athenz Domains which match the authz enabled Services in their
+// namespace with only one of the svc match modes. The assertions skipped by both modes, e.g. for an unsupported
+// action, are not returned. The delegated roles are resolved by following at most maxTrustDepth trust domains.
+func SvcMatchChanges(in Input, maxTrustDepth int) []SvcMatchChange {
+	informer := in.newInformer()
+	var changes []SvcMatchChange
+	for _, athenzDomain := range in.AthenzDomains {
+		domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(athenzDomain.Spec.Domain, &informer, maxTrustDepth)
+
+		roles := make([]string, 0, len(domainRBAC.Rules))
+		for role := range domainRBAC.Rules {
+			roles = append(roles, string(role))
+		}
+		sort.Strings(roles)
+
+		for _, service := range in.Services {
+			if service.Namespace != domainRBAC.Namespace || service.Annotations[authzEnabledAnnotation] != authzEnabled {
+				continue
+			}
+			svcLabel := service.Labels["svc"]
+			for _, role := range roles {
+				for _, assertion := range domainRBAC.Rules[zms.ResourceName(role)] {
+					svc, _, err := common.ParseAssertionResource(domainRBAC.Name, assertion)
+					if err != nil {
+						continue
+					}
+					if _, err := common.ParseAssertionEffect(assertion); err != nil {
+						continue
+					}
+					if _, err := common.ParseAssertionAction(assertion); err != nil {
+						continue
+					}
+
+					// an invalid regular expression is skipped by the legacy regex mode
+					legacyMatch, _ := common.SvcMatchLegacyRegex.Match(svc, svcLabel)
+					globMatch, _ := common.SvcMatchGlob.Match(svc, svcLabel)
+					if legacyMatch == globMatch {
+						continue
+					}
+					change := SvcMatchChange{
+						Namespace: service.Namespace,
+						Service:   service.Name,
+						SvcLabel:  svcLabel,
+						Role:      assertion.Role,
+						Resource:  assertion.Resource,
+						Action:    assertion.Action,
+						Mode:      common.SvcMatchGlob,
+					}
+					if legacyMatch {
+						change.Mode = common.SvcMatchLegacyRegex
+					}
+					changes = append(changes, change)
+				}
+			}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,383 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package explain
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	rbacv2 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v2"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"istio.io/api/security/v1beta1"
+)
+
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+
+	// Reasons of the rejected role memberships
+	ReasonExpired        = "expired"
+	ReasonSystemDisabled = "system_disabled"
+	ReasonInvalidMember  = "invalid_member"
+	// Reasons of the rejected assertions, along with the skip reasons of the metrics
+	ReasonServiceMismatch   = "svc_mismatch"
+	ReasonMethodMismatch    = "method_mismatch"
+	ReasonPathMismatch      = "path_mismatch"
+	ReasonConditionMismatch = "condition_mismatch"
+)
+
+// Request is the request of a principal to a service
+type Request struct {
+	// Principal is the athenz name of the user or the service, e.g. user.name or my.domain.service
+	Principal string `json:"principal"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	// SvcLabel of the service is matched with the svc of the athenz assertions, the service name is used if empty
+	SvcLabel string `json:"svcLabel,omitempty"`
+	Method   string `json:"method"`
+	// Path of the request, the query string is only matched with the conditions on the :path header
+	Path string `json:"path"`
+	// SourceIP, DestinationPort, Headers and Claims of the request are matched with the conditions of the roles, a
+	// condition on an attribute which is not given does not match
+	SourceIP        string            `json:"sourceIP,omitempty"`
+	DestinationPort string            `json:"destinationPort,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Claims          map[string]string `json:"claims,omitempty"`
+}
+
+// Explanation is the outcome of the evaluation of a request
+type Explanation struct {
+	Request  Request `json:"request"`
+	Domain   string  `json:"domain"`
+	Decision string  `json:"decision"`
+	// Reason summarizes the decision
+	Reason string `json:"reason"`
+	// Roles of the principal with at least one assertion matching the request
+	Roles       []string     `json:"roles,omitempty"`
+	Assertions  []Assertion  `json:"assertions,omitempty"`
+	Memberships []Membership `json:"memberships,omitempty"`
+	Rejections  []Rejection  `json:"rejections,omitempty"`
+}
+
+// Allowed returns true if the request is allowed
+func (e *Explanation) Allowed() bool {
+	return e.Decision == DecisionAllow
+}
+
+// Assertion is an athenz assertion matching the request
+type Assertion struct {
+	Role     string `json:"role"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	Effect   string `json:"effect"`
+}
+
+// Membership of the principal in a role, directly or through a group
+type Membership struct {
+	Role   string `json:"role"`
+	Member string `json:"member"`
+	Group  string `json:"group,omitempty"`
+}
+
+// Rejection is a role membership of the principal or an assertion of one of its roles which does not apply to the
+// request
+type Rejection struct {
+	Role     string `json:"role"`
+	Member   string `json:"member,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+}
+
+// Explain evaluates the request against the athenz model the way the v2 provider converts it into authorization
+// policies with the given options. The request is denied if a DENY assertion of one of the roles of the principal
+// matches, and allowed if an ALLOW assertion matches otherwise.
+func Explain(athenzModel athenz.Model, req Request, options common.ConversionOptions) *Explanation {
+	e := &Explanation{
+		Request:  req,
+		Domain:   string(athenzModel.Name),
+		Decision: DecisionDeny,
+	}
+	method := strings.ToUpper(req.Method)
+	path, _ := common.SplitQueryString(req.Path)
+	svcLabel := req.SvcLabel
+	if svcLabel == "" {
+		svcLabel = req.Service
+	}
+
+	roleList := make([]string, 0, len(athenzModel.Rules))
+	for role := range athenzModel.Rules {
+		roleList = append(roleList, string(role))
+	}
+	sort.Strings(roleList)
+
+	var allowed, denied *Assertion
+	for _, roleKey := range roleList {
+		role := zms.ResourceName(roleKey)
+		memberships, rejections := getMemberships(athenzModel, role, req.Principal)
+		e.Rejections = append(e.Rejections, rejections...)
+		if len(memberships) == 0 {
+			continue
+		}
+		if _, err := common.ParseRoleFQDN(athenzModel.Name, roleKey); err != nil {
+			e.Rejections = append(e.Rejections, Rejection{Role: roleKey, Reason: metrics.SkipReasonInvalidRole, Message: err.Error()})
+			continue
+		}
+
+		matched := false
+		for _, assertion := range athenzModel.Rules[role] {
+			if assertion == nil {
+				continue
+			}
+			rejection := Rejection{Role: roleKey, Resource: assertion.Resource, Action: assertion.Action}
+			rule, reason, err := rbacv2.ParseAssertion(athenzModel, assertion, svcLabel, options)
+			if err != nil {
+				rejection.Reason, rejection.Message = reason, err.Error()
+			} else if rule == nil {
+				rejection.Reason, rejection.Message = ReasonServiceMismatch, fmt.Sprintf("svc of resource %s does not match svc %s", assertion.Resource, svcLabel)
+			} else if !matchValues(rule.To.Operation.Methods, method) {
+				rejection.Reason, rejection.Message = ReasonMethodMismatch, fmt.Sprintf("method %s does not match %s", method, strings.Join(rule.To.Operation.Methods, ", "))
+			} else if !matchValues(rule.To.Operation.Paths, path) {
+				rejection.Reason, rejection.Message = ReasonPathMismatch, fmt.Sprintf("path %s does not match %s", path, strings.Join(rule.To.Operation.Paths, ", "))
+			} else if condition := getUnmatchedCondition(rule.When, req); condition != nil {
+				rejection.Reason, rejection.Message = ReasonConditionMismatch, fmt.Sprintf("condition %s does not match %s", condition.Key, strings.Join(condition.Values, ", "))
+			}
+			if rejection.Reason != "" {
+				e.Rejections = append(e.Rejections, rejection)
+				continue
+			}
+
+			match := Assertion{Role: roleKey, Resource: assertion.Resource, Action: assertion.Action, Effect: rule.Effect}
+			e.Assertions = append(e.Assertions, match)
+			matched = true
+			if rule.Effect == zms.DENY.String() && denied == nil {
+				denied = &match
+			}
+			if rule.Effect == zms.ALLOW.String() && allowed == nil {
+				allowed = &match
+			}
+		}
+		if matched {
+			e.Roles = append(e.Roles, roleKey)
+			e.Memberships = append(e.Memberships, memberships...)
+		}
+	}
+
+	switch {
+	case denied != nil:
+		e.Reason = fmt.Sprintf("denied by assertion %s %s on %s of role %s", denied.Effect, denied.Action, denied.Resource, denied.Role)
+	case allowed != nil:
+		e.Decision = DecisionAllow
+		e.Reason = fmt.Sprintf("allowed by assertion %s %s on %s of role %s", allowed.Effect, allowed.Action, allowed.Resource, allowed.Role)
+	default:
+		e.Reason = "no ALLOW assertion of the roles of the principal matches the request"
+	}
+	return e
+}
+
+// getMemberships returns the memberships of the principal in the role, directly or through a group, which are
+// converted into the rule sources of the authorization policy. The memberships which are dropped from the rule
+// sources, such as the expired or system disabled ones, are returned as rejections.
+func getMemberships(athenzModel athenz.Model, role zms.ResourceName, principal string) ([]Membership, []Rejection) {
+	var memberships []Membership
+	var rejections []Rejection
+	for _, roleMember := range athenzModel.Members[role] {
+		if roleMember == nil {
+			continue
+		}
+		groupMembers, isGroup := athenzModel.GroupMembers[roleMember.MemberName]
+		if !isGroup {
+			if !matchPrincipal(roleMember, athenzModel.Name, principal) {
+				continue
+			}
+			membership := Membership{Role: string(role), Member: string(roleMember.MemberName)}
+			reason, err := checkMember(roleMember)
+			if err == nil {
+				reason, err = checkSource(roleMember, athenzModel.Name)
+			}
+			if err != nil {
+				rejections = append(rejections, newMemberRejection(membership, reason, err))
+				continue
+			}
+			memberships = append(memberships, membership)
+			continue
+		}
+
+		for _, groupMember := range groupMembers {
+			if groupMember == nil || !matchPrincipal(groupMember, athenzModel.Name, principal) {
+				continue
+			}
+			membership := Membership{Role: string(role), Member: string(groupMember.MemberName), Group: string(roleMember.MemberName)}
+			// the membership of the group in the role is checked before the membership of the principal in the group
+			reason, err := checkMember(roleMember)
+			if err == nil {
+				reason, err = checkMember(groupMember)
+			}
+			if err == nil {
+				reason, err = checkSource(groupMember, athenzModel.Name)
+			}
+			if err != nil {
+				rejections = append(rejections, newMemberRejection(membership, reason, err))
+				continue
+			}
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships, rejections
+}
+
+// checkMember returns the rejection reason and the error of an expired or system disabled member
+func checkMember(member interface{}) (string, error) {
+	if _, err := common.CheckAthenzMemberExpiry(member); err != nil {
+		return ReasonExpired, err
+	}
+	if _, err := common.CheckAthenzSystemDisabled(member); err != nil {
+		return ReasonSystemDisabled, err
+	}
+	return "", nil
+}
+
+// checkSource returns the rejection reason and the error of a member which cannot be converted into a rule source
+func checkSource(member interface{}, domainName zms.DomainName) (string, error) {
+	namespace, err := common.CheckIfMemberIsAllUsersFromDomain(member, domainName)
+	if err != nil {
+		return ReasonInvalidMember, err
+	}
+	if namespace != "" {
+		return "", nil
+	}
+	if _, err := common.MemberToSpiffe(member); err != nil {
+		return ReasonInvalidMember, err
+	}
+	return "", nil
+}
+
+func newMemberRejection(membership Membership, reason string, err error) Rejection {
+	return Rejection{
+		Role:    membership.Role,
+		Member:  membership.Member,
+		Group:   membership.Group,
+		Reason:  reason,
+		Message: err.Error(),
+	}
+}
+
+// matchPrincipal returns true if the rule sources generated for the member match with the principal: the member
+// itself, any principal for user.*, or the principals of the domain for <domain>.*
+func matchPrincipal(member interface{}, domainName zms.DomainName, principal string) bool {
+	memberName := common.GetMemberName(member)
+	if memberName == principal || memberName == "user.*" {
+		return true
+	}
+	namespace, err := common.CheckIfMemberIsAllUsersFromDomain(member, domainName)
+	if err != nil || namespace == "" {
+		return false
+	}
+	i := strings.LastIndex(principal, ".")
+	return i > 0 && athenz.ResolveNamespace(principal[:i]) == namespace
+}
+
+// matchValues returns true if the value matches with one of the values of an authorization policy operation, an
+// empty list matches with any value. The values are matched the way istio does, exactly, by prefix with a trailing
+// *, by suffix with a leading * or with any value for *.
+func matchValues(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		switch {
+		case v == common.WildCardAll:
+			return true
+		case strings.HasSuffix(v, "*") && strings.HasPrefix(value, strings.TrimSuffix(v, "*")):
+			return true
+		case strings.HasPrefix(v, "*") && strings.HasSuffix(value, strings.TrimPrefix(v, "*")):
+			return true
+		case v == value:
+			return true
+		}
+	}
+	return false
+}
+
+// getUnmatchedCondition returns the first condition of the rule which does not match with the request, nil is
+// returned if all the conditions match
+func getUnmatchedCondition(conditions []*v1beta1.Condition, req Request) *v1beta1.Condition {
+	for _, condition := range conditions {
+		if !matchCondition(condition, req) {
+			return condition
+		}
+	}
+	return nil
+}
+
+// matchCondition returns true if the attribute of the request matches with one of the values of the condition the
+// way istio does, the conditions on attributes which are not part of the request never match
+func matchCondition(condition *v1beta1.Condition, req Request) bool {
+	switch condition.Key {
+	case common.ConditionPathHeaderKey:
+		return matchValues(condition.Values, req.Path)
+	case common.ConditionSourceIPKey:
+		return matchIP(condition.Values, req.SourceIP)
+	case common.ConditionDestinationPortKey:
+		for _, value := range condition.Values {
+			if value == req.DestinationPort {
+				return true
+			}
+		}
+		return false
+	}
+	if name := common.GetConditionName(condition.Key, common.ConditionRequestHeadersKey); name != "" {
+		for header, value := range req.Headers {
+			if strings.EqualFold(header, name) {
+				return matchValues(condition.Values, value)
+			}
+		}
+		return false
+	}
+	if name := common.GetConditionName(condition.Key, common.ConditionRequestClaimsKey); name != "" {
+		value, ok := req.Claims[name]
+		return ok && matchValues(condition.Values, value)
+	}
+	return false
+}
+
+// matchIP returns true if the ip is one of the ip addresses or in one of the cidr ranges of the values
+func matchIP(values []string, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, value := range values {
+		if _, cidr, err := net.ParseCIDR(value); err == nil && cidr.Contains(parsed) {
+			return true
+		}
+		if parsed.Equal(net.ParseIP(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseKeyValues parses the name=value pairs of the headers and the claims of a request
+func ParseKeyValues(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s is not in the name=value format", pair)
+		}
+		values[pair[:i]] = pair[i+1:]
+	}
+	return values, nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package explain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+const (
+	domainName = "test.namespace"
+	readerRole = domainName + ":role.reader"
+	adminRole  = domainName + ":role.admin"
+	devsGroup  = domainName + ":group.devs"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func newAssertion(role, resource, action string, effect zms.AssertionEffect) *zms.Assertion {
+	return &zms.Assertion{
+		Role:     role,
+		Resource: resource,
+		Action:   action,
+		Effect:   &effect,
+	}
+}
+
+func newTestModel() athenz.Model {
+	expired := rdl.NewTimestamp(time.Now().Add(-time.Hour))
+	disabled := int32(1)
+	return athenz.Model{
+		Name:      domainName,
+		Namespace: "test-namespace",
+		Rules: map[zms.ResourceName][]*zms.Assertion{
+			readerRole: {
+				newAssertion(readerRole, domainName+":svc.productpage", "get", zms.ALLOW),
+				newAssertion(readerRole, domainName+":svc.details", "get", zms.ALLOW),
+				newAssertion(readerRole, domainName+":svc.productpage:/admin*", "get", zms.DENY),
+			},
+			adminRole: {
+				newAssertion(adminRole, domainName+":svc.*", "*", zms.ALLOW),
+			},
+		},
+		Members: map[zms.ResourceName][]*zms.RoleMember{
+			readerRole: {
+				{MemberName: "user.reader"},
+				{MemberName: "user.expired", Expiration: &expired},
+				{MemberName: "user.disabled", SystemDisabled: &disabled},
+				{MemberName: "client.domain.*"},
+			},
+			adminRole: {
+				{MemberName: devsGroup},
+			},
+		},
+		GroupMembers: map[zms.MemberName][]*zms.GroupMember{
+			devsGroup: {
+				{MemberName: "user.admin"},
+				{MemberName: "user.former", Expiration: &expired},
+			},
+		},
+	}
+}
+
+func TestExplain(t *testing.T) {
+	cases := []struct {
+		test                string
+		req                 Request
+		expectedDecision    string
+		expectedRoles       []string
+		expectedMemberships []Membership
+		expectedRejections  []string
+	}{
+		{
+			test:                "allowed role member",
+			req:                 Request{Principal: "user.reader", Service: "productpage", Method: "get", Path: "/"},
+			expectedDecision:    DecisionAllow,
+			expectedRoles:       []string{readerRole},
+			expectedMemberships: []Membership{{Role: readerRole, Member: "user.reader"}},
+			expectedRejections:  []string{ReasonServiceMismatch, ReasonPathMismatch},
+		},
+		{
+			test:                "denied by a deny assertion",
+			req:                 Request{Principal: "user.reader", Service: "productpage", Method: "GET", Path: "/admin/users"},
+			expectedDecision:    DecisionDeny,
+			expectedRoles:       []string{readerRole},
+			expectedMemberships: []Membership{{Role: readerRole, Member: "user.reader"}},
+			expectedRejections:  []string{ReasonServiceMismatch},
+		},
+		{
+			test:               "method mismatch",
+			req:                Request{Principal: "user.reader", Service: "productpage", Method: "POST", Path: "/"},
+			expectedDecision:   DecisionDeny,
+			expectedRejections: []string{ReasonMethodMismatch, ReasonServiceMismatch, ReasonMethodMismatch},
+		},
+		{
+			test:               "expired role member",
+			req:                Request{Principal: "user.expired", Service: "productpage", Method: "GET", Path: "/"},
+			expectedDecision:   DecisionDeny,
+			expectedRejections: []string{ReasonExpired},
+		},
+		{
+			test:               "system disabled role member",
+			req:                Request{Principal: "user.disabled", Service: "productpage", Method: "GET", Path: "/"},
+			expectedDecision:   DecisionDeny,
+			expectedRejections: []string{ReasonSystemDisabled},
+		},
+		{
+			test:                "all services of a domain",
+			req:                 Request{Principal: "client.domain.frontend", Service: "details", SvcLabel: "details", Method: "GET", Path: "/"},
+			expectedDecision:    DecisionAllow,
+			expectedRoles:       []string{readerRole},
+			expectedMemberships: []Membership{{Role: readerRole, Member: "client.domain.*"}},
+			expectedRejections:  []string{ReasonServiceMismatch, ReasonServiceMismatch},
+		},
+		{
+			test:                "group member",
+			req:                 Request{Principal: "user.admin", Service: "ratings", Method: "DELETE", Path: "/"},
+			expectedDecision:    DecisionAllow,
+			expectedRoles:       []string{adminRole},
+			expectedMemberships: []Membership{{Role: adminRole, Member: "user.admin", Group: devsGroup}},
+		},
+		{
+			test:               "expired group member",
+			req:                Request{Principal: "user.former", Service: "ratings", Method: "DELETE", Path: "/"},
+			expectedDecision:   DecisionDeny,
+			expectedRejections: []string{ReasonExpired},
+		},
+		{
+			test:             "principal without roles",
+			req:              Request{Principal: "user.other", Service: "productpage", Method: "GET", Path: "/"},
+			expectedDecision: DecisionDeny,
+		},
+	}
+
+	for _, c := range cases {
+		e := Explain(newTestModel(), c.req, common.DefaultConversionOptions())
+		assert.Equal(t, c.expectedDecision, e.Decision, c.test)
+		assert.Equal(t, c.expectedRoles, e.Roles, c.test)
+		assert.Equal(t, c.expectedMemberships, e.Memberships, c.test)
+		var actualRejections []string
+		for _, rejection := range e.Rejections {
+			actualRejections = append(actualRejections, rejection.Reason)
+		}
+		assert.Equal(t, c.expectedRejections, actualRejections, c.test)
+	}
+}
+
+func TestExplainQueryString(t *testing.T) {
+	athenzModel := newTestModel()
+	athenzModel.Rules[readerRole] = []*zms.Assertion{
+		newAssertion(readerRole, domainName+":svc.productpage:/search?q=public", "get", zms.ALLOW),
+	}
+	cases := []struct {
+		test               string
+		path               string
+		expectedDecision   string
+		expectedRejections []string
+	}{
+		{test: "query string matches", path: "/search?q=public", expectedDecision: DecisionAllow},
+		{test: "query string mismatch", path: "/search?q=private", expectedDecision: DecisionDeny, expectedRejections: []string{ReasonConditionMismatch}},
+		{test: "missing query string", path: "/search", expectedDecision: DecisionDeny, expectedRejections: []string{ReasonConditionMismatch}},
+		{test: "path mismatch", path: "/other?q=public", expectedDecision: DecisionDeny, expectedRejections: []string{ReasonPathMismatch}},
+	}
+
+	for _, c := range cases {
+		e := Explain(athenzModel, Request{Principal: "user.reader", Service: "productpage", Method: "GET", Path: c.path}, common.DefaultConversionOptions())
+		assert.Equal(t, c.expectedDecision, e.Decision, c.test)
+		var actualRejections []string
+		for _, rejection := range e.Rejections {
+			actualRejections = append(actualRejections, rejection.Reason)
+		}
+		assert.Equal(t, c.expectedRejections, actualRejections, c.test)
+	}
+}
+
+func TestExplainConditions(t *testing.T) {
+	athenzModel := newTestModel()
+	athenzModel.Conditions = athenz.RoleConditions{
+		readerRole: {
+			{Key: "source.ip", Values: []string{"10.0.0.0/8"}},
+			{Key: "request.headers[x-env]", Values: []string{"prod"}},
+			{Key: "request.auth.claims[iss]", Values: []string{"athenz"}},
+			{Key: "destination.port", Values: []string{"8080"}},
+		},
+	}
+	athenzModel.Rules[readerRole] = []*zms.Assertion{
+		newAssertion(readerRole, domainName+":svc.productpage", "get", zms.ALLOW),
+	}
+	matching := Request{
+		Principal:       "user.reader",
+		Service:         "productpage",
+		Method:          "GET",
+		Path:            "/",
+		SourceIP:        "10.1.2.3",
+		DestinationPort: "8080",
+		Headers:         map[string]string{"X-Env": "prod"},
+		Claims:          map[string]string{"iss": "athenz"},
+	}
+	cases := []struct {
+		test             string
+		update           func(req *Request)
+		expectedDecision string
+	}{
+		{test: "all conditions match", update: func(req *Request) {}, expectedDecision: DecisionAllow},
+		{test: "source ip out of range", update: func(req *Request) { req.SourceIP = "192.168.0.1" }, expectedDecision: DecisionDeny},
+		{test: "missing source ip", update: func(req *Request) { req.SourceIP = "" }, expectedDecision: DecisionDeny},
+		{test: "header mismatch", update: func(req *Request) { req.Headers = map[string]string{"x-env": "dev"} }, expectedDecision: DecisionDeny},
+		{test: "missing claim", update: func(req *Request) { req.Claims = nil }, expectedDecision: DecisionDeny},
+		{test: "destination port mismatch", update: func(req *Request) { req.DestinationPort = "9090" }, expectedDecision: DecisionDeny},
+	}
+
+	for _, c := range cases {
+		req := matching
+		c.update(&req)
+		e := Explain(athenzModel, req, common.DefaultConversionOptions())
+		assert.Equal(t, c.expectedDecision, e.Decision, c.test)
+		if c.expectedDecision == DecisionDeny {
+			assert.Len(t, e.Rejections, 1, c.test)
+			assert.Equal(t, ReasonConditionMismatch, e.Rejections[0].Reason, c.test)
+		}
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	values, err := ParseKeyValues([]string{"x-env=prod", "x-query=a=b"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"x-env": "prod", "x-query": "a=b"}, values)
+
+	values, err = ParseKeyValues(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, values, "no pairs should not be parsed into a map")
+
+	_, err = ParseKeyValues([]string{"=prod"})
+	assert.EqualError(t, err, "=prod is not in the name=value format")
+}
+
+func TestMatchValues(t *testing.T) {
+	cases := []struct {
+		values   []string
+		value    string
+		expected bool
+	}{
+		{values: nil, value: "/any", expected: true},
+		{values: []string{"*"}, value: "/any", expected: true},
+		{values: []string{"/api"}, value: "/api", expected: true},
+		{values: []string{"/api"}, value: "/api/v1", expected: false},
+		{values: []string{"/api/*"}, value: "/api/v1", expected: true},
+		{values: []string{"*.json"}, value: "/api/v1.json", expected: true},
+		{values: []string{"/other", "/api/*"}, value: "/api/v1", expected: true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, matchValues(c.values, c.value), "%v should match %s: %t", c.values, c.value, c.expected)
+	}
+}
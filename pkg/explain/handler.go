@@ -0,0 +1,57 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Path of the explain debug endpoint
+const Path = "/debug/explain"
+
+// NewHandler returns the handler of the explain debug endpoint. The request is given with the principal, namespace,
+// service, method and path query parameters, and optionally the sourceIP, destinationPort and the repeated header and
+// claim name=value query parameters matched with the conditions of the roles. The explanation is returned as JSON.
+func NewHandler(explain func(Request) (*Explanation, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := Request{
+			Principal:       query.Get("principal"),
+			Namespace:       query.Get("namespace"),
+			Service:         query.Get("service"),
+			Method:          query.Get("method"),
+			Path:            query.Get("path"),
+			SourceIP:        query.Get("sourceIP"),
+			DestinationPort: query.Get("destinationPort"),
+		}
+		if req.Principal == "" || req.Namespace == "" || req.Service == "" || req.Method == "" {
+			http.Error(w, "principal, namespace, service and method query parameters are required", http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" {
+			req.Path = "/"
+		}
+		var err error
+		if req.Headers, err = ParseKeyValues(query["header"]); err != nil {
+			http.Error(w, fmt.Sprintf("invalid header query parameter: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if req.Claims, err = ParseKeyValues(query["claim"]); err != nil {
+			http.Error(w, fmt.Sprintf("invalid claim query parameter: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		e, err := explain(req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error explaining the request: %s", err.Error()), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(e)
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package explain
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+)
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler(func(req Request) (*Explanation, error) {
+		if req.Namespace != "test-namespace" {
+			return nil, errors.New("athenz domain does not exist in cache")
+		}
+		return Explain(newTestModel(), req, common.DefaultConversionOptions()), nil
+	})
+
+	cases := []struct {
+		test             string
+		query            string
+		expectedCode     int
+		expectedDecision string
+	}{
+		{
+			test:             "allowed request",
+			query:            "?principal=user.reader&namespace=test-namespace&service=productpage&method=GET&path=/",
+			expectedCode:     http.StatusOK,
+			expectedDecision: DecisionAllow,
+		},
+		{
+			test:             "path defaults to /",
+			query:            "?principal=user.other&namespace=test-namespace&service=productpage&method=GET",
+			expectedCode:     http.StatusOK,
+			expectedDecision: DecisionDeny,
+		},
+		{
+			test:         "missing parameters",
+			query:        "?principal=user.reader",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			test:         "invalid header",
+			query:        "?principal=user.reader&namespace=test-namespace&service=productpage&method=GET&header=x-env",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			test:         "unknown namespace",
+			query:        "?principal=user.reader&namespace=other&service=productpage&method=GET",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, Path+c.query, nil))
+		assert.Equal(t, c.expectedCode, recorder.Code, c.test)
+		if c.expectedCode != http.StatusOK {
+			continue
+		}
+		e := &Explanation{}
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), e), c.test)
+		assert.Equal(t, c.expectedDecision, e.Decision, c.test)
+	}
+}
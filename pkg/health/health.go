@@ -0,0 +1,92 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ReadinessPath = "/readyz"
+	LivenessPath  = "/healthz"
+)
+
+// Progress records the last time a worker made progress on its queue, the zero
+// value is ready to use
+type Progress struct {
+	lock sync.Mutex
+	last time.Time
+	now  func() time.Time
+}
+
+// Tick records the progress of the worker, it is called when the worker starts
+// and whenever it takes an item off the queue or finishes processing it
+func (p *Progress) Tick() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.last = p.currentTime()
+}
+
+// Stalled returns true if the queue is not empty and the worker has not made
+// progress within the threshold, a worker which has not started is not stalled
+func (p *Progress) Stalled(queueLen int, threshold time.Duration) bool {
+	if queueLen == 0 {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.last.IsZero() {
+		return false
+	}
+	return p.currentTime().Sub(p.last) > threshold
+}
+
+func (p *Progress) currentTime() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// Flag is a boolean condition safe for concurrent use, the zero value is unset
+type Flag struct {
+	lock sync.RWMutex
+	set  bool
+}
+
+// Set sets the condition
+func (f *Flag) Set(set bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.set = set
+}
+
+// IsSet returns whether the condition is set
+func (f *Flag) IsSet() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.set
+}
+
+// NewHandler returns the handler of the readiness and liveness probes, a probe
+// responds with a 503 and the reason of the failure if its check returns an error
+func NewHandler(readiness func() error, liveness func() error) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ReadinessPath, probe(readiness))
+	mux.HandleFunc(LivenessPath, probe(liveness))
+	return mux
+}
+
+func probe(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
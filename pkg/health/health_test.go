@@ -0,0 +1,69 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStalled(t *testing.T) {
+	now := time.Now()
+	p := &Progress{
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	assert.False(t, p.Stalled(1, time.Minute), "worker which has not started should not be stalled")
+
+	p.Tick()
+	now = now.Add(2 * time.Minute)
+	assert.False(t, p.Stalled(0, time.Minute), "worker with an empty queue should not be stalled")
+	assert.True(t, p.Stalled(1, time.Minute), "worker without progress within the threshold should be stalled")
+	assert.False(t, p.Stalled(1, 3*time.Minute), "worker with progress within the threshold should not be stalled")
+
+	p.Tick()
+	assert.False(t, p.Stalled(1, time.Minute), "worker should not be stalled after making progress")
+}
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler(func() error {
+		return errors.New("caches are not synced")
+	}, func() error {
+		return nil
+	})
+
+	cases := []struct {
+		test         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			test:         "failing readiness probe",
+			path:         ReadinessPath,
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: "caches are not synced\n",
+		},
+		{
+			test:         "passing liveness probe",
+			path:         LivenessPath,
+			expectedCode: http.StatusOK,
+			expectedBody: "ok\n",
+		},
+	}
+
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, c.path, nil))
+		assert.Equal(t, c.expectedCode, recorder.Code, c.test)
+		assert.Equal(t, c.expectedBody, recorder.Body.String(), c.test)
+	}
+}
@@ -10,18 +10,24 @@ import (
 	"time"
 
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/health"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/guard"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	rbacv2 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v2"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/lint"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/syncstatus"
 	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
 	"istio.io/client-go/pkg/clientset/versioned"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config/schema/collections"
 	corev1 "k8s.io/api/core/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/util/wait"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -30,9 +36,12 @@ const (
 	authzEnabled           = "true"
 	authzEnabledAnnotation = "authz.istio.io/enabled"
 	overrideAnnotation     = "overrideAuthzPolicy"
+	// controllerName identifies the authzpolicy controller in the blast radius guard, the queue and the metrics
+	controllerName = "authzpolicy"
 )
 
 type Controller struct {
+	*common.Worker
 	configStoreCache            model.ConfigStoreCache
 	serviceIndexInformer        cache.SharedIndexInformer
 	adIndexInformer             cache.SharedIndexInformer
@@ -43,21 +52,48 @@ type Controller struct {
 	componentEnabledAuthzPolicy *common.ComponentEnabled
 	dryRunHandler               common.DryRunHandler
 	apiHandler                  common.ApiHandler
+	trustIndex                  *athenz.TrustIndex
+	guard                       *guard.Guard
+	domainDeletePolicy          rbac.DomainDeletePolicy
+	tombstones                  *athenz.Tombstones
+	cleanedUp                   health.Flag
+	recorder                    record.EventRecorder
+	statusWriter                *syncstatus.Writer
+	statuses                    *syncstatus.Cache
+	selectorLabels              []string
+	maxTrustDepth               int
+	conversionOptions           common.ConversionOptions
+	adoptUnlabeled              bool
+	// lintDomains is false if the domains are linted by the domain controller
+	lintDomains bool
 }
 
-func NewController(configStoreCache model.ConfigStoreCache, serviceIndexInformer cache.SharedIndexInformer, adIndexInformer cache.SharedIndexInformer, istioClientSet versioned.Interface, apResyncInterval time.Duration, enableOriginJwtSubject bool, componentEnabledAuthzPolicy *common.ComponentEnabled) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+func NewController(configStoreCache model.ConfigStoreCache, serviceIndexInformer cache.SharedIndexInformer, adIndexInformer cache.SharedIndexInformer, istioClientSet versioned.Interface, apResyncInterval time.Duration, enableOriginJwtSubject bool, componentEnabledAuthzPolicy *common.ComponentEnabled, guard *guard.Guard, domainDeletePolicy rbac.DomainDeletePolicy, recorder record.EventRecorder, statusWriter *syncstatus.Writer, selectorLabels []string, maxTrustDepth int, conversionOptions common.ConversionOptions, adoptUnlabeled bool, lintDomains bool) *Controller {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName)
 
 	c := &Controller{
+		Worker:                      common.NewWorker(queue, nil),
 		configStoreCache:            configStoreCache,
 		serviceIndexInformer:        serviceIndexInformer,
 		adIndexInformer:             adIndexInformer,
 		queue:                       queue,
-		rbacProvider:                rbacv2.NewProvider(componentEnabledAuthzPolicy, enableOriginJwtSubject),
+		rbacProvider:                rbacv2.NewProvider(componentEnabledAuthzPolicy, enableOriginJwtSubject, conversionOptions),
 		apResyncInterval:            apResyncInterval,
 		enableOriginJwtSubject:      enableOriginJwtSubject,
 		componentEnabledAuthzPolicy: componentEnabledAuthzPolicy,
 		dryRunHandler:               common.DryRunHandler{},
+		trustIndex:                  athenz.NewTrustIndex(),
+		guard:                       guard,
+		domainDeletePolicy:          domainDeletePolicy,
+		tombstones:                  athenz.NewTombstones(),
+		recorder:                    recorder,
+		statusWriter:                statusWriter,
+		statuses:                    syncstatus.NewCache(),
+		selectorLabels:              selectorLabels,
+		maxTrustDepth:               maxTrustDepth,
+		conversionOptions:           conversionOptions,
+		adoptUnlabeled:              adoptUnlabeled,
+		lintDomains:                 lintDomains,
 	}
 
 	c.apiHandler = common.ApiHandler{
@@ -79,12 +115,18 @@ func NewController(configStoreCache model.ConfigStoreCache, serviceIndexInformer
 	adIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			c.processEvent(cache.MetaNamespaceKeyFunc, obj)
+			c.processDelegatingDomains(cache.MetaNamespaceKeyFunc, obj)
 		},
 		UpdateFunc: func(_, newObj interface{}) {
 			c.processEvent(cache.MetaNamespaceKeyFunc, newObj)
+			c.processDelegatingDomains(cache.MetaNamespaceKeyFunc, newObj)
 		},
 		DeleteFunc: func(obj interface{}) {
+			if domainDeletePolicy != rbac.DomainDeleteRetain {
+				c.tombstones.Add(obj)
+			}
 			c.processEvent(cache.DeletionHandlingMetaNamespaceKeyFunc, obj)
+			c.processDelegatingDomains(cache.DeletionHandlingMetaNamespaceKeyFunc, obj)
 		},
 	})
 
@@ -93,8 +135,20 @@ func NewController(configStoreCache model.ConfigStoreCache, serviceIndexInformer
 
 func (c *Controller) EventHandler(_ model.Config, config model.Config, e model.Event) {
 	// authz policy event handler, Key() returns format <type>/<namespace>/<name>
-	// should drop the type and pass <namespace>/<name> only
-	c.queue.Add(config.Namespace + "/" + config.Name)
+	// should drop the type and pass <namespace>/<service name> only, the deny authz policy
+	// is mapped back to the service it was generated for
+	c.queue.Add(config.Namespace + "/" + common.GetServiceNameFromAuthzPolicy(config))
+}
+
+// ProcessDomainMappingEvent adds the athenz domain whose namespace mapping changed to the queue, the domains which
+// do not exist in the cache are skipped
+func (c *Controller) ProcessDomainMappingEvent(domain string) {
+	if _, exists, _ := c.adIndexInformer.GetIndexer().GetByKey(domain); !exists {
+		if _, tombstoned := c.tombstones.Get(domain); !tombstoned {
+			return
+		}
+	}
+	c.queue.Add(domain)
 }
 
 // processEvent is responsible for calling the key function and adding the
@@ -108,6 +162,21 @@ func (c *Controller) processEvent(fn cache.KeyFunc, obj interface{}) {
 	log.Errorf("Error calling key func: %s", err.Error())
 }
 
+// processDelegatingDomains is responsible for adding the keys of the athenz
+// domains which delegate roles to the changed athenz domain to the queue, so
+// that the members of their trust roles are re-synced
+func (c *Controller) processDelegatingDomains(fn cache.KeyFunc, obj interface{}) {
+	key, err := fn(obj)
+	if err != nil {
+		log.Errorf("Error calling key func: %s", err.Error())
+		return
+	}
+	for _, domain := range c.trustIndex.GetDelegatingDomains(key) {
+		log.Infof("Adding delegating athenz domain %s to the queue for trust domain: %s", domain, key)
+		c.queue.Add(domain)
+	}
+}
+
 // Run starts the main controller loop running sync at every poll interval.
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	go c.resync(stopCh)
@@ -122,45 +191,41 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	if err != nil {
 		log.Panicf("Error while running cleanUpStaleAP: %v", err.Error())
 	}
+	c.cleanedUp.Set(true)
 
-	defer c.queue.ShutDown()
-	wait.Until(c.runWorker, 0, stopCh)
+	c.Worker.Run(stopCh, c.processItem)
 }
 
-// runWorker calls processNextItem to process events of the work queue
-func (c *Controller) runWorker() {
-	for c.processNextItem() {
-	}
+// CleanedUp returns true once the stale authorization policies are cleaned up at
+// the start of the worker
+func (c *Controller) CleanedUp() bool {
+	return c.cleanedUp.IsSet()
 }
 
-// processNextItem takes an item off the queue and calls the controllers sync
-// function, handles the logic of re-queuing in case any errors occur
-func (c *Controller) processNextItem() bool {
-	keyRaw, quit := c.queue.Get()
-	if quit {
-		return false
-	}
-
-	defer c.queue.Done(keyRaw)
+// processItem calls the controllers sync function for a key taken off the queue,
+// handles the logic of re-queuing in case any errors occur
+func (c *Controller) processItem(keyRaw interface{}) {
 	key, ok := keyRaw.(string)
 	if !ok {
 		log.Errorf("String cast failed for key %v", key)
 		c.queue.Forget(keyRaw)
-		return true
+		return
 	}
 
 	log.Infof("Processing key: %s", key)
+	start := time.Now()
 	err := c.sync(key)
+	metrics.ObserveSync(controllerName, start)
 	if err != nil {
 		log.Errorf("Error syncing authz policy state for key %s: %s", keyRaw, err)
 		if c.queue.NumRequeues(keyRaw) < queueNumRetries {
 			log.Infof("Retrying key %s due to sync error", keyRaw)
 			c.queue.AddRateLimited(keyRaw)
-			return true
+			return
 		}
+		metrics.QueueDropsTotal.WithLabelValues(controllerName).Inc()
+		c.newKeyReporter(key).RetriesExhausted("sync", key, err)
 	}
-
-	return true
 }
 
 // sync function receives a key string function, key can have two format:
@@ -168,24 +233,93 @@ func (c *Controller) processNextItem() bool {
 //         Compute, compare and update authz policy specs based on current state in cluster
 // Case 2: for service resource and authorization policy, key string is in format: <namespace name>/<service name>,
 //         look up svc in cache and generate corresponding authz policy, update based on current state in cluster
-func (c *Controller) sync(key string) error {
-	var serviceName, athenzDomainName string
+// A deleted athenz domain is synced from its tombstone according to the domain delete policy, the tombstone is
+// purged once the full domain sync finds the authz policies up-to-date
+// The outcome of the sync is written to the sync status of every authz enabled service, the sync statuses of the
+// services which are deleted or not authz enabled anymore and of the deleted athenz domains are deleted
+func (c *Controller) sync(key string) (err error) {
+	var serviceName, athenzDomainName, namespace string
+	var statuses []syncstatus.SyncStatus
+	// synced is set once the statuses of all the authz enabled services of the key are known
+	synced := false
+	// purged is set once the athenz domain is deleted and no longer synced, its metrics are deleted
+	purged := false
+	defer func() {
+		if namespace == "" {
+			return
+		}
+		names := make([]string, 0, len(statuses))
+		for _, status := range statuses {
+			name := syncstatus.Name(controllerName, status.Service)
+			c.statusWriter.Write(namespace, name, status, err)
+			names = append(names, name)
+		}
+		switch {
+		case !synced:
+			return
+		case serviceName == "":
+			c.statusWriter.Prune(namespace, controllerName, athenzDomainName, names)
+			c.statuses.Prune(namespace, controllerName, athenzDomainName, names)
+		case len(statuses) == 0:
+			c.statusWriter.Delete(namespace, syncstatus.Name(controllerName, serviceName))
+			c.statuses.Delete(namespace, syncstatus.Name(controllerName, serviceName))
+		}
+		if purged {
+			metrics.SkippedAssertions.DeleteLabelValues(controllerName, athenzDomainName)
+			metrics.LastSuccessfulSync.DeleteLabelValues(controllerName, athenzDomainName)
+			if c.lintDomains {
+				lint.Forget(athenzDomainName)
+			}
+			return
+		}
+		metrics.SkippedAssertions.WithLabelValues(controllerName, athenzDomainName).Set(float64(c.statuses.SkippedAssertions(controllerName, athenzDomainName)))
+	}()
 
 	parseKeyList := strings.Split(key, "/")
 	if len(parseKeyList) > 1 {
-		athenzDomainName = athenz.NamespaceToDomain(parseKeyList[0])
+		athenzDomainName = athenz.ResolveDomain(parseKeyList[0])
 		serviceName = parseKeyList[1]
+		if athenzDomainName == "" {
+			log.Debugf("Namespace %s is not governed by any athenz domain, skipping service %s", parseKeyList[0], serviceName)
+			return nil
+		}
 	} else {
 		athenzDomainName = key
 	}
 
+	// a domain whose namespace by convention is governed by another domain has no namespace, listing the
+	// authz policies of the empty namespace would list the authz policies of every namespace
+	namespace = athenz.ResolveNamespace(athenzDomainName)
+	if namespace == "" {
+		log.Infof("Athenz domain %s is not mapped to any namespace, skipping", athenzDomainName)
+		return nil
+	}
+
 	athenzDomainRaw, exists, err := c.adIndexInformer.GetIndexer().GetByKey(athenzDomainName)
 	if err != nil {
 		return fmt.Errorf("unable to fetch athenz domain from cache, error: %s", err)
 	}
 
-	if !exists {
-		return fmt.Errorf("athenz domain %s does not exist in cache", athenzDomainName)
+	deleted := false
+	if exists {
+		c.tombstones.Delete(athenzDomainName)
+	} else {
+		c.trustIndex.Delete(athenzDomainName)
+		tombstone, tombstoned := c.tombstones.Get(athenzDomainName)
+		if !tombstoned {
+			// the sync statuses of a deleted athenz domain without tombstone are deleted
+			synced = serviceName == ""
+			purged = synced
+			// the domains deleted under the retain policy are not tombstoned, their authz policies are left in place
+			if c.domainDeletePolicy == rbac.DomainDeleteRetain {
+				log.Infof("Athenz domain %s is deleted, retaining its authz policies", athenzDomainName)
+				c.queue.Forget(key)
+				return nil
+			}
+			return fmt.Errorf("athenz domain %s does not exist in cache", athenzDomainName)
+		}
+		log.Infof("Athenz domain %s is deleted, applying the %s domain delete policy", athenzDomainName, c.domainDeletePolicy)
+		athenzDomainRaw, deleted = tombstone, true
 	}
 
 	athenzDomain, ok := athenzDomainRaw.(*adv1.AthenzDomain)
@@ -194,11 +328,16 @@ func (c *Controller) sync(key string) error {
 	}
 
 	signedDomain := athenzDomain.Spec.SignedDomain
-	domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(signedDomain.Domain, &c.adIndexInformer)
+	domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(signedDomain.Domain, &c.adIndexInformer, c.maxTrustDepth)
+	c.trustIndex.Update(athenzDomainName, domainRBAC.TrustDomains)
+	athenz.ScheduleMemberExpiry(c.queue, key, domainRBAC)
+	if serviceName == "" && c.lintDomains {
+		lint.Record(lint.Lint(signedDomain.Domain, rbac.ProviderV2, c.conversionOptions))
+	}
 
 	var serviceList []*corev1.Service
 	if serviceName != "" {
-		serviceObj, err := c.getSvcObj(athenz.DomainToNamespace(athenzDomainName) + "/" + serviceName)
+		serviceObj, err := c.getSvcObj(namespace + "/" + serviceName)
 		if err != nil {
 			return fmt.Errorf("error getting service from cache: %s", err.Error())
 		}
@@ -212,9 +351,8 @@ func (c *Controller) sync(key string) error {
 		// error checking
 		// add to serviceList
 		svcKeys := c.serviceIndexInformer.GetStore().ListKeys()
-		domainName := athenz.DomainToNamespace(athenzDomainName)
 		for _, svcKey := range svcKeys {
-			if strings.Split(svcKey, "/")[0] == domainName {
+			if strings.Split(svcKey, "/")[0] == namespace {
 				serviceObj, err := c.getSvcObj(svcKey)
 				if err != nil {
 					return fmt.Errorf("error getting service from cache: %s", err.Error())
@@ -226,27 +364,94 @@ func (c *Controller) sync(key string) error {
 		}
 	}
 
+	// the tombstone resolves to an empty model, the deny-all policy generates an authz policy
+	// without any rules for the authz enabled services which denies all requests
+	if deleted && c.domainDeletePolicy == rbac.DomainDeleteDelete {
+		serviceList = nil
+	}
+
 	var desiredCRs []model.Config
+	// services skipped for an invalid workload selector keep their last known authz policies
+	skippedServices := make(map[string]bool)
+	// unlabeled authz policies are only adopted with the workload selector of their service
+	selectors := make(map[string]map[string]string)
 	// range over serviceList
 	for _, service := range serviceList {
 		// if svc annotation authz.istio.io/enabled is not present - skip processing and continue
 		if !c.checkAuthzEnabledAnnotation(service) {
 			continue
 		}
-		desiredCR := c.rbacProvider.ConvertAthenzModelIntoIstioRbac(domainRBAC, service.Name, service.Labels["svc"], service.Labels["app"])
+		reporter := common.NewEventReporter(c.recorder, common.ServiceReference(service.Namespace, service.Name), common.DomainReference(athenzDomainName))
+		status := syncstatus.SyncStatus{
+			Controller:              controllerName,
+			Domain:                  athenzDomainName,
+			Service:                 service.Name,
+			ObservedResourceVersion: athenzDomain.ResourceVersion,
+			Mode:                    syncstatus.ModeEnforced,
+		}
+		if !c.componentEnabledAuthzPolicy.IsEnabled(service.Name, service.Namespace) {
+			status.Mode = syncstatus.ModeDryRun
+		}
+
+		// a service without workload selector labels is skipped, its authz policy would select
+		// the pods of other services
+		selector, err := common.WorkloadSelector(service, c.selectorLabels)
+		if err != nil {
+			log.Warningf("Skipping authz policy of service %s/%s: %s", service.Namespace, service.Name, err.Error())
+			reporter.SkippedService(err)
+			status.LastError = err.Error()
+			c.statuses.Swap(namespace, syncstatus.Name(controllerName, service.Name), status)
+			statuses = append(statuses, status)
+			skippedServices[service.Name] = true
+			continue
+		}
+
+		selectors[service.Name] = selector
+
+		desiredCR := c.rbacProvider.ConvertAthenzModelIntoIstioRbac(domainRBAC, service.Name, service.Labels["svc"], selector, reporter)
 		// append to desiredCRs array
 		desiredCRs = append(desiredCRs, desiredCR...)
+
+		status.Policies = syncstatus.NewPolicies(desiredCR)
+		status.SkippedAssertions, status.SkippedMembers = reporter.Skipped()
+		status.BroadenedAssertions = reporter.Broadened()
+		// the skipped assertions are reported once, not on every sync until they are fixed
+		reporter.RecordAssertions(c.statuses.Swap(namespace, syncstatus.Name(controllerName, service.Name), status))
+		statuses = append(statuses, status)
 	}
+	synced = true
 
 	// get current APs from cache
-	currentCRs := c.rbacProvider.GetCurrentIstioRbac(domainRBAC, c.configStoreCache, serviceName)
+	// only the authz policies owned by the controller are updated or deleted, the desired authz policies are not
+	// applied over the ones which are not owned
+	scope := common.AdoptionScope{AdoptUnlabeled: c.adoptUnlabeled, Model: domainRBAC, Selectors: selectors}
+	reporter := common.NewEventReporter(c.recorder, common.DomainReference(athenzDomainName))
+	currentCRs, desiredCRs := common.FilterOwnedConfigs(c.rbacProvider.GetCurrentIstioRbac(domainRBAC, c.configStoreCache, serviceName), desiredCRs, scope, reporter)
+	currentCRs = filterSkippedServices(currentCRs, skippedServices)
 	cbHandler := c.getCallbackHandler(key)
 	changeList := common.ComputeChangeList(currentCRs, desiredCRs, cbHandler, c.checkOverrideAnnotation)
+	// the changes of a deleted athenz domain are not held, they could never be approved on a domain which
+	// no longer exists
+	held := false
+	if !deleted {
+		computed := changeList
+		changeList, held = c.guard.Filter(controllerName, c.queue, key, athenzDomain, currentCRs, changeList, serviceName == "")
+		setHeldChanges(statuses, computed, changeList)
+	}
 
 	// If change list is empty, nothing to do
-	if len(changeList) == 0 {
+	// the authz policies are not up-to-date while destructive changes are held
+	if len(changeList) == 0 && !held {
 		log.Infof("Everything is up-to-date for key: %s", key)
+		// the tombstone is kept until the full domain sync finds the authz policies of all the services up-to-date
+		if deleted && serviceName == "" {
+			log.Infof("Domain delete policy %s is applied for athenz domain %s, purging its tombstone", c.domainDeletePolicy, athenzDomainName)
+			c.tombstones.Delete(athenzDomainName)
+			statuses = nil
+			purged = true
+		}
 		c.queue.Forget(key)
+		metrics.LastSuccessfulSync.WithLabelValues(controllerName, athenzDomainName).SetToCurrentTime()
 		return nil
 	}
 
@@ -257,9 +462,59 @@ func (c *Controller) sync(key string) error {
 			return err
 		}
 	}
+	if held {
+		log.Warningf("Destructive changes are held for key: %s", key)
+		return nil
+	}
+	metrics.LastSuccessfulSync.WithLabelValues(controllerName, athenzDomainName).SetToCurrentTime()
 	return nil
 }
 
+// setHeldChanges sets the number of changes held by the guard on the sync statuses of the services of the held
+// authz policies
+func setHeldChanges(statuses []syncstatus.SyncStatus, computed []*common.Item, applied []*common.Item) {
+	appliedItems := make(map[*common.Item]bool, len(applied))
+	for _, item := range applied {
+		appliedItems[item] = true
+	}
+	heldChanges := make(map[string]int)
+	for _, item := range computed {
+		if !appliedItems[item] {
+			heldChanges[common.GetServiceNameFromAuthzPolicy(item.Resource)]++
+		}
+	}
+	for i := range statuses {
+		statuses[i].HeldChanges = heldChanges[statuses[i].Service]
+	}
+}
+
+// filterSkippedServices returns the current authz policies without the ones of the skipped services, so that the
+// change list computation does not delete them and open the workloads of the services to all traffic
+func filterSkippedServices(currentCRs []model.Config, skippedServices map[string]bool) []model.Config {
+	if len(skippedServices) == 0 {
+		return currentCRs
+	}
+	out := make([]model.Config, 0, len(currentCRs))
+	for _, config := range currentCRs {
+		if skippedServices[common.GetServiceNameFromAuthzPolicy(config)] {
+			log.Infof("Keeping authz policy %s of skipped service in namespace %s", config.Name, config.Namespace)
+			continue
+		}
+		out = append(out, config)
+	}
+	return out
+}
+
+// newKeyReporter returns the event reporter of a queue key, the events of a service key are recorded on the service
+// and its athenz domain, the events of an athenz domain key on the athenz domain
+func (c *Controller) newKeyReporter(key string) *common.EventReporter {
+	parseKeyList := strings.Split(key, "/")
+	if len(parseKeyList) > 1 {
+		return common.NewEventReporter(c.recorder, common.ServiceReference(parseKeyList[0], parseKeyList[1]), common.DomainReference(athenz.ResolveDomain(parseKeyList[0])))
+	}
+	return common.NewEventReporter(c.recorder, common.DomainReference(key))
+}
+
 // checkOverrideAnnotation checks if current config has override annotation, skips process if override annotation is set
 // to true
 func (c *Controller) checkOverrideAnnotation(existingConfig model.Config) bool {
@@ -278,7 +533,7 @@ func (c *Controller) processConfigChange(item *common.Item) error {
 
 	var err error
 	var eHandler common.EventHandler
-	serviceName := item.Resource.ConfigMeta.Name
+	serviceName := common.GetServiceNameFromAuthzPolicy(item.Resource)
 	serviceNamespace := item.Resource.ConfigMeta.Namespace
 
 	// Depending on if the Authz Policy is enabled for the particular service
@@ -297,10 +552,42 @@ func (c *Controller) processConfigChange(item *common.Item) error {
 	case model.EventDelete:
 		err = eHandler.Delete(item)
 	}
+	metrics.ObserveResourceOperation(item.Resource.Type, item.Operation.String(), err)
 
 	return err
 }
 
+// adoptionScope returns the scope the unlabeled authz policies of the service are adopted in: the athenz domain of
+// the namespace and the workload selector of the service. The scope is empty if either of them is not found, nothing
+// is adopted then.
+func (c *Controller) adoptionScope(namespace string, serviceName string) common.AdoptionScope {
+	scope := common.AdoptionScope{AdoptUnlabeled: c.adoptUnlabeled}
+	athenzDomainName := athenz.ResolveDomain(namespace)
+	if athenzDomainName == "" {
+		return scope
+	}
+	athenzDomainRaw, exists, err := c.adIndexInformer.GetIndexer().GetByKey(athenzDomainName)
+	if err != nil || !exists {
+		return scope
+	}
+	athenzDomain, ok := athenzDomainRaw.(*adv1.AthenzDomain)
+	if !ok {
+		return scope
+	}
+	service, err := c.getSvcObj(namespace + "/" + serviceName)
+	if err != nil || service == nil {
+		return scope
+	}
+	selector, err := common.WorkloadSelector(service, c.selectorLabels)
+	if err != nil {
+		return scope
+	}
+
+	scope.Model = athenz.ConvertAthenzPoliciesIntoRbacModel(athenzDomain.Spec.SignedDomain.Domain, &c.adIndexInformer, c.maxTrustDepth)
+	scope.Selectors = map[string]map[string]string{serviceName: selector}
+	return scope
+}
+
 // getSvcObj return single service resource in the cache
 func (c *Controller) getSvcObj(svcKey string) (*corev1.Service, error) {
 	serviceRaw, exists, err := c.serviceIndexInformer.GetIndexer().GetByKey(svcKey)
@@ -342,6 +629,8 @@ func (c *Controller) getCallbackHandler(key string) common.OnCompleteFunc {
 		}
 		if c.queue.NumRequeues(key) >= queueNumRetries {
 			log.Errorf("Max number of retries reached for %s.", key)
+			metrics.QueueDropsTotal.WithLabelValues(controllerName).Inc()
+			c.newKeyReporter(key).RetriesExhausted("sync", key, err)
 			return nil
 		}
 		if item != nil {
@@ -355,6 +644,10 @@ func (c *Controller) getCallbackHandler(key string) common.OnCompleteFunc {
 // resync will run as a periodic resync at a given interval, it will take all
 // the current athenz domains in the cache and put them onto the queue
 func (c *Controller) resync(stopCh <-chan struct{}) {
+	for _, domain := range c.tombstoneOrphanedDomains() {
+		c.queue.Add(domain)
+	}
+
 	t := time.NewTicker(c.apResyncInterval)
 	defer t.Stop()
 	for {
@@ -365,6 +658,11 @@ func (c *Controller) resync(stopCh <-chan struct{}) {
 			for _, adRaw := range adListRaw {
 				c.processEvent(cache.MetaNamespaceKeyFunc, adRaw)
 			}
+			c.tombstoneOrphanedDomains()
+			// the deleted athenz domains are re-synced until their delete policy is applied
+			for _, domain := range c.tombstones.List() {
+				c.queue.Add(domain)
+			}
 		case <-stopCh:
 			log.Infoln("Stopping authorization policies resync...")
 			return
@@ -372,6 +670,33 @@ func (c *Controller) resync(stopCh <-chan struct{}) {
 	}
 }
 
+// tombstoneOrphanedDomains records the tombstones of the athenz domains which own authorization policies but do not
+// exist anymore, and returns them. The tombstones are only kept in memory, the domains deleted while no replica was
+// running, or before the leader changed, are derived from the source domain annotation of the owned authorization
+// policies so that the domain delete policy is still applied to them.
+func (c *Controller) tombstoneOrphanedDomains() []string {
+	if c.domainDeletePolicy == rbac.DomainDeleteRetain {
+		return nil
+	}
+	configs, err := c.configStoreCache.List(collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind(), corev1.NamespaceAll)
+	if err != nil {
+		log.Errorf("Error listing the authorization policies for the deleted athenz domains: %s", err.Error())
+		return nil
+	}
+	orphaned := common.OrphanedDomains(configs, func(domain string) bool {
+		if _, exists, _ := c.adIndexInformer.GetIndexer().GetByKey(domain); exists {
+			return true
+		}
+		_, tombstoned := c.tombstones.Get(domain)
+		return tombstoned
+	})
+	for _, domain := range orphaned {
+		log.Infof("Athenz domain %s of the owned authorization policies does not exist, applying the %s domain delete policy", domain, c.domainDeletePolicy)
+		c.tombstones.Add(&adv1.AthenzDomain{ObjectMeta: metav1.ObjectMeta{Name: domain}})
+	}
+	return orphaned
+}
+
 // checkAuthzEnabledAnnotation checks if current service object has "authz.istio.io/enabled" annotation set
 func (c *Controller) checkAuthzEnabledAnnotation(serviceObj *corev1.Service) bool {
 	if _, ok := serviceObj.Annotations[authzEnabledAnnotation]; ok {
@@ -392,8 +717,12 @@ func (c *Controller) cleanUpStaleAP() error {
 	}
 
 	for _, currAP := range currentAPList {
-		serviceName := currAP.Name
+		serviceName := common.GetServiceNameFromAuthzPolicy(currAP)
 		serviceNamespace := currAP.Namespace
+		// authz policies not generated by the controller are never deleted
+		if !common.IsManaged(currAP) && !common.IsOwned(currAP, c.adoptionScope(serviceNamespace, serviceName)) {
+			continue
+		}
 		key := serviceNamespace + "/" + serviceName
 
 		// Check if the Authorization Policy is enabled for the service through ap-enabled-list
@@ -12,9 +12,13 @@ import (
 	"github.com/ardielle/ardielle-go/rdl"
 	"github.com/stretchr/testify/assert"
 	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/guard"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	rbacv2 "github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/v2"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/syncstatus"
 	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
 	fakev1 "github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned/fake"
 	adInformer "github.com/yahoo/k8s-athenz-syncer/pkg/client/informers/externalversions/athenz/v1"
@@ -28,7 +32,10 @@ import (
 	"istio.io/istio/pkg/config/schema/collections"
 	"istio.io/istio/pkg/config/schema/resource"
 	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/tools/cache"
 	fcache "k8s.io/client-go/tools/cache/testing"
 	"k8s.io/client-go/util/workqueue"
@@ -59,6 +66,11 @@ var (
 				"svc": "productpage",
 			},
 		},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{
+				"app": "productpage",
+			},
+		},
 	}
 
 	undefinedAthenzRulesServiceWithAnnotationTrue = &v1.Service{
@@ -73,6 +85,11 @@ var (
 				"svc": "productpage",
 			},
 		},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{
+				"app": "productpage",
+			},
+		},
 	}
 
 	notOnboardedServiceWithAnnotationFalse = &v1.Service{
@@ -87,6 +104,11 @@ var (
 				"svc": "productpage",
 			},
 		},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{
+				"app": "productpage",
+			},
+		},
 	}
 
 	notOnboardedService = &v1.Service{
@@ -202,11 +224,15 @@ func newFakeController(athenzDomain *adv1.AthenzDomain, service *v1.Service, fak
 		panic(err)
 	}
 	c.componentEnabledAuthzPolicy = componentsEnabledAuthzPolicy
-	c.rbacProvider = rbacv2.NewProvider(componentsEnabledAuthzPolicy, c.enableOriginJwtSubject)
+	c.conversionOptions = common.DefaultConversionOptions()
+	c.rbacProvider = rbacv2.NewProvider(componentsEnabledAuthzPolicy, c.enableOriginJwtSubject, c.conversionOptions)
 	c.dryRunHandler = common.DryRunHandler{}
 	c.apiHandler = common.ApiHandler{
 		ConfigStoreCache: c.configStoreCache,
 	}
+	c.trustIndex = athenz.NewTrustIndex()
+	c.tombstones = athenz.NewTombstones()
+	c.maxTrustDepth = athenz.DefaultMaxTrustDepth
 	return c
 }
 
@@ -384,6 +410,94 @@ func TestSyncAthenzDomain(t *testing.T) {
 	}
 }
 
+func TestSyncDeletedAthenzDomain(t *testing.T) {
+	denyAllAuthzPolicy := getExpectedAuthzPolicy()
+	common.SetManagedMetadata(denyAllAuthzPolicy, domainNameOnboarded)
+	denyAllAuthzPolicy.Spec = &v1beta1.AuthorizationPolicy{
+		Selector: &workloadv1beta1.WorkloadSelector{
+			MatchLabels: map[string]string{"app": "productpage"},
+		},
+	}
+
+	tests := []struct {
+		name                string
+		policy              rbac.DomainDeletePolicy
+		tombstoned          bool
+		derived             bool
+		expectedAuthzPolicy *model.Config
+		expErr              error
+	}{
+		{
+			name:                "retain authz policy when athenz domain is deleted without tombstone",
+			policy:              rbac.DomainDeleteRetain,
+			expectedAuthzPolicy: getExpectedAuthzPolicy(),
+		},
+		{
+			name:                "delete authz policy when athenz domain is deleted",
+			policy:              rbac.DomainDeleteDelete,
+			tombstoned:          true,
+			expectedAuthzPolicy: nil,
+		},
+		{
+			name:                "delete authz policy when athenz domain is deleted before the controller started",
+			policy:              rbac.DomainDeleteDelete,
+			tombstoned:          true,
+			derived:             true,
+			expectedAuthzPolicy: nil,
+		},
+		{
+			name:                "replace authz policy with deny-all authz policy when athenz domain is deleted",
+			policy:              rbac.DomainDeleteDenyAll,
+			tombstoned:          true,
+			expectedAuthzPolicy: denyAllAuthzPolicy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeController(onboardedAthenzDomain, onboardedService, true, "*", make(chan struct{}))
+			c.domainDeletePolicy = tt.policy
+			// the changes of a deleted domain are not held by the guard
+			c.guard = guard.NewGuard(guard.Config{Domain: guard.Thresholds{MaxRemovals: 1}}, nil)
+			_, err := c.configStoreCache.Create(*getExpectedAuthzPolicy())
+			assert.Nil(t, err, "configstore create resource should not return error")
+			time.Sleep(100 * time.Millisecond)
+
+			// simulate domain deletion with the tombstone recorded by the informer delete event
+			err = c.adIndexInformer.GetStore().Delete(onboardedAthenzDomain)
+			assert.Nil(t, err, "delete athenz domain crd in the cache should not return error")
+			switch {
+			case tt.derived:
+				assert.Equal(t, []string{domainNameOnboarded}, c.tombstoneOrphanedDomains(), "tombstone should be derived from the owned authz policies")
+			case tt.tombstoned:
+				c.tombstones.Add(cache.DeletedFinalStateUnknown{Key: domainNameOnboarded, Obj: onboardedAthenzDomain})
+			default:
+				assert.Empty(t, c.tombstoneOrphanedDomains(), "tombstone should not be derived under the retain policy")
+			}
+
+			err = c.sync(domainNameOnboarded)
+			assert.Equal(t, tt.expErr, err, "sync function error should be equal")
+			if tt.tombstoned {
+				_, exists := c.tombstones.Get(domainNameOnboarded)
+				assert.True(t, exists, "tombstone should be kept until the authz policies are up-to-date")
+				err = c.sync(domainNameOnboarded)
+				assert.Nil(t, err, "sync function should not return error")
+				_, exists = c.tombstones.Get(domainNameOnboarded)
+				assert.False(t, exists, "tombstone should be purged once the domain delete policy is applied")
+			}
+			genAuthzPolicy := c.configStoreCache.Get(collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind(), onboardedService.Name, onboardedService.Namespace)
+			if tt.expectedAuthzPolicy == nil {
+				assert.Nil(t, genAuthzPolicy, "authorization policy should be deleted")
+				return
+			}
+			assert.NotNil(t, genAuthzPolicy, "authorization policy should exist")
+			tt.expectedAuthzPolicy.ConfigMeta.CreationTimestamp = genAuthzPolicy.ConfigMeta.CreationTimestamp
+			tt.expectedAuthzPolicy.ConfigMeta.ResourceVersion = genAuthzPolicy.ConfigMeta.ResourceVersion
+			assert.Equal(t, *tt.expectedAuthzPolicy, *genAuthzPolicy, "authorization policy should be equal")
+		})
+	}
+}
+
 func TestSyncAuthzPolicy(t *testing.T) {
 	tests := []struct {
 		name                string
@@ -472,6 +586,132 @@ func TestSyncAuthzPolicy(t *testing.T) {
 	}
 }
 
+func TestSyncDenyAuthzPolicy(t *testing.T) {
+	deny := zms.DENY
+	denyAthenzDomain := onboardedAthenzDomain.DeepCopy()
+	policy := denyAthenzDomain.Spec.SignedDomain.Domain.Policies.Contents.Policies[0]
+	policy.Assertions = append(policy.Assertions, &zms.Assertion{
+		Role:     domainNameOnboarded + ":role.productpage-reader",
+		Resource: domainNameOnboarded + ":svc.productpage:/admin",
+		Action:   "get",
+		Effect:   &deny,
+	})
+
+	c := newFakeController(denyAthenzDomain, onboardedService, true, "*", make(chan struct{}))
+	key, err := cache.MetaNamespaceKeyFunc(onboardedService)
+	assert.Nil(t, err, "function convert item interface to key should not return error")
+	err = c.sync(key)
+	assert.Nil(t, err, "sync function should not return error")
+
+	apGVK := collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind()
+	genAuthzPolicy := c.configStoreCache.Get(apGVK, onboardedService.Name, onboardedService.Namespace)
+	assert.NotNil(t, genAuthzPolicy, "allow authorization policy should be created")
+	genDenyAuthzPolicy := c.configStoreCache.Get(apGVK, common.DenyAuthzPolicyName(onboardedService.Name), onboardedService.Namespace)
+	assert.NotNil(t, genDenyAuthzPolicy, "deny authorization policy should be created")
+	assert.Equal(t, v1beta1.AuthorizationPolicy_DENY, genDenyAuthzPolicy.Spec.(*v1beta1.AuthorizationPolicy).Action, "deny authorization policy action should be DENY")
+
+	// an event on the deny authorization policy should be mapped back to the service key
+	c.EventHandler(model.Config{}, *genDenyAuthzPolicy, model.EventUpdate)
+	item, shutdown := c.queue.Get()
+	assert.False(t, shutdown, "shutdown should be false")
+	assert.Equal(t, key, item, "key should be equal")
+	c.queue.Done(item)
+
+	// removing the deny assertion from the athenz domain should delete the deny authorization policy
+	err = c.adIndexInformer.GetStore().Update(onboardedAthenzDomain.DeepCopy())
+	assert.Nil(t, err, "update athenz domain crd in the cache should not return error")
+	err = c.sync(key)
+	assert.Nil(t, err, "sync function should not return error")
+	genAuthzPolicy = c.configStoreCache.Get(apGVK, onboardedService.Name, onboardedService.Namespace)
+	assert.NotNil(t, genAuthzPolicy, "allow authorization policy should not be deleted")
+	genDenyAuthzPolicy = c.configStoreCache.Get(apGVK, common.DenyAuthzPolicyName(onboardedService.Name), onboardedService.Namespace)
+	assert.Nil(t, genDenyAuthzPolicy, "deny authorization policy should be deleted")
+}
+
+func TestSyncSkippedServiceKeepsAuthzPolicy(t *testing.T) {
+	// a service without selector, e.g. an ExternalName service, is skipped and keeps its existing authz policy
+	service := onboardedService.DeepCopy()
+	service.Spec.Selector = nil
+	c := newFakeController(onboardedAthenzDomain, service, true, "*", make(chan struct{}))
+	_, err := c.configStoreCache.Create(*getExpectedAuthzPolicy())
+	assert.Nil(t, err, "configstore create resource should not return error")
+	time.Sleep(100 * time.Millisecond)
+
+	apGVK := collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind()
+	for _, key := range []string{onboardedService.Namespace + "/" + onboardedService.Name, domainNameOnboarded} {
+		err = c.sync(key)
+		assert.Nil(t, err, "sync function should not return error")
+		genAuthzPolicy := c.configStoreCache.Get(apGVK, onboardedService.Name, onboardedService.Namespace)
+		assert.NotNil(t, genAuthzPolicy, "authorization policy of the skipped service should not be deleted for key %s", key)
+	}
+}
+
+func TestSyncWritesSyncStatus(t *testing.T) {
+	dynamicClient := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+	c := newFakeController(onboardedAthenzDomain, onboardedService, true, "", make(chan struct{}))
+	c.statusWriter = syncstatus.NewWriter(dynamicClient)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go c.statusWriter.Run(stopCh)
+	key, err := cache.MetaNamespaceKeyFunc(onboardedService)
+	assert.Nil(t, err, "function convert item interface to key should not return error")
+	err = c.sync(key)
+	assert.Nil(t, err, "sync function should not return error")
+	time.Sleep(100 * time.Millisecond)
+
+	obj, err := dynamicClient.Resource(syncstatus.GroupVersionResource).Namespace(onboardedService.Namespace).Get(syncstatus.Name(controllerName, onboardedService.Name), metav1.GetOptions{})
+	assert.Nil(t, err, "sync status should be written")
+	status := &syncstatus.AthenzIstioSyncStatus{}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), status)
+	assert.Nil(t, err, "sync status conversion should not return error")
+	assert.Equal(t, domainNameOnboarded, status.Status.Domain, "sync status domain should be equal")
+	assert.Equal(t, onboardedService.Name, status.Status.Service, "sync status service should be equal")
+	assert.Equal(t, syncstatus.ModeDryRun, status.Status.Mode, "sync status mode should be dry-run for a service which is not enabled")
+	assert.Equal(t, 1, len(status.Status.Policies), "sync status should list the generated authorization policy")
+	assert.Equal(t, onboardedService.Name, status.Status.Policies[0].Name, "sync status policy name should be equal")
+	assert.Equal(t, "", status.Status.LastError, "sync status last error should be empty")
+
+	// the sync status of a deleted service is deleted by the full domain sync
+	err = c.serviceIndexInformer.GetStore().Delete(onboardedService)
+	assert.Nil(t, err, "delete service in the cache should not return error")
+	err = c.sync(domainNameOnboarded)
+	assert.Nil(t, err, "sync function should not return error")
+	time.Sleep(100 * time.Millisecond)
+	_, err = dynamicClient.Resource(syncstatus.GroupVersionResource).Namespace(onboardedService.Namespace).Get(syncstatus.Name(controllerName, onboardedService.Name), metav1.GetOptions{})
+	assert.True(t, apiErrors.IsNotFound(err), "sync status of the deleted service should be deleted")
+}
+
+func TestSetHeldChanges(t *testing.T) {
+	newItem := func(name string) *common.Item {
+		return &common.Item{
+			Operation: model.EventDelete,
+			Resource:  common.NewConfig(collections.IstioSecurityV1Beta1Authorizationpolicies, "test-namespace", name, &v1beta1.AuthorizationPolicy{}),
+		}
+	}
+	allow, deny, other := newItem("productpage"), newItem(common.DenyAuthzPolicyName("productpage")), newItem("details")
+	statuses := []syncstatus.SyncStatus{{Service: "productpage"}, {Service: "details", HeldChanges: 1}}
+
+	setHeldChanges(statuses, []*common.Item{allow, deny, other}, []*common.Item{other})
+	assert.Equal(t, 2, statuses[0].HeldChanges, "held allow and deny authz policies should be counted for the service")
+	assert.Equal(t, 0, statuses[1].HeldChanges, "service without held changes should not have held changes")
+}
+
+func TestProcessDelegatingDomains(t *testing.T) {
+	c := newFakeController(onboardedAthenzDomain, onboardedService, true, "*", make(chan struct{}))
+	c.trustIndex.Update(domainNameOnboarded, athenz.RoleTrustDomains{
+		domainNameOnboarded + ":role.delegated": []zms.DomainName{domainNameNotOnboarded},
+	})
+
+	c.processDelegatingDomains(cache.MetaNamespaceKeyFunc, notOnboardedAthenzDomain.DeepCopy())
+	assert.Equal(t, 1, c.queue.Len(), "queue length should be 1")
+	item, shutdown := c.queue.Get()
+	assert.False(t, shutdown, "shutdown should be false")
+	assert.Equal(t, domainNameOnboarded, item, "delegating domain key should be equal")
+
+	c.processDelegatingDomains(cache.MetaNamespaceKeyFunc, onboardedAthenzDomain.DeepCopy())
+	assert.Equal(t, 0, c.queue.Len(), "queue length should be 0 for a domain without delegating domains")
+}
+
 func TestNewController(t *testing.T) {
 	configDescriptor := collection.SchemasFor(collections.IstioSecurityV1Beta1Authorizationpolicies)
 	source := fcache.NewFakeControllerSource()
@@ -486,13 +726,14 @@ func TestNewController(t *testing.T) {
 	apiHandler := common.ApiHandler{
 		ConfigStoreCache: configStoreCache,
 	}
-	c := NewController(configStoreCache, fakeIndexInformer, fakeAthenzInformer, istioClientSet, apResyncInterval, true, &common.ComponentEnabled{})
+	c := NewController(configStoreCache, fakeIndexInformer, fakeAthenzInformer, istioClientSet, apResyncInterval, true, &common.ComponentEnabled{}, nil, rbac.DomainDeleteRetain, nil, nil, nil, athenz.DefaultMaxTrustDepth, common.DefaultConversionOptions(), true, true)
 	assert.Equal(t, fakeIndexInformer, c.serviceIndexInformer, "service index informer pointer should be equal")
 	assert.Equal(t, configStoreCache, c.configStoreCache, "config configStoreCache cache pointer should be equal")
 	assert.Equal(t, fakeAthenzInformer, c.adIndexInformer, "athenz index informer cache should be equal")
 	assert.Equal(t, true, c.enableOriginJwtSubject, "enableOriginJwtSubject bool should be equal")
 	assert.Equal(t, common.DryRunHandler{}, c.dryRunHandler, "dryRun handler should be equal")
 	assert.Equal(t, apiHandler, c.apiHandler, "api handler should be equal")
+	assert.Equal(t, true, c.lintDomains, "lintDomains bool should be equal")
 }
 
 func TestCleanUpStaleAP(t *testing.T) {
@@ -503,6 +744,7 @@ func TestCleanUpStaleAP(t *testing.T) {
 		existingAuthzPolicy *model.Config
 		expectedAuthzPolicy *model.Config
 		apEnabledList       string
+		adoptUnlabeled      bool
 	}{
 		{
 			name:                "delete all authorization policies as apEnabledList has a different namespace than onboarded namespace",
@@ -528,6 +770,32 @@ func TestCleanUpStaleAP(t *testing.T) {
 			expectedAuthzPolicy: getExpectedAuthzPolicy(),
 			apEnabledList:       "test-namespace-onboarded/*",
 		},
+		{
+			name:                "existing authorization policy is not deleted as it is not managed by the controller",
+			inputService:        onboardedService,
+			inputAthenzDomain:   onboardedAthenzDomain,
+			existingAuthzPolicy: getHandWrittenAuthzPolicy(),
+			expectedAuthzPolicy: getHandWrittenAuthzPolicy(),
+			apEnabledList:       "foobar/*",
+		},
+		{
+			name:                "hand-written authorization policy named after the service is not deleted when the unlabeled resources are adopted",
+			inputService:        onboardedService,
+			inputAthenzDomain:   onboardedAthenzDomain,
+			existingAuthzPolicy: getHandWrittenAuthzPolicy(),
+			expectedAuthzPolicy: getHandWrittenAuthzPolicy(),
+			apEnabledList:       "foobar/*",
+			adoptUnlabeled:      true,
+		},
+		{
+			name:                "unlabeled authorization policy with the generated spec is deleted when the unlabeled resources are adopted",
+			inputService:        onboardedService,
+			inputAthenzDomain:   onboardedAthenzDomain,
+			existingAuthzPolicy: getUnlabeledAuthzPolicy(),
+			expectedAuthzPolicy: nil,
+			apEnabledList:       "foobar/*",
+			adoptUnlabeled:      true,
+		},
 		{
 			name:                "existing authorization policy is not deleted as the override annotation is enabled even when not in the same namespace",
 			inputService:        onboardedService,
@@ -541,6 +809,7 @@ func TestCleanUpStaleAP(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := newFakeController(tt.inputAthenzDomain, tt.inputService, true, tt.apEnabledList, make(chan struct{}))
+			c.adoptUnlabeled = tt.adoptUnlabeled
 			c.configStoreCache.Create(*tt.existingAuthzPolicy)
 			time.Sleep(100 * time.Millisecond)
 
@@ -558,6 +827,20 @@ func TestCleanUpStaleAP(t *testing.T) {
 	}
 }
 
+// getUnlabeledAuthzPolicy returns the expected authorization policy as generated before the managed-by label
+func getUnlabeledAuthzPolicy() *model.Config {
+	out := getExpectedAuthzPolicy()
+	out.Labels = nil
+	out.Annotations = nil
+	return out
+}
+
+func getHandWrittenAuthzPolicy() *model.Config {
+	out := getExistingAuthzPolicy()
+	out.Labels = nil
+	return out
+}
+
 func getExpectedAuthzPolicy() *model.Config {
 	var out model.Config
 	schema := collections.IstioSecurityV1Beta1Authorizationpolicies
@@ -573,6 +856,7 @@ func getExpectedAuthzPolicy() *model.Config {
 		Name:              "onboarded-service",
 		CreationTimestamp: createTimestamp,
 	}
+	common.SetManagedMetadata(&out, domainNameOnboarded, domainNameOnboarded+":role.productpage-reader")
 	out.Spec = &v1beta1.AuthorizationPolicy{
 		Selector: &workloadv1beta1.WorkloadSelector{
 			MatchLabels: map[string]string{"app": "productpage"},
@@ -781,6 +1065,8 @@ func getExistingAuthzPolicy() *model.Config {
 		Namespace:         "test-namespace-onboarded",
 		Name:              "onboarded-service",
 		CreationTimestamp: createTimestamp,
+		// the existing authz policy is generated by the controller
+		Labels: map[string]string{common.ManagedByLabel: common.ManagedByValue},
 	}
 	out.Spec = &v1beta1.AuthorizationPolicy{
 		Selector: &workloadv1beta1.WorkloadSelector{
@@ -894,6 +1180,7 @@ func getExpectedEmptyAuthzPolicy() *model.Config {
 		Name:              "onboarded-service",
 		CreationTimestamp: createTimestamp,
 	}
+	common.SetManagedMetadata(out, domainNameNotOnboarded)
 	out.Spec = &v1beta1.AuthorizationPolicy{
 		Selector: &workloadv1beta1.WorkloadSelector{
 			MatchLabels: map[string]string{"app": "productpage"},
@@ -0,0 +1,111 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package guard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ConfigMapApprovals reads the approvals of the held destructive changes from a ConfigMap, the data keys are athenz
+// domains and the values are comma separated lists of approved fingerprints. Unlike the ApprovalAnnotation, it
+// approves the changes of the athenz domains read from a directory, which are not objects of the api server. A nil
+// ConfigMapApprovals does not approve anything.
+type ConfigMapApprovals struct {
+	configMapIndexInformer cache.SharedIndexInformer
+	configMapKey           string
+}
+
+// NewConfigMapApprovals returns a ConfigMapApprovals watching the ConfigMap in the <namespace>/<name> format
+func NewConfigMapApprovals(k8sClient kubernetes.Interface, configMapKey string) (*ConfigMapApprovals, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(configMapKey)
+	if err != nil || namespace == "" || name == "" {
+		return nil, fmt.Errorf("config map %s is not in the <namespace>/<name> format", configMapKey)
+	}
+	configMapListWatch := cache.NewListWatchFromClient(k8sClient.CoreV1().RESTClient(), "configmaps", namespace, fields.OneTermEqualSelector("metadata.name", name))
+	return &ConfigMapApprovals{
+		configMapIndexInformer: cache.NewSharedIndexInformer(configMapListWatch, &corev1.ConfigMap{}, 0, nil),
+		configMapKey:           configMapKey,
+	}, nil
+}
+
+// Run starts the config map informer
+func (a *ConfigMapApprovals) Run(stopCh <-chan struct{}) {
+	go a.configMapIndexInformer.Run(stopCh)
+}
+
+// HasSynced returns true once the config map informer has synced
+func (a *ConfigMapApprovals) HasSynced() bool {
+	return a.configMapIndexInformer.HasSynced()
+}
+
+// String returns the key of the config map, used in the event of the held changes
+func (a *ConfigMapApprovals) String() string {
+	return a.configMapKey
+}
+
+// isApproved checks if the fingerprint is listed for the athenz domain in the config map
+func (a *ConfigMapApprovals) isApproved(domain string, fingerprint string) bool {
+	if a == nil {
+		return false
+	}
+	obj, exists, err := a.configMapIndexInformer.GetIndexer().GetByKey(a.configMapKey)
+	if err != nil || !exists {
+		return false
+	}
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		log.Errorln("Could not cast to config map object, skipping the approvals")
+		return false
+	}
+	approved, exists := configMap.Data[domain]
+	return exists && containsFingerprint(approved, fingerprint)
+}
+
+// AddDomainHandler registers a handler called with the athenz domains whose approvals changed, so that their held
+// changes are synced again
+func (a *ConfigMapApprovals) AddDomainHandler(handler func(domain string)) {
+	a.configMapIndexInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			a.processConfigMapEvent(nil, obj, handler)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			a.processConfigMapEvent(oldObj, newObj, handler)
+		},
+	})
+}
+
+// processConfigMapEvent calls the handler with the athenz domains whose approved fingerprints were added or changed,
+// the removed approvals do not release any held change
+func (a *ConfigMapApprovals) processConfigMapEvent(oldObj, newObj interface{}, handler func(domain string)) {
+	var oldData, newData map[string]string
+	if configMap, ok := oldObj.(*corev1.ConfigMap); ok {
+		oldData = configMap.Data
+	}
+	if configMap, ok := newObj.(*corev1.ConfigMap); ok {
+		newData = configMap.Data
+	}
+	for domain, approved := range newData {
+		if oldData[domain] != approved {
+			log.Infof("Approvals of domain %s changed in config map %s", domain, a.configMapKey)
+			handler(domain)
+		}
+	}
+}
+
+// containsFingerprint checks if the fingerprint is in the comma separated list of approved fingerprints
+func containsFingerprint(approved string, fingerprint string) bool {
+	for _, value := range strings.Split(approved, ",") {
+		if strings.TrimSpace(value) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package guard
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestApprovals(t *testing.T, data map[string]string) *ConfigMapApprovals {
+	a, err := NewConfigMapApprovals(fake.NewSimpleClientset(), "kube-system/athenz-guard-approvals")
+	assert.Nil(t, err, "error should be nil")
+	if data != nil {
+		assert.Nil(t, a.configMapIndexInformer.GetIndexer().Add(newTestApprovalsConfigMap(data)), "error should be nil")
+	}
+	return a
+}
+
+func newTestApprovalsConfigMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "athenz-guard-approvals",
+			Namespace: "kube-system",
+		},
+		Data: data,
+	}
+}
+
+func TestNewConfigMapApprovals(t *testing.T) {
+	_, err := NewConfigMapApprovals(fake.NewSimpleClientset(), "athenz-guard-approvals")
+	assert.Equal(t, fmt.Errorf("config map athenz-guard-approvals is not in the <namespace>/<name> format"), err, "invalid config map key should fail")
+}
+
+func TestConfigMapApprovals(t *testing.T) {
+	a := newTestApprovals(t, map[string]string{"test.namespace": "other, abc123"})
+	assert.True(t, a.isApproved("test.namespace", "abc123"), "listed fingerprint should be approved")
+	assert.False(t, a.isApproved("test.namespace", "def456"), "unlisted fingerprint should not be approved")
+	assert.False(t, a.isApproved("other.domain", "abc123"), "fingerprint of another domain should not be approved")
+	assert.False(t, newTestApprovals(t, nil).isApproved("test.namespace", "abc123"), "missing config map should not approve")
+
+	var nilApprovals *ConfigMapApprovals
+	assert.False(t, nilApprovals.isApproved("test.namespace", "abc123"), "nil approvals should not approve")
+}
+
+func TestConfigMapApprovalsDomainHandler(t *testing.T) {
+	a := newTestApprovals(t, nil)
+	var domains []string
+	handler := func(domain string) {
+		domains = append(domains, domain)
+	}
+
+	a.processConfigMapEvent(nil, newTestApprovalsConfigMap(map[string]string{"added.domain": "abc123"}), handler)
+	assert.Equal(t, []string{"added.domain"}, domains, "domains of the added config map should be notified")
+
+	domains = nil
+	a.processConfigMapEvent(newTestApprovalsConfigMap(map[string]string{"kept.domain": "abc123", "changed.domain": "abc123", "removed.domain": "abc123"}),
+		newTestApprovalsConfigMap(map[string]string{"kept.domain": "abc123", "changed.domain": "abc123,def456"}), handler)
+	assert.Equal(t, []string{"changed.domain"}, domains, "domains with changed approvals should be notified")
+}
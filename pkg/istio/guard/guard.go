@@ -0,0 +1,344 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package guard
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/api/security/v1beta1"
+	"istio.io/istio/pilot/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// ApprovalAnnotation is the athenz domain annotation used by operators to approve held destructive changes, the
+	// value is a comma separated list of the fingerprints reported when the changes were held. A fingerprint is bound
+	// to the version of the athenz domain and the content of the held resources, an approval does not apply to the
+	// changes of a later domain version.
+	ApprovalAnnotation = "athenz.io/approve-destructive-changes"
+	// HeldEventReason is the reason of the event recorded on the namespace of the athenz domain when destructive
+	// changes are held
+	HeldEventReason = "DestructiveChangesHeld"
+
+	scopeDomain  = "domain"
+	scopeCluster = "cluster"
+
+	// windowDelay is added to the time the cluster-wide window clears at, so that the removals falling out of the
+	// window are not counted anymore when the held changes are synced again
+	windowDelay = time.Second
+)
+
+// Thresholds are the limits on the removals in one sync, a zero value disables the limit
+type Thresholds struct {
+	// MaxRemovals is the maximum number of removals
+	MaxRemovals int
+	// MaxRemovalPercent is the maximum percentage of removals out of the current resources and rules
+	MaxRemovalPercent int
+}
+
+// exceeded checks if the removals out of the total exceed the thresholds
+func (t Thresholds) exceeded(removals, total int) bool {
+	if removals == 0 {
+		return false
+	}
+	if t.MaxRemovals > 0 && removals > t.MaxRemovals {
+		return true
+	}
+	return t.MaxRemovalPercent > 0 && removals*100 > t.MaxRemovalPercent*total
+}
+
+// enabled returns true if any of the thresholds is set
+func (t Thresholds) enabled() bool {
+	return t.MaxRemovals > 0 || t.MaxRemovalPercent > 0
+}
+
+// Config holds the per domain and cluster-wide thresholds of the guard, the cluster-wide thresholds apply to the
+// removals of all the domains within the window
+type Config struct {
+	Domain  Thresholds
+	Cluster Thresholds
+	Window  time.Duration
+	// Approvals approves the held changes in addition to the ApprovalAnnotation if it is not nil
+	Approvals *ConfigMapApprovals
+}
+
+type removalRecord struct {
+	timestamp time.Time
+	removals  int
+}
+
+// Guard holds destructive change lists which exceed the configured thresholds. A removal is either a deleted
+// resource or a rule removed from an updated resource, where the rules are the access rules of a ServiceRole, the
+// subjects of a ServiceRoleBinding and the rules of an AuthorizationPolicy. The percentage is relative to the number
+// of current resources and rules. The held changes are applied once the athenz domain carries the fingerprint of the
+// change list in the ApprovalAnnotation or the approvals ConfigMap lists it for the domain, or once the change list
+// does not exceed the thresholds anymore. The changes held by the cluster-wide thresholds are synced again once the
+// oldest removals fall out of the window.
+type Guard struct {
+	config   Config
+	recorder record.EventRecorder
+	now      func() time.Time
+
+	mu sync.Mutex
+	// totals holds the last number of current resources and rules per controller and domain
+	totals map[string]map[string]int
+	// history holds the removals applied per controller within the cluster-wide window
+	history map[string][]removalRecord
+}
+
+// NewGuard returns a Guard with the given thresholds, events are recorded with the recorder if it is not nil
+func NewGuard(config Config, recorder record.EventRecorder) *Guard {
+	return &Guard{
+		config:   config,
+		recorder: recorder,
+		now:      time.Now,
+		totals:   make(map[string]map[string]int),
+		history:  make(map[string][]removalRecord),
+	}
+}
+
+// Filter returns the change list to apply for the athenz domain and whether destructive changes are held. The
+// destructive changes are removed from the change list while they are held, the non destructive changes are always
+// returned. The key is added back to the queue once the cluster-wide window clears when the changes are held by the
+// cluster-wide thresholds. The current resources should be the full set of owned resources of the domain when
+// fullSync is true, otherwise the last known domain total is used for the percentage thresholds.
+func (g *Guard) Filter(controller string, queue workqueue.DelayingInterface, key string, athenzDomain *adv1.AthenzDomain, currentCRs []model.Config, changeList []*common.Item, fullSync bool) ([]*common.Item, bool) {
+	if g == nil || (!g.config.Domain.enabled() && !g.config.Cluster.enabled()) {
+		return changeList, false
+	}
+
+	domainName := athenzDomain.Name
+	currMap := common.ConvertSliceToKeyedMap(currentCRs)
+	var destructive, safe []*common.Item
+	removals := 0
+	for _, item := range changeList {
+		count := countRemovals(item, currMap)
+		if count == 0 {
+			safe = append(safe, item)
+			continue
+		}
+		removals += count
+		destructive = append(destructive, item)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	total := countTotal(currentCRs)
+	if _, exists := g.totals[controller]; !exists {
+		g.totals[controller] = make(map[string]int)
+	}
+	if fullSync || g.totals[controller][domainName] < total {
+		g.totals[controller][domainName] = total
+	}
+
+	if removals == 0 {
+		metrics.GuardHeldChanges.WithLabelValues(controller, domainName).Set(0)
+		return changeList, false
+	}
+
+	domainTotal := g.totals[controller][domainName]
+	clusterRemovals, clusterTotal := g.clusterRemovalsLocked(controller)
+	clusterRemovals += removals
+
+	scope := ""
+	switch {
+	case g.config.Domain.exceeded(removals, domainTotal):
+		scope = scopeDomain
+	case g.config.Cluster.exceeded(clusterRemovals, clusterTotal):
+		scope = scopeCluster
+	}
+
+	if scope == "" {
+		g.recordRemovalsLocked(controller, removals)
+		metrics.GuardHeldChanges.WithLabelValues(controller, domainName).Set(0)
+		return changeList, false
+	}
+
+	fingerprint := Fingerprint(domainVersion(athenzDomain), destructive)
+	if isApproved(athenzDomain, fingerprint) || g.config.Approvals.isApproved(domainName, fingerprint) {
+		log.Infof("Destructive changes %s for domain %s exceeding the %s thresholds are approved, applying %d removals", fingerprint, domainName, scope, removals)
+		g.recordRemovalsLocked(controller, removals)
+		metrics.GuardHeldChanges.WithLabelValues(controller, domainName).Set(0)
+		return changeList, false
+	}
+
+	message := fmt.Sprintf("Holding %d destructive changes with %d removals for domain %s exceeding the %s thresholds, "+
+		"approve with the %s=%s annotation on the athenz domain", len(destructive), removals, domainName, scope, ApprovalAnnotation, fingerprint)
+	if g.config.Approvals != nil {
+		message += fmt.Sprintf(" or the %s key of the %s config map", domainName, g.config.Approvals)
+	}
+	log.Warningln(message)
+	for _, item := range destructive {
+		log.Warningf("Held %s on %s for domain %s", item.Operation, item.Resource.Key(), domainName)
+	}
+	metrics.GuardTripsTotal.WithLabelValues(controller, domainName, scope).Inc()
+	metrics.GuardHeldChanges.WithLabelValues(controller, domainName).Set(float64(len(destructive)))
+	// the athenz domains read from a directory are not objects of the api server, the event is recorded on the
+	// namespace of the domain instead
+	if namespace := athenz.ResolveNamespace(domainName); g.recorder != nil && namespace != "" {
+		g.recorder.Event(namespaceReference(namespace), corev1.EventTypeWarning, HeldEventReason, message)
+	}
+	// the removals of the window fall out of it over time, unlike the domain thresholds which need an approval
+	if scope == scopeCluster && queue != nil {
+		if wait, ok := g.windowClearsLocked(controller); ok {
+			log.Infof("Scheduling sync for key: %s once the cluster-wide window clears in %s", key, wait)
+			queue.AddAfter(key, wait)
+		}
+	}
+	return safe, true
+}
+
+// windowClearsLocked returns the time until the oldest removals of the controller fall out of the window, false is
+// returned if there are no removals within the window
+func (g *Guard) windowClearsLocked(controller string) (time.Duration, bool) {
+	records := g.history[controller]
+	if len(records) == 0 {
+		return 0, false
+	}
+	return records[0].timestamp.Add(g.config.Window).Sub(g.now()) + windowDelay, true
+}
+
+// clusterRemovalsLocked returns the removals within the window and the total resources and rules of the controller
+func (g *Guard) clusterRemovalsLocked(controller string) (int, int) {
+	cutoff := g.now().Add(-g.config.Window)
+	records := g.history[controller][:0]
+	removals := 0
+	for _, r := range g.history[controller] {
+		if r.timestamp.Before(cutoff) {
+			continue
+		}
+		records = append(records, r)
+		removals += r.removals
+	}
+	g.history[controller] = records
+
+	total := 0
+	for _, domainTotal := range g.totals[controller] {
+		total += domainTotal
+	}
+	return removals, total
+}
+
+func (g *Guard) recordRemovalsLocked(controller string, removals int) {
+	g.history[controller] = append(g.history[controller], removalRecord{timestamp: g.now(), removals: removals})
+}
+
+// isApproved checks if the fingerprint is listed in the approval annotation of the athenz domain
+func isApproved(athenzDomain *adv1.AthenzDomain, fingerprint string) bool {
+	approved, exists := athenzDomain.Annotations[ApprovalAnnotation]
+	return exists && containsFingerprint(approved, fingerprint)
+}
+
+// namespaceReference returns the reference of the namespace object the events of the held changes are recorded on
+func namespaceReference(name string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: corev1.SchemeGroupVersion.String(),
+		Kind:       "Namespace",
+		Name:       name,
+	}
+}
+
+// domainVersion returns the version of the athenz domain the fingerprint is bound to. The generation is used for the
+// athenz domains served by the api server, as the resource version also changes when the approval annotation is
+// set. The athenz domains read from a directory have no generation, their resource version is the content hash.
+func domainVersion(athenzDomain *adv1.AthenzDomain) string {
+	if athenzDomain.Generation > 0 {
+		return strconv.FormatInt(athenzDomain.Generation, 10)
+	}
+	return athenzDomain.ResourceVersion
+}
+
+// Fingerprint returns a short hash identifying the destructive changes of the athenz domain version, so that an
+// approval only applies to the change list and the content it was given for
+func Fingerprint(version string, items []*common.Item) string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Operation.String()+" "+item.Resource.Key()+" "+contentHash(item.Resource))
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(version+"\n"+strings.Join(keys, "\n"))))[:12]
+}
+
+// contentHash returns the hash of the spec of the resource
+func contentHash(config model.Config) string {
+	message, ok := config.Spec.(proto.Message)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(proto.CompactTextString(message))))
+}
+
+// getRules returns the rules of the ServiceRole, ServiceRoleBinding or AuthorizationPolicy spec
+func getRules(config model.Config) []proto.Message {
+	var rules []proto.Message
+	switch spec := config.Spec.(type) {
+	case *v1alpha1.ServiceRole:
+		for _, rule := range spec.Rules {
+			rules = append(rules, rule)
+		}
+	case *v1alpha1.ServiceRoleBinding:
+		for _, subject := range spec.Subjects {
+			rules = append(rules, subject)
+		}
+	case *v1beta1.AuthorizationPolicy:
+		for _, rule := range spec.Rules {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// countRemovals returns the number of removals of the item, a deleted resource counts with all of its rules
+func countRemovals(item *common.Item, currMap map[string]model.Config) int {
+	switch item.Operation {
+	case model.EventDelete:
+		return 1 + len(getRules(item.Resource))
+	case model.EventUpdate:
+		existing, exists := currMap[item.Resource.Key()]
+		if !exists {
+			return 0
+		}
+		desiredRules := getRules(item.Resource)
+		removed := 0
+		for _, rule := range getRules(existing) {
+			found := false
+			for _, desiredRule := range desiredRules {
+				if proto.Equal(rule, desiredRule) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				removed++
+			}
+		}
+		return removed
+	}
+	return 0
+}
+
+// countTotal returns the number of resources and rules
+func countTotal(configs []model.Config) int {
+	total := 0
+	for _, config := range configs {
+		total += 1 + len(getRules(config))
+	}
+	return total
+}
@@ -0,0 +1,293 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package guard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	"istio.io/api/security/v1beta1"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config/schema/collections"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func newTestAthenzDomain(name string, annotations map[string]string) *adv1.AthenzDomain {
+	return &adv1.AthenzDomain{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Annotations:     annotations,
+			ResourceVersion: "10",
+			Generation:      2,
+		},
+	}
+}
+
+func newTestAuthzPolicy(name string, rules int) model.Config {
+	spec := &v1beta1.AuthorizationPolicy{}
+	for i := 0; i < rules; i++ {
+		spec.Rules = append(spec.Rules, &v1beta1.Rule{
+			From: []*v1beta1.Rule_From{
+				{
+					Source: &v1beta1.Source{
+						Principals: []string{"principal-" + string(rune('a'+i))},
+					},
+				},
+			},
+		})
+	}
+	return common.NewConfig(collections.IstioSecurityV1Beta1Authorizationpolicies, "test-namespace", name, spec)
+}
+
+func newTestItem(operation model.Event, config model.Config) *common.Item {
+	return &common.Item{Operation: operation, Resource: config}
+}
+
+// fakeDelayingQueue records the keys added after a delay
+type fakeDelayingQueue struct {
+	workqueue.Interface
+	delays map[interface{}]time.Duration
+}
+
+func (q *fakeDelayingQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.delays[item] = duration
+}
+
+func newFakeDelayingQueue() *fakeDelayingQueue {
+	return &fakeDelayingQueue{Interface: workqueue.New(), delays: make(map[interface{}]time.Duration)}
+}
+
+func TestThresholdsExceeded(t *testing.T) {
+	cases := []struct {
+		test       string
+		thresholds Thresholds
+		removals   int
+		total      int
+		expected   bool
+	}{
+		{
+			test:       "disabled thresholds",
+			thresholds: Thresholds{},
+			removals:   10,
+			total:      10,
+			expected:   false,
+		},
+		{
+			test:       "no removals",
+			thresholds: Thresholds{MaxRemovals: 1, MaxRemovalPercent: 1},
+			removals:   0,
+			total:      10,
+			expected:   false,
+		},
+		{
+			test:       "removals at the limit",
+			thresholds: Thresholds{MaxRemovals: 2},
+			removals:   2,
+			total:      10,
+			expected:   false,
+		},
+		{
+			test:       "removals over the limit",
+			thresholds: Thresholds{MaxRemovals: 2},
+			removals:   3,
+			total:      10,
+			expected:   true,
+		},
+		{
+			test:       "removal percentage at the limit",
+			thresholds: Thresholds{MaxRemovalPercent: 50},
+			removals:   5,
+			total:      10,
+			expected:   false,
+		},
+		{
+			test:       "removal percentage over the limit",
+			thresholds: Thresholds{MaxRemovalPercent: 50},
+			removals:   6,
+			total:      10,
+			expected:   true,
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.thresholds.exceeded(c.removals, c.total), c.test)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	current := []model.Config{
+		newTestAuthzPolicy("first", 2),
+		newTestAuthzPolicy("second", 2),
+	}
+	deleteItem := newTestItem(model.EventDelete, current[0])
+	updateItem := newTestItem(model.EventUpdate, newTestAuthzPolicy("second", 1))
+	addItem := newTestItem(model.EventAdd, newTestAuthzPolicy("third", 1))
+	changeList := []*common.Item{deleteItem, updateItem, addItem}
+	fingerprint := Fingerprint("2", []*common.Item{deleteItem, updateItem})
+
+	cases := []struct {
+		test           string
+		config         Config
+		annotations    map[string]string
+		approvals      map[string]string
+		expectedList   []*common.Item
+		expectedEvents int
+	}{
+		{
+			test:         "disabled guard",
+			config:       Config{},
+			expectedList: changeList,
+		},
+		{
+			test:         "removals within the domain thresholds",
+			config:       Config{Domain: Thresholds{MaxRemovals: 4, MaxRemovalPercent: 80}},
+			expectedList: changeList,
+		},
+		{
+			test:           "removals exceeding the domain max removals",
+			config:         Config{Domain: Thresholds{MaxRemovals: 3}},
+			expectedList:   []*common.Item{addItem},
+			expectedEvents: 1,
+		},
+		{
+			test:           "removals exceeding the domain max removal percent",
+			config:         Config{Domain: Thresholds{MaxRemovalPercent: 50}},
+			expectedList:   []*common.Item{addItem},
+			expectedEvents: 1,
+		},
+		{
+			test:           "removals exceeding the cluster max removals",
+			config:         Config{Cluster: Thresholds{MaxRemovals: 3}, Window: time.Minute},
+			expectedList:   []*common.Item{addItem},
+			expectedEvents: 1,
+		},
+		{
+			test:         "approved removals",
+			config:       Config{Domain: Thresholds{MaxRemovals: 3}},
+			annotations:  map[string]string{ApprovalAnnotation: "other, " + fingerprint},
+			expectedList: changeList,
+		},
+		{
+			test:         "removals approved in the config map",
+			config:       Config{Domain: Thresholds{MaxRemovals: 3}},
+			approvals:    map[string]string{"test.namespace": fingerprint},
+			expectedList: changeList,
+		},
+		{
+			test:           "removals approved for another domain in the config map",
+			config:         Config{Domain: Thresholds{MaxRemovals: 3}},
+			approvals:      map[string]string{"other.domain": fingerprint},
+			expectedList:   []*common.Item{addItem},
+			expectedEvents: 1,
+		},
+		{
+			test:           "approval of another domain version",
+			config:         Config{Domain: Thresholds{MaxRemovals: 3}},
+			annotations:    map[string]string{ApprovalAnnotation: Fingerprint("1", []*common.Item{deleteItem, updateItem})},
+			expectedList:   []*common.Item{addItem},
+			expectedEvents: 1,
+		},
+		{
+			test:           "approval of another change list",
+			config:         Config{Domain: Thresholds{MaxRemovals: 3}},
+			annotations:    map[string]string{ApprovalAnnotation: "other"},
+			expectedList:   []*common.Item{addItem},
+			expectedEvents: 1,
+		},
+	}
+
+	for _, c := range cases {
+		recorder := record.NewFakeRecorder(10)
+		if c.approvals != nil {
+			c.config.Approvals = newTestApprovals(t, c.approvals)
+		}
+		g := NewGuard(c.config, recorder)
+		athenzDomain := newTestAthenzDomain("test.namespace", c.annotations)
+		actualList, held := g.Filter("test", nil, "test.namespace", athenzDomain, current, changeList, true)
+		assert.Equal(t, c.expectedList, actualList, c.test)
+		assert.Equal(t, c.expectedEvents > 0, held, c.test)
+		assert.Equal(t, c.expectedEvents, len(recorder.Events), c.test)
+	}
+}
+
+func TestFilterNilGuard(t *testing.T) {
+	var g *Guard
+	changeList := []*common.Item{newTestItem(model.EventDelete, newTestAuthzPolicy("first", 1))}
+	actualList, held := g.Filter("test", nil, "test.namespace", newTestAthenzDomain("test.namespace", nil), nil, changeList, true)
+	assert.Equal(t, changeList, actualList, "nil guard should not filter the change list")
+	assert.False(t, held, "nil guard should not hold the change list")
+}
+
+func TestFilterClusterWindow(t *testing.T) {
+	now := time.Now()
+	g := NewGuard(Config{Cluster: Thresholds{MaxRemovals: 3}, Window: time.Minute}, nil)
+	g.now = func() time.Time {
+		return now
+	}
+
+	firstDomain := newTestAthenzDomain("first.domain", nil)
+	secondDomain := newTestAthenzDomain("second.domain", nil)
+	firstCurrent := []model.Config{newTestAuthzPolicy("first", 1)}
+	secondCurrent := []model.Config{newTestAuthzPolicy("second", 1)}
+	firstChangeList := []*common.Item{newTestItem(model.EventDelete, firstCurrent[0])}
+	secondChangeList := []*common.Item{newTestItem(model.EventDelete, secondCurrent[0])}
+
+	queue := newFakeDelayingQueue()
+	actualList, held := g.Filter("test", queue, "first.domain", firstDomain, firstCurrent, firstChangeList, true)
+	assert.Equal(t, firstChangeList, actualList, "first domain removals should be applied")
+	assert.False(t, held, "first domain removals should not be held")
+
+	now = now.Add(20 * time.Second)
+	actualList, held = g.Filter("test", queue, "second.domain", secondDomain, secondCurrent, secondChangeList, true)
+	assert.Equal(t, 0, len(actualList), "second domain removals should be held within the window")
+	assert.True(t, held, "second domain removals should be held")
+	assert.Equal(t, map[interface{}]time.Duration{"second.domain": 40*time.Second + windowDelay}, queue.delays, "second domain should be synced again once the window clears")
+
+	now = now.Add(40*time.Second + windowDelay)
+	actualList, held = g.Filter("test", queue, "second.domain", secondDomain, secondCurrent, secondChangeList, true)
+	assert.Equal(t, secondChangeList, actualList, "second domain removals should be applied after the window")
+	assert.False(t, held, "second domain removals should not be held after the window")
+}
+
+func TestFilterDomainHoldNotRequeued(t *testing.T) {
+	g := NewGuard(Config{Domain: Thresholds{MaxRemovals: 1}}, nil)
+	current := []model.Config{newTestAuthzPolicy("first", 1)}
+	changeList := []*common.Item{newTestItem(model.EventDelete, current[0])}
+
+	queue := newFakeDelayingQueue()
+	actualList, held := g.Filter("test", queue, "test.namespace", newTestAthenzDomain("test.namespace", nil), current, changeList, true)
+	assert.Equal(t, 0, len(actualList), "removals should be held")
+	assert.True(t, held, "removals should be held")
+	assert.Equal(t, 0, len(queue.delays), "domain held by the domain thresholds should wait for an approval")
+}
+
+func TestFingerprint(t *testing.T) {
+	first := newTestItem(model.EventDelete, newTestAuthzPolicy("first", 1))
+	second := newTestItem(model.EventUpdate, newTestAuthzPolicy("second", 1))
+
+	changed := newTestItem(model.EventUpdate, newTestAuthzPolicy("second", 2))
+
+	assert.Equal(t, 12, len(Fingerprint("1", []*common.Item{first, second})), "fingerprint should be 12 characters")
+	assert.Equal(t, Fingerprint("1", []*common.Item{first, second}), Fingerprint("1", []*common.Item{second, first}), "fingerprint should not depend on the order")
+	assert.NotEqual(t, Fingerprint("1", []*common.Item{first}), Fingerprint("1", []*common.Item{first, second}), "fingerprint should depend on the items")
+	assert.NotEqual(t, Fingerprint("1", []*common.Item{first, second}), Fingerprint("1", []*common.Item{first, changed}), "fingerprint should depend on the content of the items")
+	assert.NotEqual(t, Fingerprint("1", []*common.Item{first, second}), Fingerprint("2", []*common.Item{first, second}), "fingerprint should depend on the domain version")
+}
+
+func TestDomainVersion(t *testing.T) {
+	athenzDomain := newTestAthenzDomain("test.namespace", nil)
+	assert.Equal(t, "2", domainVersion(athenzDomain), "generation should be used when set")
+	athenzDomain.Generation = 0
+	assert.Equal(t, "10", domainVersion(athenzDomain), "resource version should be used without a generation")
+}
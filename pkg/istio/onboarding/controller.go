@@ -11,7 +11,6 @@ import (
 
 	"k8s.io/api/core/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
@@ -21,6 +20,7 @@ import (
 
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/processor"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
 )
 
 const (
@@ -28,9 +28,12 @@ const (
 	authzEnabled           = "true"
 	authzEnabledAnnotation = "authz.istio.io/enabled"
 	queueKey               = v1.NamespaceDefault + "/" + constants.DefaultRbacConfigName
+	// controllerName identifies the onboarding controller in the queue and the metrics
+	controllerName = "onboarding"
 )
 
 type Controller struct {
+	*common.Worker
 	configStoreCache     model.ConfigStoreCache
 	dnsSuffix            string
 	serviceIndexInformer cache.SharedIndexInformer
@@ -41,9 +44,10 @@ type Controller struct {
 
 // NewController initializes the Controller object and its dependencies
 func NewController(configStoreCache model.ConfigStoreCache, dnsSuffix string, serviceIndexInformer cache.SharedIndexInformer, crcResyncInterval time.Duration, processor *processor.Controller) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName)
 
 	c := &Controller{
+		Worker:               common.NewWorker(queue, nil),
 		configStoreCache:     configStoreCache,
 		dnsSuffix:            dnsSuffix,
 		serviceIndexInformer: serviceIndexInformer,
@@ -71,37 +75,24 @@ func NewController(configStoreCache model.ConfigStoreCache, dnsSuffix string, se
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	go c.resync(stopCh)
 
-	defer c.queue.ShutDown()
-	wait.Until(c.runWorker, 0, stopCh)
+	c.Worker.Run(stopCh, c.processItem)
 }
 
-// runWorker calls processNextItem to process events of the work queue
-func (c *Controller) runWorker() {
-	for c.processNextItem() {
-	}
-}
-
-// processNextItem takes an item off the queue and calls the controllers sync
-// function, handles the logic of requeuing in case any errors occur
-func (c *Controller) processNextItem() bool {
-	key, quit := c.queue.Get()
-	if quit {
-		return false
-	}
-
-	defer c.queue.Done(key)
-
+// processItem calls the controllers sync function for a key taken off the queue,
+// handles the logic of requeuing in case any errors occur
+func (c *Controller) processItem(key interface{}) {
+	start := time.Now()
 	err := c.sync()
+	metrics.ObserveSync(controllerName, start)
 	if err != nil {
 		log.Errorf("Error syncing cluster rbac config for key %s: %s", key, err.Error())
 		if c.queue.NumRequeues(key) < queueNumRetries {
 			log.Infof("Retrying key %s due to sync error", key)
 			c.queue.AddRateLimited(key)
-			return true
+			return
 		}
+		metrics.QueueDropsTotal.WithLabelValues(controllerName).Inc()
 	}
-
-	return true
 }
 
 // addService will add a service to the ClusterRbacConfig object
@@ -201,6 +192,7 @@ func (c *Controller) callbackHandler(err error, item *common.Item) error {
 	}
 	if c.queue.NumRequeues(queueKey) >= queueNumRetries {
 		log.Errorf("Max number of retries reached for %s.", queueKey)
+		metrics.QueueDropsTotal.WithLabelValues(controllerName).Inc()
 		return nil
 	}
 	if item != nil {
@@ -123,7 +123,7 @@ func newFakeController(services []*v1.Service, fake bool, stopCh <-chan struct{}
 		}
 	}
 	c.configStoreCache = memory.NewController(configStore)
-	c.processor = processor.NewController(c.configStoreCache)
+	c.processor = processor.NewController(c.configStoreCache, nil)
 	go c.processor.Run(stopCh)
 
 	source := fcache.NewFakeControllerSource()
@@ -149,7 +149,7 @@ func TestNewController(t *testing.T) {
 	fakeIndexInformer := cache.NewSharedIndexInformer(source, &v1.Service{}, 0, nil)
 	configStore := memory.Make(configDescriptor)
 	configStoreCache := memory.NewController(configStore)
-	processor := processor.NewController(configStoreCache)
+	processor := processor.NewController(configStoreCache, nil)
 	stopCh := make(chan struct{})
 	go processor.Run(stopCh)
 
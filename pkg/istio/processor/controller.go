@@ -4,28 +4,42 @@
 package processor
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
 	"istio.io/istio/pilot/pkg/model"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
 )
 
-const queueNumRetries = 3
+const (
+	queueNumRetries = 3
+	// controllerName identifies the processor in the queue and the metrics
+	controllerName = "processor"
+)
 
 type Controller struct {
+	*common.Worker
 	configStoreCache model.ConfigStoreCache
 	queue            workqueue.RateLimitingInterface
+	recorder         record.EventRecorder
 }
 
-// NewController is responsible for creating the processing controller workqueue
-func NewController(configStoreCache model.ConfigStoreCache) *Controller {
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+// NewController is responsible for creating the processing controller workqueue, the
+// operations dropped after the max number of retries are recorded as events on the
+// athenz domain of the resource when a recorder is given
+func NewController(configStoreCache model.ConfigStoreCache, recorder record.EventRecorder) *Controller {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName)
 
 	c := &Controller{
+		Worker:           common.NewWorker(queue, describeItem),
 		configStoreCache: configStoreCache,
 		queue:            queue,
+		recorder:         recorder,
 	}
 
 	return c
@@ -37,58 +51,60 @@ func (c *Controller) ProcessConfigChange(item *common.Item) {
 	c.queue.Add(item)
 }
 
-// Run starts the main controller loop running sync at every poll interval.
+// Run starts the main controller loop running sync at every poll interval. The
+// worker returns once the stop channel is closed, or once the queue is shut down
+// with ShutDown and all of the items left in it are processed.
 func (c *Controller) Run(stopCh <-chan struct{}) {
-	defer c.queue.ShutDown()
-	wait.Until(c.runWorker, 0, stopCh)
+	c.Worker.Run(stopCh, c.processItem)
 }
 
-// runWorker calls processNextItem to process events of the work queue
-func (c *Controller) runWorker() {
-	for c.processNextItem() {
+// describeItem returns the operation and the key of the resource of a queue item
+func describeItem(itemRaw interface{}) string {
+	item, ok := itemRaw.(*common.Item)
+	if !ok || item == nil {
+		return fmt.Sprint(itemRaw)
 	}
+	return fmt.Sprintf("%s on %s", item.Operation, item.Resource.Key())
 }
 
-// processNextItem takes an item off the queue and calls the controllers sync
-// function, handles the logic of re-queuing in case any errors occur
-func (c *Controller) processNextItem() bool {
-	itemRaw, quit := c.queue.Get()
-	if quit {
-		return false
-	}
-
-	defer c.queue.Done(itemRaw)
-
+// processItem calls the controllers sync function for an item taken off the queue,
+// handles the logic of re-queuing in case any errors occur
+func (c *Controller) processItem(itemRaw interface{}) {
 	item, ok := itemRaw.(*common.Item)
 	if !ok {
 		log.Errorf("Item cast failed for resource %v", item)
-		return true
+		return
 	}
 
 	log.Infof("Processing %s for resource: %s", item.Operation, item.Resource.Key())
+	start := time.Now()
 	err := c.sync(item)
+	metrics.ObserveSync(controllerName, start)
 	if err != nil {
 		log.Errorf("Error performing %s for resource: %s: %s", item.Operation, item.Resource.Key(), err)
 	}
 	if item.CallbackHandler == nil {
 		c.queue.Forget(itemRaw)
-		return true
+		return
 	}
 
 	// All errors/successes should be handled by the CallbackHandler()
 	err = item.CallbackHandler(err, item)
 	if err == nil {
 		c.queue.Forget(itemRaw)
-		return true
+		return
 	}
 	// If callback returns an error, retry if within limit
 	if c.queue.NumRequeues(itemRaw) < queueNumRetries {
 		log.Infof("Retrying %s for resource: %s due to sync error", item.Operation, item.Resource.Key())
 		c.queue.AddRateLimited(itemRaw)
-		return true
+		return
 	}
 	log.Errorf("Max number of retries reached for operation %s on %s", item.Operation, item.Resource.Key())
-	return true
+	metrics.QueueDropsTotal.WithLabelValues(controllerName).Inc()
+	if domain := item.Resource.Annotations[common.SourceDomainAnnotation]; domain != "" {
+		common.NewEventReporter(c.recorder, common.DomainReference(domain)).RetriesExhausted(item.Operation.String(), item.Resource.Key(), err)
+	}
 }
 
 // sync is responsible for invoking the appropriate API operation on the model.Config resource
@@ -107,6 +123,7 @@ func (c *Controller) sync(item *common.Item) error {
 		res := item.Resource
 		err = c.configStoreCache.Delete(res.GroupVersionKind(), res.Name, res.Namespace)
 	}
+	metrics.ObserveResourceOperation(item.Resource.Type, item.Operation.String(), err)
 
 	return err
 }
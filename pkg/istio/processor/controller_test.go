@@ -199,7 +199,7 @@ func TestSync(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			configStoreCache := tt.startingCache
-			c := NewController(configStoreCache)
+			c := NewController(configStoreCache, nil)
 
 			err := c.sync(tt.input)
 			assert.Equal(t, tt.expectedErr, err, "sync err should match expected error")
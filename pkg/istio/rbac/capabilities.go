@@ -0,0 +1,119 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package rbac
+
+import (
+	"fmt"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"k8s.io/client-go/discovery"
+)
+
+const (
+	rbacV1Alpha1GroupVersion    = "rbac.istio.io/v1alpha1"
+	securityV1Beta1GroupVersion = "security.istio.io/v1beta1"
+	securityV1GroupVersion      = "security.istio.io/v1"
+)
+
+// Capabilities holds the Istio security APIs served by the cluster
+type Capabilities struct {
+	// RbacV1Alpha1 is true if the ServiceRole, ServiceRoleBinding and ClusterRbacConfig resources are served
+	RbacV1Alpha1 bool
+	// SecurityV1Beta1 is true if the v1beta1 AuthorizationPolicy resource is served
+	SecurityV1Beta1 bool
+	// SecurityV1 is true if the v1 AuthorizationPolicy resource is served
+	SecurityV1 bool
+}
+
+// DetectCapabilities uses the API discovery to find the Istio security APIs served by the cluster
+func DetectCapabilities(client discovery.DiscoveryInterface) (*Capabilities, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("error discovering server groups: %s", err.Error())
+	}
+
+	served := make(map[string]bool)
+	for _, group := range groups.Groups {
+		for _, version := range group.Versions {
+			served[version.GroupVersion] = true
+		}
+	}
+
+	c := &Capabilities{}
+	c.RbacV1Alpha1, err = servesResources(client, served, rbacV1Alpha1GroupVersion, "serviceroles", "servicerolebindings", "clusterrbacconfigs")
+	if err != nil {
+		return nil, err
+	}
+	c.SecurityV1Beta1, err = servesResources(client, served, securityV1Beta1GroupVersion, "authorizationpolicies")
+	if err != nil {
+		return nil, err
+	}
+	c.SecurityV1, err = servesResources(client, served, securityV1GroupVersion, "authorizationpolicies")
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// servesResources returns true if all the resources of the group version are served
+func servesResources(client discovery.DiscoveryInterface, served map[string]bool, groupVersion string, resources ...string) (bool, error) {
+	if !served[groupVersion] {
+		return false, nil
+	}
+
+	resourceList, err := client.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return false, fmt.Errorf("error discovering resources for %s: %s", groupVersion, err.Error())
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range resourceList.APIResources {
+		names[resource.Name] = true
+	}
+	for _, resource := range resources {
+		if !names[resource] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// String returns the capability summary in the format rbac.istio.io/v1alpha1=true, ...
+func (c *Capabilities) String() string {
+	return fmt.Sprintf("%s=%t, %s=%t, %s=%t", rbacV1Alpha1GroupVersion, c.RbacV1Alpha1,
+		securityV1Beta1GroupVersion, c.SecurityV1Beta1, securityV1GroupVersion, c.SecurityV1)
+}
+
+// ResolveProviderMode returns the rbac provider mode matching both the requested mode and the served APIs. If no
+// mode is requested, the providers of all the served APIs are enabled. The v2 provider requires the v1beta1
+// AuthorizationPolicy resource, the v1 version alone is not sufficient.
+func (c *Capabilities) ResolveProviderMode(requested ProviderMode) (ProviderMode, error) {
+	v1 := c.RbacV1Alpha1
+	v2 := c.SecurityV1Beta1
+	if c.SecurityV1 && !c.SecurityV1Beta1 {
+		log.Warningf("%s is served without %s, the v2 rbac provider requires %s", securityV1GroupVersion, securityV1Beta1GroupVersion, securityV1Beta1GroupVersion)
+	}
+
+	if requested != "" {
+		if requested.V1Enabled() && !v1 {
+			log.Warningf("%s is not served, disabling the v1 rbac provider", rbacV1Alpha1GroupVersion)
+		}
+		if requested.V2Enabled() && !v2 {
+			log.Warningf("%s is not served, disabling the v2 rbac provider", securityV1Beta1GroupVersion)
+		}
+		v1 = v1 && requested.V1Enabled()
+		v2 = v2 && requested.V2Enabled()
+	}
+
+	switch {
+	case v1 && v2:
+		return ProviderBoth, nil
+	case v1:
+		return ProviderV1, nil
+	case v2:
+		return ProviderV2, nil
+	default:
+		return "", fmt.Errorf("none of the istio security apis required by the rbac provider are served: %s", c)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package rbac
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func newFakeResourceList(groupVersion string, resources ...string) *metav1.APIResourceList {
+	list := &metav1.APIResourceList{GroupVersion: groupVersion}
+	for _, resource := range resources {
+		list.APIResources = append(list.APIResources, metav1.APIResource{Name: resource})
+	}
+	return list
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	cases := []struct {
+		test     string
+		input    []*metav1.APIResourceList
+		expected *Capabilities
+	}{
+		{
+			test:     "no istio security apis",
+			input:    []*metav1.APIResourceList{newFakeResourceList("v1", "services")},
+			expected: &Capabilities{},
+		},
+		{
+			test: "all istio security apis",
+			input: []*metav1.APIResourceList{
+				newFakeResourceList(rbacV1Alpha1GroupVersion, "serviceroles", "servicerolebindings", "clusterrbacconfigs"),
+				newFakeResourceList(securityV1Beta1GroupVersion, "authorizationpolicies", "peerauthentications"),
+				newFakeResourceList(securityV1GroupVersion, "authorizationpolicies"),
+			},
+			expected: &Capabilities{RbacV1Alpha1: true, SecurityV1Beta1: true, SecurityV1: true},
+		},
+		{
+			test: "incomplete rbac api",
+			input: []*metav1.APIResourceList{
+				newFakeResourceList(rbacV1Alpha1GroupVersion, "serviceroles", "servicerolebindings"),
+				newFakeResourceList(securityV1Beta1GroupVersion, "authorizationpolicies"),
+			},
+			expected: &Capabilities{SecurityV1Beta1: true},
+		},
+	}
+
+	for _, c := range cases {
+		client := &fake.FakeDiscovery{Fake: &k8stesting.Fake{Resources: c.input}}
+		capabilities, err := DetectCapabilities(client)
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, c.expected, capabilities, c.test)
+	}
+}
+
+func TestResolveProviderMode(t *testing.T) {
+	cases := []struct {
+		test         string
+		capabilities *Capabilities
+		requested    ProviderMode
+		expected     ProviderMode
+		expectedErr  error
+	}{
+		{
+			test:         "detect both providers",
+			capabilities: &Capabilities{RbacV1Alpha1: true, SecurityV1Beta1: true},
+			expected:     ProviderBoth,
+		},
+		{
+			test:         "detect v2 provider",
+			capabilities: &Capabilities{SecurityV1Beta1: true, SecurityV1: true},
+			expected:     ProviderV2,
+		},
+		{
+			test:         "requested v1 provider",
+			capabilities: &Capabilities{RbacV1Alpha1: true, SecurityV1Beta1: true},
+			requested:    ProviderV1,
+			expected:     ProviderV1,
+		},
+		{
+			test:         "requested both providers with v1 api missing",
+			capabilities: &Capabilities{SecurityV1Beta1: true},
+			requested:    ProviderBoth,
+			expected:     ProviderV2,
+		},
+		{
+			test:         "requested v1 provider with v1 api missing",
+			capabilities: &Capabilities{SecurityV1Beta1: true},
+			requested:    ProviderV1,
+			expectedErr:  fmt.Errorf("none of the istio security apis required by the rbac provider are served: rbac.istio.io/v1alpha1=false, security.istio.io/v1beta1=true, security.istio.io/v1=false"),
+		},
+		{
+			test:         "security v1 api alone",
+			capabilities: &Capabilities{SecurityV1: true},
+			expectedErr:  fmt.Errorf("none of the istio security apis required by the rbac provider are served: rbac.istio.io/v1alpha1=false, security.istio.io/v1beta1=false, security.istio.io/v1=true"),
+		},
+	}
+
+	for _, c := range cases {
+		mode, err := c.capabilities.ResolveProviderMode(c.requested)
+		assert.Equal(t, c.expectedErr, err, c.test)
+		assert.Equal(t, c.expected, mode, c.test)
+	}
+}
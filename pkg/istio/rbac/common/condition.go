@@ -0,0 +1,68 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"istio.io/api/security/v1beta1"
+)
+
+// Condition keys of the athenz role conditions supported in the authorization policy rules
+const (
+	ConditionSourceIPKey        = "source.ip"
+	ConditionDestinationPortKey = "destination.port"
+	// ConditionRequestHeadersKey and ConditionRequestClaimsKey are followed by the name of the header or the claim
+	// surrounded by brackets
+	ConditionRequestHeadersKey = "request.headers"
+	ConditionRequestClaimsKey  = "request.auth.claims"
+)
+
+// ConvertCondition converts the condition of an athenz role into a condition of an authorization policy rule. An error
+// is returned for the keys which are not supported and the values which istio would reject, since dropping the
+// condition would broaden the access of the role.
+func ConvertCondition(condition athenz.Condition) (*v1beta1.Condition, error) {
+	if len(condition.Values) == 0 {
+		return nil, fmt.Errorf("condition: %s does not have any value", condition.Key)
+	}
+
+	switch {
+	case condition.Key == ConditionSourceIPKey:
+		for _, value := range condition.Values {
+			if net.ParseIP(value) == nil {
+				if _, _, err := net.ParseCIDR(value); err != nil {
+					return nil, fmt.Errorf("condition: %s value: %s is not an ip address or a cidr range", condition.Key, value)
+				}
+			}
+		}
+	case condition.Key == ConditionDestinationPortKey:
+		for _, value := range condition.Values {
+			if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("condition: %s value: %s is not a port number", condition.Key, value)
+			}
+		}
+	case GetConditionName(condition.Key, ConditionRequestHeadersKey) != "", GetConditionName(condition.Key, ConditionRequestClaimsKey) != "":
+	default:
+		return nil, fmt.Errorf("condition: %s is not one of %s, %s, %s[<name>] or %s[<name>]", condition.Key,
+			ConditionSourceIPKey, ConditionDestinationPortKey, ConditionRequestHeadersKey, ConditionRequestClaimsKey)
+	}
+
+	return &v1beta1.Condition{
+		Key:    condition.Key,
+		Values: condition.Values,
+	}, nil
+}
+
+// GetConditionName returns the name surrounded by brackets of a condition key on a named attribute, e.g. x-env for
+// request.headers[x-env], an empty string is returned if the key is not on the attribute
+func GetConditionName(key string, attribute string) string {
+	if !strings.HasPrefix(key, attribute+"[") || !strings.HasSuffix(key, "]") {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(key, attribute+"["), "]")
+}
@@ -0,0 +1,84 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"istio.io/api/security/v1beta1"
+)
+
+func TestConvertCondition(t *testing.T) {
+	cases := []struct {
+		test              string
+		condition         athenz.Condition
+		expectedCondition *v1beta1.Condition
+		expectedErr       string
+	}{
+		{
+			test:              "source ip",
+			condition:         athenz.Condition{Key: "source.ip", Values: []string{"10.0.0.1", "192.168.0.0/16"}},
+			expectedCondition: &v1beta1.Condition{Key: "source.ip", Values: []string{"10.0.0.1", "192.168.0.0/16"}},
+		},
+		{
+			test:              "destination port",
+			condition:         athenz.Condition{Key: "destination.port", Values: []string{"8080"}},
+			expectedCondition: &v1beta1.Condition{Key: "destination.port", Values: []string{"8080"}},
+		},
+		{
+			test:              "request header",
+			condition:         athenz.Condition{Key: "request.headers[x-env]", Values: []string{"prod"}},
+			expectedCondition: &v1beta1.Condition{Key: "request.headers[x-env]", Values: []string{"prod"}},
+		},
+		{
+			test:              "request claim",
+			condition:         athenz.Condition{Key: "request.auth.claims[iss]", Values: []string{"athenz"}},
+			expectedCondition: &v1beta1.Condition{Key: "request.auth.claims[iss]", Values: []string{"athenz"}},
+		},
+		{
+			test:        "without values",
+			condition:   athenz.Condition{Key: "source.ip"},
+			expectedErr: "condition: source.ip does not have any value",
+		},
+		{
+			test:        "invalid source ip",
+			condition:   athenz.Condition{Key: "source.ip", Values: []string{"10.0.0"}},
+			expectedErr: "condition: source.ip value: 10.0.0 is not an ip address or a cidr range",
+		},
+		{
+			test:        "invalid destination port",
+			condition:   athenz.Condition{Key: "destination.port", Values: []string{"70000"}},
+			expectedErr: "condition: destination.port value: 70000 is not a port number",
+		},
+		{
+			test:        "request header without name",
+			condition:   athenz.Condition{Key: "request.headers", Values: []string{"prod"}},
+			expectedErr: "condition: request.headers is not one of source.ip, destination.port, request.headers[<name>] or request.auth.claims[<name>]",
+		},
+		{
+			test:        "unsupported key",
+			condition:   athenz.Condition{Key: "connection.sni", Values: []string{"api"}},
+			expectedErr: "condition: connection.sni is not one of source.ip, destination.port, request.headers[<name>] or request.auth.claims[<name>]",
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := ConvertCondition(c.condition)
+		if c.expectedErr != "" {
+			assert.EqualError(t, err, c.expectedErr, c.test)
+			assert.Nil(t, actual, c.test)
+			continue
+		}
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, c.expectedCondition, actual, c.test)
+	}
+}
+
+func TestGetConditionName(t *testing.T) {
+	assert.Equal(t, "x-env", GetConditionName("request.headers[x-env]", ConditionRequestHeadersKey))
+	assert.Equal(t, "", GetConditionName("request.headers[x-env]", ConditionRequestClaimsKey), "key should not be a claim")
+	assert.Equal(t, "", GetConditionName("request.headers", ConditionRequestHeadersKey), "key should not have a name")
+}
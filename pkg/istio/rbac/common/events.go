@@ -0,0 +1,199 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"fmt"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/syncstatus"
+	adv1 "github.com/yahoo/k8s-athenz-syncer/pkg/apis/athenz/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the warning events recorded on the services and the athenz domains
+const (
+	ReasonSkippedAssertion = "SkippedAssertion"
+	ReasonDroppedMember    = "DroppedMember"
+	ReasonValidationFailed = "ValidationFailed"
+	ReasonRetriesExhausted = "RetriesExhausted"
+	ReasonSkippedService   = "SkippedService"
+	ReasonBroadenedDeny    = "BroadenedDeny"
+	ReasonForeignResource  = "ForeignResource"
+)
+
+// DomainReference returns the reference of the athenz domain object the events of a domain are recorded on
+func DomainReference(domainName string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: adv1.SchemeGroupVersion.String(),
+		Kind:       "AthenzDomain",
+		Name:       domainName,
+	}
+}
+
+// ServiceReference returns the reference of the service object the events of a service are recorded on
+func ServiceReference(namespace, name string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: corev1.SchemeGroupVersion.String(),
+		Kind:       "Service",
+		Namespace:  namespace,
+		Name:       name,
+	}
+}
+
+// EventReporter records the athenz data skipped or rejected while converting an athenz domain as warning events on
+// the affected objects, and collects the skipped assertions and members for the sync status. The events of the
+// skipped and broadened assertions are only recorded by RecordAssertions, since the assertions are skipped again on
+// every sync until they are fixed in athenz. A nil reporter does not record or collect anything.
+type EventReporter struct {
+	recorder            record.EventRecorder
+	objects             []runtime.Object
+	skippedAssertions   []syncstatus.SkippedAssertion
+	broadenedAssertions []syncstatus.SkippedAssertion
+	skippedMembers      []syncstatus.SkippedMember
+}
+
+// NewEventReporter returns a reporter recording the events on the given objects, the events are not recorded
+// without a recorder
+func NewEventReporter(recorder record.EventRecorder, objects ...runtime.Object) *EventReporter {
+	return &EventReporter{
+		recorder: recorder,
+		objects:  objects,
+	}
+}
+
+// SkippedAssertion collects an assertion which could not be converted
+func (r *EventReporter) SkippedAssertion(assertion *zms.Assertion, reason string, err error) {
+	if r == nil {
+		return
+	}
+	r.skippedAssertions = append(r.skippedAssertions, newSkippedAssertion(assertion, reason, err))
+}
+
+// BroadenedAssertion collects a DENY assertion which could not be converted exactly and denies more requests than in
+// athenz, it is not collected as skipped since its rule is generated
+func (r *EventReporter) BroadenedAssertion(assertion *zms.Assertion, err error) {
+	if r == nil {
+		return
+	}
+	r.broadenedAssertions = append(r.broadenedAssertions, newSkippedAssertion(assertion, ReasonBroadenedDeny, err))
+}
+
+// RecordAssertions records the events of the collected assertions which were not skipped or broadened by the last
+// sync, so that an assertion is only reported once until it is fixed
+func (r *EventReporter) RecordAssertions(last syncstatus.SyncStatus) {
+	if r == nil {
+		return
+	}
+	for _, assertion := range newAssertions(r.skippedAssertions, last.SkippedAssertions) {
+		if assertion.Role == "" {
+			r.event(ReasonSkippedAssertion, "Skipped assertion: %s", assertion.Message)
+			continue
+		}
+		r.event(ReasonSkippedAssertion, "Skipped assertion %s on %s for role %s: %s", assertion.Action, assertion.Resource, assertion.Role, assertion.Message)
+	}
+	for _, assertion := range newAssertions(r.broadenedAssertions, last.BroadenedAssertions) {
+		r.event(ReasonBroadenedDeny, "Broadened deny assertion %s on %s for role %s: %s", assertion.Action, assertion.Resource, assertion.Role, assertion.Message)
+	}
+}
+
+func newSkippedAssertion(assertion *zms.Assertion, reason string, err error) syncstatus.SkippedAssertion {
+	skipped := syncstatus.SkippedAssertion{
+		Reason:  reason,
+		Message: fmt.Sprint(err),
+	}
+	if assertion != nil {
+		skipped.Role = assertion.Role
+		skipped.Resource = assertion.Resource
+		skipped.Action = assertion.Action
+	}
+	return skipped
+}
+
+// newAssertions returns the assertions which are not in the last assertions
+func newAssertions(assertions []syncstatus.SkippedAssertion, last []syncstatus.SkippedAssertion) []syncstatus.SkippedAssertion {
+	lastAssertions := make(map[syncstatus.SkippedAssertion]bool, len(last))
+	for _, assertion := range last {
+		lastAssertions[assertion] = true
+	}
+	var added []syncstatus.SkippedAssertion
+	for _, assertion := range assertions {
+		if !lastAssertions[assertion] {
+			added = append(added, assertion)
+		}
+	}
+	return added
+}
+
+// DroppedMember records a role member which could not be converted
+func (r *EventReporter) DroppedMember(role string, memberName string, err error) {
+	r.event(ReasonDroppedMember, "Dropped member %s of role %s: %s", memberName, role, err)
+	r.skippedMember(role, memberName, err)
+}
+
+// InactiveMember collects a role member which is expired or system disabled for the sync status, no event is
+// recorded as the member is skipped on every sync until it is removed from the role
+func (r *EventReporter) InactiveMember(role string, memberName string, err error) {
+	r.skippedMember(role, memberName, err)
+}
+
+func (r *EventReporter) skippedMember(role string, memberName string, err error) {
+	if r == nil {
+		return
+	}
+	r.skippedMembers = append(r.skippedMembers, syncstatus.SkippedMember{
+		Role:   role,
+		Member: memberName,
+		Reason: fmt.Sprint(err),
+	})
+}
+
+// SkippedService records a service for which no authorization policy could be generated
+func (r *EventReporter) SkippedService(err error) {
+	r.event(ReasonSkippedService, "Skipped service: %s", err)
+}
+
+// Skipped returns the assertions and the members collected by the reporter
+func (r *EventReporter) Skipped() ([]syncstatus.SkippedAssertion, []syncstatus.SkippedMember) {
+	if r == nil {
+		return nil, nil
+	}
+	return r.skippedAssertions, r.skippedMembers
+}
+
+// Broadened returns the broadened assertions collected by the reporter
+func (r *EventReporter) Broadened() []syncstatus.SkippedAssertion {
+	if r == nil {
+		return nil
+	}
+	return r.broadenedAssertions
+}
+
+// ForeignResource records a generated resource which is not applied since an existing resource with the same name is
+// not managed by the controller
+func (r *EventReporter) ForeignResource(kind string, namespace string, name string) {
+	r.event(ReasonForeignResource, "Skipped generated %s %s/%s, the existing resource is not managed by %s", kind, namespace, name, ManagedByValue)
+}
+
+// ValidationFailed records a generated resource which did not pass the istio validation
+func (r *EventReporter) ValidationFailed(kind string, name string, err error) {
+	r.event(ReasonValidationFailed, "Generated %s %s is not valid: %s", kind, name, err)
+}
+
+// RetriesExhausted records an operation dropped after reaching the max number of retries
+func (r *EventReporter) RetriesExhausted(operation string, key string, err error) {
+	r.event(ReasonRetriesExhausted, "Max number of retries reached for %s on %s: %s", operation, key, err)
+}
+
+func (r *EventReporter) event(reason string, messageFmt string, args ...interface{}) {
+	if r == nil || r.recorder == nil {
+		return
+	}
+	message := fmt.Sprintf(messageFmt, args...)
+	for _, object := range r.objects {
+		r.recorder.Event(object, corev1.EventTypeWarning, reason, message)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/syncstatus"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventReporter(t *testing.T) {
+	allow := zms.ALLOW
+	assertion := &zms.Assertion{
+		Role:     "test.domain:role.reader",
+		Resource: "test.domain:svc.productpage",
+		Action:   "launch",
+		Effect:   &allow,
+	}
+
+	cases := []struct {
+		test          string
+		report        func(r *EventReporter)
+		expectedEvent string
+	}{
+		{
+			test: "skipped assertion",
+			report: func(r *EventReporter) {
+				r.SkippedAssertion(assertion, metrics.SkipReasonInvalidAction, errors.New("method: launch is not a valid HTTP verb"))
+				r.RecordAssertions(syncstatus.SyncStatus{})
+			},
+			expectedEvent: "Warning SkippedAssertion Skipped assertion launch on test.domain:svc.productpage for role test.domain:role.reader: method: launch is not a valid HTTP verb",
+		},
+		{
+			test: "broadened assertion",
+			report: func(r *EventReporter) {
+				r.BroadenedAssertion(assertion, errors.New("wildcard in the middle of path"))
+				r.RecordAssertions(syncstatus.SyncStatus{})
+			},
+			expectedEvent: "Warning BroadenedDeny Broadened deny assertion launch on test.domain:svc.productpage for role test.domain:role.reader: wildcard in the middle of path",
+		},
+		{
+			test: "dropped member",
+			report: func(r *EventReporter) {
+				r.DroppedMember("test.domain:role.reader", "invalid", errors.New("principal:invalid is not of the format <Athenz-domain>.<Athenz-service>"))
+			},
+			expectedEvent: "Warning DroppedMember Dropped member invalid of role test.domain:role.reader: principal:invalid is not of the format <Athenz-domain>.<Athenz-service>",
+		},
+		{
+			test: "validation failure",
+			report: func(r *EventReporter) {
+				r.ValidationFailed("ServiceRole", "reader", errors.New("at least 1 rule must be specified"))
+			},
+			expectedEvent: "Warning ValidationFailed Generated ServiceRole reader is not valid: at least 1 rule must be specified",
+		},
+		{
+			test: "exhausted retries",
+			report: func(r *EventReporter) {
+				r.RetriesExhausted("sync", "test.domain", errors.New("conflict"))
+			},
+			expectedEvent: "Warning RetriesExhausted Max number of retries reached for sync on test.domain: conflict",
+		},
+		{
+			test: "skipped service",
+			report: func(r *EventReporter) {
+				r.SkippedService(errors.New("selector of service test-namespace/productpage is empty"))
+			},
+			expectedEvent: "Warning SkippedService Skipped service: selector of service test-namespace/productpage is empty",
+		},
+	}
+
+	for _, c := range cases {
+		recorder := record.NewFakeRecorder(10)
+		c.report(NewEventReporter(recorder, ServiceReference("test-namespace", "productpage"), DomainReference("test.domain")))
+		assert.Equal(t, 2, len(recorder.Events), c.test)
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, c.expectedEvent, <-recorder.Events, c.test)
+		}
+	}
+}
+
+func TestEventReporterInactiveMember(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	reporter := NewEventReporter(recorder, DomainReference("test.domain"))
+	reporter.InactiveMember("test.domain:role.reader", "user.expired", errors.New("member user.expired is expired"))
+	assert.Equal(t, 0, len(recorder.Events), "no event should be recorded for an inactive member")
+}
+
+func TestEventReporterRecordAssertions(t *testing.T) {
+	allow := zms.ALLOW
+	reader := &zms.Assertion{
+		Role:     "test.domain:role.reader",
+		Resource: "test.domain:svc.productpage",
+		Action:   "launch",
+		Effect:   &allow,
+	}
+	writer := &zms.Assertion{
+		Role:     "test.domain:role.writer",
+		Resource: "test.domain:svc.productpage",
+		Action:   "launch",
+		Effect:   &allow,
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	reporter := NewEventReporter(recorder, DomainReference("test.domain"))
+	reporter.SkippedAssertion(reader, metrics.SkipReasonInvalidAction, errors.New("method: launch is not a valid HTTP verb"))
+	reporter.SkippedAssertion(writer, metrics.SkipReasonInvalidAction, errors.New("method: launch is not a valid HTTP verb"))
+	reporter.BroadenedAssertion(reader, errors.New("wildcard in the middle of path"))
+	assert.Equal(t, 0, len(recorder.Events), "no event should be recorded while collecting the assertions")
+
+	last := syncstatus.SyncStatus{}
+	last.SkippedAssertions = reporter.skippedAssertions[:1]
+	last.BroadenedAssertions = reporter.Broadened()
+	reporter.RecordAssertions(last)
+	assert.Equal(t, 1, len(recorder.Events), "only the assertions which were not skipped by the last sync should be recorded")
+	assert.Equal(t, "Warning SkippedAssertion Skipped assertion launch on test.domain:svc.productpage for role test.domain:role.writer: method: launch is not a valid HTTP verb", <-recorder.Events)
+
+	skippedAssertions, _ := reporter.Skipped()
+	last.SkippedAssertions = skippedAssertions
+	reporter.RecordAssertions(last)
+	assert.Equal(t, 0, len(recorder.Events), "no event should be recorded if the assertions are unchanged")
+}
+
+func TestEventReporterSkipped(t *testing.T) {
+	allow := zms.ALLOW
+	assertion := &zms.Assertion{
+		Role:     "test.domain:role.reader",
+		Resource: "test.domain:svc.productpage",
+		Action:   "launch",
+		Effect:   &allow,
+	}
+
+	reporter := NewEventReporter(nil, DomainReference("test.domain"))
+	reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidAction, errors.New("method: launch is not a valid HTTP verb"))
+	reporter.DroppedMember("test.domain:role.reader", "invalid", errors.New("invalid member"))
+	reporter.InactiveMember("test.domain:role.reader", "user.expired", errors.New("member user.expired is expired"))
+	reporter.ValidationFailed("ServiceRole", "reader", errors.New("invalid"))
+
+	skippedAssertions, skippedMembers := reporter.Skipped()
+	assert.Equal(t, []syncstatus.SkippedAssertion{
+		{
+			Role:     "test.domain:role.reader",
+			Resource: "test.domain:svc.productpage",
+			Action:   "launch",
+			Reason:   metrics.SkipReasonInvalidAction,
+			Message:  "method: launch is not a valid HTTP verb",
+		},
+	}, skippedAssertions, "skipped assertions should be collected without a recorder")
+	assert.Equal(t, []syncstatus.SkippedMember{
+		{
+			Role:   "test.domain:role.reader",
+			Member: "invalid",
+			Reason: "invalid member",
+		},
+		{
+			Role:   "test.domain:role.reader",
+			Member: "user.expired",
+			Reason: "member user.expired is expired",
+		},
+	}, skippedMembers, "skipped members should be collected without a recorder")
+
+	var nilReporter *EventReporter
+	nilReporter.DroppedMember("test.domain:role.reader", "invalid", errors.New("invalid member"))
+	skippedAssertions, skippedMembers = nilReporter.Skipped()
+	assert.Nil(t, skippedAssertions, "nil reporter should not collect assertions")
+	assert.Nil(t, skippedMembers, "nil reporter should not collect members")
+}
@@ -0,0 +1,276 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/api/security/v1beta1"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+const (
+	ManagedByLabel             = "app.kubernetes.io/managed-by"
+	ManagedByValue             = "k8s-athenz-istio-auth"
+	SourceDomainAnnotation     = "athenz.io/source-domain"
+	SourceRoleAnnotation       = "athenz.io/source-role"
+	GeneratorVersionAnnotation = "athenz.io/generator-version"
+)
+
+// GeneratorVersion is the version of the controller recorded on the generated resources, it is set at build time with
+// -ldflags "-X github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common.GeneratorVersion=<version>"
+var GeneratorVersion = "dev"
+
+// SetManagedMetadata stamps the config with the managed-by label and the source domain, source role and generator
+// version annotations. The roles are sorted and joined with a comma when the resource is generated from multiple roles.
+func SetManagedMetadata(config *model.Config, domain string, roles ...string) {
+	if config.Labels == nil {
+		config.Labels = make(map[string]string)
+	}
+	if config.Annotations == nil {
+		config.Annotations = make(map[string]string)
+	}
+
+	sortedRoles := append([]string{}, roles...)
+	sort.Strings(sortedRoles)
+
+	config.Labels[ManagedByLabel] = ManagedByValue
+	config.Annotations[SourceDomainAnnotation] = domain
+	config.Annotations[SourceRoleAnnotation] = strings.Join(sortedRoles, ",")
+	config.Annotations[GeneratorVersionAnnotation] = GeneratorVersion
+}
+
+// IsManaged returns true if the config has the managed-by label of this controller
+func IsManaged(config model.Config) bool {
+	return config.Labels[ManagedByLabel] == ManagedByValue
+}
+
+// AdoptionScope is the athenz domain and the services the unlabeled resources are adopted for, an unlabeled
+// authorization policy is only adopted if it has the shape of the authorization policy generated for one of the
+// services from the roles of the domain
+type AdoptionScope struct {
+	// AdoptUnlabeled enables the adoption of the resources generated before the managed-by label was introduced,
+	// they are labeled on their next update or deleted when they are not desired anymore
+	AdoptUnlabeled bool
+	Model          athenz.Model
+	// Selectors are the workload selectors of the services by service name
+	Selectors map[string]map[string]string
+}
+
+// IsOwned returns true if the config has the managed-by label of this controller, or if the adoption of unlabeled
+// resources is enabled and the config without the managed-by label matches the spec generated in the scope
+func IsOwned(config model.Config, scope AdoptionScope) bool {
+	if IsManaged(config) {
+		return true
+	}
+	if _, labeled := config.Labels[ManagedByLabel]; labeled || !scope.AdoptUnlabeled {
+		return false
+	}
+	return isGeneratedSpec(config, scope)
+}
+
+// FilterOwnedConfigs returns the current configs owned by this controller, only those are diffed against the desired
+// configs and deleted, and the desired configs which do not conflict with a current config not owned by this
+// controller. Configs without the managed-by label are only owned if their spec matches the generated spec while
+// the adoption of unlabeled resources is enabled, this adopts the resources generated before the label was
+// introduced. A desired config with the key of a config not owned is skipped and reported, the config not owned is
+// never overwritten.
+func FilterOwnedConfigs(currentCRs []model.Config, desiredCRs []model.Config, scope AdoptionScope, reporter *EventReporter) ([]model.Config, []model.Config) {
+	desiredMap := ConvertSliceToKeyedMap(desiredCRs)
+
+	owned := make([]model.Config, 0, len(currentCRs))
+	foreign := make(map[string]bool)
+	for _, currConfig := range currentCRs {
+		if IsOwned(currConfig, scope) {
+			if !IsManaged(currConfig) {
+				log.Infof("Adopting resource %s without the %s label", currConfig.Key(), ManagedByLabel)
+			}
+			owned = append(owned, currConfig)
+			continue
+		}
+		managedBy, labeled := currConfig.Labels[ManagedByLabel]
+		if _, exists := desiredMap[currConfig.Key()]; exists {
+			log.Warningf("Skipping desired resource %s, the existing resource is not managed by %s", currConfig.Key(), ManagedByValue)
+			reporter.ForeignResource(currConfig.Type, currConfig.Namespace, currConfig.Name)
+			foreign[currConfig.Key()] = true
+			continue
+		}
+		if labeled {
+			log.Debugf("Skipping resource %s managed by %s", currConfig.Key(), managedBy)
+		} else {
+			log.Debugf("Skipping resource %s not managed by %s", currConfig.Key(), ManagedByValue)
+		}
+	}
+
+	if len(foreign) == 0 {
+		return owned, desiredCRs
+	}
+	desired := make([]model.Config, 0, len(desiredCRs))
+	for _, desiredConfig := range desiredCRs {
+		if !foreign[desiredConfig.Key()] {
+			desired = append(desired, desiredConfig)
+		}
+	}
+	return owned, desired
+}
+
+// OrphanedDomains returns the sorted source domains of the configs with the managed-by label for which exists
+// returns false, they are the athenz domains deleted while no controller was running to record their tombstone
+func OrphanedDomains(configs []model.Config, exists func(domain string) bool) []string {
+	orphaned := make(map[string]bool)
+	for _, config := range configs {
+		domain := config.Annotations[SourceDomainAnnotation]
+		if !IsManaged(config) || domain == "" || orphaned[domain] {
+			continue
+		}
+		if !exists(domain) {
+			orphaned[domain] = true
+		}
+	}
+	domains := make([]string, 0, len(orphaned))
+	for domain := range orphaned {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// isGeneratedSpec checks if the spec has the shape of the ServiceRoles, ServiceRoleBindings and AuthorizationPolicies
+// generated by the controller: access rules on all services constrained to an athenz svc, bindings referencing the
+// ServiceRole of the same name and authorization policies generated for a service of the scope
+func isGeneratedSpec(config model.Config, scope AdoptionScope) bool {
+	switch spec := config.Spec.(type) {
+	case *v1alpha1.ServiceRole:
+		if len(spec.Rules) == 0 {
+			return false
+		}
+		for _, rule := range spec.Rules {
+			if len(rule.Services) != 1 || rule.Services[0] != WildCardAll || len(rule.Methods) != 1 {
+				return false
+			}
+			if len(rule.Constraints) == 0 || rule.Constraints[0].Key != ConstraintSvcKey {
+				return false
+			}
+		}
+		return true
+	case *v1alpha1.ServiceRoleBinding:
+		return spec.RoleRef != nil && spec.RoleRef.Kind == ServiceRoleKind && spec.RoleRef.Name == config.Name && len(spec.Subjects) > 0
+	case *v1beta1.AuthorizationPolicy:
+		return isGeneratedAuthzPolicy(config, spec, scope)
+	}
+	return false
+}
+
+// isGeneratedAuthzPolicy checks if the authorization policy is named after a service of the scope or its DENY
+// counterpart, selects the workloads of the service with its workload selector, and only has rules on athenz
+// members with the SPIFFE identity of a role of the domain as principal
+func isGeneratedAuthzPolicy(config model.Config, spec *v1beta1.AuthorizationPolicy, scope AdoptionScope) bool {
+	action := v1beta1.AuthorizationPolicy_ALLOW
+	if strings.HasSuffix(config.Name, DenyAuthzPolicySuffix) {
+		action = v1beta1.AuthorizationPolicy_DENY
+	}
+	selector, exists := scope.Selectors[GetServiceNameFromAuthzPolicy(config)]
+	if !exists || spec.Action != action || len(spec.Rules) == 0 {
+		return false
+	}
+	if spec.Selector == nil || !reflect.DeepEqual(spec.Selector.MatchLabels, selector) {
+		return false
+	}
+
+	roleSpiffes := make(map[string]bool, len(scope.Model.Roles))
+	for _, role := range scope.Model.Roles {
+		roleName, err := ParseRoleFQDN(scope.Model.Name, string(role))
+		if err != nil {
+			continue
+		}
+		if roleSpiffe, err := RoleToSpiffe(string(scope.Model.Name), roleName); err == nil {
+			roleSpiffes[roleSpiffe] = true
+		}
+	}
+
+	for _, rule := range spec.Rules {
+		if len(rule.From) == 0 || len(rule.To) == 0 {
+			return false
+		}
+		for _, to := range rule.To {
+			if to.Operation == nil || len(to.Operation.Methods) != 1 {
+				return false
+			}
+		}
+		// the role spiffe is the last principal of the first source of every generated rule
+		source := rule.From[0].Source
+		if source == nil || len(source.Principals) == 0 || !roleSpiffes[source.Principals[len(source.Principals)-1]] {
+			return false
+		}
+		for _, from := range rule.From {
+			if from.Source == nil {
+				return false
+			}
+			for _, principal := range from.Source.Principals {
+				if principal != WildCardAll && !isAthenzSpiffe(principal) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// isAthenzSpiffe checks if the principal is the SPIFFE identity of an athenz service or role in the
+// <domain>/sa/<service> or <domain>/ra/<role> format
+func isAthenzSpiffe(principal string) bool {
+	parts := strings.Split(principal, "/")
+	return len(parts) == 3 && parts[0] != "" && parts[2] != "" && (parts[1] == "sa" || parts[1] == "ra")
+}
+
+// managedMetadataEqual checks if the existing config has the managed-by label and the source annotations of the
+// desired config. The generator version is not compared, so that upgrading the controller does not update every
+// resource.
+func managedMetadataEqual(existing, desired model.Config) bool {
+	if value, exists := desired.Labels[ManagedByLabel]; exists && existing.Labels[ManagedByLabel] != value {
+		return false
+	}
+	for _, key := range []string{SourceDomainAnnotation, SourceRoleAnnotation} {
+		if value, exists := desired.Annotations[key]; exists && existing.Annotations[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeManagedMetadata returns the existing config meta with the managed-by label and the annotations of the desired
+// config meta, the other labels and annotations of the existing config are preserved
+func mergeManagedMetadata(existing, desired model.ConfigMeta) model.ConfigMeta {
+	out := existing
+	out.Labels = make(map[string]string, len(existing.Labels))
+	for key, value := range existing.Labels {
+		out.Labels[key] = value
+	}
+	if value, exists := desired.Labels[ManagedByLabel]; exists {
+		out.Labels[ManagedByLabel] = value
+	}
+
+	out.Annotations = make(map[string]string, len(existing.Annotations))
+	for key, value := range existing.Annotations {
+		out.Annotations[key] = value
+	}
+	for _, key := range []string{SourceDomainAnnotation, SourceRoleAnnotation, GeneratorVersionAnnotation} {
+		if value, exists := desired.Annotations[key]; exists {
+			out.Annotations[key] = value
+		}
+	}
+
+	if len(out.Labels) == 0 {
+		out.Labels = existing.Labels
+	}
+	if len(out.Annotations) == 0 {
+		out.Annotations = existing.Annotations
+	}
+	return out
+}
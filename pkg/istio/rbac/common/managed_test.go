@@ -0,0 +1,211 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"istio.io/api/rbac/v1alpha1"
+	"istio.io/api/security/v1beta1"
+	workloadv1beta1 "istio.io/api/type/v1beta1"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config/schema/collections"
+	"k8s.io/client-go/tools/record"
+)
+
+func newManagedTestConfig(name string, labels map[string]string) model.Config {
+	config := NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "athenz-domain", name, &v1alpha1.ServiceRole{})
+	config.Labels = labels
+	return config
+}
+
+func TestSetManagedMetadata(t *testing.T) {
+	config := newManagedTestConfig("reader", nil)
+	SetManagedMetadata(&config, "athenz.domain", "athenz.domain:role.writer", "athenz.domain:role.reader")
+
+	assert.True(t, IsManaged(config), "config should be managed")
+	assert.Equal(t, map[string]string{ManagedByLabel: ManagedByValue}, config.Labels, "labels should be equal")
+	assert.Equal(t, map[string]string{
+		SourceDomainAnnotation:     "athenz.domain",
+		SourceRoleAnnotation:       "athenz.domain:role.reader,athenz.domain:role.writer",
+		GeneratorVersionAnnotation: GeneratorVersion,
+	}, config.Annotations, "annotations should be equal")
+}
+
+func TestFilterOwnedConfigs(t *testing.T) {
+	managed := newManagedTestConfig("managed", map[string]string{ManagedByLabel: ManagedByValue})
+	legacy := newManagedTestConfig("legacy", nil)
+	handWritten := newManagedTestConfig("hand-written", nil)
+	foreign := newManagedTestConfig("foreign", map[string]string{ManagedByLabel: "helm"})
+
+	desiredManaged := newManagedTestConfig("managed", nil)
+	desired := []model.Config{
+		desiredManaged,
+		newManagedTestConfig("legacy", nil),
+		newManagedTestConfig("foreign", nil),
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	owned, desired := FilterOwnedConfigs([]model.Config{managed, legacy, handWritten, foreign}, desired, AdoptionScope{}, NewEventReporter(recorder, DomainReference("athenz.domain")))
+	assert.Equal(t, []model.Config{managed}, owned, "owned configs should be equal")
+	assert.Equal(t, []model.Config{desiredManaged}, desired, "desired configs conflicting with configs not owned should be skipped")
+	assert.Equal(t, 2, len(recorder.Events), "configs not owned with the key of a desired config should be reported")
+	assert.Equal(t, "Warning ForeignResource Skipped generated ServiceRole athenz-domain/legacy, the existing resource is not managed by k8s-athenz-istio-auth", <-recorder.Events, "event should be equal")
+}
+
+func TestOrphanedDomains(t *testing.T) {
+	newSourceConfig := func(name, domain string, managed bool) model.Config {
+		config := newManagedTestConfig(name, nil)
+		if managed {
+			SetManagedMetadata(&config, domain)
+		} else {
+			config.Annotations = map[string]string{SourceDomainAnnotation: domain}
+		}
+		return config
+	}
+	configs := []model.Config{
+		newSourceConfig("existing", "existing.domain", true),
+		newSourceConfig("deleted-reader", "deleted.domain", true),
+		newSourceConfig("deleted-writer", "deleted.domain", true),
+		newSourceConfig("another", "another.domain", true),
+		newSourceConfig("unmanaged", "unmanaged.domain", false),
+		newManagedTestConfig("unannotated", map[string]string{ManagedByLabel: ManagedByValue}),
+	}
+	exists := func(domain string) bool {
+		return domain == "existing.domain"
+	}
+	assert.Equal(t, []string{"another.domain", "deleted.domain"}, OrphanedDomains(configs, exists), "source domains of the managed configs which do not exist should be returned")
+	assert.Empty(t, OrphanedDomains(nil, exists), "no domain should be returned without configs")
+}
+
+func TestFilterOwnedConfigsAdoptUnlabeled(t *testing.T) {
+	generatedSR := NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "athenz-domain", "reader", &v1alpha1.ServiceRole{
+		Rules: []*v1alpha1.AccessRule{
+			{
+				Constraints: []*v1alpha1.AccessRule_Constraint{{Key: ConstraintSvcKey, Values: []string{"my-service"}}},
+				Methods:     []string{"GET"},
+				Services:    []string{WildCardAll},
+			},
+		},
+	})
+	handWrittenSR := NewConfig(collections.IstioRbacV1Alpha1Serviceroles, "athenz-domain", "hand-written", &v1alpha1.ServiceRole{
+		Rules: []*v1alpha1.AccessRule{
+			{
+				Methods:  []string{"GET", "POST"},
+				Services: []string{"my-service.athenz-domain.svc.cluster.local"},
+			},
+		},
+	})
+	generatedSRB := NewConfig(collections.IstioRbacV1Alpha1Servicerolebindings, "athenz-domain", "reader", &v1alpha1.ServiceRoleBinding{
+		RoleRef:  &v1alpha1.RoleRef{Kind: ServiceRoleKind, Name: "reader"},
+		Subjects: []*v1alpha1.Subject{{User: "athenz.domain/sa/client"}},
+	})
+	handWrittenSRB := NewConfig(collections.IstioRbacV1Alpha1Servicerolebindings, "athenz-domain", "hand-written", &v1alpha1.ServiceRoleBinding{
+		RoleRef:  &v1alpha1.RoleRef{Kind: ServiceRoleKind, Name: "reader"},
+		Subjects: []*v1alpha1.Subject{{User: "athenz.domain/sa/client"}},
+	})
+	generatedAP := NewConfig(collections.IstioSecurityV1Beta1Authorizationpolicies, "athenz-domain", "my-service", &v1beta1.AuthorizationPolicy{
+		Selector: &workloadv1beta1.WorkloadSelector{MatchLabels: map[string]string{"app": "my-service"}},
+		Rules: []*v1beta1.Rule{
+			{
+				From: []*v1beta1.Rule_From{{Source: &v1beta1.Source{Principals: []string{"athenz.domain/ra/reader"}}}},
+				To:   []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"GET"}}}},
+			},
+		},
+	})
+	generatedDenyAP := NewConfig(collections.IstioSecurityV1Beta1Authorizationpolicies, "athenz-domain", DenyAuthzPolicyName("my-service"), &v1beta1.AuthorizationPolicy{
+		Selector: &workloadv1beta1.WorkloadSelector{MatchLabels: map[string]string{"app": "my-service"}},
+		Action:   v1beta1.AuthorizationPolicy_DENY,
+		Rules: []*v1beta1.Rule{
+			{
+				From: []*v1beta1.Rule_From{{Source: &v1beta1.Source{Principals: []string{"client.domain/sa/client", "athenz.domain/ra/reader"}}}},
+				To:   []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"DELETE"}}}},
+			},
+		},
+	})
+	handWrittenAP := NewConfig(collections.IstioSecurityV1Beta1Authorizationpolicies, "athenz-domain", "allow-all", &v1beta1.AuthorizationPolicy{
+		Rules: []*v1beta1.Rule{{}},
+	})
+	// a hand-written authz policy named after the service, allowing the frontend with its kubernetes identity
+	handWrittenServiceAP := NewConfig(collections.IstioSecurityV1Beta1Authorizationpolicies, "athenz-domain", "my-service", &v1beta1.AuthorizationPolicy{
+		Selector: &workloadv1beta1.WorkloadSelector{MatchLabels: map[string]string{"app": "my-service"}},
+		Rules: []*v1beta1.Rule{
+			{
+				From: []*v1beta1.Rule_From{{Source: &v1beta1.Source{Principals: []string{"cluster.local/ns/frontend/sa/frontend"}}}},
+				To:   []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"GET"}}}},
+			},
+		},
+	})
+	otherSelectorAP := generatedAP
+	otherSelectorAP.Spec = &v1beta1.AuthorizationPolicy{
+		Selector: &workloadv1beta1.WorkloadSelector{MatchLabels: map[string]string{"app": "my-service", "version": "v1"}},
+		Rules:    generatedAP.Spec.(*v1beta1.AuthorizationPolicy).Rules,
+	}
+	otherRoleAP := generatedAP
+	otherRoleAP.Spec = &v1beta1.AuthorizationPolicy{
+		Selector: generatedAP.Spec.(*v1beta1.AuthorizationPolicy).Selector,
+		Rules: []*v1beta1.Rule{
+			{
+				From: []*v1beta1.Rule_From{{Source: &v1beta1.Source{Principals: []string{"other.domain/ra/reader"}}}},
+				To:   []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"GET"}}}},
+			},
+		},
+	}
+	foreignAP := generatedAP
+	foreignAP.Name = "foreign"
+	foreignAP.Labels = map[string]string{ManagedByLabel: "helm"}
+
+	scope := AdoptionScope{
+		Model: athenz.Model{
+			Name:  "athenz.domain",
+			Roles: athenz.Roles{"athenz.domain:role.reader"},
+		},
+		Selectors: map[string]map[string]string{"my-service": {"app": "my-service"}},
+	}
+	current := []model.Config{generatedSR, handWrittenSR, generatedSRB, handWrittenSRB, generatedAP, generatedDenyAP, handWrittenAP, foreignAP}
+	owned, _ := FilterOwnedConfigs(current, nil, scope, nil)
+	assert.Equal(t, []model.Config{}, owned, "unlabeled configs should not be adopted unless enabled")
+
+	scope.AdoptUnlabeled = true
+	owned, _ = FilterOwnedConfigs(current, nil, scope, nil)
+	assert.Equal(t, []model.Config{generatedSR, generatedSRB, generatedAP, generatedDenyAP}, owned, "unlabeled configs with the generated spec should be adopted")
+	assert.True(t, IsOwned(generatedAP, scope), "unlabeled authz policy with the generated spec should be owned")
+	assert.False(t, IsOwned(generatedAP, AdoptionScope{AdoptUnlabeled: true, Model: scope.Model}), "unlabeled authz policy of a service outside of the scope should not be owned")
+	assert.False(t, IsOwned(handWrittenServiceAP, scope), "hand-written authz policy named after the service should not be owned")
+	assert.False(t, IsOwned(otherSelectorAP, scope), "unlabeled authz policy with another workload selector should not be owned")
+	assert.False(t, IsOwned(otherRoleAP, scope), "unlabeled authz policy on the role of another domain should not be owned")
+	assert.False(t, IsOwned(foreignAP, scope), "authz policy managed by anything else should not be owned")
+
+	// the hand-written authz policy is skipped along with the desired authz policy of the same name
+	owned, desired := FilterOwnedConfigs([]model.Config{handWrittenServiceAP}, []model.Config{generatedAP}, scope, nil)
+	assert.Equal(t, []model.Config{}, owned, "hand-written authz policy should not be owned")
+	assert.Equal(t, []model.Config{}, desired, "desired authz policy should not overwrite the hand-written authz policy")
+}
+
+func TestComputeChangeListManagedMetadata(t *testing.T) {
+	existing := newManagedTestConfig("reader", map[string]string{"team": "a"})
+	existing.ResourceVersion = "1"
+	desired := newManagedTestConfig("reader", nil)
+	SetManagedMetadata(&desired, "athenz.domain", "athenz.domain:role.reader")
+
+	// the spec is equal, the managed metadata is missing from the existing config
+	changeList := ComputeChangeList([]model.Config{existing}, []model.Config{desired}, nil, nil)
+	assert.Equal(t, 1, len(changeList), "change list should contain the update")
+	assert.Equal(t, model.EventUpdate, changeList[0].Operation, "operation should be update")
+	assert.Equal(t, "1", changeList[0].Resource.ResourceVersion, "resource version should be copied from the existing config")
+	assert.Equal(t, map[string]string{"team": "a", ManagedByLabel: ManagedByValue}, changeList[0].Resource.Labels, "labels should be merged")
+	assert.Equal(t, desired.Annotations, changeList[0].Resource.Annotations, "annotations should be equal")
+
+	// a different generator version alone does not result in an update
+	updated := changeList[0].Resource
+	updated.Annotations = map[string]string{
+		SourceDomainAnnotation:     "athenz.domain",
+		SourceRoleAnnotation:       "athenz.domain:role.reader",
+		GeneratorVersionAnnotation: "old",
+	}
+	changeList = ComputeChangeList([]model.Config{updated}, []model.Config{desired}, nil, nil)
+	assert.Equal(t, 0, len(changeList), "change list should be empty")
+}
@@ -0,0 +1,20 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+// ConversionOptions select how the athenz assertions are matched with the services and converted into authorization
+// policy rules, they are given to the providers and the tools converting the athenz domains
+type ConversionOptions struct {
+	SvcMatchMode SvcMatchMode
+	// QueryStringMode is the header mode if it is not set
+	QueryStringMode QueryStringMode
+}
+
+// DefaultConversionOptions returns the conversion options of the default flags
+func DefaultConversionOptions() ConversionOptions {
+	return ConversionOptions{
+		SvcMatchMode:    SvcMatchGlob,
+		QueryStringMode: QueryStringHeader,
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxPathAlternatives is the maximum number of paths the alternations of an athenz path glob are expanded into
+const maxPathAlternatives = 16
+
+// Regex for collapsing the consecutive wildcards of a path glob, ** matches the same paths as *
+var wildcardsRegex = regexp.MustCompile(`\*+`)
+
+// TranslatePath translates the path glob of an athenz assertion resource into the istio paths of a rule. Istio only
+// supports exact, prefix (/api/*), suffix (*.json) and presence (*) matches, the brace alternations of the glob are
+// expanded into multiple paths, e.g. /api/{v1,v2}/* into /api/v1/* and /api/v2/*. An error is returned for the globs
+// which cannot be expressed, e.g. a wildcard in the middle of the path, rather than a path which never matches. The ?
+// character is not a wildcard since it starts the query string of the path.
+func TranslatePath(path string) ([]string, error) {
+	expanded, err := expandAlternations(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(expanded))
+	seen := make(map[string]bool, len(expanded))
+	for _, p := range expanded {
+		p = wildcardsRegex.ReplaceAllString(p, WildCardAll)
+		wildcards := strings.Count(p, WildCardAll)
+		switch {
+		case wildcards == 0, p == WildCardAll:
+		case wildcards == 1 && (strings.HasPrefix(p, WildCardAll) || strings.HasSuffix(p, WildCardAll)):
+		default:
+			return nil, fmt.Errorf("path: %s is not supported, istio only supports exact, prefix, suffix and presence matches", path)
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// expandAlternations returns the paths of the brace alternations of the path glob, the alternations can not be nested
+func expandAlternations(path string) ([]string, error) {
+	start := strings.Index(path, "{")
+	if start < 0 {
+		if strings.Contains(path, "}") {
+			return nil, fmt.Errorf("path: %s has an unbalanced alternation", path)
+		}
+		return []string{path}, nil
+	}
+	end := strings.Index(path[start:], "}")
+	if end < 0 {
+		return nil, fmt.Errorf("path: %s has an unbalanced alternation", path)
+	}
+	end += start
+	alternatives := path[start+1 : end]
+	if strings.Contains(alternatives, "{") || strings.Contains(path[:start], "}") {
+		return nil, fmt.Errorf("path: %s has a nested or unbalanced alternation", path)
+	}
+
+	suffixes, err := expandAlternations(path[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, alternative := range strings.Split(alternatives, ",") {
+		for _, suffix := range suffixes {
+			paths = append(paths, path[:start]+alternative+suffix)
+		}
+	}
+	if len(paths) > maxPathAlternatives {
+		return nil, fmt.Errorf("path: %s expands into more than %d paths", path, maxPathAlternatives)
+	}
+	return paths, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslatePath(t *testing.T) {
+	cases := []struct {
+		test          string
+		path          string
+		expectedPaths []string
+		expectedErr   string
+	}{
+		{test: "exact", path: "/api/items", expectedPaths: []string{"/api/items"}},
+		{test: "prefix", path: "/data*", expectedPaths: []string{"/data*"}},
+		{test: "suffix", path: "*.json", expectedPaths: []string{"*.json"}},
+		{test: "presence", path: "*", expectedPaths: []string{"*"}},
+		{test: "consecutive wildcards", path: "/api/**", expectedPaths: []string{"/api/*"}},
+		{test: "query string is not a wildcard", path: "/api?user=admin", expectedPaths: []string{"/api?user=admin"}},
+		{test: "alternation", path: "/api/{v1,v2}/*", expectedPaths: []string{"/api/v1/*", "/api/v2/*"}},
+		{test: "empty alternative", path: "/item{,s}", expectedPaths: []string{"/item", "/items"}},
+		{
+			test:          "multiple alternations",
+			path:          "/{a,b}/{c,d}",
+			expectedPaths: []string{"/a/c", "/a/d", "/b/c", "/b/d"},
+		},
+		{test: "duplicate alternatives", path: "/{a,a}", expectedPaths: []string{"/a"}},
+		{
+			test:        "wildcard in the middle",
+			path:        "/api/*/items",
+			expectedErr: "path: /api/*/items is not supported, istio only supports exact, prefix, suffix and presence matches",
+		},
+		{
+			test:        "leading and trailing wildcards",
+			path:        "*items*",
+			expectedErr: "path: *items* is not supported, istio only supports exact, prefix, suffix and presence matches",
+		},
+		{
+			test:        "alternation with a wildcard in the middle",
+			path:        "/{api/*,data}/items",
+			expectedErr: "path: /{api/*,data}/items is not supported, istio only supports exact, prefix, suffix and presence matches",
+		},
+		{test: "unclosed alternation", path: "/api/{v1,v2", expectedErr: "path: /api/{v1,v2 has an unbalanced alternation"},
+		{test: "unopened alternation", path: "/api/v1}", expectedErr: "path: /api/v1} has an unbalanced alternation"},
+		{test: "nested alternation", path: "/{a,{b,c}}", expectedErr: "path: /{a,{b,c}} has a nested or unbalanced alternation"},
+		{
+			test:        "too many alternatives",
+			path:        "/{a,b,c}/{d,e,f}/{g,h}",
+			expectedErr: "path: /{a,b,c}/{d,e,f}/{g,h} expands into more than 16 paths",
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := TranslatePath(c.path)
+		if c.expectedErr != "" {
+			assert.EqualError(t, err, c.expectedErr, c.test)
+			assert.Nil(t, actual, c.test)
+			continue
+		}
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, c.expectedPaths, actual, c.test)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConditionPathHeaderKey is the authorization policy condition key of the :path pseudo header, which contains the
+// query string of the request path unlike the paths of the rule operation
+const ConditionPathHeaderKey = "request.headers[:path]"
+
+// QueryStringMode selects how the query string of the path of an athenz assertion resource is converted into an
+// authorization policy rule
+type QueryStringMode string
+
+const (
+	// QueryStringHeader matches the whole request path, including the query string, with a condition on the :path
+	// header the way the v1 ServiceRoles do
+	QueryStringHeader QueryStringMode = "header"
+	// QueryStringDrop drops the query string, the rule matches the path with any query string
+	QueryStringDrop QueryStringMode = "drop"
+	// QueryStringDeny skips the ALLOW assertions with a query string until they are reviewed, the DENY assertions
+	// are converted with the query string dropped and deny the path with any query string
+	QueryStringDeny QueryStringMode = "deny"
+)
+
+// ParseQueryStringMode parses the query string mode from the command line argument
+func ParseQueryStringMode(mode string) (QueryStringMode, error) {
+	switch QueryStringMode(mode) {
+	case QueryStringHeader, QueryStringDrop, QueryStringDeny:
+		return QueryStringMode(mode), nil
+	default:
+		return "", fmt.Errorf("query string mode %s is not one of %s, %s or %s", mode, QueryStringHeader, QueryStringDrop, QueryStringDeny)
+	}
+}
+
+// SplitQueryString splits the path of an athenz assertion resource into the path and the query string, the query
+// string is empty if the path does not contain a ?
+func SplitQueryString(path string) (string, string) {
+	i := strings.Index(path, "?")
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
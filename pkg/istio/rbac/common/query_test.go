@@ -0,0 +1,40 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQueryStringMode(t *testing.T) {
+	for _, mode := range []QueryStringMode{QueryStringHeader, QueryStringDrop, QueryStringDeny} {
+		actual, err := ParseQueryStringMode(string(mode))
+		assert.Nil(t, err, "query string mode %s should be valid", mode)
+		assert.Equal(t, mode, actual)
+	}
+	_, err := ParseQueryStringMode("lua")
+	assert.EqualError(t, err, "query string mode lua is not one of header, drop or deny")
+}
+
+func TestSplitQueryString(t *testing.T) {
+	cases := []struct {
+		test          string
+		path          string
+		expectedPath  string
+		expectedQuery string
+	}{
+		{test: "without query string", path: "/api", expectedPath: "/api"},
+		{test: "with query string", path: "/api?user=admin&id=1", expectedPath: "/api", expectedQuery: "user=admin&id=1"},
+		{test: "with query string wildcard", path: "/api?*", expectedPath: "/api", expectedQuery: "*"},
+		{test: "empty query string", path: "/api?", expectedPath: "/api"},
+	}
+
+	for _, c := range cases {
+		path, query := SplitQueryString(c.path)
+		assert.Equal(t, c.expectedPath, path, c.test)
+		assert.Equal(t, c.expectedQuery, query, c.test)
+	}
+}
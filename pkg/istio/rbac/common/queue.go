@@ -0,0 +1,156 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/health"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// drainQueue removes and returns the items left in the queue, it is used at shutdown to report the items which were
+// not processed and must only be called once the workers of the queue have returned
+func drainQueue(queue workqueue.Interface) []interface{} {
+	var items []interface{}
+	for queue.Len() > 0 {
+		item, quit := queue.Get()
+		if quit {
+			break
+		}
+		queue.Done(item)
+		items = append(items, item)
+	}
+	return items
+}
+
+// InFlight tracks the keys taken off a queue by its workers until they are done with them, the keys still in flight
+// are reported at shutdown when the workers are abandoned. The zero value is ready to use.
+type InFlight struct {
+	lock sync.Mutex
+	keys map[string]int
+}
+
+// Start records that a worker took the key off the queue
+func (f *InFlight) Start(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.keys == nil {
+		f.keys = make(map[string]int)
+	}
+	f.keys[key]++
+}
+
+// Done records that a worker is done with the key
+func (f *InFlight) Done(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.keys[key] <= 1 {
+		delete(f.keys, key)
+		return
+	}
+	f.keys[key]--
+}
+
+// Keys returns the sorted keys the workers are not done with
+func (f *InFlight) Keys() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var keys []string
+	for key := range f.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Worker runs the worker of a controller queue, it tracks the progress of the worker for the liveness probe and the
+// items in flight for the shutdown report. The controllers embed it to expose ShutDown, Unprocessed, Pending and
+// Stalled.
+type Worker struct {
+	queue    workqueue.RateLimitingInterface
+	describe func(item interface{}) string
+	progress health.Progress
+	inFlight InFlight
+}
+
+// NewWorker returns the worker of the queue, the items are reported with describe, or with their default format if
+// describe is nil
+func NewWorker(queue workqueue.RateLimitingInterface, describe func(item interface{}) string) *Worker {
+	if describe == nil {
+		describe = func(item interface{}) string {
+			return fmt.Sprint(item)
+		}
+	}
+	return &Worker{
+		queue:    queue,
+		describe: describe,
+	}
+}
+
+// Run calls process for the items of the queue until the stop channel is closed, or until the queue is shut down
+// with ShutDown and all of the items left in it are processed. The remaining items are left in the queue, the queue
+// is shut down on stop to release the worker waiting for an item.
+func (w *Worker) Run(stopCh <-chan struct{}, process func(item interface{})) {
+	go func() {
+		<-stopCh
+		w.queue.ShutDown()
+	}()
+
+	w.progress.Tick()
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		item, quit := w.queue.Get()
+		if quit {
+			return
+		}
+		w.processItem(item, process)
+	}
+}
+
+// processItem calls process for an item taken off the queue, the item is done once process returns
+func (w *Worker) processItem(item interface{}, process func(item interface{})) {
+	// taking an item off the queue and finishing it are both progress of the worker
+	w.progress.Tick()
+	defer w.progress.Tick()
+	defer w.queue.Done(item)
+	description := w.describe(item)
+	w.inFlight.Start(description)
+	defer w.inFlight.Done(description)
+
+	process(item)
+}
+
+// ShutDown stops accepting new items, the worker keeps processing the items left in the queue and returns once the
+// queue is empty
+func (w *Worker) ShutDown() {
+	w.queue.ShutDown()
+}
+
+// Unprocessed returns the items left in the queue, it must only be called once the worker has returned
+func (w *Worker) Unprocessed() []string {
+	var out []string
+	for _, item := range drainQueue(w.queue) {
+		out = append(out, w.describe(item))
+	}
+	return out
+}
+
+// Pending returns the number of items left in the queue and the items the worker is not done with, unlike
+// Unprocessed it can be called while the worker is running
+func (w *Worker) Pending() (int, []string) {
+	return w.queue.Len(), w.inFlight.Keys()
+}
+
+// Stalled returns true if the worker has not made progress within the threshold while its queue is not empty
+func (w *Worker) Stalled(threshold time.Duration) bool {
+	return w.progress.Stalled(w.queue.Len(), threshold)
+}
@@ -0,0 +1,69 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestWorker(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	w := NewWorker(queue, func(item interface{}) string {
+		return "sync of " + item.(string)
+	})
+	queue.Add("first.domain")
+	queue.Add("second.domain")
+	queue.Add("third.domain")
+	assert.False(t, w.Stalled(time.Nanosecond), "worker which has not started should not be stalled")
+
+	// the worker blocks on the first key while the other keys are left in the queue
+	stopCh := make(chan struct{})
+	release := make(chan struct{})
+	taken := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(stopCh, func(item interface{}) {
+			close(taken)
+			<-release
+		})
+	}()
+	<-taken
+	queued, inFlight := w.Pending()
+	assert.Equal(t, 2, queued, "keys left in the queue should be counted")
+	assert.Equal(t, []string{"sync of first.domain"}, inFlight, "key taken off the queue should be in flight")
+	time.Sleep(time.Millisecond)
+	assert.True(t, w.Stalled(time.Nanosecond), "worker without progress within the threshold should be stalled")
+	assert.False(t, w.Stalled(time.Minute), "worker with progress within the threshold should not be stalled")
+
+	close(stopCh)
+	close(release)
+	<-done
+	queued, inFlight = w.Pending()
+	assert.Equal(t, 0, len(inFlight), "key should not be in flight once the worker returned")
+	assert.Equal(t, 2, queued, "keys should be left in the queue on stop")
+	assert.Equal(t, []string{"sync of second.domain", "sync of third.domain"}, w.Unprocessed(), "unprocessed keys should be equal")
+	assert.Equal(t, 0, queue.Len(), "queue should be drained")
+}
+
+func TestInFlight(t *testing.T) {
+	var inFlight InFlight
+	assert.Nil(t, inFlight.Keys(), "zero value should not have keys in flight")
+
+	inFlight.Start("second.domain")
+	inFlight.Start("first.domain")
+	inFlight.Start("first.domain")
+	assert.Equal(t, []string{"first.domain", "second.domain"}, inFlight.Keys(), "keys in flight should be equal")
+
+	inFlight.Done("first.domain")
+	inFlight.Done("second.domain")
+	assert.Equal(t, []string{"first.domain"}, inFlight.Keys(), "key taken off twice should still be in flight")
+
+	inFlight.Done("first.domain")
+	assert.Nil(t, inFlight.Keys(), "keys should not be in flight once done")
+}
@@ -0,0 +1,66 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SelectorLabelsAnnotation is the service annotation with the comma separated label keys of the service selector used
+// as the workload selector of the authorization policy, it takes precedence over the cluster wide selector label keys
+const SelectorLabelsAnnotation = "authz.istio.io/selector-labels"
+
+// ParseSelectorLabels parses a comma separated list of label keys, the empty keys are dropped
+func ParseSelectorLabels(list string) []string {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// WorkloadSelector returns the labels selecting the pods of the service in its authorization policy. The labels are
+// taken from the spec.selector of the service, restricted to the label keys of the SelectorLabelsAnnotation if it is
+// set or to the given cluster wide label keys otherwise. All the labels of the spec.selector are used if no label
+// keys are configured. An error is returned if the resulting selector is empty, since the authorization policy would
+// either select all the pods of the namespace or none of them.
+func WorkloadSelector(service *corev1.Service, selectorLabels []string) (map[string]string, error) {
+	if service == nil {
+		return nil, fmt.Errorf("service is nil")
+	}
+
+	keys := selectorLabels
+	if annotation, exists := service.Annotations[SelectorLabelsAnnotation]; exists {
+		keys = ParseSelectorLabels(annotation)
+	}
+
+	selector := make(map[string]string)
+	for key, value := range service.Spec.Selector {
+		if len(keys) == 0 || contains(keys, key) {
+			selector[key] = value
+		}
+	}
+
+	if len(selector) == 0 {
+		if len(keys) > 0 {
+			return nil, fmt.Errorf("selector of service %s/%s does not have any of the labels %s", service.Namespace, service.Name, strings.Join(keys, ","))
+		}
+		return nil, fmt.Errorf("selector of service %s/%s is empty", service.Namespace, service.Name)
+	}
+	return selector, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
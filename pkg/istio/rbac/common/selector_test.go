@@ -0,0 +1,85 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newSelectorTestService(annotations map[string]string, selector map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "productpage",
+			Namespace:   "test-namespace",
+			Annotations: annotations,
+			Labels:      map[string]string{"app": "shared"},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+		},
+	}
+}
+
+func TestParseSelectorLabels(t *testing.T) {
+	assert.Nil(t, ParseSelectorLabels(""), "empty list should not have keys")
+	assert.Equal(t, []string{"app", "version"}, ParseSelectorLabels(" app, ,version "), "keys should be trimmed")
+}
+
+func TestWorkloadSelector(t *testing.T) {
+	selector := map[string]string{"app": "productpage", "version": "v1"}
+	cases := []struct {
+		test             string
+		service          *corev1.Service
+		selectorLabels   []string
+		expectedSelector map[string]string
+		expectedErr      string
+	}{
+		{
+			test:             "all labels of the service selector",
+			service:          newSelectorTestService(nil, selector),
+			expectedSelector: selector,
+		},
+		{
+			test:             "cluster selector label keys",
+			service:          newSelectorTestService(nil, selector),
+			selectorLabels:   []string{"app", "tier"},
+			expectedSelector: map[string]string{"app": "productpage"},
+		},
+		{
+			test:             "annotation takes precedence over the cluster selector label keys",
+			service:          newSelectorTestService(map[string]string{SelectorLabelsAnnotation: "version"}, selector),
+			selectorLabels:   []string{"app"},
+			expectedSelector: map[string]string{"version": "v1"},
+		},
+		{
+			test:        "empty service selector",
+			service:     newSelectorTestService(nil, nil),
+			expectedErr: "selector of service test-namespace/productpage is empty",
+		},
+		{
+			test:           "service selector without the selector label keys",
+			service:        newSelectorTestService(nil, selector),
+			selectorLabels: []string{"tier"},
+			expectedErr:    "selector of service test-namespace/productpage does not have any of the labels tier",
+		},
+		{
+			test:        "nil service",
+			expectedErr: "service is nil",
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := WorkloadSelector(c.service, c.selectorLabels)
+		if c.expectedErr != "" {
+			assert.EqualError(t, err, c.expectedErr, c.test)
+			continue
+		}
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, c.expectedSelector, actual, c.test)
+	}
+}
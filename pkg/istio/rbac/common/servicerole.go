@@ -9,6 +9,7 @@ import (
 
 	"github.com/yahoo/athenz/clients/go/zms"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
 
 	"istio.io/api/rbac/v1alpha1"
 )
@@ -22,14 +23,16 @@ func ConvertAthenzRoleNameToK8sName(roleName string) string {
 	return strings.ReplaceAll(roleName, "_", "--")
 }
 
-// GetServiceRoleSpec returns the ServiceRoleSpec for a given Athenz role and the associated assertions
-func GetServiceRoleSpec(domainName zms.DomainName, roleName string, assertions []*zms.Assertion) (*v1alpha1.ServiceRole, error) {
+// GetServiceRoleSpec returns the ServiceRoleSpec for a given Athenz role and the associated assertions, the skipped
+// assertions are reported to the reporter
+func GetServiceRoleSpec(domainName zms.DomainName, roleName string, assertions []*zms.Assertion, reporter *EventReporter) (*v1alpha1.ServiceRole, error) {
 
 	rules := make([]*v1alpha1.AccessRule, 0)
 	for _, assertion := range assertions {
 		assertionRole, err := ParseRoleFQDN(domainName, string(assertion.Role))
 		if err != nil {
 			log.Debug(err.Error())
+			reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidRole, err)
 			continue
 		}
 
@@ -41,21 +44,41 @@ func GetServiceRoleSpec(domainName zms.DomainName, roleName string, assertions [
 		svc, path, err := ParseAssertionResource(domainName, assertion)
 		if err != nil {
 			log.Debugf(err.Error())
+			reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidResource, err)
 			continue
 		}
 
-		_, err = ParseAssertionEffect(assertion)
+		effect, err := ParseAssertionEffect(assertion)
 		if err != nil {
 			log.Debugf(err.Error())
+			reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidEffect, err)
+			continue
+		}
+
+		// ServiceRoles only express allowed access, DENY assertions are not supported by the v1 RBAC api
+		if effect != zms.ALLOW.String() {
+			log.Debugf("Assertion: %v with effect: %s is not supported for a ServiceRole", assertion, effect)
+			reporter.SkippedAssertion(assertion, metrics.SkipReasonDenyUnsupported, fmt.Errorf("effect %s is not supported for a ServiceRole", effect))
 			continue
 		}
 
 		method, err := ParseAssertionAction(assertion)
 		if err != nil {
 			log.Debugf(err.Error())
+			reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidAction, err)
 			continue
 		}
 
+		var paths []string
+		if path != "" {
+			paths, err = TranslatePath(path)
+			if err != nil {
+				log.Debugf(err.Error())
+				reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidPath, err)
+				continue
+			}
+		}
+
 		rule := &v1alpha1.AccessRule{
 			Constraints: []*v1alpha1.AccessRule_Constraint{
 				{
@@ -64,11 +87,9 @@ func GetServiceRoleSpec(domainName zms.DomainName, roleName string, assertions [
 				},
 			},
 			Methods:  []string{method},
+			Paths:    paths,
 			Services: []string{WildCardAll},
 		}
-		if path != "" {
-			rule.Paths = []string{path}
-		}
 
 		rules = append(rules, rule)
 	}
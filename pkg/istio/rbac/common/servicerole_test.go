@@ -54,6 +54,7 @@ func TestConvertAthenzRoleNameToK8sName(t *testing.T) {
 func TestGetServiceRoleSpec(t *testing.T) {
 
 	allow := zms.ALLOW
+	deny := zms.DENY
 	type input struct {
 		domainName zms.DomainName
 		roleName   string
@@ -146,10 +147,82 @@ func TestGetServiceRoleSpec(t *testing.T) {
 			},
 			expectedErr: nil,
 		},
+		{
+			test: "valid role spec with path alternations",
+			input: input{
+				domainName: "athenz.domain",
+				roleName:   "client-reader-role",
+				assertions: []*zms.Assertion{
+					{
+						Effect:   &allow,
+						Action:   "get",
+						Role:     "athenz.domain:role.client-reader-role",
+						Resource: "athenz.domain:svc.my-service-name:/api/{v1,v2}/*",
+					},
+				},
+			},
+			expectedSpec: &v1alpha1.ServiceRole{
+				Rules: []*v1alpha1.AccessRule{
+					{
+						Methods: []string{
+							"GET",
+						},
+						Paths: []string{
+							"/api/v1/*",
+							"/api/v2/*",
+						},
+						Services: []string{WildCardAll},
+						Constraints: []*v1alpha1.AccessRule_Constraint{
+							{
+								Key: ConstraintSvcKey,
+								Values: []string{
+									"my-service-name",
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedErr: nil,
+		},
+		{
+			test: "assertions with an unsupported path are skipped",
+			input: input{
+				domainName: "athenz.domain",
+				roleName:   "client-reader-role",
+				assertions: []*zms.Assertion{
+					{
+						Effect:   &allow,
+						Action:   "get",
+						Role:     "athenz.domain:role.client-reader-role",
+						Resource: "athenz.domain:svc.my-service-name:/api/*/items",
+					},
+				},
+			},
+			expectedSpec: nil,
+			expectedErr:  fmt.Errorf("no rules found for the ServiceRole: client-reader-role"),
+		},
+		{
+			test: "deny assertions are skipped",
+			input: input{
+				domainName: "athenz.domain",
+				roleName:   "client-writer-role",
+				assertions: []*zms.Assertion{
+					{
+						Effect:   &deny,
+						Action:   "put",
+						Role:     "athenz.domain:role.client-writer-role",
+						Resource: "athenz.domain:svc.my-service-name",
+					},
+				},
+			},
+			expectedSpec: nil,
+			expectedErr:  fmt.Errorf("no rules found for the ServiceRole: client-writer-role"),
+		},
 	}
 
 	for _, c := range cases {
-		gotSpec, gotErr := GetServiceRoleSpec(c.input.domainName, c.input.roleName, c.input.assertions)
+		gotSpec, gotErr := GetServiceRoleSpec(c.input.domainName, c.input.roleName, c.input.assertions, nil)
 		assert.Equal(t, c.expectedSpec, gotSpec, c.test)
 		assert.Equal(t, c.expectedErr, gotErr, c.test)
 	}
@@ -10,17 +10,24 @@ import (
 	"istio.io/api/rbac/v1alpha1"
 )
 
-// GetServiceRoleBindingSpec returns the ServiceRoleBindingSpec for a given Athenz role and its members
-func GetServiceRoleBindingSpec(athenzDomainName string, roleName string, k8sRoleName string, members []*zms.RoleMember, enableOriginJwtSubject bool) (*v1alpha1.ServiceRoleBinding, error) {
+// GetServiceRoleBindingSpec returns the ServiceRoleBindingSpec for a given Athenz role and its members, the members
+// which cannot be converted are reported to the reporter
+func GetServiceRoleBindingSpec(athenzDomainName string, roleName string, k8sRoleName string, members []*zms.RoleMember, enableOriginJwtSubject bool, reporter *EventReporter) (*v1alpha1.ServiceRoleBinding, error) {
 
 	subjects := make([]*v1alpha1.Subject, 0)
 	for _, member := range members {
 
-		//TODO: handle member.Expiration for expired members, for now ignore expiration
+		// skip expired members, the controller schedules a sync at the next member expiration
+		if _, err := CheckAthenzMemberExpiry(member); err != nil {
+			log.Infoln(err.Error())
+			reporter.InactiveMember(roleName, GetMemberName(member), err)
+			continue
+		}
 
 		spiffeName, err := MemberToSpiffe(member)
 		if err != nil {
 			log.Warningln(err.Error())
+			reporter.DroppedMember(roleName, GetMemberName(member), err)
 			continue
 		}
 
@@ -33,6 +40,7 @@ func GetServiceRoleBindingSpec(athenzDomainName string, roleName string, k8sRole
 			originJwtName, err := MemberToOriginJwtSubject(member)
 			if err != nil {
 				log.Warningln(err.Error())
+				reporter.DroppedMember(roleName, GetMemberName(member), err)
 				continue
 			}
 
@@ -6,7 +6,9 @@ package common
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/ardielle/ardielle-go/rdl"
 	"github.com/stretchr/testify/assert"
 	"github.com/yahoo/athenz/clients/go/zms"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
@@ -20,6 +22,9 @@ func init() {
 
 func TestGetServiceRoleBindingSpec(t *testing.T) {
 
+	expired := rdl.Timestamp{Time: time.Now().Add(-time.Hour)}
+	notExpired := rdl.Timestamp{Time: time.Now().Add(time.Hour)}
+
 	type input struct {
 		athenzDomainName       string
 		roleName               string
@@ -102,6 +107,40 @@ func TestGetServiceRoleBindingSpec(t *testing.T) {
 			},
 			expectedErr: nil,
 		},
+		{
+			test: "expired role member spec",
+			input: input{
+				athenzDomainName: "athenz.domain",
+				roleName:         "client-reader_role",
+				k8sRoleName:      "client-reader--role",
+				members: []*zms.RoleMember{
+					{
+						MemberName: "athenz.domain.client-serviceA",
+						Expiration: &expired,
+					},
+					{
+						MemberName: "user.athenzuser",
+						Expiration: &notExpired,
+					},
+				},
+				enableOriginJwtSubject: false,
+			},
+			expectedSpec: &v1alpha1.ServiceRoleBinding{
+				RoleRef: &v1alpha1.RoleRef{
+					Name: "client-reader--role",
+					Kind: ServiceRoleKind,
+				},
+				Subjects: []*v1alpha1.Subject{
+					{
+						User: "user/sa/athenzuser",
+					},
+					{
+						User: "athenz.domain/ra/client-reader_role",
+					},
+				},
+			},
+			expectedErr: nil,
+		},
 		{
 			test: "invalid role member spec",
 			input: input{
@@ -169,7 +208,7 @@ func TestGetServiceRoleBindingSpec(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		gotSpec, gotErr := GetServiceRoleBindingSpec(c.input.athenzDomainName, c.input.roleName, c.input.k8sRoleName, c.input.members, c.input.enableOriginJwtSubject)
+		gotSpec, gotErr := GetServiceRoleBindingSpec(c.input.athenzDomainName, c.input.roleName, c.input.k8sRoleName, c.input.members, c.input.enableOriginJwtSubject, nil)
 		assert.Equal(t, c.expectedSpec, gotSpec, c.test)
 		assert.Equal(t, c.expectedErr, gotErr, c.test)
 	}
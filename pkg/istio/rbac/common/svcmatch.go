@@ -0,0 +1,45 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+)
+
+// SvcMatchMode selects how the svc of an athenz assertion resource is matched with the svc label of a service
+type SvcMatchMode string
+
+const (
+	// SvcMatchGlob matches the whole svc label with the athenz glob of the svc, * matches any sequence of characters
+	// and ? a single character
+	SvcMatchGlob SvcMatchMode = "glob"
+	// SvcMatchLegacyRegex matches the svc label with the svc as an unanchored regular expression, svc.api matches
+	// api-admin and legacy-api as well
+	SvcMatchLegacyRegex SvcMatchMode = "legacy-regex"
+)
+
+// ParseSvcMatchMode parses the svc match mode from the command line argument
+func ParseSvcMatchMode(mode string) (SvcMatchMode, error) {
+	switch SvcMatchMode(mode) {
+	case SvcMatchGlob, SvcMatchLegacyRegex:
+		return SvcMatchMode(mode), nil
+	default:
+		return "", fmt.Errorf("svc match mode %s is not one of %s or %s", mode, SvcMatchGlob, SvcMatchLegacyRegex)
+	}
+}
+
+// Match reports whether the svc of an athenz assertion resource matches the svc label of a service, the svc is
+// matched as an athenz glob unless the mode is the legacy regex mode
+func (m SvcMatchMode) Match(svc string, svcLabel string) (bool, error) {
+	if m == SvcMatchLegacyRegex {
+		if svc == WildCardAll {
+			svc = ".*"
+		}
+		return regexp.MatchString(svc, svcLabel)
+	}
+	return athenz.MatchGlob(svc, svcLabel)
+}
@@ -0,0 +1,33 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSvcMatchMode(t *testing.T) {
+	for _, mode := range []SvcMatchMode{SvcMatchGlob, SvcMatchLegacyRegex} {
+		actual, err := ParseSvcMatchMode(string(mode))
+		assert.Nil(t, err, "svc match mode %s should be valid", mode)
+		assert.Equal(t, mode, actual)
+	}
+	_, err := ParseSvcMatchMode("regex")
+	assert.EqualError(t, err, "svc match mode regex is not one of glob or legacy-regex")
+}
+
+func TestSvcMatchModeMatch(t *testing.T) {
+	matched, err := SvcMatchGlob.Match("product(page", "product(page")
+	assert.Nil(t, err, "glob should escape the regular expression characters")
+	assert.True(t, matched)
+
+	_, err = SvcMatchLegacyRegex.Match("product(page", "product(page")
+	assert.NotNil(t, err, "legacy regex should return an error for an invalid regular expression")
+
+	matched, err = SvcMatchMode("").Match("api", "legacy-api")
+	assert.Nil(t, err)
+	assert.False(t, matched, "unset mode should match the glob")
+}
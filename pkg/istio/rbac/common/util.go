@@ -31,6 +31,9 @@ const (
 	AthenzJwtPrefix              = "athenz/"
 	RequestAuthPrincipalProperty = "request.auth.principal"
 	DryRunStoredFilesDirectory   = "/root/authzpolicy/"
+	// DenyAuthzPolicySuffix contains a dot, which service names cannot contain, so that the DENY authorization
+	// policy of a service never has the name of the ALLOW authorization policy of another service
+	DenyAuthzPolicySuffix = ".deny"
 )
 
 var supportedMethods = map[string]bool{
@@ -157,7 +160,12 @@ func CheckIfMemberIsAllUsersFromDomain(member interface{}, domainName zms.Domain
 		return "", nil
 	}
 
-	return athenz.DomainToNamespace(memberStr[0 : len(memberStr)-2]), nil
+	domain := memberStr[0 : len(memberStr)-2]
+	namespace := athenz.ResolveNamespace(domain)
+	if namespace == "" {
+		return "", fmt.Errorf("domain %s of member %s is not mapped to any namespace", domain, memberStr)
+	}
+	return namespace, nil
 }
 
 // MemberToSpiffe parses the Athenz role/group member into a SPIFFE compliant name.
@@ -223,7 +231,7 @@ func PrincipalToSpiffe(principal string) (string, error) {
 	return spiffeName, nil
 }
 
-// ParseAssertionEffect parses the effect of an assertion into a supported Istio RBAC action
+// ParseAssertionEffect parses the effect of an assertion into a supported Istio RBAC action (ALLOW or DENY)
 func ParseAssertionEffect(assertion *zms.Assertion) (string, error) {
 	if assertion == nil {
 		return "", fmt.Errorf("assertion is nil")
@@ -232,10 +240,13 @@ func ParseAssertionEffect(assertion *zms.Assertion) (string, error) {
 	if effect == nil {
 		return "", fmt.Errorf("assertion effect is nil")
 	}
-	if strings.ToUpper(effect.String()) != zms.ALLOW.String() {
-		return "", fmt.Errorf("effect: %s is not a supported assertion effect", effect)
+	switch strings.ToUpper(effect.String()) {
+	case zms.ALLOW.String():
+		return zms.ALLOW.String(), nil
+	case zms.DENY.String():
+		return zms.DENY.String(), nil
 	}
-	return zms.ALLOW.String(), nil
+	return "", fmt.Errorf("effect: %s is not a supported assertion effect", effect)
 }
 
 // ParseAssertionAction parses the action of an assertion into a supported Istio RBAC HTTP method
@@ -326,6 +337,19 @@ func ParseRoleFQDN(domainName zms.DomainName, roleFQDN string) (string, error) {
 	return roleName, nil
 }
 
+// DenyAuthzPolicyName returns the name of the companion DENY authorization policy generated for a service
+// e.g. productpage -> productpage.deny
+func DenyAuthzPolicyName(serviceName string) string {
+	return serviceName + DenyAuthzPolicySuffix
+}
+
+// GetServiceNameFromAuthzPolicy returns the name of the service the authorization policy is generated for.
+// The ALLOW authorization policy is named after the service, the companion DENY authorization policy has the
+// DenyAuthzPolicySuffix appended to the service name.
+func GetServiceNameFromAuthzPolicy(config model.Config) string {
+	return strings.TrimSuffix(config.Name, DenyAuthzPolicySuffix)
+}
+
 // NewConfig returns a new model.Config resource for the passed-in type with the given namespace/name and spec
 func NewConfig(schema collection.Schema, namespace string, name string, spec proto.Message) model.Config {
 	meta := model.ConfigMeta{
@@ -380,14 +404,15 @@ func ComputeChangeList(currentCRs []model.Config, desiredCRs []model.Config, cbH
 			continue
 		}
 
-		if !Equal(existingConfig, desiredConfig) {
+		if !Equal(existingConfig, desiredConfig) || !managedMetadataEqual(existingConfig, desiredConfig) {
 			// case 2: current CR is not empty, desired CR is not empty, current CR != desired CR, and additional check is not set or not true,
 			// results in resource update
 			if checkFn != nil && checkFn(existingConfig) {
 				continue
 			}
-			// copy metadata(for resource version) from current config to desired config
-			desiredConfig.ConfigMeta = existingConfig.ConfigMeta
+			// copy metadata(for resource version) from current config to desired config, keeping the managed
+			// label and annotations of the desired config
+			desiredConfig.ConfigMeta = mergeManagedMetadata(existingConfig.ConfigMeta, desiredConfig.ConfigMeta)
 			item := Item{
 				Operation:       model.EventUpdate,
 				Resource:        desiredConfig,
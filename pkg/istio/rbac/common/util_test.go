@@ -364,12 +364,12 @@ func TestParseAssertionEffect(t *testing.T) {
 			expectedErr:    nil,
 		},
 		{
-			test: "invalid(unsupported) effect",
+			test: "valid deny effect",
 			assertion: &zms.Assertion{
 				Effect: &deny,
 			},
-			expectedEffect: "",
-			expectedErr:    fmt.Errorf("effect: DENY is not a supported assertion effect"),
+			expectedEffect: "DENY",
+			expectedErr:    nil,
 		},
 	}
 
@@ -1186,6 +1186,44 @@ func getAuthzPolicyItem(action model.Event) Item {
 	return item
 }
 
+func TestGetServiceNameFromAuthzPolicy(t *testing.T) {
+	allowAP := getAuthzPolicyItem(model.EventAdd).Resource
+	allowAPNamedDeny := getAuthzPolicyItem(model.EventAdd).Resource
+	allowAPNamedDeny.Name = "onboarded-service-deny"
+	denyAP := getAuthzPolicyItem(model.EventAdd).Resource
+	denyAP.Name = DenyAuthzPolicyName("onboarded-service")
+	denyAP.Spec = &v1beta1.AuthorizationPolicy{
+		Action: v1beta1.AuthorizationPolicy_DENY,
+	}
+
+	cases := []struct {
+		test            string
+		input           model.Config
+		expectedService string
+	}{
+		{
+			test:            "allow authz policy",
+			input:           allowAP,
+			expectedService: "onboarded-service",
+		},
+		{
+			test:            "deny authz policy",
+			input:           denyAP,
+			expectedService: "onboarded-service",
+		},
+		{
+			test:            "allow authz policy of a service named with a deny suffix",
+			input:           allowAPNamedDeny,
+			expectedService: "onboarded-service-deny",
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expectedService, GetServiceNameFromAuthzPolicy(c.input), c.test)
+	}
+	assert.NotEqual(t, allowAPNamedDeny.Name, denyAP.Name, "deny authz policy name should not be a service name")
+}
+
 func TestParseComponentsEnabledAuthzPolicy(t *testing.T) {
 	type inputData struct {
 		description string
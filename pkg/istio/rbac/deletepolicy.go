@@ -0,0 +1,31 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package rbac
+
+import "fmt"
+
+// DomainDeletePolicy selects how the resources generated for an Athenz domain are handled once the domain is deleted
+type DomainDeletePolicy string
+
+const (
+	// DomainDeleteRetain leaves the generated resources in place
+	DomainDeleteRetain DomainDeletePolicy = "retain"
+	// DomainDeleteDelete deletes the generated resources
+	DomainDeleteDelete DomainDeletePolicy = "delete"
+	// DomainDeleteDenyAll replaces the generated resources with resources denying all access, for the v1 provider the
+	// service roles and service role bindings are deleted which denies all access to the services onboarded by the
+	// cluster rbac config, for the v2 provider an authorization policy without rules is kept for every authz enabled
+	// service
+	DomainDeleteDenyAll DomainDeletePolicy = "deny-all"
+)
+
+// ParseDomainDeletePolicy parses the domain delete policy from the command line argument
+func ParseDomainDeletePolicy(policy string) (DomainDeletePolicy, error) {
+	switch DomainDeletePolicy(policy) {
+	case DomainDeleteRetain, DomainDeleteDelete, DomainDeleteDenyAll:
+		return DomainDeletePolicy(policy), nil
+	default:
+		return "", fmt.Errorf("domain delete policy %s is not one of %s, %s or %s", policy, DomainDeleteRetain, DomainDeleteDelete, DomainDeleteDenyAll)
+	}
+}
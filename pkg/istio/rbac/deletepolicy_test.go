@@ -0,0 +1,47 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package rbac
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDomainDeletePolicy(t *testing.T) {
+	cases := []struct {
+		test        string
+		input       string
+		expected    DomainDeletePolicy
+		expectedErr error
+	}{
+		{
+			test:     "retain policy",
+			input:    "retain",
+			expected: DomainDeleteRetain,
+		},
+		{
+			test:     "delete policy",
+			input:    "delete",
+			expected: DomainDeleteDelete,
+		},
+		{
+			test:     "deny-all policy",
+			input:    "deny-all",
+			expected: DomainDeleteDenyAll,
+		},
+		{
+			test:        "invalid policy",
+			input:       "purge",
+			expectedErr: fmt.Errorf("domain delete policy purge is not one of retain, delete or deny-all"),
+		},
+	}
+
+	for _, c := range cases {
+		policy, err := ParseDomainDeletePolicy(c.input)
+		assert.Equal(t, c.expectedErr, err, c.test)
+		assert.Equal(t, c.expected, policy, c.test)
+	}
+}
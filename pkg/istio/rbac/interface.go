@@ -5,6 +5,7 @@ package rbac
 
 import (
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 
 	"istio.io/istio/pilot/pkg/model"
 )
@@ -13,7 +14,9 @@ type Provider interface {
 
 	// ConvertAthenzModelIntoIstioRbac converts the given Athenz model into a list of Istio type RBAC resources
 	// Any implementation should return exactly the same list of output resources for a given Athenz model
-	ConvertAthenzModelIntoIstioRbac(athenzModel athenz.Model, serviceName string, svcLabel, appLabel string) []model.Config
+	// The assertions and members which cannot be converted are reported to the reporter
+	// The selector holds the labels of the workloads of the service
+	ConvertAthenzModelIntoIstioRbac(athenzModel athenz.Model, serviceName string, svcLabel string, selector map[string]string, reporter *common.EventReporter) []model.Config
 
 	// GetCurrentIstioRbac returns the Istio RBAC custom resources associated with the given model
 	GetCurrentIstioRbac(model athenz.Model, csc model.ConfigStoreCache, serviceName string) []model.Config
@@ -0,0 +1,38 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package rbac
+
+import "fmt"
+
+// ProviderMode selects the Istio RBAC resources managed by the controllers
+type ProviderMode string
+
+const (
+	// ProviderV1 manages the rbac.istio.io ServiceRole, ServiceRoleBinding and ClusterRbacConfig resources
+	ProviderV1 ProviderMode = "v1"
+	// ProviderV2 manages the security.istio.io AuthorizationPolicy resources only
+	ProviderV2 ProviderMode = "v2"
+	// ProviderBoth manages both the v1 and the v2 resources
+	ProviderBoth ProviderMode = "both"
+)
+
+// ParseProviderMode parses the rbac provider mode from the command line argument
+func ParseProviderMode(mode string) (ProviderMode, error) {
+	switch ProviderMode(mode) {
+	case ProviderV1, ProviderV2, ProviderBoth:
+		return ProviderMode(mode), nil
+	default:
+		return "", fmt.Errorf("rbac provider %s is not one of %s, %s or %s", mode, ProviderV1, ProviderV2, ProviderBoth)
+	}
+}
+
+// V1Enabled returns true if the v1 ServiceRole, ServiceRoleBinding and ClusterRbacConfig resources are managed
+func (m ProviderMode) V1Enabled() bool {
+	return m == ProviderV1 || m == ProviderBoth
+}
+
+// V2Enabled returns true if the v2 AuthorizationPolicy resources are managed
+func (m ProviderMode) V2Enabled() bool {
+	return m == ProviderV2 || m == ProviderBoth
+}
@@ -0,0 +1,55 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package rbac
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProviderMode(t *testing.T) {
+	cases := []struct {
+		test        string
+		input       string
+		expected    ProviderMode
+		expectedV1  bool
+		expectedV2  bool
+		expectedErr error
+	}{
+		{
+			test:       "v1 provider",
+			input:      "v1",
+			expected:   ProviderV1,
+			expectedV1: true,
+		},
+		{
+			test:       "v2 provider",
+			input:      "v2",
+			expected:   ProviderV2,
+			expectedV2: true,
+		},
+		{
+			test:       "both providers",
+			input:      "both",
+			expected:   ProviderBoth,
+			expectedV1: true,
+			expectedV2: true,
+		},
+		{
+			test:        "invalid provider",
+			input:       "v3",
+			expectedErr: fmt.Errorf("rbac provider v3 is not one of v1, v2 or both"),
+		},
+	}
+
+	for _, c := range cases {
+		mode, err := ParseProviderMode(c.input)
+		assert.Equal(t, c.expectedErr, err, c.test)
+		assert.Equal(t, c.expected, mode, c.test)
+		assert.Equal(t, c.expectedV1, mode.V1Enabled(), c.test)
+		assert.Equal(t, c.expectedV2, mode.V2Enabled(), c.test)
+	}
+}
@@ -4,10 +4,14 @@
 package v1
 
 import (
+	"fmt"
+
+	"github.com/yahoo/athenz/clients/go/zms"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config/schema/collections"
 	"istio.io/istio/pkg/config/validation"
@@ -25,9 +29,9 @@ func NewProvider(enableOriginJwtSubject bool) rbac.Provider {
 }
 
 // ConvertAthenzModelIntoIstioRbac converts the Athenz RBAC model into the list of Istio Authorization V1 specific
-// RBAC custom resources (ServiceRoles, ServiceRoleBindings)
+// RBAC custom resources (ServiceRoles, ServiceRoleBindings), the roles with conditions are skipped
 // The idea is that with a given input model, the function should always return the same output list of resources
-func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model, _ string, _ string, _ string) []model.Config {
+func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model, _ string, _ string, _ map[string]string, reporter *common.EventReporter) []model.Config {
 
 	out := make([]model.Config, 0)
 
@@ -48,8 +52,27 @@ func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model, _ string, _ string,
 			continue
 		}
 
+		// ServiceRoles cannot express the conditions of a role, the role is skipped rather than granted the
+		// access of its assertions without the conditions
+		if len(m.Conditions[roleFQDN]) > 0 {
+			err := fmt.Errorf("conditions of role: %s are not supported for a ServiceRole", roleFQDN)
+			log.Debugln(err.Error())
+			for _, assertion := range assertions {
+				effect, effectErr := common.ParseAssertionEffect(assertion)
+				switch {
+				case effectErr != nil:
+					reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidEffect, effectErr)
+				case effect != zms.ALLOW.String():
+					reporter.SkippedAssertion(assertion, metrics.SkipReasonDenyUnsupported, fmt.Errorf("effect %s is not supported for a ServiceRole", effect))
+				default:
+					reporter.SkippedAssertion(assertion, metrics.SkipReasonInvalidCondition, err)
+				}
+			}
+			continue
+		}
+
 		// Transform the assertions for an Athenz Role into a ServiceRole spec
-		srSpec, err := common.GetServiceRoleSpec(m.Name, roleName, assertions)
+		srSpec, err := common.GetServiceRoleSpec(m.Name, roleName, assertions, reporter)
 		if err != nil {
 			log.Debugf("Error converting the assertions for role: %s to a ServiceRole: %s", roleName, err.Error())
 			continue
@@ -59,11 +82,13 @@ func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model, _ string, _ string,
 		err = validation.ValidateServiceRole(roleName, m.Namespace, srSpec)
 		if err != nil {
 			log.Warningf("Error validating the converted ServiceRole spec: %s for role: %s", err.Error(), roleName)
+			reporter.ValidationFailed(collections.IstioRbacV1Alpha1Serviceroles.Resource().Kind(), roleName, err)
 			continue
 		}
 
 		k8sRoleName := common.ConvertAthenzRoleNameToK8sName(roleName)
 		sr := common.NewConfig(collections.IstioRbacV1Alpha1Serviceroles, m.Namespace, k8sRoleName, srSpec)
+		common.SetManagedMetadata(&sr, string(m.Name), string(roleFQDN))
 		out = append(out, sr)
 
 		// Transform the members for an Athenz Role into a ServiceRoleBinding spec
@@ -73,7 +98,7 @@ func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model, _ string, _ string,
 			continue
 		}
 
-		srbSpec, err := common.GetServiceRoleBindingSpec(string(m.Name), roleName, k8sRoleName, roleMembers, p.enableOriginJwtSubject)
+		srbSpec, err := common.GetServiceRoleBindingSpec(string(m.Name), roleName, k8sRoleName, roleMembers, p.enableOriginJwtSubject, reporter)
 		if err != nil {
 			log.Debugf("Error converting the members for role: %s to a ServiceRoleBinding: %s", roleName, err.Error())
 			continue
@@ -83,10 +108,12 @@ func (p *v1) ConvertAthenzModelIntoIstioRbac(m athenz.Model, _ string, _ string,
 		err = validation.ValidateServiceRoleBinding(roleName, m.Namespace, srbSpec)
 		if err != nil {
 			log.Warningf("Error validating the converted ServiceRoleBinding spec: %s for role: %s", err.Error(), roleName)
+			reporter.ValidationFailed(collections.IstioRbacV1Alpha1Servicerolebindings.Resource().Kind(), roleName, err)
 			continue
 		}
 
 		srb := common.NewConfig(collections.IstioRbacV1Alpha1Servicerolebindings, m.Namespace, k8sRoleName, srbSpec)
+		common.SetManagedMetadata(&srb, string(m.Name), string(roleFQDN))
 		out = append(out, srb)
 	}
 
@@ -4,6 +4,7 @@
 package v1
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,6 +15,7 @@ import (
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
 
 	"istio.io/api/rbac/v1alpha1"
 	"istio.io/istio/pilot/pkg/config/memory"
@@ -758,12 +760,68 @@ func TestConvertAthenzModelIntoIstioRbac(t *testing.T) {
 	for _, c := range cases {
 		t.Run(c.test, func(t *testing.T) {
 			p := NewProvider(c.enableOriginJwtSubject)
-			gotConfigs := p.ConvertAthenzModelIntoIstioRbac(c.model, "", "", "")
+			gotConfigs := p.ConvertAthenzModelIntoIstioRbac(c.model, "", "", nil, nil)
+			// the managed metadata is verified separately from the expected configs
+			for i := range gotConfigs {
+				assert.True(t, common.IsManaged(gotConfigs[i]), c.test)
+				assert.Equal(t, string(c.model.Name), gotConfigs[i].Annotations[common.SourceDomainAnnotation], c.test)
+				assert.True(t, strings.HasPrefix(gotConfigs[i].Annotations[common.SourceRoleAnnotation], string(c.model.Name)+":role."), c.test)
+				gotConfigs[i].Labels = nil
+				gotConfigs[i].Annotations = nil
+			}
 			assert.EqualValues(t, c.expectedConfigs, gotConfigs, c.test)
 		})
 	}
 }
 
+func TestConvertAthenzModelIntoIstioRbacWithConditions(t *testing.T) {
+	allow, deny := zms.ALLOW, zms.DENY
+	readerRole := zms.ResourceName("athenz.domain:role.reader")
+	m := athenz.Model{
+		Name:      "athenz.domain",
+		Namespace: "athenz-domain",
+		Roles:     []zms.ResourceName{readerRole},
+		Rules: map[zms.ResourceName][]*zms.Assertion{
+			readerRole: {
+				{
+					Effect:   &allow,
+					Action:   "get",
+					Role:     string(readerRole),
+					Resource: "athenz.domain:svc.my-service-name",
+				},
+				{
+					Effect:   &deny,
+					Action:   "delete",
+					Role:     string(readerRole),
+					Resource: "athenz.domain:svc.my-service-name",
+				},
+				{
+					Action:   "put",
+					Role:     string(readerRole),
+					Resource: "athenz.domain:svc.my-service-name",
+				},
+			},
+		},
+		Members: map[zms.ResourceName][]*zms.RoleMember{
+			readerRole: {{MemberName: "user.athenzuser"}},
+		},
+		Conditions: athenz.RoleConditions{
+			readerRole: []athenz.Condition{{Key: "source.ip", Values: []string{"10.0.0.0/8"}}},
+		},
+	}
+
+	// the role with conditions is not converted without them
+	reporter := common.NewEventReporter(nil)
+	gotConfigs := NewProvider(true).ConvertAthenzModelIntoIstioRbac(m, "", "", nil, reporter)
+	assert.Empty(t, gotConfigs, "role with conditions should be skipped")
+	skippedAssertions, _ := reporter.Skipped()
+	assert.Len(t, skippedAssertions, 3, "assertions of the role with conditions should be reported")
+	assert.Equal(t, metrics.SkipReasonInvalidCondition, skippedAssertions[0].Reason, "skip reason should be equal")
+	assert.Equal(t, metrics.SkipReasonDenyUnsupported, skippedAssertions[1].Reason, "skip reason of the deny assertion should be equal")
+	assert.Equal(t, metrics.SkipReasonInvalidEffect, skippedAssertions[2].Reason, "skip reason of the assertion without effect should be equal")
+	assert.Equal(t, "assertion effect is nil", skippedAssertions[2].Message, "skip message of the assertion without effect should be equal")
+}
+
 func newCache() model.ConfigStoreCache {
 	configDescriptor := collection.SchemasFor(collections.IstioRbacV1Alpha1Serviceroles, collections.IstioRbacV1Alpha1Clusterrbacconfigs, collections.IstioRbacV1Alpha1Servicerolebindings)
 
@@ -4,9 +4,10 @@
 package v2
 
 import (
-	"regexp"
+	"errors"
+	"fmt"
+	"os"
 	"sort"
-	"strings"
 
 	"github.com/yahoo/athenz/clients/go/zms"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
 	"istio.io/api/security/v1beta1"
 	workloadv1beta1 "istio.io/api/type/v1beta1"
 	"istio.io/istio/pilot/pkg/model"
@@ -24,41 +26,26 @@ import (
 type v2 struct {
 	componentEnabledAuthzPolicy *common.ComponentEnabled
 	enableOriginJwtSubject      bool
+	options                     common.ConversionOptions
 }
 
-func NewProvider(componentEnabledAuthzPolicy *common.ComponentEnabled, enableOriginJwtSubject bool) rbac.Provider {
+func NewProvider(componentEnabledAuthzPolicy *common.ComponentEnabled, enableOriginJwtSubject bool, options common.ConversionOptions) rbac.Provider {
 	return &v2{
 		componentEnabledAuthzPolicy: componentEnabledAuthzPolicy,
 		enableOriginJwtSubject:      enableOriginJwtSubject,
+		options:                     options,
 	}
 }
 
-// Regex for finding if the HTTP path contains a query parameter
-var queryRegex = regexp.MustCompile(`.*\?.*`)
-
 // ConvertAthenzModelIntoIstioRbac converts the Athenz RBAC model into Istio Authorization V1Beta1 specific
-// RBAC custom resource (AuthorizationPolicy)
-func (p *v2) ConvertAthenzModelIntoIstioRbac(athenzModel athenz.Model, serviceName string, svcLabel, appLabel string) []model.Config {
+// RBAC custom resource (AuthorizationPolicy). An ALLOW authorization policy is always returned for the service,
+// a companion DENY authorization policy is returned when the Athenz domain defines DENY assertions for the service.
+func (p *v2) ConvertAthenzModelIntoIstioRbac(athenzModel athenz.Model, serviceName string, svcLabel string, matchLabels map[string]string, reporter *common.EventReporter) []model.Config {
 	// authz policy is created per service. each rule is created by each role, and form the rules under
 	// this authz policy.
-	var out model.Config
-	// form authorization config meta
-	// namespace: service's namespace
-	// name: service's name
-	schema := collections.IstioSecurityV1Beta1Authorizationpolicies
-	out.ConfigMeta = model.ConfigMeta{
-		Type:      schema.Resource().Kind(),
-		Group:     schema.Resource().Group(),
-		Version:   schema.Resource().Version(),
-		Namespace: athenzModel.Namespace,
-		Name:      serviceName,
-	}
-
-	// matching label, same with the service label
-	spec := &v1beta1.AuthorizationPolicy{}
-
-	spec.Selector = &workloadv1beta1.WorkloadSelector{
-		MatchLabels: map[string]string{"app": appLabel},
+	// matching labels, derived from the service selector
+	selector := &workloadv1beta1.WorkloadSelector{
+		MatchLabels: matchLabels,
 	}
 
 	// sort athenzModel.Rules map based on alphabetical order of key's name (role's name)
@@ -71,201 +58,352 @@ func (p *v2) ConvertAthenzModelIntoIstioRbac(athenzModel athenz.Model, serviceNa
 	sort.Strings(roleList)
 
 	// generating rules, iterate through assertions, find the one match with desired format.
-	var rules []*v1beta1.Rule
+	var allowRules, denyRules []*v1beta1.Rule
+	var allowRoles, denyRoles []string
 	for _, roleKey := range roleList {
 		role := zms.ResourceName(roleKey)
 		assertions := athenzModel.Rules[role]
-		rule := &v1beta1.Rule{}
+		var allowTo, denyTo []*v1beta1.Rule_To
+		// assertions with conditions are converted into rules of their own since the conditions apply to the rule
+		var allowWhen, denyWhen []*AssertionRule
+		// form rule_to array by appending the assertions matching with the service
 		for _, assert := range assertions {
-			// form rule_to array by appending matching assertions.
-			// assert.Resource contains the svc information that needs to parse and match
-			svc, path, err := common.ParseAssertionResource(athenzModel.Name, assert)
-			if err != nil {
-				continue
-			}
-
-			if svc == "*" {
-				svc = ".*"
-			}
-
-			// Drop the query parameters from the HTTP path in the assertions due to the difference
-			// in the RBAC Envoy permissions config created by Authorization Policy and ServiceRole/ServiceRoleBindings.
-			// Which in case of,
-			// Authorization Policy - is created with a url_path object
-			// ServiceRole/ServiceRoleBindings - is created with a header object
-			if queryRegex.MatchString(path) {
-				pathArr := strings.Split(path, "?")
-				path = pathArr[0]
-			}
-
-			// if svc match with current svc, process it and add it to the rules
-			// note that svc defined on athenz can be a regex, need to match the pattern
-			res, err := regexp.MatchString(svc, svcLabel)
-			if err != nil {
-				log.Errorln("error matching string: ", err.Error())
-				continue
-			}
-			if !res {
-				log.Debugf("athenz svc %s does not match with current svc %s", svc, svcLabel)
-				continue
-			}
-			// form rules_to
-			_, err = common.ParseAssertionEffect(assert)
+			rule, reason, err := ParseAssertion(athenzModel, assert, svcLabel, p.options)
 			if err != nil {
 				log.Debugf(err.Error())
+				reporter.SkippedAssertion(assert, reason, err)
 				continue
 			}
-			method, err := common.ParseAssertionAction(assert)
-			if err != nil {
-				log.Debugf(err.Error())
+			if rule == nil {
 				continue
 			}
-			// form rule.To
-			to := &v1beta1.Rule_To{
-				Operation: &v1beta1.Operation{
-					Methods: []string{method},
-				},
+			if rule.Broadened != nil {
+				log.Warningf("Broadened deny assertion %s on %s for role %s: %s", assert.Action, assert.Resource, assert.Role, rule.Broadened.Error())
+				reporter.BroadenedAssertion(assert, rule.Broadened)
 			}
-			if path != "" {
-				to.Operation.Paths = []string{path}
+			switch {
+			case rule.Effect == zms.DENY.String() && rule.When != nil:
+				denyWhen = append(denyWhen, rule)
+			case rule.Effect == zms.DENY.String():
+				denyTo = append(denyTo, rule.To)
+			case rule.When != nil:
+				allowWhen = append(allowWhen, rule)
+			default:
+				allowTo = append(allowTo, rule.To)
 			}
-			rule.To = append(rule.To, to)
 		}
 
 		// group by role, for each role, form rule_from from role members,
-		// skip if rule.To is nil, indicating no assertion match with service
-		if rule.To == nil {
+		// skip if there are no allow and deny operations, indicating no assertion match with service
+		if allowTo == nil && denyTo == nil && allowWhen == nil && denyWhen == nil {
 			continue
 		}
-		from_principal := &v1beta1.Rule_From{
-			Source: &v1beta1.Source{},
+
+		from, err := p.getRuleFrom(athenzModel, role, reporter)
+		if err != nil {
+			log.Debugln(err.Error())
+			continue
 		}
-		from_requestPrincipal := &v1beta1.Rule_From{
-			Source: &v1beta1.Source{},
+
+		if allowTo != nil || allowWhen != nil {
+			allowRules = append(allowRules, getRoleRules(from, allowTo, allowWhen)...)
+			allowRoles = append(allowRoles, roleKey)
 		}
-		from_namespace := &v1beta1.Rule_From{
-			Source: &v1beta1.Source{},
+		if denyTo != nil || denyWhen != nil {
+			denyRules = append(denyRules, getRoleRules(from, denyTo, denyWhen)...)
+			denyRoles = append(denyRoles, roleKey)
 		}
+	}
 
-		// role name should match zms resource name
-		for _, roleMember := range athenzModel.Members[role] {
-			var members []interface{}
-			roleflag := false
-
-			// Check to see in the roleMember is a group in Athenz
-			// - If is a group add all the members of the group to the
-			// member array.
-			// - If not add only the original roleMember
-			if _, ok := athenzModel.GroupMembers[roleMember.MemberName]; ok {
-				for _, groupMember := range athenzModel.GroupMembers[roleMember.MemberName] {
-					members = append(members, groupMember)
-				}
-			} else {
-				members = append(members, roleMember)
-				roleflag = true
-			}
+	out := []model.Config{
+		newAuthzPolicy(athenzModel, serviceName, allowRoles, &v1beta1.AuthorizationPolicy{
+			Selector: selector,
+			Rules:    allowRules,
+		}),
+	}
 
-			// In both the cases - Role and Groups Members first check the
-			// if the role has not yet expired or the role is not system disabled
-			res, err := common.CheckAthenzMemberExpiry(roleMember)
-			if err != nil {
-				log.Errorf("error when checking athenz member expiration date, skipping current member: %s, error: %s", roleMember.MemberName, err)
-				continue
-			}
-			if !res {
-				log.Infoln("member expired, skip adding member to authz policy resource, member: ", roleMember.MemberName)
-				continue
-			}
+	// the deny authz policy is only generated if there are deny assertions matching with the service,
+	// otherwise it is omitted and an existing one is cleaned up by the change list computation
+	if len(denyRules) > 0 {
+		out = append(out, newAuthzPolicy(athenzModel, common.DenyAuthzPolicyName(serviceName), denyRoles, &v1beta1.AuthorizationPolicy{
+			Selector: selector,
+			Rules:    denyRules,
+			Action:   v1beta1.AuthorizationPolicy_DENY,
+		}))
+	}
+	return out
+}
 
-			res, err = common.CheckAthenzSystemDisabled(roleMember)
-			if err != nil {
-				log.Errorf("error when checking athenz member system disabled, skipping current member: %s, error: %s", roleMember.MemberName, err)
-				continue
+// getRoleRules returns the rules of a role, the operations without conditions are grouped into one rule and each
+// assertion with conditions is converted into a rule of its own
+func getRoleRules(from []*v1beta1.Rule_From, to []*v1beta1.Rule_To, conditional []*AssertionRule) []*v1beta1.Rule {
+	var rules []*v1beta1.Rule
+	if to != nil {
+		rules = append(rules, &v1beta1.Rule{From: from, To: to})
+	}
+	for _, rule := range conditional {
+		rules = append(rules, &v1beta1.Rule{From: from, To: []*v1beta1.Rule_To{rule.To}, When: rule.When})
+	}
+	return rules
+}
+
+// AssertionRule is an athenz assertion converted into the operation of an authorization policy rule
+type AssertionRule struct {
+	To *v1beta1.Rule_To
+	// When are the conditions of the rule, an assertion with conditions is converted into a rule of its own
+	When []*v1beta1.Condition
+	// Effect is ALLOW or DENY
+	Effect string
+	// Broadened is the error of a DENY assertion which cannot be converted exactly, the rule is broadened instead
+	// of skipped so that the assertion never fails open
+	Broadened error
+}
+
+// ParseAssertion converts the assertion into the operation of an authorization policy rule of the service with the
+// given svc label, nil is returned if the assertion is for another service. The skip reason is returned with the
+// error of an assertion which cannot be converted. A DENY assertion with a path which cannot be translated or of a
+// role with an invalid condition is not skipped, it denies the method on all the paths of the svc or without the
+// role conditions respectively. The assertion is matched with the service and converted with the given options.
+func ParseAssertion(athenzModel athenz.Model, assertion *zms.Assertion, svcLabel string, options common.ConversionOptions) (*AssertionRule, string, error) {
+	// assertion.Resource contains the svc information that needs to parse and match
+	svc, path, err := common.ParseAssertionResource(athenzModel.Name, assertion)
+	if err != nil {
+		return nil, metrics.SkipReasonInvalidResource, err
+	}
+
+	// The paths of the authorization policy operation are matched with the url_path of the request, which does not
+	// contain the query string unlike the :path header matched by the ServiceRoles. The query string is split from
+	// the path and converted according to the query string mode.
+	fullPath := path
+	path, query := common.SplitQueryString(path)
+
+	// if svc match with current svc, process it and add it to the rules
+	// note that svc defined on athenz can be a glob, need to match the pattern
+	res, err := options.SvcMatchMode.Match(svc, svcLabel)
+	if err != nil {
+		return nil, metrics.SkipReasonInvalidResource, fmt.Errorf("error matching svc %s: %s", svc, err.Error())
+	}
+	if !res {
+		log.Debugf("athenz svc %s does not match with current svc %s", svc, svcLabel)
+		return nil, "", nil
+	}
+
+	effect, err := common.ParseAssertionEffect(assertion)
+	if err != nil {
+		return nil, metrics.SkipReasonInvalidEffect, err
+	}
+	method, err := common.ParseAssertionAction(assertion)
+	if err != nil {
+		return nil, metrics.SkipReasonInvalidAction, err
+	}
+	mode := options.QueryStringMode
+	if query != "" && mode == common.QueryStringDeny && effect == zms.ALLOW.String() {
+		return nil, metrics.SkipReasonQueryStringUnreviewed, fmt.Errorf("assertion with the query string of path: %s is denied until reviewed", fullPath)
+	}
+	var paths []string
+	var broadened error
+	if path != "" {
+		paths, err = common.TranslatePath(path)
+		if err != nil && effect == zms.DENY.String() {
+			paths, broadened = nil, fmt.Errorf("%s, denying all the paths of the svc", err.Error())
+		} else if err != nil {
+			return nil, metrics.SkipReasonInvalidPath, err
+		}
+	}
+	var when []*v1beta1.Condition
+	// the query string only narrows the ALLOW assertions, the DENY assertions are converted with the query string
+	// dropped in every mode, otherwise a request could escape the deny by adding or reordering query parameters
+	if query != "" && mode != common.QueryStringDrop && effect == zms.ALLOW.String() {
+		values, err := common.TranslatePath(fullPath)
+		if err != nil {
+			return nil, metrics.SkipReasonInvalidPath, err
+		}
+		when = append(when, &v1beta1.Condition{Key: common.ConditionPathHeaderKey, Values: values})
+	}
+	// the conditions of the role apply to all its assertions, the DENY assertions of a role with an invalid
+	// condition are converted without any of the role conditions
+	var roleWhen []*v1beta1.Condition
+	for _, condition := range athenzModel.Conditions[zms.ResourceName(assertion.Role)] {
+		c, err := common.ConvertCondition(condition)
+		if err != nil && effect == zms.DENY.String() {
+			message := fmt.Sprintf("role: %s %s, denying without the role conditions", assertion.Role, err.Error())
+			if broadened != nil {
+				message = broadened.Error() + ", " + message
 			}
-			if !res {
-				log.Infoln("member expired, skip adding member to authz policy resource, member: ", roleMember.MemberName)
-				continue
+			roleWhen, broadened = nil, errors.New(message)
+			break
+		} else if err != nil {
+			return nil, metrics.SkipReasonInvalidCondition, fmt.Errorf("role: %s %s", assertion.Role, err.Error())
+		}
+		roleWhen = append(roleWhen, c)
+	}
+	when = append(when, roleWhen...)
+
+	// form rule.To
+	to := &v1beta1.Rule_To{
+		Operation: &v1beta1.Operation{
+			Methods: []string{method},
+			Paths:   paths,
+		},
+	}
+	return &AssertionRule{To: to, When: when, Effect: effect, Broadened: broadened}, "", nil
+}
+
+// newAuthzPolicy returns the authorization policy model.Config with the given name and spec in the namespace of the
+// athenz model, stamped as managed and generated from the given roles
+func newAuthzPolicy(athenzModel athenz.Model, name string, roles []string, spec *v1beta1.AuthorizationPolicy) model.Config {
+	// form authorization config meta
+	// namespace: service's namespace
+	// name: service's name
+	schema := collections.IstioSecurityV1Beta1Authorizationpolicies
+	config := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:      schema.Resource().Kind(),
+			Group:     schema.Resource().Group(),
+			Version:   schema.Resource().Version(),
+			Namespace: athenzModel.Namespace,
+			Name:      name,
+		},
+		Spec: spec,
+	}
+	common.SetManagedMetadata(&config, string(athenzModel.Name), roles...)
+	return config
+}
+
+// getRuleFrom converts the members of the role into the rule sources of an authorization policy rule, the members
+// which cannot be converted are reported to the reporter
+func (p *v2) getRuleFrom(athenzModel athenz.Model, role zms.ResourceName, reporter *common.EventReporter) ([]*v1beta1.Rule_From, error) {
+	from_principal := &v1beta1.Rule_From{
+		Source: &v1beta1.Source{},
+	}
+	from_requestPrincipal := &v1beta1.Rule_From{
+		Source: &v1beta1.Source{},
+	}
+	from_namespace := &v1beta1.Rule_From{
+		Source: &v1beta1.Source{},
+	}
+
+	// role name should match zms resource name
+	for _, roleMember := range athenzModel.Members[role] {
+		var members []interface{}
+		roleflag := false
+
+		// Check to see in the roleMember is a group in Athenz
+		// - If is a group add all the members of the group to the
+		// member array.
+		// - If not add only the original roleMember
+		if _, ok := athenzModel.GroupMembers[roleMember.MemberName]; ok {
+			for _, groupMember := range athenzModel.GroupMembers[roleMember.MemberName] {
+				members = append(members, groupMember)
 			}
+		} else {
+			members = append(members, roleMember)
+			roleflag = true
+		}
 
-			for _, member := range members {
-				// This is only done for Group Members to check the expiry and system disabled
-				// at a Group Member level after doing a check for the entire role
-				if !roleflag {
-					res, err := common.CheckAthenzMemberExpiry(member)
-					if err != nil {
-						log.Errorf("error when checking athenz member expiration date, skipping current member: %s, error: %s", common.GetMemberName(member), err)
-						continue
-					}
-					if !res {
-						log.Infoln("member expired, skip adding member to authz policy resource, member: ", common.GetMemberName(member))
-						continue
-					}
-
-					res, err = common.CheckAthenzSystemDisabled(member)
-					if err != nil {
-						log.Errorf("error when checking athenz member system disabled, skipping current member: %s, error: %s", common.GetMemberName(member), err)
-						continue
-					}
-					if !res {
-						log.Infoln("member expired, skip adding member to authz policy resource, member: ", common.GetMemberName(member))
-						continue
-					}
-				}
+		// In both the cases - Role and Groups Members first check the
+		// if the role has not yet expired or the role is not system disabled
+		res, err := common.CheckAthenzMemberExpiry(roleMember)
+		if err != nil {
+			log.Errorf("error when checking athenz member expiration date, skipping current member: %s, error: %s", roleMember.MemberName, err)
+			reporter.InactiveMember(string(role), string(roleMember.MemberName), err)
+			continue
+		}
+		if !res {
+			log.Infoln("member expired, skip adding member to authz policy resource, member: ", roleMember.MemberName)
+			continue
+		}
+
+		res, err = common.CheckAthenzSystemDisabled(roleMember)
+		if err != nil {
+			log.Errorf("error when checking athenz member system disabled, skipping current member: %s, error: %s", roleMember.MemberName, err)
+			reporter.InactiveMember(string(role), string(roleMember.MemberName), err)
+			continue
+		}
+		if !res {
+			log.Infoln("member expired, skip adding member to authz policy resource, member: ", roleMember.MemberName)
+			continue
+		}
 
-				namespace, err := common.CheckIfMemberIsAllUsersFromDomain(member, athenzModel.Name)
+		for _, member := range members {
+			// This is only done for Group Members to check the expiry and system disabled
+			// at a Group Member level after doing a check for the entire role
+			if !roleflag {
+				res, err := common.CheckAthenzMemberExpiry(member)
 				if err != nil {
-					log.Errorln("error checking if role member is all users in an Athenz domain: ", err.Error())
+					log.Errorf("error when checking athenz member expiration date, skipping current member: %s, error: %s", common.GetMemberName(member), err)
+					reporter.InactiveMember(string(role), common.GetMemberName(member), err)
 					continue
 				}
-				if namespace != "" {
-					from_namespace.Source.Namespaces = append(from_namespace.Source.Namespaces, namespace)
+				if !res {
+					log.Infoln("member expired, skip adding member to authz policy resource, member: ", common.GetMemberName(member))
 					continue
 				}
 
-				spiffeName, err := common.MemberToSpiffe(member)
+				res, err = common.CheckAthenzSystemDisabled(member)
 				if err != nil {
-					log.Errorln("error converting role member to spiffeName: ", err.Error())
+					log.Errorf("error when checking athenz member system disabled, skipping current member: %s, error: %s", common.GetMemberName(member), err)
+					reporter.InactiveMember(string(role), common.GetMemberName(member), err)
+					continue
+				}
+				if !res {
+					log.Infoln("member expired, skip adding member to authz policy resource, member: ", common.GetMemberName(member))
 					continue
 				}
+			}
 
-				from_principal.Source.Principals = append(from_principal.Source.Principals, spiffeName)
-				if p.enableOriginJwtSubject {
-					originJwtName, err := common.MemberToOriginJwtSubject(member)
-					if err != nil {
-						log.Errorln(err.Error())
-						continue
-					}
-					from_requestPrincipal.Source.RequestPrincipals = append(from_requestPrincipal.Source.RequestPrincipals, originJwtName)
+			namespace, err := common.CheckIfMemberIsAllUsersFromDomain(member, athenzModel.Name)
+			if err != nil {
+				log.Errorln("error checking if role member is all users in an Athenz domain: ", err.Error())
+				reporter.DroppedMember(string(role), common.GetMemberName(member), err)
+				continue
+			}
+			if namespace != "" {
+				from_namespace.Source.Namespaces = append(from_namespace.Source.Namespaces, namespace)
+				continue
+			}
+
+			spiffeName, err := common.MemberToSpiffe(member)
+			if err != nil {
+				log.Errorln("error converting role member to spiffeName: ", err.Error())
+				reporter.DroppedMember(string(role), common.GetMemberName(member), err)
+				continue
+			}
+
+			from_principal.Source.Principals = append(from_principal.Source.Principals, spiffeName)
+			if p.enableOriginJwtSubject {
+				originJwtName, err := common.MemberToOriginJwtSubject(member)
+				if err != nil {
+					log.Errorln(err.Error())
+					reporter.DroppedMember(string(role), common.GetMemberName(member), err)
+					continue
 				}
+				from_requestPrincipal.Source.RequestPrincipals = append(from_requestPrincipal.Source.RequestPrincipals, originJwtName)
 			}
 		}
+	}
 
-		// Extract only the role name from the <domain>:role.<roleName> format
-		roleName, err := common.ParseRoleFQDN(athenzModel.Name, string(role))
-		if err != nil {
-			log.Debugln(err.Error())
-			continue
-		}
+	// Extract only the role name from the <domain>:role.<roleName> format
+	roleName, err := common.ParseRoleFQDN(athenzModel.Name, string(role))
+	if err != nil {
+		return nil, err
+	}
 
-		//add role spiffe for role certificate
-		roleSpiffeName, err := common.RoleToSpiffe(string(athenzModel.Name), string(roleName))
-		if err != nil {
-			log.Errorln("error when convert role to spiffe name: ", err.Error())
-			continue
-		}
-		from_principal.Source.Principals = append(from_principal.Source.Principals, roleSpiffeName)
-		rule.From = append(rule.From, from_principal)
-		if len(from_namespace.Source.Namespaces) > 0 {
-			rule.From = append(rule.From, from_namespace)
-		}
-		if p.enableOriginJwtSubject && len(from_requestPrincipal.Source.RequestPrincipals) > 0 {
-			rule.From = append(rule.From, from_requestPrincipal)
-		}
-		rules = append(rules, rule)
+	//add role spiffe for role certificate
+	roleSpiffeName, err := common.RoleToSpiffe(string(athenzModel.Name), string(roleName))
+	if err != nil {
+		return nil, fmt.Errorf("error when convert role to spiffe name: %s", err.Error())
+	}
+	from_principal.Source.Principals = append(from_principal.Source.Principals, roleSpiffeName)
+
+	from := []*v1beta1.Rule_From{from_principal}
+	if len(from_namespace.Source.Namespaces) > 0 {
+		from = append(from, from_namespace)
 	}
-	spec.Rules = rules
-	out.Spec = spec
-	return []model.Config{out}
+	if p.enableOriginJwtSubject && len(from_requestPrincipal.Source.RequestPrincipals) > 0 {
+		from = append(from, from_requestPrincipal)
+	}
+	return from, nil
 }
 
 // GetCurrentIstioRbac returns the authorization policies resources for the specified model's namespace
@@ -288,19 +426,40 @@ func (p *v2) GetCurrentIstioRbac(m athenz.Model, csc model.ConfigStoreCache, ser
 		return apList
 	}
 
-	// case when there is single service sync
+	// case when there is single service sync, fetch both the allow and deny authz policies of the service
 	if !p.componentEnabledAuthzPolicy.IsEnabled(serviceName, namespace) {
+		var out []model.Config
 		config, err := common.ReadConvertToModelConfig(serviceName, namespace, common.DryRunStoredFilesDirectory)
 		if err != nil {
 			log.Errorf("unable to convert local yaml file into model config object, error: %s", err)
 			return []model.Config{}
 		}
-		return []model.Config{*config}
+		out = append(out, *config)
+		denyName := common.DenyAuthzPolicyName(serviceName)
+		if _, err := os.Stat(common.DryRunStoredFilesDirectory + namespace + "/" + denyName + ".yaml"); err == nil {
+			config, err := common.ReadConvertToModelConfig(denyName, namespace, common.DryRunStoredFilesDirectory)
+			if err != nil {
+				log.Errorf("unable to convert local yaml file into model config object, error: %s", err)
+			} else {
+				out = append(out, *config)
+			}
+		}
+		return out
 	}
+
+	var out []model.Config
 	ap := csc.Get(collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind(), serviceName, namespace)
 	if ap != nil {
-		return []model.Config{*ap}
+		out = append(out, *ap)
+	} else {
+		log.Infof("authorization policy does not exist in the cache, name: %s, namespace: %s", serviceName, namespace)
+	}
+	denyAP := csc.Get(collections.IstioSecurityV1Beta1Authorizationpolicies.Resource().GroupVersionKind(), common.DenyAuthzPolicyName(serviceName), namespace)
+	if denyAP != nil {
+		out = append(out, *denyAP)
+	}
+	if out == nil {
+		return []model.Config{}
 	}
-	log.Infof("authorization policy does not exist in the cache, name: %s, namespace: %s", serviceName, namespace)
-	return []model.Config{}
+	return out
 }
@@ -13,6 +13,9 @@ import (
 	"github.com/yahoo/athenz/clients/go/zms"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
 	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/syncstatus"
 	fakev1 "github.com/yahoo/k8s-athenz-syncer/pkg/client/clientset/versioned/fake"
 	adInformer "github.com/yahoo/k8s-athenz-syncer/pkg/client/informers/externalversions/athenz/v1"
 	"istio.io/api/security/v1beta1"
@@ -22,6 +25,7 @@ import (
 	k8sv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -33,6 +37,10 @@ const (
 	usernameTwoInGroup         = "user.groupTwouser"
 )
 
+func init() {
+	log.InitLogger("", "debug")
+}
+
 var (
 	onboardedService = &k8sv1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -46,6 +54,11 @@ var (
 				"app": "productpage",
 			},
 		},
+		Spec: k8sv1.ServiceSpec{
+			Selector: map[string]string{
+				"app": "productpage",
+			},
+		},
 	}
 
 	undefinedAthenzRulesServiceWithAnnotationTrue = &k8sv1.Service{
@@ -60,6 +73,11 @@ var (
 				"app": "productpage",
 			},
 		},
+		Spec: k8sv1.ServiceSpec{
+			Selector: map[string]string{
+				"app": "productpage",
+			},
+		},
 	}
 )
 
@@ -120,16 +138,20 @@ func TestConvertAthenzModelIntoIstioRbac(t *testing.T) {
 		},
 	}
 
+	// the query strings of the onboarded domain paths are dropped, see TestConvertAthenzModelIntoIstioRbacWithQueryStrings
+	options := common.DefaultConversionOptions()
+	options.QueryStringMode = common.QueryStringDrop
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			athenzclientset := fakev1.NewSimpleClientset()
 			fakeAthenzInformer := adInformer.NewAthenzDomainInformer(athenzclientset, 0, cache.Indexers{})
 			labels := onboardedService.GetLabels()
-			domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(tt.inputAthenzDomain.Domain, &fakeAthenzInformer)
+			selector := onboardedService.Spec.Selector
+			domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(tt.inputAthenzDomain.Domain, &fakeAthenzInformer, athenz.DefaultMaxTrustDepth)
 			componentsEnabledAuthzPolicy, err := common.ParseComponentsEnabledAuthzPolicy("*")
 			assert.Equal(t, nil, err, "ParseComponentsEnabledAuthzPolicy func should not return nil")
-			p := NewProvider(componentsEnabledAuthzPolicy, true)
-			convertedAuthzPolicy := p.ConvertAthenzModelIntoIstioRbac(domainRBAC, tt.inputService.Name, labels["svc"], labels["app"])
+			p := NewProvider(componentsEnabledAuthzPolicy, true, options)
+			convertedAuthzPolicy := p.ConvertAthenzModelIntoIstioRbac(domainRBAC, tt.inputService.Name, labels["svc"], selector, nil)
 			configSpec := (convertedAuthzPolicy[0].Spec).(*v1beta1.AuthorizationPolicy)
 			sort.Slice(configSpec.Rules, func(i, j int) bool {
 				return configSpec.Rules[i].To[0].Operation.Methods[0] < configSpec.Rules[j].To[0].Operation.Methods[0]
@@ -141,6 +163,108 @@ func TestConvertAthenzModelIntoIstioRbac(t *testing.T) {
 	}
 }
 
+func TestConvertAthenzModelIntoIstioRbacWithDenyAssertions(t *testing.T) {
+	deny := zms.DENY
+	athenzDomain := getFakeNotOnboardedDomain(true, true, true, true)
+	athenzDomain.Domain.Policies.Contents.Policies[0].Assertions = append(athenzDomain.Domain.Policies.Contents.Policies[0].Assertions, &zms.Assertion{
+		Role:     domainName + ":role.onboarded-service-access",
+		Resource: domainName + ":svc.productpage:/admin",
+		Action:   "delete",
+		Effect:   &deny,
+	})
+
+	athenzclientset := fakev1.NewSimpleClientset()
+	fakeAthenzInformer := adInformer.NewAthenzDomainInformer(athenzclientset, 0, cache.Indexers{})
+	domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(athenzDomain.Domain, &fakeAthenzInformer, athenz.DefaultMaxTrustDepth)
+	componentsEnabledAuthzPolicy, err := common.ParseComponentsEnabledAuthzPolicy("*")
+	assert.Nil(t, err, "ParseComponentsEnabledAuthzPolicy func should not return error")
+	p := NewProvider(componentsEnabledAuthzPolicy, false, common.DefaultConversionOptions())
+	convertedAuthzPolicy := p.ConvertAthenzModelIntoIstioRbac(domainRBAC, onboardedService.Name, "productpage", map[string]string{"app": "productpage"}, nil)
+	assert.Equal(t, 2, len(convertedAuthzPolicy), "allow and deny authz policies should be generated")
+
+	from := []*v1beta1.Rule_From{
+		{
+			Source: &v1beta1.Source{
+				Principals: []string{
+					"user/sa/name",
+					"test.namespace/ra/onboarded-service-access",
+				},
+			},
+		},
+	}
+	expectedAllow := &v1beta1.AuthorizationPolicy{
+		Selector: &workloadv1beta1.WorkloadSelector{
+			MatchLabels: map[string]string{"app": "productpage"},
+		},
+		Rules: []*v1beta1.Rule{
+			{
+				From: from,
+				To: []*v1beta1.Rule_To{
+					{
+						Operation: &v1beta1.Operation{
+							Methods: []string{"POST"},
+						},
+					},
+				},
+			},
+		},
+	}
+	expectedDeny := &v1beta1.AuthorizationPolicy{
+		Selector: &workloadv1beta1.WorkloadSelector{
+			MatchLabels: map[string]string{"app": "productpage"},
+		},
+		Action: v1beta1.AuthorizationPolicy_DENY,
+		Rules: []*v1beta1.Rule{
+			{
+				From: from,
+				To: []*v1beta1.Rule_To{
+					{
+						Operation: &v1beta1.Operation{
+							Methods: []string{"DELETE"},
+							Paths:   []string{"/admin"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, "onboarded-service", convertedAuthzPolicy[0].Name, "allow authz policy name should be equal")
+	assert.Equal(t, expectedAllow, convertedAuthzPolicy[0].Spec, "allow authz policy spec should be equal")
+	assert.Equal(t, "onboarded-service.deny", convertedAuthzPolicy[1].Name, "deny authz policy name should be equal")
+	assert.Equal(t, "test-namespace", convertedAuthzPolicy[1].Namespace, "deny authz policy namespace should be equal")
+	assert.Equal(t, expectedDeny, convertedAuthzPolicy[1].Spec, "deny authz policy spec should be equal")
+}
+
+func TestConvertAthenzModelIntoIstioRbacRecordsEvents(t *testing.T) {
+	allow := zms.ALLOW
+	athenzDomain := getFakeNotOnboardedDomain(true, true, true, true)
+	athenzDomain.Domain.Policies.Contents.Policies[0].Assertions = append(athenzDomain.Domain.Policies.Contents.Policies[0].Assertions, &zms.Assertion{
+		Role:     domainName + ":role.onboarded-service-access",
+		Resource: domainName + ":svc.productpage:/admin",
+		Action:   "launch",
+		Effect:   &allow,
+	})
+
+	athenzclientset := fakev1.NewSimpleClientset()
+	fakeAthenzInformer := adInformer.NewAthenzDomainInformer(athenzclientset, 0, cache.Indexers{})
+	domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(athenzDomain.Domain, &fakeAthenzInformer, athenz.DefaultMaxTrustDepth)
+	componentsEnabledAuthzPolicy, err := common.ParseComponentsEnabledAuthzPolicy("*")
+	assert.Nil(t, err, "ParseComponentsEnabledAuthzPolicy func should not return error")
+	recorder := record.NewFakeRecorder(10)
+	reporter := common.NewEventReporter(recorder, common.ServiceReference(onboardedService.Namespace, onboardedService.Name), common.DomainReference(domainName))
+	p := NewProvider(componentsEnabledAuthzPolicy, false, common.DefaultConversionOptions())
+	p.ConvertAthenzModelIntoIstioRbac(domainRBAC, onboardedService.Name, "productpage", map[string]string{"app": "productpage"}, reporter)
+	reporter.RecordAssertions(syncstatus.SyncStatus{})
+
+	assert.Equal(t, 2, len(recorder.Events), "skipped assertion should be recorded on the service and the athenz domain")
+	for i := 0; i < 2; i++ {
+		assert.Contains(t, <-recorder.Events, "Warning "+common.ReasonSkippedAssertion, "event should have the skipped assertion reason")
+	}
+	skippedAssertions, _ := reporter.Skipped()
+	assert.Equal(t, 1, len(skippedAssertions), "skipped assertion should be collected for the sync status")
+}
+
 func getExpectedEmptyAuthzPolicy() []model.Config {
 	var out model.Config
 	schema := collections.IstioSecurityV1Beta1Authorizationpolicies
@@ -156,6 +280,7 @@ func getExpectedEmptyAuthzPolicy() []model.Config {
 		Name:              "onboarded-service",
 		CreationTimestamp: createTimestamp,
 	}
+	common.SetManagedMetadata(&out, "test.namespace")
 	out.Spec = &v1beta1.AuthorizationPolicy{
 		Selector: &workloadv1beta1.WorkloadSelector{
 			MatchLabels: map[string]string{"app": "productpage"},
@@ -166,6 +291,7 @@ func getExpectedEmptyAuthzPolicy() []model.Config {
 
 func getExpectedAuthzPolicyWithRole() []model.Config {
 	ap := getExpectedEmptyAuthzPolicy()
+	common.SetManagedMetadata(&ap[0], "test.namespace", "test.namespace:role.onboarded-service-access")
 
 	configSpec := (ap[0].Spec).(*v1beta1.AuthorizationPolicy)
 	configSpec.Rules = []*v1beta1.Rule{
@@ -209,6 +335,7 @@ func getExpectedAuthzPolicy() []model.Config {
 		Name:              "onboarded-service",
 		CreationTimestamp: createTimestamp,
 	}
+	common.SetManagedMetadata(&out, "test.namespace", "test.namespace:role.productpage-reader", "test.namespace:role.productpage-writer")
 	out.Spec = &v1beta1.AuthorizationPolicy{
 		Selector: &workloadv1beta1.WorkloadSelector{
 			MatchLabels: map[string]string{"app": "productpage"},
@@ -496,3 +623,339 @@ func getFakeNotOnboardedDomain(addRole, addRoleMember, addPolicy, addAssertion b
 
 	return domain
 }
+
+func TestParseAssertionSvcMatch(t *testing.T) {
+	allow := zms.ALLOW
+	athenzModel := athenz.Model{Name: domainName}
+	cases := []struct {
+		test     string
+		mode     common.SvcMatchMode
+		svc      string
+		svcLabel string
+		expected bool
+	}{
+		{test: "exact svc", mode: common.SvcMatchGlob, svc: "api", svcLabel: "api", expected: true},
+		{test: "glob is anchored at the end", mode: common.SvcMatchGlob, svc: "api", svcLabel: "api-admin", expected: false},
+		{test: "glob is anchored at the start", mode: common.SvcMatchGlob, svc: "api", svcLabel: "legacy-api", expected: false},
+		{test: "glob wildcard", mode: common.SvcMatchGlob, svc: "api*", svcLabel: "api-admin", expected: true},
+		{test: "glob single character", mode: common.SvcMatchGlob, svc: "api-v?", svcLabel: "api-v2", expected: true},
+		{test: "glob escapes the regular expression characters", mode: common.SvcMatchGlob, svc: "api.v1", svcLabel: "api-v1", expected: false},
+		{test: "glob wildcard all", mode: common.SvcMatchGlob, svc: "*", svcLabel: "api", expected: true},
+		{test: "legacy regex is unanchored", mode: common.SvcMatchLegacyRegex, svc: "api", svcLabel: "legacy-api", expected: true},
+		{test: "legacy regex wildcard all", mode: common.SvcMatchLegacyRegex, svc: "*", svcLabel: "api", expected: true},
+	}
+
+	for _, c := range cases {
+		assertion := &zms.Assertion{
+			Role:     domainName + ":role.reader",
+			Resource: domainName + ":svc." + c.svc,
+			Action:   "get",
+			Effect:   &allow,
+		}
+		rule, reason, err := ParseAssertion(athenzModel, assertion, c.svcLabel, common.ConversionOptions{SvcMatchMode: c.mode})
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, "", reason, c.test)
+		assert.Equal(t, c.expected, rule != nil, c.test)
+	}
+}
+
+func TestParseAssertionPath(t *testing.T) {
+	options := common.DefaultConversionOptions()
+	options.QueryStringMode = common.QueryStringDrop
+	allow := zms.ALLOW
+	athenzModel := athenz.Model{Name: domainName}
+	cases := []struct {
+		test           string
+		path           string
+		expectedPaths  []string
+		expectedReason string
+	}{
+		{test: "no path", path: ""},
+		{test: "exact path", path: ":/api/items", expectedPaths: []string{"/api/items"}},
+		{test: "prefix path", path: ":/data*", expectedPaths: []string{"/data*"}},
+		{test: "alternation", path: ":/api/{v1,v2}/*", expectedPaths: []string{"/api/v1/*", "/api/v2/*"}},
+		{test: "query string is dropped before the translation", path: ":/data*?user=admin", expectedPaths: []string{"/data*"}},
+		{test: "wildcard in the middle", path: ":/api/*/items", expectedReason: metrics.SkipReasonInvalidPath},
+	}
+
+	for _, c := range cases {
+		assertion := &zms.Assertion{
+			Role:     domainName + ":role.reader",
+			Resource: domainName + ":svc.api" + c.path,
+			Action:   "get",
+			Effect:   &allow,
+		}
+		rule, reason, err := ParseAssertion(athenzModel, assertion, "api", options)
+		assert.Equal(t, c.expectedReason, reason, c.test)
+		if c.expectedReason != "" {
+			assert.NotNil(t, err, c.test)
+			assert.Nil(t, rule, c.test)
+			continue
+		}
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, c.expectedPaths, rule.To.Operation.Paths, c.test)
+	}
+}
+
+func TestParseAssertionDenyPath(t *testing.T) {
+	deny := zms.DENY
+	athenzModel := athenz.Model{Name: domainName}
+	assertion := &zms.Assertion{
+		Role:     domainName + ":role.reader",
+		Resource: domainName + ":svc.api:/api/*/items",
+		Action:   "get",
+		Effect:   &deny,
+	}
+
+	// the deny assertion with a path which cannot be translated denies all the paths instead of being skipped
+	rule, reason, err := ParseAssertion(athenzModel, assertion, "api", common.DefaultConversionOptions())
+	assert.Nil(t, err, "deny assertion should not be skipped")
+	assert.Equal(t, "", reason, "deny assertion should not have a skip reason")
+	assert.NotNil(t, rule.Broadened, "deny assertion should be broadened")
+	assert.Equal(t, &v1beta1.Operation{Methods: []string{"GET"}}, rule.To.Operation, "deny rule should match all the paths")
+}
+
+func TestParseAssertionQueryString(t *testing.T) {
+	allow := zms.ALLOW
+	deny := zms.DENY
+	athenzModel := athenz.Model{Name: domainName}
+	cases := []struct {
+		test           string
+		mode           common.QueryStringMode
+		path           string
+		effect         *zms.AssertionEffect
+		expectedPaths  []string
+		expectedWhen   []*v1beta1.Condition
+		expectedReason string
+	}{
+		{
+			test:          "header mode matches the query string with the :path header",
+			mode:          common.QueryStringHeader,
+			path:          "/api?user=admin",
+			effect:        &allow,
+			expectedPaths: []string{"/api"},
+			expectedWhen:  []*v1beta1.Condition{{Key: common.ConditionPathHeaderKey, Values: []string{"/api?user=admin"}}},
+		},
+		{
+			test:          "header mode without query string",
+			mode:          common.QueryStringHeader,
+			path:          "/api",
+			effect:        &allow,
+			expectedPaths: []string{"/api"},
+		},
+		{
+			test:          "header mode with a query string wildcard",
+			mode:          common.QueryStringHeader,
+			path:          "/api?*",
+			effect:        &allow,
+			expectedPaths: []string{"/api"},
+			expectedWhen:  []*v1beta1.Condition{{Key: common.ConditionPathHeaderKey, Values: []string{"/api?*"}}},
+		},
+		{
+			test:          "header mode drops the query string of the deny assertions",
+			mode:          common.QueryStringHeader,
+			path:          "/api?debug=true",
+			effect:        &deny,
+			expectedPaths: []string{"/api"},
+		},
+		{
+			test:           "header mode with a path wildcard before the query string",
+			mode:           common.QueryStringHeader,
+			path:           "/api*?user=admin",
+			effect:         &allow,
+			expectedReason: metrics.SkipReasonInvalidPath,
+		},
+		{
+			test:          "drop mode drops the query string",
+			mode:          common.QueryStringDrop,
+			path:          "/api?user=admin",
+			effect:        &allow,
+			expectedPaths: []string{"/api"},
+		},
+		{
+			test:           "deny mode skips the allow assertions",
+			mode:           common.QueryStringDeny,
+			path:           "/api?user=admin",
+			effect:         &allow,
+			expectedReason: metrics.SkipReasonQueryStringUnreviewed,
+		},
+		{
+			test:          "drop mode drops the query string of the deny assertions",
+			mode:          common.QueryStringDrop,
+			path:          "/api?debug=true",
+			effect:        &deny,
+			expectedPaths: []string{"/api"},
+		},
+		{
+			test:          "deny mode drops the query string of the deny assertions",
+			mode:          common.QueryStringDeny,
+			path:          "/api?user=admin",
+			effect:        &deny,
+			expectedPaths: []string{"/api"},
+		},
+		{
+			test:          "deny mode without query string",
+			mode:          common.QueryStringDeny,
+			path:          "/api",
+			effect:        &allow,
+			expectedPaths: []string{"/api"},
+		},
+	}
+
+	for _, c := range cases {
+		assertion := &zms.Assertion{
+			Role:     domainName + ":role.reader",
+			Resource: domainName + ":svc.api:" + c.path,
+			Action:   "get",
+			Effect:   c.effect,
+		}
+		rule, reason, err := ParseAssertion(athenzModel, assertion, "api", common.ConversionOptions{QueryStringMode: c.mode})
+		assert.Equal(t, c.expectedReason, reason, c.test)
+		if c.expectedReason != "" {
+			assert.NotNil(t, err, c.test)
+			assert.Nil(t, rule, c.test)
+			continue
+		}
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, c.expectedPaths, rule.To.Operation.Paths, c.test)
+		assert.Equal(t, c.expectedWhen, rule.When, c.test)
+	}
+}
+
+func TestConvertAthenzModelIntoIstioRbacWithQueryStrings(t *testing.T) {
+	athenzclientset := fakev1.NewSimpleClientset()
+	fakeAthenzInformer := adInformer.NewAthenzDomainInformer(athenzclientset, 0, cache.Indexers{})
+	domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(getFakeOnboardedDomain().Domain, &fakeAthenzInformer, athenz.DefaultMaxTrustDepth)
+	componentsEnabledAuthzPolicy, err := common.ParseComponentsEnabledAuthzPolicy("*")
+	assert.Nil(t, err)
+	p := NewProvider(componentsEnabledAuthzPolicy, true, common.DefaultConversionOptions())
+
+	// the assertions with a query string are converted into rules of their own with a condition on the :path header
+	configs := p.ConvertAthenzModelIntoIstioRbac(domainRBAC, onboardedService.Name, onboardedService.Labels["svc"], onboardedService.Spec.Selector, nil)
+	rules := configs[0].Spec.(*v1beta1.AuthorizationPolicy).Rules
+	assert.Len(t, rules, 4, "each assertion with a query string should have its own rule")
+	writerFrom := rules[1].From
+	assert.Equal(t, []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"POST"}}}}, rules[1].To)
+	assert.Nil(t, rules[1].When)
+	for i, value := range []string{"/api/query?*", "/api/query?foo=bar&bar=foo"} {
+		rule := rules[i+2]
+		assert.Equal(t, writerFrom, rule.From, "rule should have the sources of the role")
+		assert.Equal(t, []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"POST"}, Paths: []string{"/api/query"}}}}, rule.To)
+		assert.Equal(t, []*v1beta1.Condition{{Key: common.ConditionPathHeaderKey, Values: []string{value}}}, rule.When)
+	}
+
+	// the assertions with a query string are skipped until reviewed in the deny mode
+	p = NewProvider(componentsEnabledAuthzPolicy, true, common.ConversionOptions{QueryStringMode: common.QueryStringDeny})
+	configs = p.ConvertAthenzModelIntoIstioRbac(domainRBAC, onboardedService.Name, onboardedService.Labels["svc"], onboardedService.Spec.Selector, nil)
+	rules = configs[0].Spec.(*v1beta1.AuthorizationPolicy).Rules
+	assert.Len(t, rules, 2, "assertions with a query string should be skipped")
+	assert.Equal(t, []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"POST"}}}}, rules[1].To)
+}
+
+func TestParseAssertionConditions(t *testing.T) {
+	allow := zms.ALLOW
+	readerRole := zms.ResourceName(domainName + ":role.reader")
+	cases := []struct {
+		test           string
+		path           string
+		conditions     []athenz.Condition
+		expectedWhen   []*v1beta1.Condition
+		expectedReason string
+	}{
+		{
+			test: "role without conditions",
+		},
+		{
+			test: "role conditions",
+			conditions: []athenz.Condition{
+				{Key: "request.headers[x-env]", Values: []string{"prod"}},
+				{Key: "source.ip", Values: []string{"10.0.0.0/8"}},
+			},
+			expectedWhen: []*v1beta1.Condition{
+				{Key: "request.headers[x-env]", Values: []string{"prod"}},
+				{Key: "source.ip", Values: []string{"10.0.0.0/8"}},
+			},
+		},
+		{
+			test:       "role conditions along with the query string condition",
+			path:       ":/api?user=admin",
+			conditions: []athenz.Condition{{Key: "destination.port", Values: []string{"8080"}}},
+			expectedWhen: []*v1beta1.Condition{
+				{Key: common.ConditionPathHeaderKey, Values: []string{"/api?user=admin"}},
+				{Key: "destination.port", Values: []string{"8080"}},
+			},
+		},
+		{
+			test:           "unsupported condition",
+			conditions:     []athenz.Condition{{Key: "connection.sni", Values: []string{"api"}}},
+			expectedReason: metrics.SkipReasonInvalidCondition,
+		},
+	}
+
+	for _, c := range cases {
+		athenzModel := athenz.Model{Name: domainName, Conditions: athenz.RoleConditions{readerRole: c.conditions}}
+		assertion := &zms.Assertion{
+			Role:     string(readerRole),
+			Resource: domainName + ":svc.api" + c.path,
+			Action:   "get",
+			Effect:   &allow,
+		}
+		rule, reason, err := ParseAssertion(athenzModel, assertion, "api", common.DefaultConversionOptions())
+		assert.Equal(t, c.expectedReason, reason, c.test)
+		if c.expectedReason != "" {
+			assert.NotNil(t, err, c.test)
+			assert.Nil(t, rule, c.test)
+			continue
+		}
+		assert.Nil(t, err, c.test)
+		assert.Equal(t, c.expectedWhen, rule.When, c.test)
+	}
+}
+
+func TestParseAssertionDenyConditions(t *testing.T) {
+	deny := zms.DENY
+	readerRole := zms.ResourceName(domainName + ":role.reader")
+	athenzModel := athenz.Model{
+		Name: domainName,
+		Conditions: athenz.RoleConditions{readerRole: []athenz.Condition{
+			{Key: "source.ip", Values: []string{"10.0.0.0/8"}},
+			{Key: "connection.sni", Values: []string{"api"}},
+		}},
+	}
+	assertion := &zms.Assertion{
+		Role:     string(readerRole),
+		Resource: domainName + ":svc.api",
+		Action:   "get",
+		Effect:   &deny,
+	}
+
+	// the deny assertion of a role with an invalid condition denies without any of the role conditions
+	rule, reason, err := ParseAssertion(athenzModel, assertion, "api", common.DefaultConversionOptions())
+	assert.Nil(t, err, "deny assertion should not be skipped")
+	assert.Equal(t, "", reason, "deny assertion should not have a skip reason")
+	assert.NotNil(t, rule.Broadened, "deny assertion should be broadened")
+	assert.Nil(t, rule.When, "deny rule should not have conditions")
+}
+
+func TestConvertAthenzModelIntoIstioRbacWithConditions(t *testing.T) {
+	athenzclientset := fakev1.NewSimpleClientset()
+	fakeAthenzInformer := adInformer.NewAthenzDomainInformer(athenzclientset, 0, cache.Indexers{})
+	domain := getFakeOnboardedDomain().Domain
+	for _, role := range domain.Roles {
+		if role.Name == domainName+":role.productpage-reader" {
+			role.Tags = map[zms.CompoundName]*zms.StringList{
+				athenz.ConditionTagPrefix + "request.headers.x-env": {List: []zms.CompoundName{"prod"}},
+			}
+		}
+	}
+	domainRBAC := athenz.ConvertAthenzPoliciesIntoRbacModel(domain, &fakeAthenzInformer, athenz.DefaultMaxTrustDepth)
+	componentsEnabledAuthzPolicy, err := common.ParseComponentsEnabledAuthzPolicy("*")
+	assert.Nil(t, err)
+	p := NewProvider(componentsEnabledAuthzPolicy, true, common.DefaultConversionOptions())
+
+	configs := p.ConvertAthenzModelIntoIstioRbac(domainRBAC, onboardedService.Name, onboardedService.Labels["svc"], onboardedService.Spec.Selector, nil)
+	rules := configs[0].Spec.(*v1beta1.AuthorizationPolicy).Rules
+	assert.Equal(t, []*v1beta1.Rule_To{{Operation: &v1beta1.Operation{Methods: []string{"GET"}}}}, rules[0].To)
+	assert.Equal(t, []*v1beta1.Condition{{Key: "request.headers[x-env]", Values: []string{"prod"}}}, rules[0].When, "rule should have the role conditions")
+	assert.Nil(t, rules[1].When, "rule of the role without conditions should not have conditions")
+}
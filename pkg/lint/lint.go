@@ -0,0 +1,223 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package lint
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/athenz"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+const (
+	// SeverityError findings are assertions skipped by the controller
+	SeverityError = "error"
+	// SeverityWarning findings are assertions converted with a broader meaning than in athenz
+	SeverityWarning = "warning"
+)
+
+// Rules of the findings
+const (
+	RuleInvalidRole         = "invalid_role"
+	RuleCrossDomainResource = "cross_domain_resource"
+	RuleMalformedResource   = "malformed_svc_resource"
+	RuleInvalidSvcPattern   = "invalid_svc_pattern"
+	RuleQueryStringDropped  = "query_string_dropped"
+	RuleQueryStringDenied   = "query_string_denied"
+	RuleUnsupportedPath     = "unsupported_path"
+	RuleUnsupportedEffect   = "unsupported_effect"
+	RuleUnsupportedAction   = "unsupported_action"
+	RuleInvalidCondition    = "invalid_condition"
+	// RuleDenyUnsupported and RuleUnsupportedCondition are only reported for the v1 rbac provider, whose
+	// ServiceRoles express neither DENY assertions nor role conditions
+	RuleDenyUnsupported      = "deny_unsupported"
+	RuleUnsupportedCondition = "unsupported_condition"
+)
+
+// Severities of the findings
+var Severities = []string{SeverityError, SeverityWarning}
+
+// Finding is an assertion which the controller cannot translate as defined in athenz
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Policy   string `json:"policy"`
+	// Assertion is the text of the assertion, e.g. ALLOW get to my.domain:role.reader on my.domain:svc.productpage
+	Assertion string `json:"assertion"`
+	Message   string `json:"message"`
+}
+
+// Report holds the findings of an athenz domain
+type Report struct {
+	Domain   string    `json:"domain"`
+	Findings []Finding `json:"findings"`
+}
+
+// Lint returns the report of the assertions of the athenz domain which are skipped or broadened by the conversion
+// into the istio rbac resources of the provider mode with the given options
+func Lint(domain *zms.DomainData, mode rbac.ProviderMode, options common.ConversionOptions) Report {
+	report := Report{Findings: []Finding{}}
+	if domain == nil {
+		return report
+	}
+	report.Domain = string(domain.Name)
+	if domain.Policies == nil || domain.Policies.Contents == nil {
+		return report
+	}
+
+	conditions := make(map[string][]athenz.Condition, len(domain.Roles))
+	for _, role := range domain.Roles {
+		if role != nil {
+			conditions[string(role.Name)] = athenz.GetRoleConditions(role)
+		}
+	}
+
+	for _, policy := range domain.Policies.Contents.Policies {
+		if policy == nil {
+			continue
+		}
+		for _, assertion := range policy.Assertions {
+			if assertion == nil {
+				continue
+			}
+			report.lintAssertion(domain.Name, string(policy.Name), assertion, conditions[assertion.Role], mode, options)
+		}
+	}
+	return report
+}
+
+// lintAssertion appends the findings of the assertion and the conditions of its role to the report, the v1 rules
+// apply if the v1 provider is enabled and the v2 rules if the v2 provider is enabled
+func (r *Report) lintAssertion(domainName zms.DomainName, policy string, assertion *zms.Assertion, conditions []athenz.Condition, mode rbac.ProviderMode, options common.ConversionOptions) {
+	add := func(rule, severity, message string) {
+		r.Findings = append(r.Findings, Finding{
+			Rule:      rule,
+			Severity:  severity,
+			Policy:    policy,
+			Assertion: assertionText(assertion),
+			Message:   message,
+		})
+	}
+
+	effect, effectErr := common.ParseAssertionEffect(assertion)
+	deny := effectErr == nil && effect == zms.DENY.String()
+
+	if _, err := common.ParseRoleFQDN(domainName, assertion.Role); err != nil {
+		add(RuleInvalidRole, SeverityError, err.Error())
+	} else if !strings.HasPrefix(assertion.Role, string(domainName)+":role.") {
+		add(RuleInvalidRole, SeverityError, fmt.Sprintf("role: %s is not in the <domain>:role.<name> format", assertion.Role))
+	}
+
+	if !strings.HasPrefix(assertion.Resource, string(domainName)+":") {
+		add(RuleCrossDomainResource, SeverityError, fmt.Sprintf("resource: %s does not belong to the Athenz domain: %s", assertion.Resource, domainName))
+	} else if svc, path, err := common.ParseAssertionResource(domainName, assertion); err != nil {
+		add(RuleMalformedResource, SeverityError, err.Error())
+	} else {
+		// the v1 service roles match the svc as a constraint value and keep the query string in the path
+		if mode.V2Enabled() {
+			if _, err := options.SvcMatchMode.Match(svc, ""); err != nil {
+				add(RuleInvalidSvcPattern, SeverityError, fmt.Sprintf("svc: %s is not a valid pattern: %s", svc, err.Error()))
+			}
+			if _, query := common.SplitQueryString(path); query != "" {
+				switch queryMode := options.QueryStringMode; {
+				case deny:
+					add(RuleQueryStringDropped, SeverityWarning, fmt.Sprintf("query string of path: %s is dropped from the deny authorization policy, the rule denies any query", path))
+				case queryMode == common.QueryStringDeny:
+					add(RuleQueryStringDenied, SeverityWarning, fmt.Sprintf("query string of path: %s is not reviewed, the assertion is skipped from the authorization policy", path))
+				case queryMode == common.QueryStringDrop:
+					add(RuleQueryStringDropped, SeverityWarning, fmt.Sprintf("query string of path: %s is dropped from the authorization policy, the rule matches any query", path))
+				}
+			}
+		}
+		// the deny assertions are skipped by the v1 provider before their path is translated
+		if path != "" && (mode.V2Enabled() || !deny) {
+			if _, err := common.TranslatePath(path); err != nil {
+				if deny {
+					add(RuleUnsupportedPath, SeverityError, err.Error()+", the deny rule applies to all the paths of the svc")
+				} else {
+					add(RuleUnsupportedPath, SeverityError, err.Error())
+				}
+			}
+		}
+	}
+
+	if effectErr != nil {
+		add(RuleUnsupportedEffect, SeverityError, effectErr.Error())
+	}
+	if _, err := common.ParseAssertionAction(assertion); err != nil {
+		add(RuleUnsupportedAction, SeverityError, err.Error())
+	}
+
+	if mode.V1Enabled() {
+		switch {
+		case deny:
+			add(RuleDenyUnsupported, SeverityError, fmt.Sprintf("effect %s is not supported for a ServiceRole, the assertion is skipped", effect))
+		case effectErr == nil && len(conditions) > 0:
+			add(RuleUnsupportedCondition, SeverityError, fmt.Sprintf("conditions of role: %s are not supported for a ServiceRole, the assertion is skipped", assertion.Role))
+		}
+	}
+	if mode.V2Enabled() {
+		for _, condition := range conditions {
+			if _, err := common.ConvertCondition(condition); err != nil {
+				if deny {
+					add(RuleInvalidCondition, SeverityError, err.Error()+", the deny rule applies without the role conditions")
+				} else {
+					add(RuleInvalidCondition, SeverityError, err.Error())
+				}
+			}
+		}
+	}
+}
+
+// assertionText returns the assertion in the athenz format, e.g. ALLOW get to my.domain:role.reader on
+// my.domain:svc.productpage
+func assertionText(assertion *zms.Assertion) string {
+	effect := zms.ALLOW.String()
+	if assertion.Effect != nil {
+		effect = assertion.Effect.String()
+	}
+	return fmt.Sprintf("%s %s to %s on %s", effect, assertion.Action, assertion.Role, assertion.Resource)
+}
+
+// Count returns the number of findings with the given severity
+func (r Report) Count(severity string) int {
+	count := 0
+	for _, finding := range r.Findings {
+		if finding.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteText writes the report in a human readable format, one line per finding
+func (r Report) WriteText(w io.Writer) error {
+	for _, finding := range r.Findings {
+		_, err := fmt.Fprintf(w, "%s: %s [%s] %s: %s (policy %s)\n", r.Domain, finding.Severity, finding.Rule, finding.Assertion, finding.Message, finding.Policy)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s: %d errors, %d warnings\n", r.Domain, r.Count(SeverityError), r.Count(SeverityWarning))
+	return err
+}
+
+// Record sets the lint findings metrics of the athenz domain of the report
+func Record(r Report) {
+	for _, severity := range Severities {
+		metrics.LintFindings.WithLabelValues(r.Domain, severity).Set(float64(r.Count(severity)))
+	}
+}
+
+// Forget deletes the lint findings metrics of a deleted athenz domain
+func Forget(domain string) {
+	for _, severity := range Severities {
+		metrics.LintFindings.DeleteLabelValues(domain, severity)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package lint
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/athenz/clients/go/zms"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/istio/rbac/common"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/metrics"
+)
+
+const (
+	domainName = "test.namespace"
+	readerRole = domainName + ":role.reader"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func newDomain(assertions ...*zms.Assertion) *zms.DomainData {
+	return &zms.DomainData{
+		Name: domainName,
+		Policies: &zms.SignedPolicies{
+			Contents: &zms.DomainPolicies{
+				Domain: domainName,
+				Policies: []*zms.Policy{
+					{
+						Name:       domainName + ":policy.reader",
+						Assertions: assertions,
+					},
+				},
+			},
+		},
+	}
+}
+
+func newAssertion(role, resource, action string) *zms.Assertion {
+	effect := zms.ALLOW
+	return &zms.Assertion{
+		Role:     role,
+		Resource: resource,
+		Action:   action,
+		Effect:   &effect,
+	}
+}
+
+func TestLint(t *testing.T) {
+	deny := zms.DENY
+	cases := []struct {
+		test          string
+		assertion     *zms.Assertion
+		expectedRules []string
+	}{
+		{
+			test:      "valid assertion",
+			assertion: newAssertion(readerRole, domainName+":svc.productpage:/api/*", "get"),
+		},
+		{
+			test:          "role of another domain",
+			assertion:     newAssertion("other.domain:role.reader", domainName+":svc.productpage", "get"),
+			expectedRules: []string{RuleInvalidRole},
+		},
+		{
+			test:          "role without the role prefix",
+			assertion:     newAssertion(domainName+":reader", domainName+":svc.productpage", "get"),
+			expectedRules: []string{RuleInvalidRole},
+		},
+		{
+			test:          "resource of another domain",
+			assertion:     newAssertion(readerRole, "other.domain:svc.productpage", "get"),
+			expectedRules: []string{RuleCrossDomainResource},
+		},
+		{
+			test:          "resource without svc",
+			assertion:     newAssertion(readerRole, domainName+":productpage", "get"),
+			expectedRules: []string{RuleMalformedResource},
+		},
+		{
+			test:      "svc glob with regular expression characters",
+			assertion: newAssertion(readerRole, domainName+":svc.product(page", "get"),
+		},
+		{
+			test:      "query string matched with the :path header",
+			assertion: newAssertion(readerRole, domainName+":svc.productpage:/api?user=admin", "get"),
+		},
+		{
+			test:      "path alternation",
+			assertion: newAssertion(readerRole, domainName+":svc.productpage:/api/{v1,v2}/*", "get"),
+		},
+		{
+			test:          "wildcard in the middle of the path",
+			assertion:     newAssertion(readerRole, domainName+":svc.productpage:/api/*/items", "get"),
+			expectedRules: []string{RuleUnsupportedPath},
+		},
+		{
+			test: "missing effect",
+			assertion: &zms.Assertion{
+				Role:     readerRole,
+				Resource: domainName + ":svc.productpage",
+				Action:   "get",
+			},
+			expectedRules: []string{RuleUnsupportedEffect},
+		},
+		{
+			test: "deny effect",
+			assertion: &zms.Assertion{
+				Role:     readerRole,
+				Resource: domainName + ":svc.productpage",
+				Action:   "get",
+				Effect:   &deny,
+			},
+		},
+		{
+			test:          "unsupported action",
+			assertion:     newAssertion(readerRole, domainName+":svc.productpage", "launch"),
+			expectedRules: []string{RuleUnsupportedAction},
+		},
+	}
+
+	for _, c := range cases {
+		report := Lint(newDomain(c.assertion), rbac.ProviderV2, common.DefaultConversionOptions())
+		assert.Equal(t, domainName, report.Domain, c.test)
+		var actualRules []string
+		for _, finding := range report.Findings {
+			actualRules = append(actualRules, finding.Rule)
+			assert.Equal(t, domainName+":policy.reader", finding.Policy, c.test)
+		}
+		assert.Equal(t, c.expectedRules, actualRules, c.test)
+	}
+}
+
+func TestLintLegacySvcMatch(t *testing.T) {
+	report := Lint(newDomain(newAssertion(readerRole, domainName+":svc.product(page", "get")), rbac.ProviderV2, common.ConversionOptions{SvcMatchMode: common.SvcMatchLegacyRegex})
+	assert.Len(t, report.Findings, 1)
+	assert.Equal(t, RuleInvalidSvcPattern, report.Findings[0].Rule, "svc should be an invalid regular expression")
+}
+
+func TestLintQueryStringModes(t *testing.T) {
+	deny := zms.DENY
+	denyAssertion := newAssertion(readerRole, domainName+":svc.productpage:/api?user=admin", "get")
+	denyAssertion.Effect = &deny
+	cases := []struct {
+		test          string
+		mode          common.QueryStringMode
+		assertion     *zms.Assertion
+		expectedRules []string
+	}{
+		{
+			test:          "drop mode",
+			mode:          common.QueryStringDrop,
+			assertion:     newAssertion(readerRole, domainName+":svc.productpage:/api?user=admin", "get"),
+			expectedRules: []string{RuleQueryStringDropped},
+		},
+		{
+			test:          "deny mode skips the allow assertions",
+			mode:          common.QueryStringDeny,
+			assertion:     newAssertion(readerRole, domainName+":svc.productpage:/api?user=admin", "get"),
+			expectedRules: []string{RuleQueryStringDenied},
+		},
+		{
+			test:          "deny mode drops the query string of the deny assertions",
+			mode:          common.QueryStringDeny,
+			assertion:     denyAssertion,
+			expectedRules: []string{RuleQueryStringDropped},
+		},
+		{
+			test:          "header mode drops the query string of the deny assertions",
+			mode:          common.QueryStringHeader,
+			assertion:     denyAssertion,
+			expectedRules: []string{RuleQueryStringDropped},
+		},
+	}
+
+	for _, c := range cases {
+		var actualRules []string
+		for _, finding := range Lint(newDomain(c.assertion), rbac.ProviderV2, common.ConversionOptions{QueryStringMode: c.mode}).Findings {
+			actualRules = append(actualRules, finding.Rule)
+		}
+		assert.Equal(t, c.expectedRules, actualRules, c.test)
+	}
+}
+
+func TestLintConditions(t *testing.T) {
+	domain := newDomain(newAssertion(readerRole, domainName+":svc.productpage", "get"))
+	domain.Roles = []*zms.Role{
+		{
+			Name: readerRole,
+			Tags: map[zms.CompoundName]*zms.StringList{
+				"istio.when.source.ip":      {List: []zms.CompoundName{"10.0.0.1"}},
+				"istio.when.connection.sni": {List: []zms.CompoundName{"api"}},
+			},
+		},
+	}
+
+	report := Lint(domain, rbac.ProviderV2, common.DefaultConversionOptions())
+	assert.Len(t, report.Findings, 1)
+	assert.Equal(t, RuleInvalidCondition, report.Findings[0].Rule, "unsupported condition key should be reported")
+	assert.Equal(t, SeverityError, report.Findings[0].Severity)
+}
+
+func TestLintProviderModes(t *testing.T) {
+	deny := zms.DENY
+	denyAssertion := newAssertion(readerRole, domainName+":svc.productpage:/api/*/items?user=admin", "get")
+	denyAssertion.Effect = &deny
+	conditionedRole := &zms.Role{
+		Name: readerRole,
+		Tags: map[zms.CompoundName]*zms.StringList{
+			"istio.when.connection.sni": {List: []zms.CompoundName{"api"}},
+		},
+	}
+	cases := []struct {
+		test          string
+		mode          rbac.ProviderMode
+		assertion     *zms.Assertion
+		roles         []*zms.Role
+		expectedRules []string
+	}{
+		{
+			test:          "v1 skips the deny assertions before their path",
+			mode:          rbac.ProviderV1,
+			assertion:     denyAssertion,
+			expectedRules: []string{RuleDenyUnsupported},
+		},
+		{
+			test:          "v2 broadens the deny assertions",
+			mode:          rbac.ProviderV2,
+			assertion:     denyAssertion,
+			expectedRules: []string{RuleQueryStringDropped, RuleUnsupportedPath},
+		},
+		{
+			test:          "both reports the findings of each provider once",
+			mode:          rbac.ProviderBoth,
+			assertion:     denyAssertion,
+			expectedRules: []string{RuleQueryStringDropped, RuleUnsupportedPath, RuleDenyUnsupported},
+		},
+		{
+			test:      "v1 keeps the svc and the query string of the path",
+			mode:      rbac.ProviderV1,
+			assertion: newAssertion(readerRole, domainName+":svc.product(page:/api?user=admin", "get"),
+		},
+		{
+			test:          "v1 skips the roles with conditions",
+			mode:          rbac.ProviderV1,
+			assertion:     newAssertion(readerRole, domainName+":svc.productpage", "get"),
+			roles:         []*zms.Role{conditionedRole},
+			expectedRules: []string{RuleUnsupportedCondition},
+		},
+		{
+			test:          "both reports the conditions of each provider",
+			mode:          rbac.ProviderBoth,
+			assertion:     newAssertion(readerRole, domainName+":svc.productpage", "get"),
+			roles:         []*zms.Role{conditionedRole},
+			expectedRules: []string{RuleUnsupportedCondition, RuleInvalidCondition},
+		},
+	}
+
+	for _, c := range cases {
+		domain := newDomain(c.assertion)
+		domain.Roles = c.roles
+		var actualRules []string
+		for _, finding := range Lint(domain, c.mode, common.ConversionOptions{QueryStringMode: common.QueryStringDeny}).Findings {
+			actualRules = append(actualRules, finding.Rule)
+		}
+		assert.Equal(t, c.expectedRules, actualRules, c.test)
+	}
+}
+
+func TestLintEmptyDomain(t *testing.T) {
+	assert.Empty(t, Lint(nil, rbac.ProviderV2, common.DefaultConversionOptions()).Findings, "nil domain should not have findings")
+	assert.Empty(t, Lint(&zms.DomainData{Name: domainName}, rbac.ProviderV2, common.DefaultConversionOptions()).Findings, "domain without policies should not have findings")
+}
+
+func TestReport(t *testing.T) {
+	report := Lint(newDomain(
+		newAssertion(readerRole, "other.domain:svc.productpage", "get"),
+		newAssertion(readerRole, domainName+":svc.productpage:/api?user=admin", "launch"),
+	), rbac.ProviderV2, common.ConversionOptions{QueryStringMode: common.QueryStringDrop})
+	assert.Equal(t, 2, report.Count(SeverityError), "errors should be counted")
+	assert.Equal(t, 1, report.Count(SeverityWarning), "warnings should be counted")
+
+	var buf bytes.Buffer
+	assert.Nil(t, report.WriteText(&buf))
+	expected := "test.namespace: error [cross_domain_resource] ALLOW get to test.namespace:role.reader on other.domain:svc.productpage: " +
+		"resource: other.domain:svc.productpage does not belong to the Athenz domain: test.namespace (policy test.namespace:policy.reader)\n" +
+		"test.namespace: warning [query_string_dropped] ALLOW launch to test.namespace:role.reader on test.namespace:svc.productpage:/api?user=admin: " +
+		"query string of path: /api?user=admin is dropped from the authorization policy, the rule matches any query (policy test.namespace:policy.reader)\n" +
+		"test.namespace: error [unsupported_action] ALLOW launch to test.namespace:role.reader on test.namespace:svc.productpage:/api?user=admin: " +
+		"method: launch is not a supported HTTP method (policy test.namespace:policy.reader)\n" +
+		"test.namespace: 2 errors, 1 warnings\n"
+	assert.Equal(t, expected, buf.String())
+
+	Record(report)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.LintFindings.WithLabelValues(domainName, SeverityError)), "error findings should be recorded")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.LintFindings.WithLabelValues(domainName, SeverityWarning)), "warning findings should be recorded")
+
+	Record(Lint(newDomain(), rbac.ProviderV2, common.DefaultConversionOptions()))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.LintFindings.WithLabelValues(domainName, SeverityError)), "fixed findings should be reset")
+
+	Forget(domainName)
+	assert.False(t, metrics.LintFindings.DeleteLabelValues(domainName, SeverityError), "findings of the deleted domain should be deleted")
+}
@@ -63,6 +63,12 @@ func InitLogger(logFile, level string) {
 	log = l
 }
 
+// SetOutput redirects the output of the logger, it must be called after InitLogger. It is used by the
+// subcommands which print their results on stdout.
+func SetOutput(w io.Writer) {
+	log.Out = w
+}
+
 // getCallerInfo retrieves the function caller information and creates a
 // log prefix out of callers package name, filename, and function name.
 func getCallerInfo(depth int) string {
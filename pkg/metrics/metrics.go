@@ -0,0 +1,104 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "athenz_istio_auth"
+
+// Reasons of the assertions skipped while converting an athenz domain into istio rbac resources
+const (
+	SkipReasonInvalidRole           = "invalid_role"
+	SkipReasonInvalidResource       = "invalid_resource"
+	SkipReasonInvalidEffect         = "invalid_effect"
+	SkipReasonInvalidAction         = "invalid_action"
+	SkipReasonDenyUnsupported       = "deny_unsupported"
+	SkipReasonInvalidPath           = "invalid_path"
+	SkipReasonQueryStringUnreviewed = "query_string_unreviewed"
+	SkipReasonInvalidCondition      = "invalid_condition"
+)
+
+var (
+	// GuardHeldChanges is the number of destructive changes currently held by the blast radius guard
+	GuardHeldChanges = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "guard",
+		Name:      "held_changes",
+		Help:      "Number of destructive changes held by the blast radius guard.",
+	}, []string{"controller", "domain"})
+
+	// GuardTripsTotal is the number of times the blast radius guard held a change list
+	GuardTripsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "guard",
+		Name:      "trips_total",
+		Help:      "Number of change lists held by the blast radius guard.",
+	}, []string{"controller", "domain", "scope"})
+
+	// SyncDuration is the latency of the sync of a queue key by a controller
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "sync_duration_seconds",
+		Help:      "Time in seconds taken by a controller to sync a queue key.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"controller"})
+
+	// ResourceOperationsTotal is the number of create, update and delete calls made on the istio rbac resources
+	ResourceOperationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "resource_operations_total",
+		Help:      "Number of create, update and delete calls on the istio rbac resources by kind and outcome.",
+	}, []string{"kind", "operation", "outcome"})
+
+	// SkippedAssertions is the number of athenz assertions of an athenz domain which could not be converted, as of
+	// the last sync of the domain and of its services
+	SkippedAssertions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "skipped_assertions",
+		Help:      "Number of athenz assertions of the athenz domain skipped by the last sync of a controller.",
+	}, []string{"controller", "domain"})
+
+	// LastSuccessfulSync is the unix time of the last successful sync of an athenz domain, a sync is successful once
+	// its changes are applied. The time since the last sync is computed at query time with
+	// time() - athenz_istio_auth_controller_last_successful_sync_timestamp_seconds
+	LastSuccessfulSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time of the last successful sync of an athenz domain by a controller.",
+	}, []string{"controller", "domain"})
+
+	// LintFindings is the number of findings of the lint of an athenz domain by severity, as of its last sync
+	LintFindings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "lint",
+		Name:      "findings",
+		Help:      "Number of lint findings of the athenz domain assertions by severity.",
+	}, []string{"domain", "severity"})
+)
+
+func init() {
+	prometheus.MustRegister(GuardHeldChanges, GuardTripsTotal, SyncDuration, ResourceOperationsTotal, SkippedAssertions, LastSuccessfulSync, LintFindings)
+}
+
+// ObserveSync records the latency of a sync started at the given time
+func ObserveSync(controller string, start time.Time) {
+	SyncDuration.WithLabelValues(controller).Observe(time.Since(start).Seconds())
+}
+
+// ObserveResourceOperation records the outcome of a create, update or delete call on an istio rbac resource
+func ObserveResourceOperation(kind, operation string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	ResourceOperationsTotal.WithLabelValues(kind, operation, outcome).Inc()
+}
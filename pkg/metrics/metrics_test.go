@@ -0,0 +1,38 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestWorkqueueMetricsProvider(t *testing.T) {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	defer queue.ShutDown()
+
+	queue.Add("first")
+	queue.Add("second")
+	assert.Equal(t, float64(2), testutil.ToFloat64(queueDepth.WithLabelValues("test")), "depth should count the queued items")
+	assert.Equal(t, float64(2), testutil.ToFloat64(queueAdds.WithLabelValues("test")), "adds should count the added items")
+
+	item, _ := queue.Get()
+	queue.AddRateLimited(item)
+	queue.Done(item)
+	assert.Equal(t, float64(1), testutil.ToFloat64(queueDepth.WithLabelValues("test")), "depth should not count the item being processed")
+	assert.Equal(t, float64(1), testutil.ToFloat64(queueRetries.WithLabelValues("test")), "retries should count the rate limited items")
+}
+
+func TestObserveResourceOperation(t *testing.T) {
+	ObserveResourceOperation("ServiceRole", "add", nil)
+	ObserveResourceOperation("ServiceRole", "add", nil)
+	ObserveResourceOperation("ServiceRole", "add", errors.New("conflict"))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(ResourceOperationsTotal.WithLabelValues("ServiceRole", "add", "success")), "successful operations should be counted")
+	assert.Equal(t, float64(1), testutil.ToFloat64(ResourceOperationsTotal.WithLabelValues("ServiceRole", "add", "error")), "failed operations should be counted")
+}
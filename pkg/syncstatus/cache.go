@@ -0,0 +1,82 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package syncstatus
+
+import "sync"
+
+// Cache holds the last status of each sync status in memory, whether or not the sync statuses are written, so that
+// the outcome of a sync can be compared with the outcome of the previous sync. A nil cache does not hold anything.
+type Cache struct {
+	mu       sync.Mutex
+	statuses map[string]SyncStatus
+}
+
+// NewCache returns an empty cache of the sync statuses
+func NewCache() *Cache {
+	return &Cache{
+		statuses: make(map[string]SyncStatus),
+	}
+}
+
+// Swap holds the status of the sync status with the given name in the namespace and returns the status it replaces,
+// the zero status is returned for the first sync
+func (c *Cache) Swap(namespace string, name string, status SyncStatus) SyncStatus {
+	if c == nil {
+		return SyncStatus{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := c.statuses[namespace+"/"+name]
+	c.statuses[namespace+"/"+name] = status
+	return last
+}
+
+// Delete forgets the status of the sync status with the given name in the namespace
+func (c *Cache) Delete(namespace string, name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.statuses, namespace+"/"+name)
+}
+
+// Prune forgets the statuses of the controller for the domain in the namespace, except the ones with the names to
+// keep
+func (c *Cache) Prune(namespace string, controller string, domain string, keep []string) {
+	if c == nil {
+		return
+	}
+
+	keepKeys := make(map[string]bool, len(keep))
+	for _, name := range keep {
+		keepKeys[namespace+"/"+name] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, status := range c.statuses {
+		if !keepKeys[key] && status.Controller == controller && status.Domain == domain {
+			delete(c.statuses, key)
+		}
+	}
+}
+
+// SkippedAssertions returns the number of assertions skipped by the last syncs of the controller for the domain
+func (c *Cache) SkippedAssertions(controller string, domain string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	skipped := 0
+	for _, status := range c.statuses {
+		if status.Controller == controller && status.Domain == domain {
+			skipped += len(status.SkippedAssertions)
+		}
+	}
+	return skipped
+}
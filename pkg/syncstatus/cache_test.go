@@ -0,0 +1,44 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package syncstatus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	skipped := []SkippedAssertion{
+		{
+			Role:     "test.domain:role.reader",
+			Resource: "test.domain:svc.productpage",
+			Action:   "launch",
+			Reason:   "invalid_action",
+		},
+	}
+	productpage := SyncStatus{Controller: "authzpolicy", Domain: "test.domain", Service: "productpage", SkippedAssertions: skipped}
+	details := SyncStatus{Controller: "authzpolicy", Domain: "test.domain", Service: "details", SkippedAssertions: skipped}
+	other := SyncStatus{Controller: "authzpolicy", Domain: "other.domain", SkippedAssertions: skipped}
+
+	c := NewCache()
+	assert.Equal(t, SyncStatus{}, c.Swap("test-namespace", "authzpolicy-productpage", productpage), "first sync should return the zero status")
+	assert.Equal(t, productpage, c.Swap("test-namespace", "authzpolicy-productpage", productpage), "next sync should return the last status")
+	c.Swap("test-namespace", "authzpolicy-details", details)
+	c.Swap("other-namespace", "authzpolicy", other)
+	assert.Equal(t, 2, c.SkippedAssertions("authzpolicy", "test.domain"), "skipped assertions of the services of the domain should be added")
+
+	c.Prune("test-namespace", "authzpolicy", "test.domain", []string{"authzpolicy-details"})
+	assert.Equal(t, 1, c.SkippedAssertions("authzpolicy", "test.domain"), "pruned statuses should be forgotten")
+	assert.Equal(t, 1, c.SkippedAssertions("authzpolicy", "other.domain"), "statuses of other domains should be kept")
+
+	c.Delete("test-namespace", "authzpolicy-details")
+	assert.Equal(t, 0, c.SkippedAssertions("authzpolicy", "test.domain"), "deleted status should be forgotten")
+
+	var nilCache *Cache
+	assert.Equal(t, SyncStatus{}, nilCache.Swap("test-namespace", "authzpolicy-productpage", productpage), "nil cache should not hold any status")
+	nilCache.Delete("test-namespace", "authzpolicy-productpage")
+	nilCache.Prune("test-namespace", "authzpolicy", "test.domain", nil)
+	assert.Equal(t, 0, nilCache.SkippedAssertions("authzpolicy", "test.domain"), "nil cache should not count any assertion")
+}
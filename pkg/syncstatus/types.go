@@ -0,0 +1,80 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package syncstatus
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Kind = "AthenzIstioSyncStatus"
+	// ModeEnforced and ModeDryRun are the modes of the generated authorization policies
+	ModeEnforced = "enforced"
+	ModeDryRun   = "dry-run"
+)
+
+// GroupVersionResource of the AthenzIstioSyncStatus custom resource
+var GroupVersionResource = schema.GroupVersionResource{
+	Group:    "athenz.io",
+	Version:  "v1",
+	Resource: "athenzistiosyncstatuses",
+}
+
+// AthenzIstioSyncStatus is written by a controller in the namespace of an athenz domain after each sync of the
+// domain or one of its services
+type AthenzIstioSyncStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status SyncStatus `json:"status,omitempty"`
+}
+
+// SyncStatus is the outcome of the last sync
+type SyncStatus struct {
+	// Controller which synced the domain or the service
+	Controller string `json:"controller"`
+	Domain     string `json:"domain"`
+	// Service is empty for the syncs of the whole domain
+	Service string `json:"service,omitempty"`
+	// ObservedResourceVersion is the resource version of the athenz domain used by the sync
+	ObservedResourceVersion string `json:"observedResourceVersion,omitempty"`
+	// Mode is enforced or dry-run
+	Mode              string             `json:"mode,omitempty"`
+	Policies          []Policy           `json:"policies,omitempty"`
+	SkippedAssertions []SkippedAssertion `json:"skippedAssertions,omitempty"`
+	SkippedMembers    []SkippedMember    `json:"skippedMembers,omitempty"`
+	// BroadenedAssertions are the DENY assertions converted into rules denying more requests than in athenz
+	BroadenedAssertions []SkippedAssertion `json:"broadenedAssertions,omitempty"`
+	LastError           string             `json:"lastError,omitempty"`
+	// HeldChanges is the number of destructive changes held by the blast-radius guard, the generated resources are
+	// not up-to-date while changes are held
+	HeldChanges int `json:"heldChanges,omitempty"`
+	// LastSyncTime is the time of the last sync which changed the status
+	LastSyncTime metav1.Time `json:"lastSyncTime"`
+}
+
+// Policy is a resource generated by the sync
+type Policy struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Hash of the generated spec
+	Hash string `json:"hash"`
+}
+
+// SkippedAssertion is an athenz assertion which could not be converted
+type SkippedAssertion struct {
+	Role     string `json:"role"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message,omitempty"`
+}
+
+// SkippedMember is an athenz role member which could not be converted
+type SkippedMember struct {
+	Role   string `json:"role"`
+	Member string `json:"member"`
+	Reason string `json:"reason"`
+}
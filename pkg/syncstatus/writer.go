@@ -0,0 +1,306 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package syncstatus
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"istio.io/istio/pilot/pkg/model"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	queueNumRetries = 3
+	// controllerName identifies the sync status writer in the queue
+	controllerName = "syncstatus"
+)
+
+// operation is a pending write, delete or prune of the sync statuses
+type operation func() error
+
+// Writer creates, updates and deletes the AthenzIstioSyncStatus resources from its own worker, so that the syncs of
+// the controllers do not wait for the api server. Only the last pending operation of a sync status is performed, and a
+// status which only differs from the written one by the sync time is not written. A nil writer does not write
+// anything.
+type Writer struct {
+	client dynamic.NamespaceableResourceInterface
+	queue  workqueue.RateLimitingInterface
+
+	mu sync.Mutex
+	// pending holds the last operation of each queue key
+	pending map[string]operation
+	// written holds the last written status of each sync status without the sync time
+	written map[string]SyncStatus
+}
+
+// NewWriter returns a writer of the AthenzIstioSyncStatus resources, the operations are performed once Run is called
+func NewWriter(client dynamic.Interface) *Writer {
+	return &Writer{
+		client:  client.Resource(GroupVersionResource),
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName),
+		pending: make(map[string]operation),
+		written: make(map[string]SyncStatus),
+	}
+}
+
+// Name returns the name of the sync status of a controller for a domain, or for a service of the domain
+func Name(controller string, service string) string {
+	if service == "" {
+		return controller
+	}
+	return controller + "-" + service
+}
+
+// NewPolicies returns the names and the spec hashes of the generated resources
+func NewPolicies(configs []model.Config) []Policy {
+	policies := make([]Policy, 0, len(configs))
+	for _, config := range configs {
+		spec, err := json.Marshal(config.Spec)
+		if err != nil {
+			log.Warningf("Error hashing the spec of %s: %s", config.Key(), err.Error())
+		}
+		policies = append(policies, Policy{
+			Kind: config.Type,
+			Name: config.Name,
+			Hash: fmt.Sprintf("%x", sha256.Sum256(spec))[:12],
+		})
+	}
+	return policies
+}
+
+// Write stamps the status with the sync time and the error, and queues its write to the sync status with the given
+// name in the namespace. The sync error takes precedence over the error already set on the status. The status is not
+// written if only its sync time changed. Write errors are logged, the sync status is informational and does not fail
+// the sync.
+func (w *Writer) Write(namespace string, name string, status SyncStatus, syncErr error) {
+	if w == nil {
+		return
+	}
+
+	if syncErr != nil {
+		status.LastError = syncErr.Error()
+	}
+	status.LastSyncTime = metav1.Time{}
+	key := namespace + "/" + name
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if written, exists := w.written[key]; exists && reflect.DeepEqual(written, status) {
+		delete(w.pending, key)
+		return
+	}
+	status.LastSyncTime = metav1.Now()
+	w.addLocked(key, func() error {
+		return w.write(key, namespace, name, status)
+	})
+}
+
+// Delete queues the delete of the sync status with the given name in the namespace, it is called once the domain or
+// the service of the sync status does not exist anymore
+func (w *Writer) Delete(namespace string, name string) {
+	if w == nil {
+		return
+	}
+
+	key := namespace + "/" + name
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.addLocked(key, func() error {
+		return w.delete(key, namespace, name)
+	})
+}
+
+// Prune queues the delete of the sync statuses of the controller for the domain in the namespace, except the sync
+// statuses with the names to keep. It is called after a sync of the whole domain with the names of the written sync
+// statuses, so that the sync statuses of the deleted services are garbage collected.
+func (w *Writer) Prune(namespace string, controller string, domain string, keep []string) {
+	if w == nil {
+		return
+	}
+
+	keepNames := make(map[string]bool, len(keep))
+	for _, name := range keep {
+		keepNames[name] = true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.addLocked(namespace+"/"+controller+"/"+domain, func() error {
+		return w.prune(namespace, controller, domain, keepNames)
+	})
+}
+
+// addLocked replaces the pending operation of the key and adds the key to the queue
+func (w *Writer) addLocked(key string, op operation) {
+	w.pending[key] = op
+	w.queue.Add(key)
+}
+
+// Run performs the queued operations until the stop channel is closed, the remaining operations are dropped
+func (w *Writer) Run(stopCh <-chan struct{}) {
+	if w == nil {
+		return
+	}
+
+	// the queue is shut down on stop to release the worker waiting for a key
+	go func() {
+		<-stopCh
+		w.queue.ShutDown()
+	}()
+	for w.processNextItem() {
+	}
+}
+
+// processNextItem takes a key off the queue and performs its pending operation, the failed operations are retried
+// unless a newer operation is pending for the key
+func (w *Writer) processNextItem() bool {
+	keyRaw, quit := w.queue.Get()
+	if quit {
+		return false
+	}
+	defer w.queue.Done(keyRaw)
+
+	key, ok := keyRaw.(string)
+	if !ok {
+		log.Errorf("String cast failed for key %v", keyRaw)
+		w.queue.Forget(keyRaw)
+		return true
+	}
+
+	w.mu.Lock()
+	op, exists := w.pending[key]
+	delete(w.pending, key)
+	w.mu.Unlock()
+	if !exists {
+		w.queue.Forget(keyRaw)
+		return true
+	}
+
+	err := op()
+	if err == nil {
+		w.queue.Forget(keyRaw)
+		return true
+	}
+	log.Warningf("Error writing the sync status %s: %s", key, err.Error())
+	if w.queue.NumRequeues(keyRaw) >= queueNumRetries {
+		log.Errorf("Max number of retries reached for the sync status %s", key)
+		w.queue.Forget(keyRaw)
+		return true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, exists := w.pending[key]; !exists {
+		w.pending[key] = op
+		w.queue.AddRateLimited(keyRaw)
+	}
+	return true
+}
+
+// write creates or updates the sync status, the update is skipped if the current status only differs by the sync time
+func (w *Writer) write(key string, namespace string, name string, status SyncStatus) error {
+	syncStatus := &AthenzIstioSyncStatus{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: GroupVersionResource.GroupVersion().String(),
+			Kind:       Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Status: status,
+	}
+	comparable := status
+	comparable.LastSyncTime = metav1.Time{}
+
+	current, err := w.client.Namespace(namespace).Get(name, metav1.GetOptions{})
+	found := err == nil
+	if err != nil && !apiErrors.IsNotFound(err) {
+		return err
+	}
+	if found {
+		currentStatus := &AthenzIstioSyncStatus{}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(current.UnstructuredContent(), currentStatus)
+		if err == nil {
+			currentStatus.Status.LastSyncTime = metav1.Time{}
+			if reflect.DeepEqual(currentStatus.Status, comparable) {
+				w.setWritten(key, &comparable)
+				return nil
+			}
+		}
+		syncStatus.ResourceVersion = current.GetResourceVersion()
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(syncStatus)
+	if err != nil {
+		return err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+
+	if !found {
+		_, err = w.client.Namespace(namespace).Create(obj, metav1.CreateOptions{})
+	} else {
+		_, err = w.client.Namespace(namespace).Update(obj, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		w.setWritten(key, nil)
+		return err
+	}
+	w.setWritten(key, &comparable)
+	return nil
+}
+
+// delete deletes the sync status, a sync status which does not exist is ignored
+func (w *Writer) delete(key string, namespace string, name string) error {
+	w.setWritten(key, nil)
+	err := w.client.Namespace(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apiErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// prune deletes the sync statuses of the controller for the domain in the namespace which are not kept
+func (w *Writer) prune(namespace string, controller string, domain string, keep map[string]bool) error {
+	list, err := w.client.Namespace(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		if keep[item.GetName()] {
+			continue
+		}
+		syncStatus := &AthenzIstioSyncStatus{}
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), syncStatus)
+		if err != nil || syncStatus.Status.Controller != controller || syncStatus.Status.Domain != domain {
+			continue
+		}
+		log.Infof("Deleting the stale sync status %s/%s of domain %s", namespace, item.GetName(), domain)
+		err = w.delete(namespace+"/"+item.GetName(), namespace, item.GetName())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setWritten records the last written status of the sync status, or forgets it if the status is nil
+func (w *Writer) setWritten(key string, status *SyncStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if status == nil {
+		delete(w.written, key)
+		return
+	}
+	w.written[key] = *status
+}
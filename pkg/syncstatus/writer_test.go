@@ -0,0 +1,180 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package syncstatus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+	"istio.io/api/security/v1beta1"
+	"istio.io/istio/pilot/pkg/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func init() {
+	log.InitLogger("", "debug")
+}
+
+func getSyncStatus(t *testing.T, w *Writer, namespace, name string) *AthenzIstioSyncStatus {
+	obj, err := w.client.Namespace(namespace).Get(name, metav1.GetOptions{})
+	assert.Nil(t, err, "get should not return an error")
+	syncStatus := &AthenzIstioSyncStatus{}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), syncStatus)
+	assert.Nil(t, err, "conversion should not return an error")
+	return syncStatus
+}
+
+// processAll performs the queued operations of the writer
+func processAll(w *Writer) {
+	for w.queue.Len() > 0 {
+		w.processNextItem()
+	}
+}
+
+func newTestConfig(name string, spec *v1beta1.AuthorizationPolicy) model.Config {
+	return model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type:      "AuthorizationPolicy",
+			Namespace: "test-namespace",
+			Name:      name,
+		},
+		Spec: spec,
+	}
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "domain", Name("domain", ""), "domain sync status name should be the controller")
+	assert.Equal(t, "authzpolicy-productpage", Name("authzpolicy", "productpage"), "service sync status name should include the service")
+}
+
+func TestNewPolicies(t *testing.T) {
+	first := newTestConfig("first", &v1beta1.AuthorizationPolicy{})
+	second := newTestConfig("second", &v1beta1.AuthorizationPolicy{
+		Action: v1beta1.AuthorizationPolicy_DENY,
+	})
+
+	policies := NewPolicies([]model.Config{first, second})
+	assert.Equal(t, 2, len(policies), "every config should have a policy")
+	assert.Equal(t, "first", policies[0].Name, "policy name should be equal")
+	assert.Equal(t, "AuthorizationPolicy", policies[0].Kind, "policy kind should be equal")
+	assert.Equal(t, 12, len(policies[0].Hash), "policy hash should be 12 characters")
+	assert.NotEqual(t, policies[0].Hash, policies[1].Hash, "policy hash should depend on the spec")
+	assert.Equal(t, policies[0].Hash, NewPolicies([]model.Config{first})[0].Hash, "policy hash should be stable")
+}
+
+func TestWrite(t *testing.T) {
+	w := NewWriter(fake.NewSimpleDynamicClient(runtime.NewScheme()))
+	status := SyncStatus{
+		Controller:              "authzpolicy",
+		Domain:                  "test.namespace",
+		Service:                 "productpage",
+		ObservedResourceVersion: "10",
+		Mode:                    ModeDryRun,
+		Policies:                []Policy{{Kind: "AuthorizationPolicy", Name: "productpage", Hash: "0123456789ab"}},
+		SkippedAssertions: []SkippedAssertion{
+			{Role: "test.namespace:role.reader", Resource: "test.namespace:svc.productpage", Action: "launch", Reason: "invalid_action"},
+		},
+	}
+
+	w.Write("test-namespace", "authzpolicy-productpage", status, nil)
+	processAll(w)
+	actual := getSyncStatus(t, w, "test-namespace", "authzpolicy-productpage")
+	assert.Equal(t, Kind, actual.Kind, "kind should be equal")
+	assert.Equal(t, "productpage", actual.Status.Service, "service should be equal")
+	assert.Equal(t, ModeDryRun, actual.Status.Mode, "mode should be equal")
+	assert.Equal(t, status.Policies, actual.Status.Policies, "policies should be equal")
+	assert.Equal(t, status.SkippedAssertions, actual.Status.SkippedAssertions, "skipped assertions should be equal")
+	assert.Equal(t, "", actual.Status.LastError, "last error should be empty")
+	assert.False(t, actual.Status.LastSyncTime.IsZero(), "last sync time should be set")
+
+	status.ObservedResourceVersion = "11"
+	w.Write("test-namespace", "authzpolicy-productpage", status, errors.New("sync failed"))
+	processAll(w)
+	actual = getSyncStatus(t, w, "test-namespace", "authzpolicy-productpage")
+	assert.Equal(t, "11", actual.Status.ObservedResourceVersion, "observed resource version should be updated")
+	assert.Equal(t, "sync failed", actual.Status.LastError, "last error should be updated")
+
+	status.LastError = "selector is empty"
+	w.Write("test-namespace", "authzpolicy-productpage", status, nil)
+	processAll(w)
+	actual = getSyncStatus(t, w, "test-namespace", "authzpolicy-productpage")
+	assert.Equal(t, "selector is empty", actual.Status.LastError, "last error of the status should be kept without sync error")
+}
+
+func TestWriteUnchangedStatus(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	w := NewWriter(client)
+	status := SyncStatus{Controller: "domain", Domain: "test.namespace"}
+
+	w.Write("test-namespace", "domain", status, nil)
+	processAll(w)
+	lastSyncTime := getSyncStatus(t, w, "test-namespace", "domain").Status.LastSyncTime
+
+	w.Write("test-namespace", "domain", status, nil)
+	assert.Equal(t, 0, w.queue.Len(), "unchanged status should not be queued")
+
+	// a new writer compares the status with the current sync status
+	w = NewWriter(client)
+	w.Write("test-namespace", "domain", status, nil)
+	processAll(w)
+	assert.Equal(t, lastSyncTime, getSyncStatus(t, w, "test-namespace", "domain").Status.LastSyncTime, "unchanged status should not be updated")
+
+	status.LastError = "sync failed"
+	w.Write("test-namespace", "domain", status, nil)
+	assert.Equal(t, 1, w.queue.Len(), "changed status should be queued")
+	processAll(w)
+	assert.Equal(t, "sync failed", getSyncStatus(t, w, "test-namespace", "domain").Status.LastError, "changed status should be updated")
+}
+
+func TestWriteLastOperation(t *testing.T) {
+	w := NewWriter(fake.NewSimpleDynamicClient(runtime.NewScheme()))
+	w.Write("test-namespace", "domain", SyncStatus{Controller: "domain", Domain: "test.namespace", LastError: "first"}, nil)
+	w.Write("test-namespace", "domain", SyncStatus{Controller: "domain", Domain: "test.namespace", LastError: "second"}, nil)
+	assert.Equal(t, 1, w.queue.Len(), "operations of the same sync status should be queued once")
+	processAll(w)
+	assert.Equal(t, "second", getSyncStatus(t, w, "test-namespace", "domain").Status.LastError, "last status should be written")
+}
+
+func TestDeleteAndPrune(t *testing.T) {
+	w := NewWriter(fake.NewSimpleDynamicClient(runtime.NewScheme()))
+	for _, service := range []string{"productpage", "reviews", "details"} {
+		w.Write("test-namespace", Name("authzpolicy", service), SyncStatus{Controller: "authzpolicy", Domain: "test.namespace", Service: service}, nil)
+	}
+	w.Write("test-namespace", Name("authzpolicy", "other"), SyncStatus{Controller: "authzpolicy", Domain: "other.domain", Service: "other"}, nil)
+	w.Write("test-namespace", Name("domain", ""), SyncStatus{Controller: "domain", Domain: "test.namespace"}, nil)
+	processAll(w)
+
+	exists := func(name string) bool {
+		_, err := w.client.Namespace("test-namespace").Get(name, metav1.GetOptions{})
+		return err == nil
+	}
+
+	w.Delete("test-namespace", Name("authzpolicy", "details"))
+	processAll(w)
+	assert.False(t, exists("authzpolicy-details"), "deleted sync status should not exist")
+
+	w.Prune("test-namespace", "authzpolicy", "test.namespace", []string{"authzpolicy-productpage"})
+	processAll(w)
+	assert.True(t, exists("authzpolicy-productpage"), "kept sync status should exist")
+	assert.False(t, exists("authzpolicy-reviews"), "pruned sync status should not exist")
+	assert.True(t, exists("authzpolicy-other"), "sync status of another domain should exist")
+	assert.True(t, exists("domain"), "sync status of another controller should exist")
+
+	// a deleted sync status is written again even if it is unchanged
+	w.Write("test-namespace", Name("authzpolicy", "reviews"), SyncStatus{Controller: "authzpolicy", Domain: "test.namespace", Service: "reviews"}, nil)
+	processAll(w)
+	assert.True(t, exists("authzpolicy-reviews"), "sync status should be written again")
+}
+
+func TestWriteNilWriter(t *testing.T) {
+	var w *Writer
+	w.Write("test-namespace", "domain", SyncStatus{}, nil)
+	w.Delete("test-namespace", "domain")
+	w.Prune("test-namespace", "domain", "test.namespace", nil)
+	w.Run(make(chan struct{}))
+}
@@ -0,0 +1,76 @@
+// Copyright 2021, Verizon Media Inc.
+// Licensed under the terms of the 3-Clause BSD license. See LICENSE file in
+// github.com/yahoo/k8s-athenz-istio-auth for terms.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/convert"
+	"github.com/yahoo/k8s-athenz-istio-auth/pkg/log"
+)
+
+const svcMatchReportCommand = "svc-match-report"
+
+// runSvcMatchReport reads the AthenzDomain and Service manifests like the convert subcommand and prints the
+// assertions matching an authz enabled Service with only one of the glob and the legacy regex svc match modes on
+// stdout. The exit code is 1 if the AuthorizationPolicy of a Service changes between the modes, and 2 on errors.
+func runSvcMatchReport(args []string) int {
+	flags := flag.NewFlagSet(svcMatchReportCommand, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] [file ...]\n\n", os.Args[0], svcMatchReportCommand)
+		fmt.Fprintln(flags.Output(), "Lists the Services whose authorization policies change between the glob and the legacy-regex svc match modes.")
+		flags.PrintDefaults()
+	}
+	output := flags.String("output", "text", "output format, text or json")
+	conversion := addConversionFlags(flags, trustMaxDepthFlag)
+	logLevel := flags.String("log-level", "error", "logging level, the logs are printed on stderr")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Output format %s is not one of text or json\n", *output)
+		return 2
+	}
+
+	log.InitLogger("", *logLevel)
+	log.SetOutput(os.Stderr)
+	if err := conversion.parse(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing %s\n", err)
+		return 2
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var in convert.Input
+	for _, file := range files {
+		if err := decodeFile(file, &in); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", file, err)
+			return 2
+		}
+	}
+
+	changes := convert.SvcMatchChanges(in, conversion.maxTrustDepth)
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(changes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing the svc match report: %s\n", err)
+			return 2
+		}
+	} else {
+		for _, change := range changes {
+			fmt.Printf("%s/%s (svc %s): %s on %s for role %s is matched by the %s mode only\n", change.Namespace, change.Service,
+				change.SvcLabel, change.Action, change.Resource, change.Role, change.Mode)
+		}
+	}
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -522,6 +522,7 @@ func GetDefaultService(serviceName string, modifications []func(service *v1.Serv
 		defaultService.Labels = make(map[string]string)
 	}
 	defaultService.Labels["svc"] = defaultService.Name
+	defaultService.Spec.Selector = map[string]string{"svc": defaultService.Name}
 	if modifications == nil {
 		modifications = []func(service *v1.Service){}
 	}